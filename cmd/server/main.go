@@ -2,19 +2,49 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
 	"flag"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/blob"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/comment"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/config"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/digest"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
 	errors "github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/feed"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/healthcheck"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/info"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/live"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/moderation"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/notification"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/profile"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/push"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/quota"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/reaction"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/search"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/share"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/tag"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/trash"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/webhook"
 	accesslog "github.com/MrPomajdor/ShareFlowAPI/pkg/accesslog"
 	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httpclient"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/mailer"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/storage"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/urlsign"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/webpush"
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	routing "github.com/go-ozzo/ozzo-routing/v2"
 	content "github.com/go-ozzo/ozzo-routing/v2/content"
@@ -27,6 +57,21 @@ var Version = "1.0.0"
 
 var flagConfig = flag.String("config", "./config/default.yaml", "path to config file")
 
+// trashPurgeInterval is how often deleted items past the retention period are purged.
+const trashPurgeInterval = time.Hour
+
+// notificationCleanupInterval is how often notifications past the retention period are deleted.
+const notificationCleanupInterval = time.Hour
+
+// webhookDispatchInterval is how often due webhook deliveries are sent.
+const webhookDispatchInterval = 5 * time.Second
+
+// pushWorkers is the number of notifications pushed concurrently.
+const pushWorkers = 4
+
+// digestInterval is how often due email digests are sent.
+const digestInterval = 15 * time.Minute
+
 func main() {
 	flag.Parse()
 	logger := logrus.New()
@@ -52,20 +97,70 @@ func main() {
 
 	defer db.Close()
 
+	store, err := storage.NewLocal(cfg.StoragePath)
+	if err != nil {
+		logger.WithField("error", err.Error()).Fatal("Failed to initialize media storage")
+	}
+
+	signing, err := mediaURLSigning(cfg)
+	if err != nil {
+		logger.WithField("error", err.Error()).Fatal("Invalid media URL signing configuration")
+	}
+
+	reactions, err := reaction.ParseTypes(cfg.Reactions)
+	if err != nil {
+		logger.WithField("error", err.Error()).Fatal("Invalid reactions configuration")
+	}
+
+	// the background jobs stop once the shutdown of the server starts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbc := dbcontext.New(db)
+	blobService := blob.NewService(dbc, store, logger)
+	quotaService := quota.NewService(dbc, cfg.StorageQuota<<20, logger)
+	trashService := trash.NewService(dbc, blobService, quotaService, time.Duration(cfg.TrashRetention)*24*time.Hour, logger)
+	go trash.RunPurger(ctx, trashService, trashPurgeInterval, logger)
+	searchIndex := index.New(dbc, logger)
+	go backfillSearchIndex(ctx, searchIndex, logger)
+	webhookService := webhook.NewService(dbc, httpclient.New(cfg.WebhookAllowPrivate), logger)
+	go webhook.RunDispatcher(ctx, webhookService, webhookDispatchInterval, logger)
+	eventService := events.NewService(dbc, webhookService, logger)
+	providers, err := pushProviders(cfg)
+	if err != nil {
+		logger.WithField("error", err.Error()).Fatal("Invalid push notification configuration")
+	}
+	pushService := push.NewService(dbc, providers, cfg.PushAllowPrivate, logger)
+	pushDone := make(chan struct{})
+	go func() {
+		push.RunSender(ctx, pushService, pushWorkers, logger)
+		close(pushDone)
+	}()
+	notificationService := notification.NewService(dbc, time.Duration(cfg.NotificationRetention)*24*time.Hour, eventService, pushService, logger)
+	go notification.RunCleanup(ctx, notificationService, notificationCleanupInterval, logger)
+
+	digestService := digest.NewService(dbc, newMailer(cfg, logger), signing.Signer, cfg.PublicURL, logger)
+	go digest.RunSender(ctx, digestService, digestInterval, logger)
+
 	address := fmt.Sprintf(":%v", cfg.ServerPort)
 	hs := &http.Server{
 		Addr:    address,
-		Handler: buildHandler(logger, dbcontext.New(db), cfg),
+		Handler: buildHandler(logger, dbc, blobService, quotaService, trashService, searchIndex, eventService, notificationService, webhookService, digestService, pushService, signing, reactions, cfg),
 	}
+	// event streams and live channels never become idle, so they are ended when the shutdown starts
+	hs.RegisterOnShutdown(eventService.Close)
+	hs.RegisterOnShutdown(cancel)
 	go routing.GracefulShutdown(hs, 10*time.Second, logger.Infof)
 	logger.WithFields(logrus.Fields{"verison": Version, "address": address}).Info("Server is running")
 	if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logger.Fatal(err)
 	}
+	// the notifications queued before the shutdown are still pushed
+	<-pushDone
 }
 
 // buildHandler sets up the HTTP routing and builds an HTTP handler.
-func buildHandler(logger *logrus.Logger, db *dbcontext.DB, cfg *config.Config) http.Handler {
+func buildHandler(logger *logrus.Logger, db *dbcontext.DB, blobService blob.Service, quotaService quota.Service, trashService trash.Service, searchIndex index.Index, eventService events.Service, notificationService notification.Service, webhookService webhook.Service, digestService digest.Service, pushService push.Service, signing media.URLSigning, reactions []entity.ReactionType, cfg *config.Config) http.Handler {
 	router := routing.New()
 
 	router.Use(
@@ -79,21 +174,151 @@ func buildHandler(logger *logrus.Logger, db *dbcontext.DB, cfg *config.Config) h
 
 	rg := router.Group("/v1")
 
-	authHandler := auth.Handler(cfg.JWTSigningKey)
+	authHandler := auth.ActiveHandler(cfg.JWTSigningKey, db)
 
 	info.RegisterHandlers(rg.Group(""),
-		info.NewService(logger, db),
+		info.NewService(logger, db, quotaService),
 		authHandler, logger,
 	)
 
 	auth.RegisterHandlers(rg.Group(""),
-		auth.NewService(cfg.JWTSigningKey, cfg.JWTExpiration, db, logger),
+		auth.NewService(cfg.JWTSigningKey, cfg.JWTExpiration, db, notificationService, logger),
 		logger,
 	)
 
+	profile.RegisterHandlers(rg.Group(""), profile.NewService(db, logger), authHandler, auth.OptionalHandler(cfg.JWTSigningKey), logger)
+
+	block.RegisterHandlers(rg.Group(""), block.NewService(db, logger), authHandler, logger)
+
+	feedService := feed.NewService(db, logger)
+
+	albumService := album.NewService(db, searchIndex, feedService, notificationService, eventService, logger)
+	album.RegisterHandlers(rg.Group(""), albumService, authHandler, logger)
+
+	mediaService := media.NewService(db, blobService, albumService, quotaService, searchIndex, feedService, eventService, signing, logger)
+	media.RegisterHandlers(rg.Group(""), mediaService, authHandler, logger)
+
+	share.RegisterHandlers(rg.Group(""),
+		share.NewService(db, albumService, mediaService, signing, logger),
+		authHandler, logger,
+	)
+
+	commentService := comment.NewService(db, albumService, mediaService, searchIndex, feedService, notificationService, eventService, logger)
+	comment.RegisterHandlers(rg.Group(""), commentService, authHandler, logger)
+
+	reaction.RegisterHandlers(rg.Group(""),
+		reaction.NewService(db, mediaService, reactions, notificationService, logger),
+		authHandler, logger,
+	)
+
+	tag.RegisterHandlers(rg.Group(""),
+		tag.NewService(db, albumService, mediaService, searchIndex, logger),
+		authHandler, logger,
+	)
+
+	search.RegisterHandlers(rg.Group(""),
+		search.NewService(searchIndex, albumService, mediaService, commentService, logger),
+		authHandler, logger,
+	)
+
+	feed.RegisterHandlers(rg.Group(""), feedService, authHandler, logger)
+
+	notification.RegisterHandlers(rg.Group(""), notificationService, authHandler, logger)
+
+	events.RegisterHandlers(rg.Group(""), eventService, authHandler, logger)
+
+	webhook.RegisterHandlers(rg.Group(""), webhookService, authHandler, logger)
+
+	digest.RegisterHandlers(rg.Group(""), digestService, authHandler, logger)
+
+	push.RegisterHandlers(rg.Group(""), pushService, authHandler, logger)
+
+	live.RegisterHandlers(rg.Group(""), live.NewService(db, albumService, eventService, logger), eventService, authHandler, logger)
+
+	trash.RegisterHandlers(rg.Group(""), trashService, authHandler, logger)
+
+	quota.RegisterHandlers(rg.Group(""), quotaService, authHandler, auth.AdminHandler(db), logger)
+
+	moderation.RegisterHandlers(rg.Group(""),
+		moderation.NewService(db, albumService, mediaService, notificationService, logger),
+		authHandler, auth.ModeratorHandler(db), logger,
+	)
+
 	return router
 }
 
+// backfillSearchIndex indexes the content that is not in the search index yet, such as content created
+// before the index existed.
+func backfillSearchIndex(ctx context.Context, searchIndex index.Index, logger *logrus.Logger) {
+	n, err := searchIndex.Backfill(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Search index backfill failed")
+	} else if n > 0 {
+		logger.WithField("count", n).Info("Backfilled search index")
+	}
+}
+
+// mediaURLSigning builds the signed media URL configuration. Without explicitly configured keys a key
+// derived from the JWT signing key is used, so existing configurations keep working.
+func mediaURLSigning(cfg *config.Config) (media.URLSigning, error) {
+	keys, err := urlsign.ParseKeys(cfg.MediaURLKeys)
+	if err != nil {
+		return media.URLSigning{}, err
+	}
+	if len(keys) == 0 {
+		mac := hmac.New(sha256.New, []byte(cfg.JWTSigningKey))
+		mac.Write([]byte("shareflow media urls"))
+		keys = []urlsign.Key{{ID: "default", Secret: mac.Sum(nil)}}
+	}
+	signer, err := urlsign.New(keys...)
+	if err != nil {
+		return media.URLSigning{}, err
+	}
+	return media.URLSigning{
+		Signer:     signer,
+		BaseURL:    strings.TrimRight(cfg.PublicURL, "/"),
+		DefaultTTL: time.Duration(cfg.MediaURLTTL) * time.Second,
+		MaxTTL:     time.Duration(cfg.MediaURLMaxTTL) * time.Second,
+	}, nil
+}
+
+// pushProviders returns the providers of the configured push platforms.
+func pushProviders(cfg *config.Config) ([]push.Provider, error) {
+	var providers []push.Provider
+	if cfg.VAPIDPrivateKey != "" {
+		vapid, err := webpush.NewVAPID(cfg.VAPIDPrivateKey, cfg.VAPIDSubject)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, push.WebPush{VAPID: vapid, Client: httpclient.New(cfg.PushAllowPrivate), TTL: 24 * time.Hour})
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.FCMCredentials != "" {
+		fcm, err := push.NewFCM(cfg.FCMCredentials, cfg.FCMEndpoint, client)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, fcm)
+	}
+	if cfg.APNsKeyFile != "" {
+		apns, err := push.NewAPNs(cfg.APNsKeyFile, cfg.APNsKeyID, cfg.APNsTeamID, cfg.APNsTopic, cfg.APNsEndpoint, client)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, apns)
+	}
+	return providers, nil
+}
+
+// newMailer returns the configured SMTP mailer, or a mailer capturing the emails if no SMTP server is configured.
+func newMailer(cfg *config.Config, logger *logrus.Logger) mailer.Mailer {
+	if cfg.SMTPAddr == "" {
+		logger.WithField("dir", cfg.MailCaptureDir).Info("No SMTP server configured, emails are captured")
+		return &mailer.Capture{From: cfg.MailFrom, Dir: cfg.MailCaptureDir}
+	}
+	return mailer.SMTP{Addr: cfg.SMTPAddr, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword, From: cfg.MailFrom}
+}
+
 func logDBQuery(logger *logrus.Logger) dbx.QueryLogFunc {
 	return func(ctx context.Context, t time.Duration, query string, rows *sql.Rows, err error) {
 		if err == nil {
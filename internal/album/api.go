@@ -0,0 +1,173 @@
+package album
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the album handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/albums", res.list)
+	r.Post("/albums", res.create)
+	r.Get("/albums/<id>", res.get)
+	r.Patch("/albums/<id>", res.update)
+	r.Delete("/albums/<id>", res.delete)
+
+	r.Get("/albums/<id>/members", res.members)
+	r.Patch("/albums/<id>/members/<user>", res.updateMember)
+	r.Delete("/albums/<id>/members/<user>", res.removeMember)
+	r.Get("/albums/<id>/invitations", res.invitations)
+	r.Post("/albums/<id>/invitations", res.invite)
+	r.Delete("/albums/<id>/invitations/<invitation>", res.revokeInvitation)
+	r.Get("/me/invitations", res.myInvitations)
+	r.Post("/me/invitations/<invitation>/accept", res.respondInvitation(true))
+	r.Post("/me/invitations/<invitation>/decline", res.respondInvitation(false))
+}
+
+func (r resource) get(c *routing.Context) error {
+	album, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(album)
+}
+
+func (r resource) list(c *routing.Context) error {
+	albums, err := r.service.List(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(albums)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var req CreateAlbumRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	album, err := r.service.Create(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(album, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var req UpdateAlbumRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	album, err := r.service.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(album)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	if err := r.service.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) members(c *routing.Context) error {
+	members, err := r.service.Members(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(members)
+}
+
+func (r resource) updateMember(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		return errors.NotFound("")
+	}
+	var req struct {
+		Role entity.Role `json:"role"`
+	}
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	if err := r.service.UpdateMember(c.Request.Context(), c.Param("id"), userID, req.Role); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) removeMember(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("user"))
+	if err != nil {
+		return errors.NotFound("")
+	}
+	if err := r.service.RemoveMember(c.Request.Context(), c.Param("id"), userID); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) invitations(c *routing.Context) error {
+	invitations, err := r.service.Invitations(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(invitations)
+}
+
+func (r resource) invite(c *routing.Context) error {
+	var req InviteRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	invitation, err := r.service.Invite(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(invitation, http.StatusCreated)
+}
+
+func (r resource) revokeInvitation(c *routing.Context) error {
+	if err := r.service.RevokeInvitation(c.Request.Context(), c.Param("id"), c.Param("invitation")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) myInvitations(c *routing.Context) error {
+	invitations, err := r.service.MyInvitations(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(invitations)
+}
+
+func (r resource) respondInvitation(accept bool) routing.Handler {
+	return func(c *routing.Context) error {
+		if err := r.service.RespondInvitation(c.Request.Context(), c.Param("invitation"), accept); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
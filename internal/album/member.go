@@ -0,0 +1,354 @@
+package album
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+// InviteRequest represents an album invitation request. Exactly one of UserID and Email has to be set.
+type InviteRequest struct {
+	UserID *int        `json:"user_id"`
+	Email  string      `json:"email"`
+	Role   entity.Role `json:"role"`
+}
+
+// Validate validates the InviteRequest fields.
+func (m InviteRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.UserID, validation.When(m.Email == "", validation.Required), validation.When(m.Email != "", validation.Nil.Error("must be blank when email is set"))),
+		validation.Field(&m.Email, is.EmailFormat, validation.Length(0, 255)),
+		validation.Field(&m.Role, validation.Required, validation.By(validRole)),
+	)
+}
+
+func validRole(value interface{}) error {
+	if role, _ := value.(entity.Role); !role.Valid() {
+		return stderrors.New("must be one of viewer, contributor, editor or co-owner")
+	}
+	return nil
+}
+
+// canManage reports whether a member with the given role may grant or take away the other role.
+// Managing co-owners is reserved to the album owner.
+func canManage(role, other entity.Role) bool {
+	if other == entity.RoleCoOwner {
+		return role == entity.RoleOwner
+	}
+	return role.AtLeast(entity.RoleCoOwner)
+}
+
+func (s service) Members(ctx context.Context, albumID string) ([]entity.AlbumMember, error) {
+	a, err := s.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	// the members of public albums are not public, as the list holds their email addresses
+	if user := auth.CurrentUser(ctx).GetID(); user != a.OwnerID {
+		if role, err := s.member(ctx, a.ID, user); err != nil || role == "" {
+			if err == nil {
+				err = errors.Forbidden("only members can list the members of an album")
+			}
+			return nil, err
+		}
+	}
+	members := []entity.AlbumMember{}
+	err = s.db.With(ctx).NewQuery("SELECT a.id AS album_id, u.id AS user_id, 'owner' AS role, u.first_name, u.last_name, u.email, a.created_at " +
+		"FROM albums a JOIN users u ON u.id = a.owner_id WHERE a.id = {:album} " +
+		"UNION ALL SELECT am.album_id, am.user_id, am.role, u.first_name, u.last_name, u.email, am.created_at " +
+		"FROM album_members am JOIN users u ON u.id = am.user_id WHERE am.album_id = {:album} ORDER BY created_at").
+		Bind(dbx.Params{"album": a.ID}).
+		All(&members)
+	return members, err
+}
+
+// member returns the role of a member of the album, or an empty role if the user is not a member.
+func (s service) member(ctx context.Context, albumID string, userID int) (entity.Role, error) {
+	var role entity.Role
+	err := s.db.With(ctx).NewQuery("SELECT role FROM album_members WHERE album_id={:album} AND user_id={:user}").
+		Bind(dbx.Params{"album": albumID, "user": userID}).
+		Row(&role)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+func (s service) UpdateMember(ctx context.Context, albumID string, userID int, role entity.Role) error {
+	if err := validation.Validate(role, validation.Required, validation.By(validRole)); err != nil {
+		return errors.BadRequest("invalid role: " + err.Error())
+	}
+	a, err := s.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return err
+	}
+	current, err := s.member(ctx, a.ID, userID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return errors.NotFound("the user is not a member of this album")
+	}
+	if !canManage(a.Role, current) || !canManage(a.Role, role) {
+		return errors.Forbidden("only the album owner can manage co-owners")
+	}
+	_, err = s.db.With(ctx).NewQuery("UPDATE album_members SET role={:role} WHERE album_id={:album} AND user_id={:user}").
+		Bind(dbx.Params{"album": a.ID, "user": userID, "role": role}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	return s.events.PublishAlbum(ctx, a.ID, events.TypeAlbumUpdated, events.AlbumChange{AlbumID: a.ID})
+}
+
+func (s service) RemoveMember(ctx context.Context, albumID string, userID int) error {
+	required := entity.RoleCoOwner
+	if userID == auth.CurrentUser(ctx).GetID() {
+		required = entity.RoleViewer
+	}
+	a, err := s.Authorize(ctx, albumID, required)
+	if err != nil {
+		return err
+	}
+	if userID == a.OwnerID {
+		return errors.BadRequest("the album owner cannot be removed")
+	}
+	current, err := s.member(ctx, a.ID, userID)
+	if err != nil {
+		return err
+	}
+	if current == "" {
+		return errors.NotFound("the user is not a member of this album")
+	}
+	if required != entity.RoleViewer && !canManage(a.Role, current) {
+		return errors.Forbidden("only the album owner can manage co-owners")
+	}
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		// the event is addressed before the removal, so the removed member learns about it too
+		if err := s.events.PublishAlbum(ctx, a.ID, events.TypeAlbumUpdated, events.AlbumChange{AlbumID: a.ID}); err != nil {
+			return err
+		}
+		_, err := s.db.With(ctx).NewQuery("DELETE FROM album_members WHERE album_id={:album} AND user_id={:user}").
+			Bind(dbx.Params{"album": a.ID, "user": userID}).
+			Execute()
+		return err
+	})
+}
+
+func (s service) Invite(ctx context.Context, albumID string, req InviteRequest) (entity.AlbumInvitation, error) {
+	if err := req.Validate(); err != nil {
+		return entity.AlbumInvitation{}, err
+	}
+	a, err := s.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return entity.AlbumInvitation{}, err
+	}
+	if !canManage(a.Role, req.Role) {
+		return entity.AlbumInvitation{}, errors.Forbidden("only the album owner can invite co-owners")
+	}
+	// the content of a smart album depends on the albums its owner can access, so it is only shared
+	// through share links, which are limited to the albums the owner manages
+	if a.Rules != nil {
+		return entity.AlbumInvitation{}, errors.BadRequest("smart albums cannot have members, use a share link instead")
+	}
+
+	inv := entity.AlbumInvitation{
+		ID:           entity.GenerateID(),
+		AlbumID:      a.ID,
+		AlbumName:    a.Name,
+		InviterID:    auth.CurrentUser(ctx).GetID(),
+		InviteeEmail: strings.ToLower(strings.TrimSpace(req.Email)),
+		Role:         req.Role,
+		Status:       entity.InvitationPending,
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+	// resolve the invitee; email invitations of people without an account wait until they register
+	var user entity.User
+	q := s.db.With(ctx).NewQuery("SELECT id, email FROM users WHERE email={:email}").Bind(dbx.Params{"email": inv.InviteeEmail})
+	if req.UserID != nil {
+		q = s.db.With(ctx).NewQuery("SELECT id, email FROM users WHERE id={:id}").Bind(dbx.Params{"id": *req.UserID})
+	}
+	switch err := q.Row(&user.ID, &user.Email); {
+	case err == nil:
+		inv.InviteeID, inv.InviteeEmail = &user.ID, strings.ToLower(user.Email)
+	case stderrors.Is(err, sql.ErrNoRows) && req.UserID == nil:
+	case stderrors.Is(err, sql.ErrNoRows):
+		return entity.AlbumInvitation{}, errors.NotFound("user not found")
+	default:
+		return entity.AlbumInvitation{}, err
+	}
+
+	if inv.InviteeID != nil {
+		if *inv.InviteeID == a.OwnerID {
+			return entity.AlbumInvitation{}, errors.BadRequest("the user already owns this album")
+		}
+		if blocked, err := block.Blocked(ctx, s.db, *inv.InviteeID, inv.InviterID, a.OwnerID); err != nil {
+			return entity.AlbumInvitation{}, err
+		} else if blocked {
+			return entity.AlbumInvitation{}, errors.Forbidden("the user cannot be invited")
+		}
+		if role, err := s.member(ctx, a.ID, *inv.InviteeID); err != nil {
+			return entity.AlbumInvitation{}, err
+		} else if role != "" {
+			return entity.AlbumInvitation{}, errors.BadRequest("the user is already a member of this album")
+		}
+	}
+	var pending int
+	if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM album_invitations WHERE album_id={:album} AND status={:status} AND invitee_email={:email}").
+		Bind(dbx.Params{"album": a.ID, "status": entity.InvitationPending, "email": inv.InviteeEmail}).
+		Row(&pending); err != nil {
+		return entity.AlbumInvitation{}, err
+	}
+	if pending > 0 {
+		return entity.AlbumInvitation{}, errors.BadRequest("the user has already been invited")
+	}
+
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Insert("album_invitations", dbx.Params{
+			"id":            inv.ID,
+			"album_id":      inv.AlbumID,
+			"inviter_id":    inv.InviterID,
+			"invitee_id":    inv.InviteeID,
+			"invitee_email": inv.InviteeEmail,
+			"role":          inv.Role,
+			"status":        inv.Status,
+			"created_at":    inv.CreatedAt,
+		}).Execute(); err != nil {
+			return err
+		}
+		if inv.InviteeID == nil {
+			return nil
+		}
+		return s.notify.Notify(ctx, entity.Notification{Type: entity.NotificationInvitation, AlbumID: &inv.AlbumID, Detail: string(inv.Role)}, *inv.InviteeID)
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Invitation creation failed")
+		return entity.AlbumInvitation{}, errors.InternalServerError("")
+	}
+	return inv, nil
+}
+
+// selectInvitation selects invitations together with the name of their album.
+const selectInvitation = "SELECT i.*, a.name AS album_name FROM album_invitations i JOIN albums a ON a.id = i.album_id "
+
+func (s service) Invitations(ctx context.Context, albumID string) ([]entity.AlbumInvitation, error) {
+	a, err := s.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return nil, err
+	}
+	invitations := []entity.AlbumInvitation{}
+	err = s.db.With(ctx).NewQuery(selectInvitation + "WHERE i.album_id={:album} ORDER BY i.created_at DESC").
+		Bind(dbx.Params{"album": a.ID}).
+		All(&invitations)
+	return invitations, err
+}
+
+func (s service) RevokeInvitation(ctx context.Context, albumID, invitationID string) error {
+	a, err := s.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.With(ctx).NewQuery("UPDATE album_invitations SET status={:revoked}, responded_at={:now} WHERE id={:id} AND album_id={:album} AND status={:pending}").
+		Bind(dbx.Params{
+			"id":      invitationID,
+			"album":   a.ID,
+			"revoked": entity.InvitationRevoked,
+			"pending": entity.InvitationPending,
+			"now":     time.Now().UTC().Truncate(time.Second),
+		}).Execute()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.NotFound("")
+	}
+	return nil
+}
+
+// inviteeCondition matches the invitations addressed to the user bound to the "user" and "email" parameters.
+// Invitations to albums in the trash are excluded.
+const inviteeCondition = "(i.invitee_id = {:user} OR (i.invitee_id IS NULL AND i.invitee_email = {:email})) AND a.deleted_at IS NULL AND a.hidden_at IS NULL"
+
+func (s service) MyInvitations(ctx context.Context) ([]entity.AlbumInvitation, error) {
+	user := auth.CurrentUser(ctx)
+	invitations := []entity.AlbumInvitation{}
+	err := s.db.With(ctx).NewQuery(selectInvitation + "WHERE " + inviteeCondition + " AND i.status={:pending} ORDER BY i.created_at DESC").
+		Bind(dbx.Params{"user": user.GetID(), "email": strings.ToLower(user.GetEmail()), "pending": entity.InvitationPending}).
+		All(&invitations)
+	return invitations, err
+}
+
+func (s service) RespondInvitation(ctx context.Context, invitationID string, accept bool) error {
+	user := auth.CurrentUser(ctx)
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		var inv entity.AlbumInvitation
+		err := s.db.With(ctx).NewQuery(selectInvitation + "WHERE i.id={:id} AND " + inviteeCondition + " AND i.status={:pending} FOR UPDATE").
+			Bind(dbx.Params{"id": invitationID, "user": user.GetID(), "email": strings.ToLower(user.GetEmail()), "pending": entity.InvitationPending}).
+			One(&inv)
+		if stderrors.Is(err, sql.ErrNoRows) {
+			return errors.NotFound("")
+		}
+		if err != nil {
+			return err
+		}
+
+		status := entity.InvitationDeclined
+		if accept {
+			status = entity.InvitationAccepted
+			var owner int
+			if err := s.db.With(ctx).NewQuery("SELECT owner_id FROM albums WHERE id={:id} FOR UPDATE").
+				Bind(dbx.Params{"id": inv.AlbumID}).
+				Row(&owner); err != nil {
+				return err
+			}
+			if blocked, err := block.Blocked(ctx, s.db, user.GetID(), inv.InviterID, owner); err != nil {
+				return err
+			} else if blocked {
+				return errors.Forbidden("the invitation can no longer be accepted")
+			}
+			// the inviter may have lost the right to grant the role since the invitation was sent
+			inviter := entity.RoleOwner
+			if inv.InviterID != owner {
+				if inviter, err = s.member(ctx, inv.AlbumID, inv.InviterID); err != nil {
+					return err
+				}
+			}
+			if !canManage(inviter, inv.Role) {
+				return errors.Forbidden("the invitation can no longer be accepted")
+			}
+			// a stale invitation never changes the role of a member
+			role, err := s.member(ctx, inv.AlbumID, user.GetID())
+			if err != nil {
+				return err
+			}
+			if role == "" && user.GetID() != owner {
+				if _, err := s.db.With(ctx).NewQuery("INSERT INTO album_members (album_id, user_id, role, added_by, created_at) " +
+					"VALUES ({:album}, {:user}, {:role}, {:inviter}, {:now})").
+					Bind(dbx.Params{
+						"album":   inv.AlbumID,
+						"user":    user.GetID(),
+						"role":    inv.Role,
+						"inviter": inv.InviterID,
+						"now":     time.Now().UTC().Truncate(time.Second),
+					}).Execute(); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = s.db.With(ctx).NewQuery("UPDATE album_invitations SET status={:status}, invitee_id={:user}, responded_at={:now} WHERE id={:id}").
+			Bind(dbx.Params{"id": inv.ID, "status": status, "user": user.GetID(), "now": time.Now().UTC().Truncate(time.Second)}).
+			Execute()
+		return err
+	})
+}
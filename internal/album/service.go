@@ -0,0 +1,387 @@
+package album
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/feed"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/notification"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the album management logic.
+type Service interface {
+	// Get returns the album with the given ID together with its tags if the current user can view it.
+	Get(ctx context.Context, id string) (entity.Album, error)
+	// Authorize returns the album with the given ID if the current user holds at least the given role in it.
+	// Every user holds the viewer role in public albums, unless the owner blocked them. A Forbidden error is returned both for missing albums and for insufficient roles, so that the
+	// existence of an album is not revealed to users without access.
+	Authorize(ctx context.Context, id string, role entity.Role) (entity.Album, error)
+	// GetAll returns the albums with the given IDs that the current user owns or is a member of, together
+	// with their tags. Other albums are left out, and the order of the albums is unspecified.
+	GetAll(ctx context.Context, ids []string) ([]entity.Album, error)
+	// List returns the albums owned by the current user and the albums the user is a member of, together
+	// with their tags.
+	List(ctx context.Context) ([]entity.Album, error)
+	// Create creates a new album owned by the current user.
+	Create(ctx context.Context, req CreateAlbumRequest) (entity.Album, error)
+	// Update updates the album with the given ID.
+	Update(ctx context.Context, id string, req UpdateAlbumRequest) (entity.Album, error)
+	// Delete moves the album with the given ID to the trash of its owner.
+	Delete(ctx context.Context, id string) error
+
+	// Members returns the owner and the members of an album. Only the owner and the members can list them.
+	Members(ctx context.Context, albumID string) ([]entity.AlbumMember, error)
+	// UpdateMember changes the role of an album member.
+	UpdateMember(ctx context.Context, albumID string, userID int, role entity.Role) error
+	// RemoveMember removes a member from an album. Members may always remove themselves.
+	RemoveMember(ctx context.Context, albumID string, userID int) error
+	// Invite invites a registered user, identified by user ID or email, to become an album member.
+	Invite(ctx context.Context, albumID string, req InviteRequest) (entity.AlbumInvitation, error)
+	// Invitations returns the invitations of an album.
+	Invitations(ctx context.Context, albumID string) ([]entity.AlbumInvitation, error)
+	// RevokeInvitation revokes a pending invitation of an album.
+	RevokeInvitation(ctx context.Context, albumID, invitationID string) error
+	// MyInvitations returns the pending invitations of the current user.
+	MyInvitations(ctx context.Context) ([]entity.AlbumInvitation, error)
+	// RespondInvitation accepts or declines a pending invitation of the current user. An invitation can only
+	// be accepted while the inviter may still grant its role and no block separates the user from the
+	// inviter or the album owner. Accepting an invitation to an album the user already belongs to leaves
+	// their role unchanged.
+	RespondInvitation(ctx context.Context, invitationID string, accept bool) error
+}
+
+// CreateAlbumRequest represents an album creation request. Giving rules creates a smart album.
+type CreateAlbumRequest struct {
+	Name         string             `json:"name"`
+	Description  string             `json:"description"`
+	KeepLocation bool               `json:"keep_location"`
+	Public       bool               `json:"public"`
+	Rules        *entity.SmartRules `json:"rules"`
+}
+
+// Validate validates the CreateAlbumRequest fields.
+func (m CreateAlbumRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(0, 255)),
+	)
+}
+
+// UpdateAlbumRequest represents an album update request. Fields left nil are not changed.
+type UpdateAlbumRequest struct {
+	Name         *string          `json:"name"`
+	Description  *string          `json:"description"`
+	KeepLocation *bool            `json:"keep_location"`
+	Public       *bool            `json:"public"`
+	SortMode     *entity.SortMode `json:"sort_mode"`
+	// CoverMediaID selects the cover media item. An empty ID restores the automatic cover.
+	CoverMediaID *string `json:"cover_media_id"`
+	// Rules replaces the rules of a smart album. Regular albums cannot be turned into smart albums.
+	Rules *entity.SmartRules `json:"rules"`
+}
+
+// Validate validates the UpdateAlbumRequest fields.
+func (m UpdateAlbumRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.NilOrNotEmpty, validation.Length(0, 255)),
+		validation.Field(&m.SortMode, validation.In(entity.SortManual, entity.SortCaptured, entity.SortUploaded, entity.SortName)),
+	)
+}
+
+type service struct {
+	db     *dbcontext.DB
+	index  index.Index
+	feed   feed.Publisher
+	notify notification.Publisher
+	events events.Publisher
+	logger *logrus.Logger
+}
+
+// NewService creates a new album service that keeps the given search index up to date, publishes
+// created albums to the feeds of the followers of their owner, notifies invited users and sends album
+// changes to the event streams of the album members.
+func NewService(db *dbcontext.DB, index index.Index, feed feed.Publisher, notify notification.Publisher, events events.Publisher, logger *logrus.Logger) Service {
+	return service{db, index, feed, notify, events, logger}
+}
+
+func (s service) Get(ctx context.Context, id string) (entity.Album, error) {
+	album, err := s.Authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return album, err
+	}
+	albums := []entity.Album{album}
+	err = s.loadTags(ctx, albums)
+	return albums[0], err
+}
+
+// selectAlbum selects the albums that are not in the trash together with their cover and the role of the
+// user bound to the "user" parameter, restricted to the albums the user can view. Users who are not members
+// of a public album view it as viewers. A cover that was deleted or moved to the trash falls back to the
+// first media item by capture time.
+var selectAlbum = "SELECT a.*, IF(a.owner_id = {:user}, 'owner', COALESCE(am.role, IF(a.`public`, 'viewer', ''))) AS role, " +
+	"COALESCE((SELECT c.id FROM media c WHERE c.id = a.cover_media_id AND c.album_id = a.id AND c.deleted_at IS NULL AND c.hidden_at IS NULL), " +
+	"(SELECT f.id FROM media f LEFT JOIN media_metadata fm ON fm.media_id = f.id WHERE f.album_id = a.id AND f.deleted_at IS NULL AND f.hidden_at IS NULL " +
+	"ORDER BY COALESCE(fm.captured_at, f.created_at), f.id LIMIT 1)) AS cover FROM albums a " +
+	block.MemberJoin("{:user}") + "WHERE " + block.AlbumVisible("{:user}") + " "
+
+func (s service) Authorize(ctx context.Context, id string, role entity.Role) (entity.Album, error) {
+	var album entity.Album
+	err := s.db.With(ctx).NewQuery(selectAlbum + "AND a.id={:id}").
+		Bind(dbx.Params{"id": id, "user": auth.CurrentUser(ctx).GetID()}).
+		One(&album)
+	if stderrors.Is(err, sql.ErrNoRows) || (err == nil && !album.Role.AtLeast(role)) {
+		return entity.Album{}, errors.Forbidden("")
+	}
+	return album, err
+}
+
+func (s service) GetAll(ctx context.Context, ids []string) ([]entity.Album, error) {
+	albums := []entity.Album{}
+	if len(ids) == 0 {
+		return albums, nil
+	}
+	params := dbx.Params{"user": auth.CurrentUser(ctx).GetID()}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		name := fmt.Sprintf("id%d", i)
+		params[name], placeholders[i] = id, "{:"+name+"}"
+	}
+	err := s.db.With(ctx).NewQuery(selectAlbum + "AND a.id IN (" + strings.Join(placeholders, ", ") + ")").
+		Bind(params).
+		All(&albums)
+	if err != nil {
+		return nil, err
+	}
+	return albums, s.loadTags(ctx, albums)
+}
+
+func (s service) List(ctx context.Context) ([]entity.Album, error) {
+	albums := []entity.Album{}
+	err := s.db.With(ctx).NewQuery(selectAlbum + "AND (a.owner_id={:user} OR am.user_id IS NOT NULL) ORDER BY a.created_at DESC").
+		Bind(dbx.Params{"user": auth.CurrentUser(ctx).GetID()}).
+		All(&albums)
+	if err != nil {
+		return nil, err
+	}
+	return albums, s.loadTags(ctx, albums)
+}
+
+func (s service) Create(ctx context.Context, req CreateAlbumRequest) (entity.Album, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Album{}, err
+	}
+	if req.Rules != nil {
+		if err := s.validateRules(ctx, req.Rules); err != nil {
+			return entity.Album{}, err
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	album := entity.Album{
+		ID:           entity.GenerateID(),
+		OwnerID:      auth.CurrentUser(ctx).GetID(),
+		Name:         req.Name,
+		Description:  req.Description,
+		KeepLocation: req.KeepLocation,
+		Public:       req.Public,
+		Rules:        req.Rules,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		SortMode:     entity.SortCaptured,
+		Role:         entity.RoleOwner,
+	}
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		_, err := s.db.With(ctx).NewQuery("INSERT INTO albums (id, owner_id, name, description, keep_location, `public`, rules, created_at, updated_at) VALUES ({:id}, {:owner}, {:name}, {:description}, {:keep_location}, {:public}, {:rules}, {:created_at}, {:updated_at})").
+			Bind(dbx.Params{
+				"id":            album.ID,
+				"owner":         album.OwnerID,
+				"name":          album.Name,
+				"description":   album.Description,
+				"keep_location": album.KeepLocation,
+				"public":        album.Public,
+				"rules":         album.Rules,
+				"created_at":    album.CreatedAt,
+				"updated_at":    album.UpdatedAt,
+			}).Execute()
+		if err != nil {
+			return err
+		}
+		if err := s.index.IndexAlbum(ctx, album.ID); err != nil {
+			return err
+		}
+		return s.feed.Publish(ctx, entity.Activity{Type: entity.ActivityAlbumCreated, AlbumID: album.ID})
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Album creation failed")
+		return entity.Album{}, errors.InternalServerError("")
+	}
+	return album, nil
+}
+
+func (s service) Update(ctx context.Context, id string, req UpdateAlbumRequest) (entity.Album, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Album{}, err
+	}
+	album, err := s.Authorize(ctx, id, entity.RoleEditor)
+	if err != nil {
+		return album, err
+	}
+	// serving location data publicly is a sharing decision, so it is reserved to the owners
+	if req.KeepLocation != nil && *req.KeepLocation != album.KeepLocation && !album.Role.AtLeast(entity.RoleCoOwner) {
+		return entity.Album{}, errors.Forbidden("only album owners can change the location sharing setting")
+	}
+	if req.Public != nil && *req.Public != album.Public && album.Role != entity.RoleOwner {
+		return entity.Album{}, errors.Forbidden("only the album owner can list the album on their profile")
+	}
+	if req.Name != nil {
+		album.Name = *req.Name
+	}
+	if req.Description != nil {
+		album.Description = *req.Description
+	}
+	if req.KeepLocation != nil {
+		album.KeepLocation = *req.KeepLocation
+	}
+	if req.Public != nil {
+		album.Public = *req.Public
+	}
+	if req.SortMode != nil {
+		album.SortMode = *req.SortMode
+	}
+	if req.Rules != nil {
+		if album.Rules == nil {
+			return entity.Album{}, errors.BadRequest("only smart albums have rules")
+		}
+		// the rules decide which content is shared through the album
+		if !album.Role.AtLeast(entity.RoleCoOwner) {
+			return entity.Album{}, errors.Forbidden("only album owners can change the rules")
+		}
+		if err := s.validateRules(ctx, req.Rules); err != nil {
+			return entity.Album{}, err
+		}
+		album.Rules = req.Rules
+	}
+	album.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		_, err := s.db.With(ctx).NewQuery("UPDATE albums SET name={:name}, description={:description}, keep_location={:keep_location}, `public`={:public}, sort_mode={:sort_mode}, rules={:rules}, updated_at={:updated_at} WHERE id={:id}").
+			Bind(dbx.Params{
+				"id":            album.ID,
+				"name":          album.Name,
+				"description":   album.Description,
+				"keep_location": album.KeepLocation,
+				"public":        album.Public,
+				"sort_mode":     album.SortMode,
+				"rules":         album.Rules,
+				"updated_at":    album.UpdatedAt,
+			}).Execute()
+		if err != nil {
+			return err
+		}
+		if req.CoverMediaID != nil {
+			if err := s.setCover(ctx, album.ID, *req.CoverMediaID); err != nil {
+				return err
+			}
+		}
+		if err := s.index.IndexAlbum(ctx, album.ID); err != nil {
+			return err
+		}
+		return s.events.PublishAlbum(ctx, album.ID, events.TypeAlbumUpdated, events.AlbumChange{AlbumID: album.ID})
+	})
+	if _, ok := err.(errors.ErrorResponse); ok {
+		return entity.Album{}, err
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Album update failed")
+		return entity.Album{}, errors.InternalServerError("")
+	}
+	// the cover is resolved by the query
+	return s.Get(ctx, album.ID)
+}
+
+// maxRuleTags is the maximum number of tags of smart album rules.
+const maxRuleTags = 20
+
+// validateRules validates the rules of a smart album and normalizes their tags. The source album has to
+// be a regular album the current user can view.
+func (s service) validateRules(ctx context.Context, r *entity.SmartRules) error {
+	if r.Empty() {
+		return errors.BadRequest("smart albums need at least one rule")
+	}
+	var tags []string
+	for _, t := range r.Tags {
+		t = entity.NormalizeTag(t)
+		if t == "" {
+			return errors.BadRequest("rule tags must not be empty")
+		}
+		if !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	if len(tags) > maxRuleTags {
+		return errors.BadRequest(fmt.Sprintf("smart albums are limited to %d tags", maxRuleTags))
+	}
+	r.Tags = tags
+	if r.TakenAfter != nil && r.TakenBefore != nil && !r.TakenAfter.Before(*r.TakenBefore) {
+		return errors.BadRequest("taken_after has to precede taken_before")
+	}
+	if r.SourceAlbumID != "" {
+		source, err := s.Authorize(ctx, r.SourceAlbumID, entity.RoleViewer)
+		if err != nil {
+			return errors.BadRequest("the source album does not exist")
+		}
+		if source.Rules != nil {
+			return errors.BadRequest("the source album cannot be a smart album")
+		}
+	}
+	return nil
+}
+
+// setCover selects the cover of an album. The media item has to belong to the album and must be neither
+// in the trash nor hidden by moderators. An empty media ID restores the automatic cover.
+func (s service) setCover(ctx context.Context, albumID, mediaID string) error {
+	var cover *string
+	if mediaID != "" {
+		var n int
+		if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM media WHERE id={:media} AND album_id={:album} AND deleted_at IS NULL AND hidden_at IS NULL").
+			Bind(dbx.Params{"media": mediaID, "album": albumID}).
+			Row(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return errors.BadRequest("the cover has to be a media item of the album")
+		}
+		cover = &mediaID
+	}
+	_, err := s.db.With(ctx).NewQuery("UPDATE albums SET cover_media_id={:cover} WHERE id={:id}").
+		Bind(dbx.Params{"id": albumID, "cover": cover}).
+		Execute()
+	return err
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	album, err := s.Authorize(ctx, id, entity.RoleOwner)
+	if err != nil {
+		return err
+	}
+	// the media items stay untouched, so restoring the album brings them back as they were
+	_, err = s.db.With(ctx).NewQuery("UPDATE albums SET deleted_at={:now}, deleted_by={:user} WHERE id={:id}").
+		Bind(dbx.Params{"id": album.ID, "user": auth.CurrentUser(ctx).GetID(), "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	return s.events.PublishAlbum(ctx, album.ID, events.TypeAlbumDeleted, events.AlbumChange{AlbumID: album.ID})
+}
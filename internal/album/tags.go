@@ -0,0 +1,36 @@
+package album
+
+import (
+	"context"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// loadTags fills in the tags of the given albums with a single query.
+func (s service) loadTags(ctx context.Context, albums []entity.Album) error {
+	if len(albums) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(albums))
+	index := map[string]int{}
+	for i := range albums {
+		ids[i] = albums[i].ID
+		index[albums[i].ID] = i
+	}
+	var tags []struct {
+		AlbumID string
+		Tag     string
+	}
+	if err := s.db.With(ctx).Select("album_id", "tag").From("album_tags").
+		Where(dbx.In("album_id", ids...)).
+		OrderBy("tag").
+		All(&tags); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		a := &albums[index[t.AlbumID]]
+		a.Tags = append(a.Tags, t.Tag)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"net"
 	"net/http"
 
 	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
@@ -26,7 +27,11 @@ func login(service Service, logger *logrus.Logger) routing.Handler {
 			return errors.BadRequest("")
 		}
 
-		token, err := service.Login(c.Request.Context(), req.Email, req.Password)
+		ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			ip = c.Request.RemoteAddr
+		}
+		token, err := service.Login(c.Request.Context(), req.Email, req.Password, ip)
 		if err != nil {
 			return err
 		}
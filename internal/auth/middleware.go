@@ -2,9 +2,15 @@ package auth
 
 import (
 	"context"
+	"database/sql"
+	stderrors "errors"
 	"fmt"
+	"time"
 
 	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
 	routing "github.com/go-ozzo/ozzo-routing/v2"
 	"github.com/go-ozzo/ozzo-routing/v2/auth"
 	"github.com/golang-jwt/jwt"
@@ -15,6 +21,107 @@ func Handler(verificationKey string) routing.Handler {
 	return auth.JWT(verificationKey, auth.JWTOptions{TokenHandler: handleToken})
 }
 
+// ActiveHandler returns a JWT-based authentication middleware that also rejects suspended users. The
+// suspension is read from the database on every request, so it takes effect immediately rather than when
+// the token expires.
+func ActiveHandler(verificationKey string, db *dbcontext.DB) routing.Handler {
+	handler := Handler(verificationKey)
+	return func(c *routing.Context) error {
+		if err := handler(c); err != nil {
+			return err
+		}
+		return checkSuspension(c.Request.Context(), db, CurrentUser(c.Request.Context()).GetID())
+	}
+}
+
+// checkSuspension returns a Forbidden error if the user is suspended.
+func checkSuspension(ctx context.Context, db *dbcontext.DB, userID int) error {
+	var suspension struct {
+		SuspendedAt    *time.Time
+		SuspendedUntil *time.Time
+	}
+	err := db.With(ctx).NewQuery("SELECT suspended_at, suspended_until FROM users WHERE id={:id}").
+		Bind(dbx.Params{"id": userID}).
+		One(&suspension)
+	if err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	switch {
+	case suspension.SuspendedAt == nil:
+		return nil
+	case suspension.SuspendedUntil == nil:
+		return errors.Forbidden("your account is suspended")
+	case suspension.SuspendedUntil.After(time.Now()):
+		return errors.Forbidden("your account is suspended until " + suspension.SuspendedUntil.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// OptionalHandler returns a JWT-based authentication middleware for public routes, which identifies the
+// user if the request carries a token and lets anonymous requests through.
+func OptionalHandler(verificationKey string) routing.Handler {
+	handler := Handler(verificationKey)
+	return func(c *routing.Context) error {
+		if c.Request.Header.Get("Authorization") == "" {
+			return nil
+		}
+		return handler(c)
+	}
+}
+
+// QueryTokenHandler is a middleware that moves a token from the access_token query parameter to the
+// Authorization header. It serves browser APIs that cannot send headers, such as EventSource and
+// WebSocket, and has to run before the authentication handler.
+func QueryTokenHandler(c *routing.Context) error {
+	if token := c.Query("access_token"); token != "" && c.Request.Header.Get("Authorization") == "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// AdminHandler returns a middleware that only lets users flagged as admins through. It has to run after
+// the authentication handler. The flag is read from the database on every request, so revoking admin
+// rights takes effect immediately rather than when the token expires.
+func AdminHandler(db *dbcontext.DB) routing.Handler {
+	return func(c *routing.Context) error {
+		user := CurrentUser(c.Request.Context())
+		if user == nil {
+			return errors.Unauthorized("")
+		}
+		var admin bool
+		if err := db.With(c.Request.Context()).NewQuery("SELECT is_admin FROM users WHERE id={:id}").
+			Bind(dbx.Params{"id": user.GetID()}).
+			Row(&admin); err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if !admin {
+			return errors.Forbidden("")
+		}
+		return nil
+	}
+}
+
+// ModeratorHandler returns a middleware that only lets moderators and admins through. It has to run after
+// the authentication handler and, like AdminHandler, reads the flags from the database on every request.
+func ModeratorHandler(db *dbcontext.DB) routing.Handler {
+	return func(c *routing.Context) error {
+		user := CurrentUser(c.Request.Context())
+		if user == nil {
+			return errors.Unauthorized("")
+		}
+		var moderator bool
+		if err := db.With(c.Request.Context()).NewQuery("SELECT is_admin OR is_moderator FROM users WHERE id={:id}").
+			Bind(dbx.Params{"id": user.GetID()}).
+			Row(&moderator); err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if !moderator {
+			return errors.Forbidden("")
+		}
+		return nil
+	}
+}
+
 // handleToken stores the user identity in the request context so that it can be accessed elsewhere.
 func handleToken(c *routing.Context, token *jwt.Token) error {
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
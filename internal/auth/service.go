@@ -2,7 +2,10 @@ package auth
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"math/rand/v2"
+	"strings"
 	"time"
 
 	"github.com/MrPomajdor/ShareFlowAPI/internal/crypt"
@@ -11,6 +14,7 @@ import (
 	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
 	"github.com/dgrijalva/jwt-go"
 	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,34 +22,64 @@ import (
 type Service interface {
 	// authenticate authenticates a user using username and password.
 	// It returns a JWT token if authentication succeeds. Otherwise, an error is returned.
-	Login(ctx context.Context, email, password string) (string, error)
+	// The user receives a security alert when signing in from an address other than the last one.
+	Login(ctx context.Context, email, password, ip string) (string, error)
 	// Register registers a user using full name, email, password and AuthCode
 	// An error is returned if the registration does not succeed.
 	Register(ctx context.Context, fname, lname, email, password, authcode string) error
 }
 
+// Notifier delivers notifications to users. It is implemented by the notification service, which
+// depends on this package.
+type Notifier interface {
+	Notify(ctx context.Context, n entity.Notification, recipients ...int) error
+}
+
 type service struct {
 	signingKey      string
 	tokenExpiration int
 	database        *dbcontext.DB
+	notifier        Notifier
 	logger          *logrus.Logger
 }
 
 // NewService creates a new authentication service.
-func NewService(signingKey string, tokenExpiration int, db *dbcontext.DB, logger *logrus.Logger) Service {
-	return service{signingKey, tokenExpiration, db, logger}
+func NewService(signingKey string, tokenExpiration int, db *dbcontext.DB, notifier Notifier, logger *logrus.Logger) Service {
+	return service{signingKey, tokenExpiration, db, notifier, logger}
 }
 
 // Login authenticates a user and generates a JWT token if authentication succeeds.
 // Otherwise, an error is returned.
-func (s service) Login(ctx context.Context, username, password string) (string, error) {
+func (s service) Login(ctx context.Context, username, password, ip string) (string, error) {
 	identity, err := s.authenticate(ctx, username, password)
 	if identity != nil {
+		if err := checkSuspension(ctx, s.database, identity.GetID()); err != nil {
+			return "", err
+		}
+		s.recordLogin(ctx, identity.(entity.User), ip)
 		return s.generateJWT(identity)
 	}
 	return "", errors.Unauthorized(err.Error())
 }
 
+// recordLogin remembers the time and address of a sign-in and alerts the user when the address differs
+// from the previous one. Failures are only logged, they do not prevent the sign-in.
+func (s service) recordLogin(ctx context.Context, user entity.User, ip string) {
+	err := s.database.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.database.With(ctx).Update("users", dbx.Params{"last_login": time.Now().UTC().Truncate(time.Second), "last_login_ip": ip},
+			dbx.HashExp{"id": user.ID}).Execute(); err != nil {
+			return err
+		}
+		if user.LastLoginIP == "" || user.LastLoginIP == ip {
+			return nil
+		}
+		return s.notifier.Notify(ctx, entity.Notification{Type: entity.NotificationSecurity, Detail: "new sign-in from " + ip}, user.ID)
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithField("user", user.ID).Error("Failed to record sign-in")
+	}
+}
+
 // Register creates a user
 func (s service) Register(ctx context.Context, fname, lname, email, password, authcode string) error {
 	return s.register(ctx, fname, lname, email, password, authcode)
@@ -119,22 +153,80 @@ func (s service) register(ctx context.Context, fname, lname, email, password, au
 		return errors.InternalServerError("failed to hash password")
 	}
 
-	q3 := s.database.With(ctx).NewQuery("INSERT INTO `users`(`email`, `password`, `first_name`, `last_name`, `auth_code`) VALUES ({:email},{:password},{:first_name},{:last_name},{:auth_code})")
-	q3.Bind(dbx.Params{
-		"email":      email,
-		"password":   hashed,
-		"first_name": fname,
-		"last_name":  lname,
-		"auth_code":  authcode,
-	})
-	_, err := q3.Execute()
+	// a concurrent registration may take the handle between its check and the insert, in which case
+	// the next available candidate is tried
+	var err error
+	for attempt := 0; attempt < handleAttempts; attempt++ {
+		handle, handleErr := s.defaultHandle(ctx, fname, lname)
+		if handleErr != nil {
+			logger.WithError(handleErr).Error("Failed to generate handle")
+			return errors.InternalServerError("registration failed")
+		}
+
+		q3 := s.database.With(ctx).NewQuery("INSERT INTO `users`(`email`, `password`, `first_name`, `last_name`, `auth_code`, `handle`, `handle_normalized`) VALUES ({:email},{:password},{:first_name},{:last_name},{:auth_code},{:handle},{:handle_normalized})")
+		q3.Bind(dbx.Params{
+			"email":             email,
+			"password":          hashed,
+			"first_name":        fname,
+			"last_name":         lname,
+			"auth_code":         authcode,
+			"handle":            handle,
+			"handle_normalized": entity.NormalizeHandle(handle),
+		})
+		if _, err = q3.Execute(); !handleTaken(err) {
+			break
+		}
+	}
 	if err == nil {
 		return nil
 	}
-	logger.WithField("reason", "not sure").Error("user creation failed")
+	logger.WithError(err).Error("user creation failed")
 	return errors.InternalServerError("registration failed")
 }
 
+// handleAttempts is the number of handles tried when registering a user.
+const handleAttempts = 3
+
+// handleTaken reports whether inserting a user failed because another user took the handle concurrently.
+func handleTaken(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return stderrors.As(err, &mysqlErr) && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "uq_users_handle")
+}
+
+// defaultHandle returns an available handle for a new user, made of the letters and digits of their name,
+// with a random number appended if the name alone is taken or does not make a valid handle.
+func (s service) defaultHandle(ctx context.Context, fname, lname string) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(fname+lname))
+	if len(base) > entity.MaxHandleLength-6 {
+		base = base[:entity.MaxHandleLength-6]
+	}
+	if !entity.ValidHandle(base) || entity.ReservedHandle(base) {
+		base = "user"
+	}
+	candidate := base
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || base == "user" {
+			candidate = fmt.Sprintf("%s%d", base, 1000+rand.IntN(900000))
+		}
+		var taken int
+		err := s.database.With(ctx).NewQuery("SELECT (SELECT COUNT(*) FROM users WHERE handle_normalized={:handle}) + " +
+			"(SELECT COUNT(*) FROM handle_redirects WHERE handle={:handle} AND expires_at > {:now})").
+			Bind(dbx.Params{"handle": entity.NormalizeHandle(candidate), "now": time.Now().UTC()}).
+			Row(&taken)
+		if err != nil || taken == 0 {
+			return candidate, err
+		}
+		if attempt == 10 {
+			return "", fmt.Errorf("no available handle for %q", base)
+		}
+	}
+}
+
 // generateJWT generates a JWT that encodes an identity.
 func (s service) generateJWT(identity entity.Identity) (string, error) {
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
@@ -0,0 +1,245 @@
+// Package blob implements content-addressed, reference-counted storage of media content.
+//
+// All methods use the transaction found in the context (see dbcontext.DB.With), so callers
+// are expected to run them inside dbcontext.DB.Transactional together with their own changes.
+package blob
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/storage"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+var hashRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether the given string is a lowercase hex encoded SHA-256 digest.
+func ValidHash(hash string) bool {
+	return hashRegex.MatchString(hash)
+}
+
+// Service encapsulates the blob storage logic.
+type Service interface {
+	// Acquire adds a reference to an existing blob. sql.ErrNoRows is returned if the blob does not exist.
+	Acquire(ctx context.Context, hash string) (entity.Blob, error)
+	// Create stores the content of r as a new blob with a single reference.
+	// If a blob with the same hash exists or was created in the meantime a reference to it is added instead.
+	Create(ctx context.Context, hash string, size int64, contentType string, r io.Reader) (entity.Blob, error)
+	// Release drops a reference from a blob, deleting the stored content once no references are left and
+	// the transaction commits.
+	Release(ctx context.Context, hash string) error
+	// Unlink releases the content of a media item. Media uploaded before deduplication has no
+	// blob hash and owns its storage object, which is deleted directly.
+	Unlink(ctx context.Context, m entity.Media) error
+	// Derivative returns content derived from a blob, such as a resized preview. On first use it is
+	// produced by passing the blob content to create and stored until the blob is deleted.
+	Derivative(ctx context.Context, hash, name, contentType string, create func(w io.Writer, src io.Reader) error) (entity.BlobDerivative, error)
+	// Open returns a reader for the object stored under the given key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// OpenRange returns a reader for length bytes of the object stored under the given key starting at offset.
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+type service struct {
+	db      *dbcontext.DB
+	storage storage.Storage
+	logger  *logrus.Logger
+}
+
+// NewService creates a new blob service.
+func NewService(db *dbcontext.DB, storage storage.Storage, logger *logrus.Logger) Service {
+	return service{db, storage, logger}
+}
+
+// storageKey returns a new key to store a blob under. The first byte of the hash is used as a directory
+// so that a single directory never holds all the blobs. Each creation of a blob gets a key of its own, so
+// the removal of a released blob, which happens after its transaction commits, never hits the content of
+// the same blob uploaded again in the meantime.
+func storageKey(hash string) string {
+	return "blobs/" + hash[:2] + "/" + hash + "-" + entity.GenerateID()
+}
+
+// lock reads the blob row and locks it until the surrounding transaction ends.
+func (s service) lock(ctx context.Context, hash string) (entity.Blob, error) {
+	var b entity.Blob
+	err := s.db.With(ctx).NewQuery("SELECT * FROM blobs WHERE hash={:hash} FOR UPDATE").
+		Bind(dbx.Params{"hash": hash}).
+		One(&b)
+	return b, err
+}
+
+func (s service) Acquire(ctx context.Context, hash string) (entity.Blob, error) {
+	b, err := s.lock(ctx, hash)
+	if err != nil {
+		return b, err
+	}
+	if _, err := s.db.With(ctx).NewQuery("UPDATE blobs SET ref_count = ref_count + 1 WHERE hash={:hash}").
+		Bind(dbx.Params{"hash": hash}).
+		Execute(); err != nil {
+		return b, err
+	}
+	b.RefCount++
+	return b, nil
+}
+
+func (s service) Create(ctx context.Context, hash string, size int64, contentType string, r io.Reader) (entity.Blob, error) {
+	b := entity.Blob{
+		Hash:        hash,
+		Size:        size,
+		ContentType: contentType,
+		StorageKey:  storageKey(hash),
+		RefCount:    1,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	// the row is inserted before the content is stored: a concurrent upload of the same content waits on
+	// the duplicate key until our transaction ends and then references the blob instead of storing it again
+	res, err := s.db.With(ctx).NewQuery("INSERT IGNORE INTO blobs (hash, size, content_type, storage_key, ref_count, created_at) " +
+		"VALUES ({:hash}, {:size}, {:content_type}, {:storage_key}, {:ref_count}, {:created_at})").
+		Bind(dbx.Params{
+			"hash":         b.Hash,
+			"size":         b.Size,
+			"content_type": b.ContentType,
+			"storage_key":  b.StorageKey,
+			"ref_count":    b.RefCount,
+			"created_at":   b.CreatedAt,
+		}).Execute()
+	if err != nil {
+		return b, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return s.Acquire(ctx, hash)
+	}
+	dbcontext.AfterRollback(ctx, func() {
+		s.remove(ctx, b.StorageKey)
+	})
+	_, err = s.storage.Put(ctx, b.StorageKey, r)
+	return b, err
+}
+
+// remove deletes stored objects once the changes that stopped referencing them are committed, or
+// rolled back in case of objects stored as part of the changes. Failures only leave unused objects behind,
+// so they are logged rather than returned.
+func (s service) remove(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			s.logger.WithContext(ctx).WithError(err).WithField("key", key).Error("Failed to delete stored content")
+		}
+	}
+}
+
+func (s service) Release(ctx context.Context, hash string) error {
+	b, err := s.lock(ctx, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		s.logger.WithContext(ctx).WithField("blob", hash).Warn("Released a blob that does not exist")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if b.RefCount > 1 {
+		_, err := s.db.With(ctx).NewQuery("UPDATE blobs SET ref_count = ref_count - 1 WHERE hash={:hash}").
+			Bind(dbx.Params{"hash": hash}).
+			Execute()
+		return err
+	}
+	// the content is only removed once the deletion commits, so a rollback leaves the blob intact; an
+	// upload of the same content in the meantime creates a new blob under a key of its own
+	var derived []string
+	if err := s.db.With(ctx).NewQuery("SELECT storage_key FROM blob_derivatives WHERE blob_hash={:hash}").
+		Bind(dbx.Params{"hash": hash}).
+		Column(&derived); err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).NewQuery("DELETE FROM blobs WHERE hash={:hash}").
+		Bind(dbx.Params{"hash": hash}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	dbcontext.AfterCommit(ctx, func() {
+		s.remove(ctx, append(derived, b.StorageKey)...)
+	})
+	return nil
+}
+
+func (s service) Unlink(ctx context.Context, m entity.Media) error {
+	if m.BlobHash == "" {
+		dbcontext.AfterCommit(ctx, func() {
+			s.remove(ctx, m.StorageKey)
+		})
+		return nil
+	}
+	return s.Release(ctx, m.BlobHash)
+}
+
+func (s service) Derivative(ctx context.Context, hash, name, contentType string, create func(w io.Writer, src io.Reader) error) (entity.BlobDerivative, error) {
+	var d entity.BlobDerivative
+	err := s.db.With(ctx).NewQuery("SELECT * FROM blob_derivatives WHERE blob_hash={:hash} AND name={:name}").
+		Bind(dbx.Params{"hash": hash, "name": name}).
+		One(&d)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return d, err
+	}
+
+	var b entity.Blob
+	if err := s.db.With(ctx).NewQuery("SELECT * FROM blobs WHERE hash={:hash}").
+		Bind(dbx.Params{"hash": hash}).
+		One(&b); err != nil {
+		return d, err
+	}
+	src, err := s.storage.Open(ctx, b.StorageKey)
+	if err != nil {
+		return d, err
+	}
+	defer src.Close()
+
+	// concurrent requests may produce the same derivative; the output is identical, so the last write wins
+	d = entity.BlobDerivative{
+		BlobHash:    hash,
+		Name:        name,
+		ContentType: contentType,
+		StorageKey:  b.StorageKey + "." + name,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(create(pw, src))
+	}()
+	d.Size, err = s.storage.Put(ctx, d.StorageKey, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return d, err
+	}
+	_, err = s.db.With(ctx).NewQuery("INSERT INTO blob_derivatives (blob_hash, name, size, content_type, storage_key, created_at) " +
+		"VALUES ({:hash}, {:name}, {:size}, {:content_type}, {:storage_key}, {:created_at}) " +
+		"ON DUPLICATE KEY UPDATE size=VALUES(size), created_at=VALUES(created_at)").
+		Bind(dbx.Params{
+			"hash":         d.BlobHash,
+			"name":         d.Name,
+			"size":         d.Size,
+			"content_type": d.ContentType,
+			"storage_key":  d.StorageKey,
+			"created_at":   d.CreatedAt,
+		}).Execute()
+	if err != nil {
+		// the blob was released in the meantime
+		s.storage.Delete(ctx, d.StorageKey)
+	}
+	return d, err
+}
+
+func (s service) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.storage.Open(ctx, key)
+}
+
+func (s service) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return s.storage.OpenRange(ctx, key, offset, length)
+}
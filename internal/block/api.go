@@ -0,0 +1,53 @@
+package block
+
+import (
+	"context"
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the block and mute handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/me/blocks", res.blocks)
+	r.Put("/users/<handle>/block", res.change(service.Block))
+	r.Delete("/users/<handle>/block", res.change(service.Unblock))
+	r.Get("/me/mutes", res.mutes)
+	r.Put("/users/<handle>/mute", res.change(service.Mute))
+	r.Delete("/users/<handle>/mute", res.change(service.Unmute))
+}
+
+func (r resource) blocks(c *routing.Context) error {
+	users, err := r.service.Blocks(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(users)
+}
+
+func (r resource) mutes(c *routing.Context) error {
+	users, err := r.service.Mutes(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(users)
+}
+
+// change returns a handler that applies a block or mute change to the user in the handle parameter.
+func (r resource) change(f func(ctx context.Context, handle string) error) routing.Handler {
+	return func(c *routing.Context) error {
+		if err := f(c.Request.Context(), c.Param("handle")); err != nil {
+			return err
+		}
+		c.Response.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
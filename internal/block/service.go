@@ -0,0 +1,203 @@
+// Package block lets users block and mute each other. Blocking keeps the blocked user away from the
+// blocker: their albums and profile, and every interaction between the two. Muting silently hides the
+// content and notifications of the muted user from the muter.
+//
+// The package defines the SQL conditions enforcing the rules, and the features showing users to each
+// other build their queries from them. Album access is decided by AlbumVisible alone, which the album
+// access query, the media and tag lookups, the search, the feed and the notification delivery share.
+// Hidden filters the comment and reaction lists, the search, the feed, the digests, the notifications
+// and the recipients of album events, and the live channels filter their viewers and events with it.
+// Blocked guards the places that connect two users, such as invitations, follows and comments on each
+// other's uploads and comments.
+package block
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// BlockedBy returns an SQL condition that holds if the user given by the blocker SQL expression blocked
+// the user given by the user expression.
+func BlockedBy(blocker, user string) string {
+	return "EXISTS (SELECT 1 FROM user_blocks ub WHERE ub.user_id = " + blocker + " AND ub.blocked_id = " + user + ")"
+}
+
+// Between returns an SQL condition that holds if either of the users given by the SQL expressions blocked
+// the other.
+func Between(a, b string) string {
+	return "EXISTS (SELECT 1 FROM user_blocks ub WHERE (ub.user_id = " + a + " AND ub.blocked_id = " + b + ") " +
+		"OR (ub.user_id = " + b + " AND ub.blocked_id = " + a + "))"
+}
+
+// MemberJoin returns the join of the membership am of the user given by the SQL expression in the album a,
+// as AlbumVisible expects it.
+func MemberJoin(user string) string {
+	return "LEFT JOIN album_members am ON am.album_id = a.id AND am.user_id = " + user + " "
+}
+
+// AlbumVisible returns an SQL condition that holds if the user given by the SQL expression can view the
+// album a, given the membership am joined by MemberJoin: the album is neither in the trash nor hidden by
+// moderators, the user owns it, is a member of it or it is public, and its owner did not block the user.
+func AlbumVisible(user string) string {
+	return "(a.deleted_at IS NULL AND a.hidden_at IS NULL AND (a.owner_id = " + user + " OR am.user_id IS NOT NULL OR a.`public`) " +
+		"AND NOT " + BlockedBy("a.owner_id", user) + ")"
+}
+
+// Hidden returns an SQL condition that holds if the content of the author is hidden from the viewer,
+// given as SQL expressions: either of them blocked the other or the viewer muted the author.
+func Hidden(viewer, author string) string {
+	return "(" + Between(viewer, author) + " OR EXISTS (SELECT 1 FROM user_mutes um WHERE um.user_id = " + viewer + " AND um.muted_id = " + author + "))"
+}
+
+// Blocked reports whether the user blocked any of the others or any of them blocked the user.
+func Blocked(ctx context.Context, db *dbcontext.DB, user int, others ...int) (bool, error) {
+	if len(others) == 0 {
+		return false, nil
+	}
+	params := dbx.Params{"user": user}
+	placeholders := make([]string, len(others))
+	for i, id := range others {
+		name := fmt.Sprintf("other%d", i)
+		params[name], placeholders[i] = id, "{:"+name+"}"
+	}
+	in := strings.Join(placeholders, ", ")
+	var n int
+	err := db.With(ctx).NewQuery("SELECT COUNT(*) FROM user_blocks WHERE (user_id = {:user} AND blocked_id IN (" + in + ")) " +
+		"OR (blocked_id = {:user} AND user_id IN (" + in + "))").
+		Bind(params).
+		Row(&n)
+	return n > 0, err
+}
+
+// Service encapsulates the blocking and muting logic. Users are identified by their handles.
+type Service interface {
+	// Blocks returns the users the current user blocked, most recent first.
+	Blocks(ctx context.Context) ([]entity.RestrictedUser, error)
+	// Block blocks a user. The follows between the two users end, the user is removed from the albums of
+	// the current user and the pending invitations between them are revoked.
+	Block(ctx context.Context, handle string) error
+	// Unblock unblocks a user. The ended follows and memberships are not restored.
+	Unblock(ctx context.Context, handle string) error
+	// Mutes returns the users the current user muted, most recent first.
+	Mutes(ctx context.Context) ([]entity.RestrictedUser, error)
+	// Mute mutes a user.
+	Mute(ctx context.Context, handle string) error
+	// Unmute unmutes a user.
+	Unmute(ctx context.Context, handle string) error
+}
+
+type service struct {
+	db     *dbcontext.DB
+	logger *logrus.Logger
+}
+
+// NewService creates a new block service.
+func NewService(db *dbcontext.DB, logger *logrus.Logger) Service {
+	return service{db, logger}
+}
+
+func (s service) Blocks(ctx context.Context) ([]entity.RestrictedUser, error) {
+	return s.list(ctx, "user_blocks", "blocked_id")
+}
+
+func (s service) Mutes(ctx context.Context) ([]entity.RestrictedUser, error) {
+	return s.list(ctx, "user_mutes", "muted_id")
+}
+
+// list returns the users in the column of the rows of the current user in the table.
+func (s service) list(ctx context.Context, table, column string) ([]entity.RestrictedUser, error) {
+	users := []entity.RestrictedUser{}
+	err := s.db.With(ctx).Select("u.handle", "u.first_name", "u.last_name", "u.profile_img", "r.created_at").
+		From(table+" r").
+		InnerJoin("users u", dbx.NewExp("u.id = r."+column)).
+		Where(dbx.HashExp{"r.user_id": auth.CurrentUser(ctx).GetID()}).
+		OrderBy("r.created_at DESC", "u.handle").
+		All(&users)
+	return users, err
+}
+
+// resolve returns the ID of the user with the given handle, who has to be another user than the current one.
+func (s service) resolve(ctx context.Context, handle string) (int, error) {
+	var id int
+	err := s.db.With(ctx).NewQuery("SELECT id FROM users WHERE handle_normalized={:handle}").
+		Bind(dbx.Params{"handle": entity.NormalizeHandle(handle)}).
+		Row(&id)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return 0, errors.NotFound("user not found")
+	}
+	if err == nil && id == auth.CurrentUser(ctx).GetID() {
+		return 0, errors.BadRequest("you cannot block or mute yourself")
+	}
+	return id, err
+}
+
+func (s service) Block(ctx context.Context, handle string) error {
+	blocked, err := s.resolve(ctx, handle)
+	if err != nil {
+		return err
+	}
+	user := auth.CurrentUser(ctx).GetID()
+	params := dbx.Params{"user": user, "blocked": blocked, "now": time.Now().UTC().Truncate(time.Second), "pending": entity.InvitationPending, "revoked": entity.InvitationRevoked}
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		for _, query := range []string{
+			"INSERT IGNORE INTO user_blocks (user_id, blocked_id, created_at) VALUES ({:user}, {:blocked}, {:now})",
+			"DELETE FROM follows WHERE (follower_id = {:user} AND followee_id = {:blocked}) OR (follower_id = {:blocked} AND followee_id = {:user})",
+			"DELETE fi FROM feed_items fi JOIN activities act ON act.id = fi.activity_id " +
+				"WHERE (fi.user_id = {:user} AND act.actor_id = {:blocked}) OR (fi.user_id = {:blocked} AND act.actor_id = {:user})",
+			"DELETE am FROM album_members am JOIN albums a ON a.id = am.album_id WHERE a.owner_id = {:user} AND am.user_id = {:blocked}",
+			"UPDATE album_invitations i JOIN albums a ON a.id = i.album_id SET i.status = {:revoked}, i.responded_at = {:now} " +
+				"WHERE i.status = {:pending} AND ((i.invitee_id = {:blocked} AND (i.inviter_id = {:user} OR a.owner_id = {:user})) " +
+				"OR (i.invitee_id = {:user} AND (i.inviter_id = {:blocked} OR a.owner_id = {:blocked})))",
+		} {
+			if _, err := s.db.With(ctx).NewQuery(query).Bind(params).Execute(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Blocking failed")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+func (s service) Unblock(ctx context.Context, handle string) error {
+	blocked, err := s.resolve(ctx, handle)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).Delete("user_blocks", dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID(), "blocked_id": blocked}).Execute()
+	return err
+}
+
+func (s service) Mute(ctx context.Context, handle string) error {
+	muted, err := s.resolve(ctx, handle)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).NewQuery("INSERT IGNORE INTO user_mutes (user_id, muted_id, created_at) VALUES ({:user}, {:muted}, {:now})").
+		Bind(dbx.Params{"user": auth.CurrentUser(ctx).GetID(), "muted": muted, "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	return err
+}
+
+func (s service) Unmute(ctx context.Context, handle string) error {
+	muted, err := s.resolve(ctx, handle)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).Delete("user_mutes", dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID(), "muted_id": muted}).Execute()
+	return err
+}
@@ -0,0 +1,80 @@
+package comment
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the comment handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/albums/<id>/comments", res.list(service.AlbumComments))
+	r.Post("/albums/<id>/comments", res.create(service.CommentOnAlbum))
+	r.Get("/media/<id>/comments", res.list(service.MediaComments))
+	r.Post("/media/<id>/comments", res.create(service.CommentOnMedia))
+	r.Get("/comments/<id>/replies", res.list(service.Replies))
+	r.Patch("/comments/<id>", res.update)
+	r.Delete("/comments/<id>", res.delete)
+}
+
+// list returns a handler listing a page of comments. The page is selected with the page and per_page
+// query parameters.
+func (r resource) list(list func(context.Context, string, *pagination.Pages) ([]entity.Comment, error)) routing.Handler {
+	return func(c *routing.Context) error {
+		pages := pagination.NewFromRequest(c.Request, -1)
+		comments, err := list(c.Request.Context(), c.Param("id"), pages)
+		if err != nil {
+			return err
+		}
+		pages.Items = comments
+		return c.Write(pages)
+	}
+}
+
+func (r resource) create(create func(context.Context, string, CreateCommentRequest) (entity.Comment, error)) routing.Handler {
+	return func(c *routing.Context) error {
+		var req CreateCommentRequest
+		if err := c.Read(&req); err != nil {
+			r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+			return errors.BadRequest("")
+		}
+		comment, err := create(c.Request.Context(), c.Param("id"), req)
+		if err != nil {
+			return err
+		}
+		return c.WriteWithStatus(comment, http.StatusCreated)
+	}
+}
+
+func (r resource) update(c *routing.Context) error {
+	var req UpdateCommentRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	comment, err := r.service.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(comment)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	if err := r.service.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
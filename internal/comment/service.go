@@ -0,0 +1,484 @@
+// Package comment implements threaded comments on albums and media items.
+package comment
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/feed"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/notification"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the comment logic. Comments can be read and written by everyone who can view
+// the album they belong to.
+type Service interface {
+	// AlbumComments returns a page of the top-level comments on an album, oldest first.
+	AlbumComments(ctx context.Context, albumID string, pages *pagination.Pages) ([]entity.Comment, error)
+	// MediaComments returns a page of the top-level comments on a media item, oldest first.
+	MediaComments(ctx context.Context, mediaID string, pages *pagination.Pages) ([]entity.Comment, error)
+	// Replies returns a page of the replies to a comment, oldest first.
+	Replies(ctx context.Context, id string, pages *pagination.Pages) ([]entity.Comment, error)
+	// GetAll returns the comments with the given IDs that the current user can view, together with their
+	// mentions. Deleted and hidden comments, and comments of authors hidden from the user by a block or
+	// mute, are left out, and the order of the comments is unspecified.
+	GetAll(ctx context.Context, ids []string) ([]entity.Comment, error)
+	// CommentOnAlbum adds a comment, or a reply to a comment, to an album.
+	CommentOnAlbum(ctx context.Context, albumID string, req CreateCommentRequest) (entity.Comment, error)
+	// CommentOnMedia adds a comment, or a reply to a comment, to a media item.
+	CommentOnMedia(ctx context.Context, mediaID string, req CreateCommentRequest) (entity.Comment, error)
+	// Update changes the body of a comment. Only the author can edit a comment.
+	Update(ctx context.Context, id string, req UpdateCommentRequest) (entity.Comment, error)
+	// Delete deletes a comment. Comments can be deleted by their author and by the owners of the album.
+	// A comment with replies is blanked instead, so the thread stays intact.
+	Delete(ctx context.Context, id string) error
+}
+
+// CreateCommentRequest represents a comment creation request.
+type CreateCommentRequest struct {
+	Body     string  `json:"body"`
+	ParentID *string `json:"parent_id"`
+}
+
+// Validate validates the CreateCommentRequest fields.
+func (m CreateCommentRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Body, validation.Required, validation.RuneLength(1, 5000)),
+		validation.Field(&m.ParentID, validation.NilOrNotEmpty),
+	)
+}
+
+// UpdateCommentRequest represents a comment update request.
+type UpdateCommentRequest struct {
+	Body string `json:"body"`
+}
+
+// Validate validates the UpdateCommentRequest fields.
+func (m UpdateCommentRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Body, validation.Required, validation.RuneLength(1, 5000)),
+	)
+}
+
+type service struct {
+	db     *dbcontext.DB
+	albums album.Service
+	media  media.Service
+	index  index.Index
+	feed   feed.Publisher
+	notify notification.Publisher
+	events events.Publisher
+	logger *logrus.Logger
+}
+
+// NewService creates a new comment service that publishes new comments to the feeds of the followers
+// of their author and to the album members, notifies the users they concern and keeps their bodies in the
+// search index.
+func NewService(db *dbcontext.DB, albums album.Service, media media.Service, index index.Index, feed feed.Publisher, notify notification.Publisher, events events.Publisher, logger *logrus.Logger) Service {
+	return service{db, albums, media, index, feed, notify, events, logger}
+}
+
+// mentionRegex matches @handle mentions that are not part of a word or an email address.
+var mentionRegex = regexp.MustCompile(`(?:^|[^\w@.])@([A-Za-z0-9_]{1,30})`)
+
+// Mentions returns the distinct handles mentioned in a comment body, lowercased and in order of appearance.
+func Mentions(body string) []string {
+	handles := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionRegex.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(match[1])
+		if !seen[handle] {
+			seen[handle] = true
+			handles = append(handles, handle)
+		}
+	}
+	return handles
+}
+
+// selectComment selects comments with the name of their author and the number of their replies.
+// The body of deleted comments and of comments hidden by moderators is not selected.
+const selectComment = "SELECT c.id, c.album_id, c.media_id, c.parent_id, c.author_id, u.first_name AS author_first_name, " +
+	"u.last_name AS author_last_name, IF(c.deleted_at IS NULL AND c.hidden_at IS NULL, c.body, '') AS body, " +
+	"c.deleted_at IS NOT NULL AS deleted, c.hidden_at IS NOT NULL AS hidden, " +
+	"c.created_at, c.updated_at, (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS reply_count " +
+	"FROM comments c JOIN users u ON u.id = c.author_id "
+
+func (s service) AlbumComments(ctx context.Context, albumID string, pages *pagination.Pages) ([]entity.Comment, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return s.page(ctx, "c.album_id = {:album} AND c.media_id IS NULL AND c.parent_id IS NULL", dbx.Params{"album": a.ID}, pages)
+}
+
+func (s service) MediaComments(ctx context.Context, mediaID string, pages *pagination.Pages) ([]entity.Comment, error) {
+	m, err := s.media.Get(ctx, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	return s.page(ctx, "c.media_id = {:media} AND c.parent_id IS NULL", dbx.Params{"media": m.ID}, pages)
+}
+
+func (s service) Replies(ctx context.Context, id string, pages *pagination.Pages) ([]entity.Comment, error) {
+	c, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.page(ctx, "c.parent_id = {:parent}", dbx.Params{"parent": c.ID}, pages)
+}
+
+func (s service) GetAll(ctx context.Context, ids []string) ([]entity.Comment, error) {
+	if len(ids) == 0 {
+		return []entity.Comment{}, nil
+	}
+	params := dbx.Params{"user": auth.CurrentUser(ctx).GetID()}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		name := fmt.Sprintf("id%d", i)
+		params[name], placeholders[i] = id, "{:"+name+"}"
+	}
+	comments := []entity.Comment{}
+	if err := s.db.With(ctx).NewQuery(selectComment + "JOIN albums a ON a.id = c.album_id LEFT JOIN media m ON m.id = c.media_id " +
+		block.MemberJoin("{:user}") +
+		"WHERE c.id IN (" + strings.Join(placeholders, ", ") + ") AND c.deleted_at IS NULL AND c.hidden_at IS NULL " +
+		"AND " + block.AlbumVisible("{:user}") + " AND (c.media_id IS NULL OR (m.deleted_at IS NULL AND m.hidden_at IS NULL)) " +
+		"AND NOT " + block.Hidden("{:user}", "c.author_id")).
+		Bind(params).
+		All(&comments); err != nil {
+		return nil, err
+	}
+	return comments, s.loadMentions(ctx, comments)
+}
+
+// page selects a page of the comments matching the condition and sets the total count of pages. Comments
+// of authors hidden from the current user by a block or mute are left out.
+func (s service) page(ctx context.Context, where string, params dbx.Params, pages *pagination.Pages) ([]entity.Comment, error) {
+	where += " AND NOT " + block.Hidden("{:viewer}", "c.author_id")
+	params["viewer"] = auth.CurrentUser(ctx).GetID()
+	var total int
+	if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM comments c WHERE " + where).
+		Bind(params).
+		Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	comments := []entity.Comment{}
+	params["limit"], params["offset"] = pages.Limit(), pages.Offset()
+	if err := s.db.With(ctx).NewQuery(selectComment + "WHERE " + where + " ORDER BY c.created_at, c.id LIMIT {:limit} OFFSET {:offset}").
+		Bind(params).
+		All(&comments); err != nil {
+		return nil, err
+	}
+	return comments, s.loadMentions(ctx, comments)
+}
+
+// loadMentions fills in the mentions of the given comments with a single query.
+func (s service) loadMentions(ctx context.Context, comments []entity.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(comments))
+	index := map[string]int{}
+	for i := range comments {
+		ids[i] = comments[i].ID
+		index[comments[i].ID] = i
+		comments[i].Mentions = []string{}
+	}
+	var rows []struct {
+		CommentID string
+		Handle    string
+	}
+	if err := s.db.With(ctx).Select("comment_id", "handle").From("comment_mentions").
+		Where(dbx.In("comment_id", ids...)).
+		OrderBy("handle").
+		All(&rows); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		c := &comments[index[row.CommentID]]
+		c.Mentions = append(c.Mentions, row.Handle)
+	}
+	return nil
+}
+
+// find returns the comment with the given ID without checking whether the current user can access it.
+func (s service) find(ctx context.Context, id string) (entity.Comment, error) {
+	var c entity.Comment
+	err := s.db.With(ctx).NewQuery(selectComment + "WHERE c.id = {:id}").
+		Bind(dbx.Params{"id": id}).
+		One(&c)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return c, errors.Forbidden("")
+	}
+	return c, err
+}
+
+// authorize returns the comment with the given ID if the current user holds at least the given role in
+// its album. Comments on media items in the trash cannot be accessed.
+func (s service) authorize(ctx context.Context, id string, role entity.Role) (entity.Comment, error) {
+	c, err := s.find(ctx, id)
+	if err != nil {
+		return c, err
+	}
+	if c.MediaID != nil {
+		if _, err := s.media.Get(ctx, *c.MediaID); err != nil {
+			return entity.Comment{}, err
+		}
+	}
+	if _, err := s.albums.Authorize(ctx, c.AlbumID, role); err != nil {
+		return entity.Comment{}, err
+	}
+	return c, nil
+}
+
+// get returns the comment with the given ID together with its mentions if the current user can view it.
+func (s service) get(ctx context.Context, id string) (entity.Comment, error) {
+	c, err := s.authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return c, err
+	}
+	comments := []entity.Comment{c}
+	err = s.loadMentions(ctx, comments)
+	return comments[0], err
+}
+
+func (s service) CommentOnAlbum(ctx context.Context, albumID string, req CreateCommentRequest) (entity.Comment, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Comment{}, err
+	}
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return entity.Comment{}, err
+	}
+	return s.create(ctx, a.ID, nil, req)
+}
+
+func (s service) CommentOnMedia(ctx context.Context, mediaID string, req CreateCommentRequest) (entity.Comment, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Comment{}, err
+	}
+	m, err := s.media.Get(ctx, mediaID)
+	if err != nil {
+		return entity.Comment{}, err
+	}
+	return s.create(ctx, m.AlbumID, &m.ID, req)
+}
+
+// create saves a new comment on an album or media item the current user has access to. Users cannot
+// comment on the uploads of users they blocked or who blocked them, nor reply to their comments.
+func (s service) create(ctx context.Context, albumID string, mediaID *string, req CreateCommentRequest) (entity.Comment, error) {
+	var others []int
+	if req.ParentID != nil {
+		parent, err := s.find(ctx, *req.ParentID)
+		if err != nil || parent.AlbumID != albumID || !sameTarget(parent.MediaID, mediaID) {
+			return entity.Comment{}, errors.BadRequest("the parent comment does not belong to this thread")
+		}
+		if parent.Deleted {
+			return entity.Comment{}, errors.BadRequest("cannot reply to a deleted comment")
+		}
+		others = append(others, parent.AuthorID)
+	}
+	if mediaID != nil {
+		var uploader int
+		if err := s.db.With(ctx).NewQuery("SELECT owner_id FROM media WHERE id={:id}").Bind(dbx.Params{"id": *mediaID}).Row(&uploader); err != nil {
+			return entity.Comment{}, err
+		}
+		others = append(others, uploader)
+	}
+	if blocked, err := block.Blocked(ctx, s.db, auth.CurrentUser(ctx).GetID(), others...); err != nil {
+		return entity.Comment{}, err
+	} else if blocked {
+		return entity.Comment{}, errors.Forbidden("you cannot comment here")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	id := entity.GenerateID()
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Insert("comments", dbx.Params{
+			"id":         id,
+			"album_id":   albumID,
+			"media_id":   mediaID,
+			"parent_id":  req.ParentID,
+			"author_id":  auth.CurrentUser(ctx).GetID(),
+			"body":       req.Body,
+			"created_at": now,
+			"updated_at": now,
+		}).Execute(); err != nil {
+			return err
+		}
+		if err := s.saveMentions(ctx, id, req.Body); err != nil {
+			return err
+		}
+		if err := s.index.IndexComment(ctx, id); err != nil {
+			return err
+		}
+		if err := s.notifyAbout(ctx, id, albumID, mediaID, req.ParentID); err != nil {
+			return err
+		}
+		change := events.AlbumChange{AlbumID: albumID, CommentID: id}
+		if mediaID != nil {
+			change.MediaID = *mediaID
+		}
+		if err := s.events.PublishAlbum(ctx, albumID, events.TypeCommentAdded, change); err != nil {
+			return err
+		}
+		return s.feed.Publish(ctx, entity.Activity{Type: entity.ActivityCommentAdded, AlbumID: albumID, MediaID: mediaID, CommentID: &id})
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithField("album", albumID).Error("Comment creation failed")
+		return entity.Comment{}, errors.InternalServerError("")
+	}
+	return s.get(ctx, id)
+}
+
+// notifyAbout notifies the users a new comment concerns: the mentioned users, the author of the parent
+// comment and the uploader of the media item or the owner of the album. Everyone gets a single
+// notification, the most specific one.
+func (s service) notifyAbout(ctx context.Context, id, albumID string, mediaID, parentID *string) error {
+	n := entity.Notification{AlbumID: &albumID, MediaID: mediaID, CommentID: &id}
+	notified := map[int]bool{}
+	// mentions of handles that do not belong to a user yet are not resolved
+	var mentioned []int
+	if err := s.db.With(ctx).NewQuery("SELECT user_id FROM comment_mentions WHERE comment_id={:id} AND user_id IS NOT NULL").
+		Bind(dbx.Params{"id": id}).
+		Column(&mentioned); err != nil {
+		return err
+	}
+	n.Type = entity.NotificationMention
+	if err := s.notify.Notify(ctx, n, mentioned...); err != nil {
+		return err
+	}
+	for _, user := range mentioned {
+		notified[user] = true
+	}
+	if parentID != nil {
+		var author int
+		if err := s.db.With(ctx).NewQuery("SELECT author_id FROM comments WHERE id={:id}").Bind(dbx.Params{"id": *parentID}).Row(&author); err != nil {
+			return err
+		}
+		if !notified[author] {
+			n.Type = entity.NotificationReply
+			if err := s.notify.Notify(ctx, n, author); err != nil {
+				return err
+			}
+			notified[author] = true
+		}
+	}
+	q := s.db.With(ctx).NewQuery("SELECT owner_id FROM albums WHERE id={:id}").Bind(dbx.Params{"id": albumID})
+	if mediaID != nil {
+		q = s.db.With(ctx).NewQuery("SELECT owner_id FROM media WHERE id={:id}").Bind(dbx.Params{"id": *mediaID})
+	}
+	var owner int
+	if err := q.Row(&owner); err != nil {
+		return err
+	}
+	if notified[owner] {
+		return nil
+	}
+	n.Type = entity.NotificationComment
+	return s.notify.Notify(ctx, n, owner)
+}
+
+func sameTarget(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// saveMentions replaces the mentions of a comment with the handles mentioned in its body. The handles are
+// resolved to the users holding them, or recently holding them as long as they redirect to their user.
+// Users who blocked the author or were blocked by the author are not resolved, so they are not notified.
+func (s service) saveMentions(ctx context.Context, id, body string) error {
+	if _, err := s.db.With(ctx).Delete("comment_mentions", dbx.HashExp{"comment_id": id}).Execute(); err != nil {
+		return err
+	}
+	for _, handle := range Mentions(body) {
+		_, err := s.db.With(ctx).NewQuery("INSERT INTO comment_mentions (comment_id, handle, user_id) " +
+			"SELECT {:id}, {:handle}, IF(" + block.Between("m.user_id", "m.author_id") + ", NULL, m.user_id) FROM (" +
+			"SELECT COALESCE((SELECT u.id FROM users u WHERE u.handle_normalized = {:handle}), " +
+			"(SELECT r.user_id FROM handle_redirects r WHERE r.handle = {:handle} AND r.expires_at > {:now})) AS user_id, " +
+			"(SELECT c.author_id FROM comments c WHERE c.id = {:id}) AS author_id) m").
+			Bind(dbx.Params{"id": id, "handle": handle, "now": time.Now().UTC()}).
+			Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s service) Update(ctx context.Context, id string, req UpdateCommentRequest) (entity.Comment, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Comment{}, err
+	}
+	c, err := s.authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return c, err
+	}
+	if c.AuthorID != auth.CurrentUser(ctx).GetID() {
+		return entity.Comment{}, errors.Forbidden("only the author can edit a comment")
+	}
+	if c.Deleted {
+		return entity.Comment{}, errors.BadRequest("cannot edit a deleted comment")
+	}
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).NewQuery("UPDATE comments SET body={:body}, updated_at={:now} WHERE id={:id}").
+			Bind(dbx.Params{"id": c.ID, "body": req.Body, "now": time.Now().UTC().Truncate(time.Second)}).
+			Execute(); err != nil {
+			return err
+		}
+		if err := s.saveMentions(ctx, c.ID, req.Body); err != nil {
+			return err
+		}
+		return s.index.IndexComment(ctx, c.ID)
+	})
+	if err != nil {
+		return entity.Comment{}, err
+	}
+	return s.get(ctx, c.ID)
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	// authors may delete their own comments, deleting the comments of others is reserved to the owners
+	role := entity.RoleCoOwner
+	if c, err := s.find(ctx, id); err == nil && c.AuthorID == auth.CurrentUser(ctx).GetID() {
+		role = entity.RoleViewer
+	}
+	c, err := s.authorize(ctx, id, role)
+	if err != nil {
+		return err
+	}
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		if c.ReplyCount == 0 {
+			_, err := s.db.With(ctx).Delete("comments", dbx.HashExp{"id": c.ID}).Execute()
+			return err
+		}
+		if _, err := s.db.With(ctx).NewQuery("UPDATE comments SET body='', deleted_at={:now} WHERE id={:id}").
+			Bind(dbx.Params{"id": c.ID, "now": time.Now().UTC().Truncate(time.Second)}).
+			Execute(); err != nil {
+			return err
+		}
+		if _, err := s.db.With(ctx).Delete("comment_mentions", dbx.HashExp{"comment_id": c.ID}).Execute(); err != nil {
+			return err
+		}
+		return s.index.IndexComment(ctx, c.ID)
+	})
+}
@@ -12,6 +12,14 @@ import (
 const (
 	defaultServerPort         = 8080
 	defaultJWTExpirationHours = 72
+	defaultStoragePath        = "./data"
+	defaultMediaURLTTL        = 3600
+	defaultMediaURLMaxTTL     = 7 * 24 * 3600
+	defaultTrashRetentionDays = 30
+	defaultNotificationDays   = 90
+	defaultStorageQuotaMB     = 10 * 1024
+	defaultMailFrom           = "ShareFlow <no-reply@localhost>"
+	defaultReactions          = "like:👍,love:❤️,haha:😂,wow:😮,sad:😢,fire:🔥"
 )
 
 type Config struct {
@@ -25,14 +33,75 @@ type Config struct {
 	JWTExpiration int `yaml:"jwt_expiration" env:"JWT_EXPIRATION"`
 	// Logrus log level
 	LogLevel string `yaml:"log_level" env:"LOG_LEVEL"`
+	// directory where uploaded media is stored. Defaults to ./data
+	StoragePath string `yaml:"storage_path" env:"STORAGE_PATH"`
+	// public base URL of the API, used to build absolute links. Links are relative when empty
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL"`
+	// keys for signing media URLs as a comma separated list of "id:secret" pairs. The first key signs,
+	// all keys are accepted. Defaults to a key derived from the JWT signing key
+	MediaURLKeys string `yaml:"media_url_keys" env:"MEDIA_URL_KEYS,secret"`
+	// default lifetime of signed media URLs in seconds. Defaults to 1 hour
+	MediaURLTTL int `yaml:"media_url_ttl" env:"MEDIA_URL_TTL"`
+	// maximum lifetime of signed media URLs in seconds. Defaults to 7 days
+	MediaURLMaxTTL int `yaml:"media_url_max_ttl" env:"MEDIA_URL_MAX_TTL"`
+	// number of days deleted albums and media stay in the trash before they are purged. Defaults to 30 days
+	TrashRetention int `yaml:"trash_retention" env:"TRASH_RETENTION"`
+	// number of days notifications are kept. Defaults to 90 days
+	NotificationRetention int `yaml:"notification_retention" env:"NOTIFICATION_RETENTION"`
+	// default storage quota of a user in megabytes, 0 means unlimited. Admins can override it per user.
+	// Defaults to 10 GB
+	StorageQuota int64 `yaml:"storage_quota" env:"STORAGE_QUOTA"`
+	// lets webhooks deliver to loopback, private and link-local addresses, which is refused by default so
+	// that webhooks cannot reach the internal network. Meant for local development
+	WebhookAllowPrivate bool `yaml:"webhook_allow_private" env:"WEBHOOK_ALLOW_PRIVATE"`
+	// address of the SMTP server sending emails as "host:port". When empty, emails are captured instead of
+	// sent and written to MailCaptureDir if it is set
+	SMTPAddr string `yaml:"smtp_addr" env:"SMTP_ADDR"`
+	// SMTP credentials, no authentication is used when the username is empty
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD,secret"`
+	// sender of the emails. Defaults to ShareFlow <no-reply@localhost>
+	MailFrom string `yaml:"mail_from" env:"MAIL_FROM"`
+	// directory captured emails are written to when no SMTP server is configured
+	MailCaptureDir string `yaml:"mail_capture_dir" env:"MAIL_CAPTURE_DIR"`
+	// VAPID key pair of Web Push as the base64url encoded private key. Web Push is disabled when empty
+	VAPIDPrivateKey string `yaml:"vapid_private_key" env:"VAPID_PRIVATE_KEY,secret"`
+	// contact of the operator sent to Web Push services, a mailto: or https: URL
+	VAPIDSubject string `yaml:"vapid_subject" env:"VAPID_SUBJECT"`
+	// path of the Firebase service account key file. FCM is disabled when empty
+	FCMCredentials string `yaml:"fcm_credentials" env:"FCM_CREDENTIALS"`
+	// base URL of the FCM API. Defaults to https://fcm.googleapis.com
+	FCMEndpoint string `yaml:"fcm_endpoint" env:"FCM_ENDPOINT"`
+	// path of the .p8 APNs signing key together with its key ID, the team ID and the bundle ID of the app.
+	// APNs is disabled when the key file is empty
+	APNsKeyFile string `yaml:"apns_key_file" env:"APNS_KEY_FILE"`
+	APNsKeyID   string `yaml:"apns_key_id" env:"APNS_KEY_ID"`
+	APNsTeamID  string `yaml:"apns_team_id" env:"APNS_TEAM_ID"`
+	APNsTopic   string `yaml:"apns_topic" env:"APNS_TOPIC"`
+	// base URL of APNs. Defaults to https://api.push.apple.com, use https://api.sandbox.push.apple.com for
+	// development builds of the app
+	APNsEndpoint string `yaml:"apns_endpoint" env:"APNS_ENDPOINT"`
+	// lets browsers subscribe with push endpoints on plain HTTP and internal addresses, such as a local
+	// fake push service. Meant for local development
+	PushAllowPrivate bool `yaml:"push_allow_private" env:"PUSH_ALLOW_PRIVATE"`
+	// reactions offered on media items as a comma separated list of "name:emoji" pairs
+	Reactions string `yaml:"reactions" env:"REACTIONS"`
 }
 
 // Load returns an application configuration which is populated from the given configuration file and environment variables.
 func Load(file string, logger *logrus.Logger) (*Config, error) {
 	// default config
 	c := Config{
-		ServerPort:    defaultServerPort,
-		JWTExpiration: defaultJWTExpirationHours,
+		ServerPort:            defaultServerPort,
+		JWTExpiration:         defaultJWTExpirationHours,
+		StoragePath:           defaultStoragePath,
+		MediaURLTTL:           defaultMediaURLTTL,
+		MediaURLMaxTTL:        defaultMediaURLMaxTTL,
+		TrashRetention:        defaultTrashRetentionDays,
+		NotificationRetention: defaultNotificationDays,
+		StorageQuota:          defaultStorageQuotaMB,
+		MailFrom:              defaultMailFrom,
+		Reactions:             defaultReactions,
 	}
 
 	// load from YAML config file
@@ -61,5 +130,10 @@ func (c Config) Validate() error {
 	return validation.ValidateStruct(&c,
 		validation.Field(&c.DSN, validation.Required),
 		validation.Field(&c.JWTSigningKey, validation.Required),
+		validation.Field(&c.MediaURLTTL, validation.Required, validation.Min(1)),
+		validation.Field(&c.MediaURLMaxTTL, validation.Required, validation.Min(c.MediaURLTTL)),
+		validation.Field(&c.TrashRetention, validation.Required, validation.Min(1)),
+		validation.Field(&c.StorageQuota, validation.Min(int64(0))),
+		validation.Field(&c.MailFrom, validation.Required),
 	)
 }
@@ -0,0 +1,92 @@
+package digest
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"net/http"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the digest handlers. Unsubscribing works with the token from a digest,
+// the settings require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	// people follow the link and confirm, mail clients unsubscribe with a POST request (RFC 8058)
+	r.Get("/digest/unsubscribe", res.confirmUnsubscribe)
+	r.Post("/digest/unsubscribe", res.unsubscribe)
+
+	r.Use(authHandler)
+	r.Get("/me/digest", res.settings)
+	r.Put("/me/digest", res.updateSettings)
+}
+
+// unsubscribePage is the page asking people who followed an unsubscribe link to confirm, and telling
+// them once they did. Following the link alone changes nothing, as mail scanners follow links as well.
+var unsubscribePage = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/unsubscribe.html"))
+
+func (r resource) confirmUnsubscribe(c *routing.Context) error {
+	token := c.Query("token")
+	if token == "" {
+		return errors.BadRequest("token is required")
+	}
+	return r.writePage(c, token, false)
+}
+
+// unsubscribe turns the digests off. The confirmation form of the unsubscribe page gets the page back,
+// one-click requests of mail clients get an empty response.
+func (r resource) unsubscribe(c *routing.Context) error {
+	token := c.Query("token")
+	if token == "" {
+		return errors.BadRequest("token is required")
+	}
+	if err := r.service.Unsubscribe(c.Request.Context(), token); err != nil {
+		return err
+	}
+	if c.Request.PostFormValue("confirm") != "" {
+		return r.writePage(c, token, true)
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) writePage(c *routing.Context, token string, done bool) error {
+	var page bytes.Buffer
+	if err := unsubscribePage.Execute(&page, struct {
+		Token string
+		Done  bool
+	}{token, done}); err != nil {
+		return err
+	}
+	c.Response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := c.Response.Write(page.Bytes())
+	return err
+}
+
+func (r resource) settings(c *routing.Context) error {
+	settings, err := r.service.Settings(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(settings)
+}
+
+func (r resource) updateSettings(c *routing.Context) error {
+	var req UpdateSettingsRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	settings, err := r.service.UpdateSettings(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(settings)
+}
@@ -0,0 +1,358 @@
+// Package digest implements the email digests, which summarise the unread activity of users who were not
+// around to see it: invitations, new uploads to their albums and comments.
+//
+// Digests are off until users opt in, and are then sent daily or weekly according to their settings.
+// Every digest links to an unsubscribe URL carrying a signed token, so users can turn digests off without
+// signing in.
+package digest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	stderrors "errors"
+	htmltemplate "html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/mailer"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/urlsign"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// batchSize is the maximum number of users handled by a single SendDue call.
+	batchSize = 100
+	// sectionSize is the maximum number of entries of a digest section.
+	sectionSize = 10
+	// tokenTTL is how long the unsubscribe links of a digest work.
+	tokenTTL = 365 * 24 * time.Hour
+	// retryDelay is how long a digest that could not be sent waits before it is tried again.
+	retryDelay = time.Hour
+)
+
+//go:embed templates
+var templates embed.FS
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.ParseFS(templates, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.ParseFS(templates, "templates/digest.txt"))
+)
+
+// Service encapsulates the digest logic.
+type Service interface {
+	// Settings returns the digest settings of the current user.
+	Settings(ctx context.Context) (entity.DigestSettings, error)
+	// UpdateSettings changes the digest frequency of the current user.
+	UpdateSettings(ctx context.Context, req UpdateSettingsRequest) (entity.DigestSettings, error)
+	// Unsubscribe turns off the digests of the user an unsubscribe token was issued to.
+	Unsubscribe(ctx context.Context, token string) error
+	// SendDue sends the digests that are due and returns the number of users that were handled.
+	SendDue(ctx context.Context) (int, error)
+}
+
+// UpdateSettingsRequest represents a digest settings update request.
+type UpdateSettingsRequest struct {
+	Frequency entity.DigestFrequency `json:"frequency"`
+}
+
+// Validate validates the UpdateSettingsRequest fields.
+func (m UpdateSettingsRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Frequency, validation.Required, validation.In(entity.DigestOff, entity.DigestDaily, entity.DigestWeekly)),
+	)
+}
+
+type service struct {
+	db      *dbcontext.DB
+	mailer  mailer.Mailer
+	signer  *urlsign.Signer
+	baseURL string
+	logger  *logrus.Logger
+}
+
+// NewService creates a new digest service. The unsubscribe tokens are signed with the given signer, and
+// the unsubscribe links start with baseURL, the public URL of the API.
+func NewService(db *dbcontext.DB, mailer mailer.Mailer, signer *urlsign.Signer, baseURL string, logger *logrus.Logger) Service {
+	return service{db, mailer, signer, strings.TrimRight(baseURL, "/"), logger}
+}
+
+func (s service) Settings(ctx context.Context) (entity.DigestSettings, error) {
+	settings := entity.DigestSettings{Frequency: entity.DefaultDigestFrequency}
+	err := s.db.With(ctx).Select("frequency", "last_sent_at").
+		From("digest_settings").
+		Where(dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID()}).
+		One(&settings)
+	if err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+		return settings, err
+	}
+	return settings, nil
+}
+
+func (s service) UpdateSettings(ctx context.Context, req UpdateSettingsRequest) (entity.DigestSettings, error) {
+	if err := req.Validate(); err != nil {
+		return entity.DigestSettings{}, err
+	}
+	if err := s.setFrequency(ctx, auth.CurrentUser(ctx).GetID(), req.Frequency); err != nil {
+		return entity.DigestSettings{}, err
+	}
+	return s.Settings(ctx)
+}
+
+func (s service) setFrequency(ctx context.Context, userID int, frequency entity.DigestFrequency) error {
+	_, err := s.db.With(ctx).NewQuery("INSERT INTO digest_settings (user_id, frequency) VALUES ({:user}, {:frequency}) " +
+		"ON DUPLICATE KEY UPDATE frequency = VALUES(frequency)").
+		Bind(dbx.Params{"user": userID, "frequency": frequency}).
+		Execute()
+	return err
+}
+
+func (s service) Unsubscribe(ctx context.Context, token string) error {
+	userID, err := s.verifyToken(token)
+	if err != nil {
+		return errors.BadRequest("invalid or expired unsubscribe token")
+	}
+	return s.setFrequency(ctx, userID, entity.DigestOff)
+}
+
+// tokenPayload binds unsubscribe tokens to their purpose, so they cannot be used as other signatures.
+func tokenPayload(userID int) string {
+	return "digest-unsubscribe\n" + strconv.Itoa(userID)
+}
+
+// unsubscribeToken returns a token in the form "<user>.<expires>.<key id>.<signature>".
+func (s service) unsubscribeToken(userID int, now time.Time) string {
+	expires := now.Add(tokenTTL)
+	kid, sig := s.signer.Sign(tokenPayload(userID), expires)
+	return strconv.Itoa(userID) + "." + strconv.FormatInt(expires.Unix(), 10) + "." + kid + "." + sig
+}
+
+func (s service) verifyToken(token string) (int, error) {
+	parts := strings.SplitN(token, ".", 3)
+	last := strings.LastIndex(token, ".")
+	if len(parts) != 3 || last <= len(parts[0])+len(parts[1])+1 {
+		return 0, errors.BadRequest("")
+	}
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	kid := token[len(parts[0])+len(parts[1])+2 : last]
+	if err := s.signer.Verify(tokenPayload(userID), expires, kid, token[last+1:], time.Now()); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// recipient is a user whose digest is due.
+type recipient struct {
+	ID         int
+	Email      string
+	FirstName  string
+	Frequency  entity.DigestFrequency
+	LastSentAt *time.Time
+}
+
+func (s service) SendDue(ctx context.Context) (int, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	var due []recipient
+	if err := s.db.With(ctx).NewQuery("SELECT u.id, u.email, u.first_name, COALESCE(ds.frequency, {:default}) AS frequency, ds.last_sent_at " +
+		"FROM users u LEFT JOIN digest_settings ds ON ds.user_id = u.id " +
+		"WHERE (COALESCE(ds.frequency, {:default}) = {:daily} AND (ds.last_sent_at IS NULL OR ds.last_sent_at <= {:day})) " +
+		"OR (COALESCE(ds.frequency, {:default}) = {:weekly} AND (ds.last_sent_at IS NULL OR ds.last_sent_at <= {:week})) " +
+		"ORDER BY ds.last_sent_at, u.id LIMIT {:limit}").
+		Bind(dbx.Params{
+			"default": entity.DefaultDigestFrequency,
+			"daily":   entity.DigestDaily,
+			"weekly":  entity.DigestWeekly,
+			"day":     now.Add(-entity.DigestDaily.Period()),
+			"week":    now.Add(-entity.DigestWeekly.Period()),
+			"limit":   batchSize,
+		}).
+		All(&due); err != nil {
+		return 0, err
+	}
+	for _, r := range due {
+		claimed, err := s.claim(ctx, r, now)
+		if err != nil {
+			return 0, err
+		}
+		if !claimed {
+			continue
+		}
+		if err := s.send(ctx, r, now); err != nil {
+			s.logger.WithError(err).WithField("user", r.ID).Error("Failed to send digest")
+			// the digest becomes due again after the retry delay, behind the users who waited longer
+			if _, err := s.db.With(ctx).Update("digest_settings", dbx.Params{"last_sent_at": now.Add(retryDelay - r.Frequency.Period())},
+				dbx.HashExp{"user_id": r.ID, "last_sent_at": now}).Execute(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(due), nil
+}
+
+// claim records that the digest of a user is being sent, unless another instance did so first.
+func (s service) claim(ctx context.Context, r recipient, now time.Time) (bool, error) {
+	res, err := s.db.With(ctx).NewQuery("INSERT INTO digest_settings (user_id, frequency, last_sent_at) VALUES ({:user}, {:frequency}, {:now}) " +
+		"ON DUPLICATE KEY UPDATE last_sent_at = IF(last_sent_at <=> {:previous}, VALUES(last_sent_at), last_sent_at)").
+		Bind(dbx.Params{"user": r.ID, "frequency": r.Frequency, "now": now, "previous": r.LastSentAt}).
+		Execute()
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// item is an entry of a digest section.
+type item struct {
+	Type  string
+	Actor string
+	Album string
+	Text  string
+	Count int
+}
+
+// Verb describes a comment notification.
+func (i item) Verb() string {
+	switch entity.NotificationType(i.Type) {
+	case entity.NotificationReply:
+		return "replied to you"
+	case entity.NotificationMention:
+		return "mentioned you"
+	}
+	return "commented"
+}
+
+// content is the data of the digest templates.
+type content struct {
+	Subject        string
+	FirstName      string
+	Frequency      entity.DigestFrequency
+	Invitations    []item
+	Shares         []item
+	Comments       []item
+	UnsubscribeURL string
+}
+
+// send sends the digest of the activity since the previous digest to a user. Nothing is sent if there
+// is no activity.
+func (s service) send(ctx context.Context, r recipient, now time.Time) error {
+	since := now.Add(-r.Frequency.Period())
+	if r.LastSentAt != nil && r.LastSentAt.After(since) {
+		since = *r.LastSentAt
+	}
+	c := content{FirstName: r.FirstName, Frequency: r.Frequency}
+	var err error
+	if c.Invitations, err = s.notifications(ctx, r.ID, since, entity.NotificationInvitation); err != nil {
+		return err
+	}
+	if c.Shares, err = s.shares(ctx, r.ID, since); err != nil {
+		return err
+	}
+	if c.Comments, err = s.notifications(ctx, r.ID, since, entity.NotificationComment, entity.NotificationReply, entity.NotificationMention); err != nil {
+		return err
+	}
+	if len(c.Invitations)+len(c.Shares)+len(c.Comments) == 0 {
+		return nil
+	}
+
+	unsubscribe := s.baseURL + "/v1/digest/unsubscribe?token=" + url.QueryEscape(s.unsubscribeToken(r.ID, now))
+	c.UnsubscribeURL = unsubscribe
+	c.Subject = "Your weekly ShareFlow digest"
+	if r.Frequency == entity.DigestDaily {
+		c.Subject = "Your daily ShareFlow digest"
+	}
+	var html, text bytes.Buffer
+	if err := htmlTemplate.Execute(&html, c); err != nil {
+		return err
+	}
+	if err := textTemplate.Execute(&text, c); err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      r.Email,
+		Subject: c.Subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribe + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// notifications returns the unread notifications of the given types a user received since the given time.
+func (s service) notifications(ctx context.Context, userID int, since time.Time, types ...entity.NotificationType) ([]item, error) {
+	in := make([]interface{}, len(types))
+	for i, t := range types {
+		in[i] = t
+	}
+	items := []item{}
+	err := s.db.With(ctx).Select("n.type", "TRIM(CONCAT(COALESCE(u.first_name, ''), ' ', COALESCE(u.last_name, ''))) AS actor",
+		"a.name AS album", "COALESCE(LEFT(c.body, 200), n.detail) AS text", "1 AS count").
+		From("notifications n").
+		InnerJoin("albums a", dbx.NewExp("a.id = n.album_id AND a.deleted_at IS NULL AND a.hidden_at IS NULL")).
+		LeftJoin("users u", dbx.NewExp("u.id = n.actor_id")).
+		LeftJoin("comments c", dbx.NewExp("c.id = n.comment_id AND c.deleted_at IS NULL AND c.hidden_at IS NULL")).
+		Where(dbx.And(
+			dbx.HashExp{"n.user_id": userID, "n.read_at": nil},
+			dbx.In("n.type", in...),
+			dbx.NewExp("n.created_at > {:since}", dbx.Params{"since": since}),
+			dbx.NewExp("n.actor_id IS NULL OR NOT "+block.Hidden("n.user_id", "n.actor_id")),
+		)).
+		OrderBy("n.id DESC").
+		Limit(sectionSize).
+		All(&items)
+	return items, err
+}
+
+// shares returns the uploads of other users since the given time to the albums a user can view and owns or
+// is a member of.
+func (s service) shares(ctx context.Context, userID int, since time.Time) ([]item, error) {
+	items := []item{}
+	err := s.db.With(ctx).NewQuery("SELECT act.type, TRIM(CONCAT(u.first_name, ' ', u.last_name)) AS actor, a.name AS album, '' AS text, act.count " +
+		"FROM activities act JOIN albums a ON a.id = act.album_id JOIN users u ON u.id = act.actor_id " + block.MemberJoin("{:user}") +
+		"WHERE act.type = {:type} AND act.actor_id <> {:user} AND act.updated_at > {:since} AND " + block.AlbumVisible("{:user}") + " " +
+		"AND (a.owner_id = {:user} OR am.user_id IS NOT NULL) AND NOT " + block.Hidden("{:user}", "act.actor_id") + " " +
+		"ORDER BY act.updated_at DESC LIMIT {:limit}").
+		Bind(dbx.Params{"user": userID, "type": entity.ActivityMediaUploaded, "since": since, "limit": sectionSize}).
+		All(&items)
+	return items, err
+}
+
+// RunSender sends the due digests in the given interval until the context is canceled.
+func RunSender(ctx context.Context, service Service, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := service.SendDue(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Sending digests failed")
+		}
+		if n == batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package entity
+
+import "time"
+
+// ActivityType is the kind of an activity.
+type ActivityType string
+
+// The activity types.
+const (
+	ActivityAlbumCreated  ActivityType = "album_created"
+	ActivityMediaUploaded ActivityType = "media_uploaded"
+	ActivityCommentAdded  ActivityType = "comment_added"
+)
+
+// Activity is something a user did that is shown in the feeds of their followers.
+type Activity struct {
+	ID        int64        `json:"-"`
+	ActorID   int          `json:"actor_id"`
+	Type      ActivityType `json:"type"`
+	AlbumID   string       `json:"album_id"`
+	MediaID   *string      `json:"media_id,omitempty"`
+	CommentID *string      `json:"comment_id,omitempty"`
+	// Count is the number of uploads of a burst of uploads, in which case MediaID is the latest upload.
+	Count     int       `json:"count"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FeedItem is an activity in the feed of a user.
+type FeedItem struct {
+	Activity
+	ItemID         int64  `json:"-"`
+	ActorFirstName string `json:"actor_first_name"`
+	ActorLastName  string `json:"actor_last_name"`
+	AlbumName      string `json:"album_name"`
+}
+
+// Follow is a user in a list of followers or followed users.
+type Follow struct {
+	UserID    int       `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
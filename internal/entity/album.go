@@ -6,8 +6,52 @@ import (
 
 // Album represents an album record.
 type Album struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
+	ID          string `json:"id"`
+	OwnerID     int    `json:"owner_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// KeepLocation allows GPS and other sensitive EXIF data to be served through public and shared links.
+	KeepLocation bool `json:"keep_location"`
+	// Public lists the album on the public profile of its owner.
+	Public bool `json:"public"`
+	// Rules makes the album a smart album, whose content is computed from the rules instead of being
+	// stored in the album.
+	Rules *SmartRules `json:"rules,omitempty"`
+	// SortMode is the order in which the media items of the album are listed.
+	SortMode SortMode `json:"sort_mode"`
+	// Cover is the ID of the cover media item: the explicitly chosen one if it is still available, else
+	// the first item by capture time. It is nil for empty albums and is not stored with the album.
+	Cover     *string   `json:"cover_media_id" db:"cover"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the album is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Role is the role of the current user in the album. It is not stored with the album.
+	Role Role `json:"role,omitempty"`
+	// Tags holds the normalized tags of the album.
+	Tags []string `json:"tags,omitempty" db:"-"`
+}
+
+// SortMode is the order in which the media items of an album are listed.
+type SortMode string
+
+// The album sort modes.
+const (
+	// SortManual orders media items by their position, as arranged by the album editors.
+	SortManual SortMode = "manual"
+	// SortCaptured orders media items by their capture time, falling back to their upload time.
+	SortCaptured SortMode = "captured"
+	// SortUploaded orders media items by their upload time.
+	SortUploaded SortMode = "uploaded"
+	// SortName orders media items by their filename.
+	SortName SortMode = "name"
+)
+
+// Valid reports whether m is a known sort mode.
+func (m SortMode) Valid() bool {
+	switch m {
+	case SortManual, SortCaptured, SortUploaded, SortName:
+		return true
+	}
+	return false
 }
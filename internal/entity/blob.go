@@ -0,0 +1,25 @@
+package entity
+
+import "time"
+
+// Blob represents stored media content identified by its SHA-256 hash.
+// A blob is shared by every media item with identical content and is reference counted.
+type Blob struct {
+	Hash        string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	StorageKey  string    `json:"-"`
+	RefCount    int       `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BlobDerivative represents content derived from a blob, such as a resized preview.
+// Derivatives are created on demand and deleted together with their blob.
+type BlobDerivative struct {
+	BlobHash    string
+	Name        string
+	Size        int64
+	ContentType string
+	StorageKey  string
+	CreatedAt   time.Time
+}
@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// RestrictedUser is a user the current user blocked or muted. Like profiles, it leaves out the email
+// address and the user ID.
+type RestrictedUser struct {
+	Handle     string    `json:"handle"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	ProfileIMG string    `json:"profile_img"`
+	CreatedAt  time.Time `json:"created_at"`
+}
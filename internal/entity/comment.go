@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+// Comment represents a comment on an album or, when MediaID is set, on a media item of the album.
+// Replies refer to the comment they answer with ParentID.
+type Comment struct {
+	ID              string    `json:"id"`
+	AlbumID         string    `json:"album_id"`
+	MediaID         *string   `json:"media_id"`
+	ParentID        *string   `json:"parent_id"`
+	AuthorID        int       `json:"author_id"`
+	AuthorFirstName string    `json:"author_first_name"`
+	AuthorLastName  string    `json:"author_last_name"`
+	Body            string    `json:"body"`
+	Mentions        []string  `json:"mentions" db:"-"`
+	ReplyCount      int       `json:"reply_count"`
+	Deleted         bool      `json:"deleted"`
+	Hidden          bool      `json:"hidden"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package entity
+
+import "time"
+
+// DigestFrequency is how often a user receives the email digest of their unread activity.
+type DigestFrequency string
+
+// The digest frequencies.
+const (
+	DigestOff    DigestFrequency = "off"
+	DigestDaily  DigestFrequency = "daily"
+	DigestWeekly DigestFrequency = "weekly"
+)
+
+// DefaultDigestFrequency is the frequency of users who did not choose one. Digests are emails nobody
+// asked for until they turn them on.
+const DefaultDigestFrequency = DigestOff
+
+// Period returns the time between two digests, or zero if digests are turned off.
+func (f DigestFrequency) Period() time.Duration {
+	switch f {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// DigestSettings are the email digest settings of a user.
+type DigestSettings struct {
+	Frequency DigestFrequency `json:"frequency"`
+	// LastSentAt is when the last digest was sent or skipped for lack of activity.
+	LastSentAt *time.Time `json:"last_sent_at"`
+}
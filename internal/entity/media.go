@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"time"
+)
+
+// Media represents a single uploaded photo or video stored in an album.
+type Media struct {
+	ID          string     `json:"id"`
+	AlbumID     string     `json:"album_id"`
+	OwnerID     int        `json:"owner_id"`
+	Filename    string     `json:"filename"`
+	Caption     string     `json:"caption"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	BlobHash    string     `json:"-"`
+	StorageKey  string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// SortKey is the fractional key positioning the item in the manual order of its album.
+	SortKey  *string        `json:"sort_key,omitempty"`
+	Metadata *MediaMetadata `json:"metadata,omitempty" db:"-"`
+	// Reactions holds the number of reactions of each type, MyReactions the reactions of the current user.
+	Reactions   map[string]int `json:"reactions,omitempty" db:"-"`
+	MyReactions []string       `json:"my_reactions,omitempty" db:"-"`
+	// Tags holds the normalized tags of the media item.
+	Tags []string `json:"tags,omitempty" db:"-"`
+}
+
+// MediaMetadata represents the EXIF metadata extracted from an uploaded image.
+type MediaMetadata struct {
+	MediaID      string     `json:"-"`
+	CameraMake   string     `json:"camera_make,omitempty"`
+	CameraModel  string     `json:"camera_model,omitempty"`
+	LensModel    string     `json:"lens_model,omitempty"`
+	ExposureTime string     `json:"exposure_time,omitempty"`
+	FNumber      *float64   `json:"f_number,omitempty"`
+	ISO          *int       `json:"iso,omitempty" db:"iso"`
+	FocalLength  *float64   `json:"focal_length,omitempty"`
+	CapturedAt   *time.Time `json:"captured_at,omitempty"`
+	Latitude     *float64   `json:"latitude,omitempty"`
+	Longitude    *float64   `json:"longitude,omitempty"`
+	Altitude     *float64   `json:"altitude,omitempty"`
+}
+
+// Redacted returns a copy of the metadata without location data, suitable for public and shared views.
+func (m MediaMetadata) Redacted() MediaMetadata {
+	m.Latitude, m.Longitude, m.Altitude = nil, nil, nil
+	return m
+}
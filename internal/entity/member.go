@@ -0,0 +1,61 @@
+package entity
+
+import "time"
+
+// Role is the role of a user in an album. Every role includes the permissions of the roles below it.
+type Role string
+
+// Album roles, from the least to the most privileged. RoleOwner is held only by the album owner.
+const (
+	RoleViewer      Role = "viewer"
+	RoleContributor Role = "contributor"
+	RoleEditor      Role = "editor"
+	RoleCoOwner     Role = "co-owner"
+	RoleOwner       Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleContributor: 2, RoleEditor: 3, RoleCoOwner: 4, RoleOwner: 5}
+
+// Valid reports whether the role can be granted to an album member.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok && r != RoleOwner
+}
+
+// AtLeast reports whether the role includes the permissions of the other role.
+func (r Role) AtLeast(other Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[other]
+}
+
+// AlbumMember represents a user with access to an album.
+type AlbumMember struct {
+	AlbumID   string    `json:"album_id"`
+	UserID    int       `json:"user_id"`
+	Role      Role      `json:"role"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invitation statuses.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+// AlbumInvitation represents an invitation of a user to become an album member.
+type AlbumInvitation struct {
+	ID           string     `json:"id"`
+	AlbumID      string     `json:"album_id"`
+	AlbumName    string     `json:"album_name" db:"album_name"`
+	InviterID    int        `json:"inviter_id"`
+	InviteeID    *int       `json:"invitee_id"`
+	InviteeEmail string     `json:"invitee_email"`
+	Role         Role       `json:"role"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RespondedAt  *time.Time `json:"responded_at"`
+}
@@ -0,0 +1,117 @@
+package entity
+
+import "time"
+
+// ReportTarget is the kind of content a report is about.
+type ReportTarget string
+
+// The report targets. Profiles are identified by the handle of their user.
+const (
+	ReportAlbum   ReportTarget = "album"
+	ReportMedia   ReportTarget = "media"
+	ReportComment ReportTarget = "comment"
+	ReportProfile ReportTarget = "profile"
+)
+
+// ReportReason is why content was reported.
+type ReportReason string
+
+// The report reasons.
+const (
+	ReasonSpam          ReportReason = "spam"
+	ReasonHarassment    ReportReason = "harassment"
+	ReasonHate          ReportReason = "hate"
+	ReasonViolence      ReportReason = "violence"
+	ReasonNudity        ReportReason = "nudity"
+	ReasonCopyright     ReportReason = "copyright"
+	ReasonImpersonation ReportReason = "impersonation"
+	ReasonOther         ReportReason = "other"
+)
+
+// ReportStatus is the state of a report in the moderation queue.
+type ReportStatus string
+
+// The report statuses. Resolved reports led to an action, dismissed ones did not.
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+// Report is a report of content by a user.
+type Report struct {
+	ID         string       `json:"id"`
+	TargetType ReportTarget `json:"target_type"`
+	TargetID   string       `json:"target_id"`
+	Reason     ReportReason `json:"reason"`
+	Details    string       `json:"details"`
+	Status     ReportStatus `json:"status"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ResolvedAt *time.Time   `json:"resolved_at"`
+
+	// The following fields are only shown to moderators.
+
+	// ReporterHandle and TargetUserHandle are the handles of the reporter and of the user responsible
+	// for the content: the owner of an album, the uploader of a media item, the author of a comment.
+	ReporterHandle   string `json:"reporter_handle,omitempty"`
+	TargetUserHandle string `json:"target_user_handle,omitempty"`
+	// Snapshot is the content as it was reported, kept in case it is changed or deleted later.
+	Snapshot       string             `json:"snapshot,omitempty"`
+	ResolutionNote string             `json:"resolution_note,omitempty"`
+	ResolvedBy     string             `json:"resolved_by,omitempty"`
+	Actions        []ModerationAction `json:"actions,omitempty" db:"-"`
+}
+
+// ModerationActionType is a measure taken by a moderator.
+type ModerationActionType string
+
+// The moderation actions. Hiding applies to the reported content, warnings and suspensions to the user
+// responsible for it.
+const (
+	ActionHide    ModerationActionType = "hide"
+	ActionWarn    ModerationActionType = "warn"
+	ActionSuspend ModerationActionType = "suspend"
+)
+
+// ModerationAction is a measure taken by a moderator. Actions are reverted rather than deleted.
+type ModerationAction struct {
+	ID               string               `json:"id"`
+	ReportID         *string              `json:"report_id"`
+	Type             ModerationActionType `json:"type"`
+	TargetType       ReportTarget         `json:"target_type"`
+	TargetID         string               `json:"target_id"`
+	TargetUserHandle string               `json:"target_user_handle"`
+	ModeratorHandle  string               `json:"moderator_handle"`
+	// Message is shown to the affected user, Note only to moderators.
+	Message string `json:"message"`
+	Note    string `json:"note"`
+	// ExpiresAt ends a suspension. It is nil for other actions and for indefinite suspensions.
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevertedAt *time.Time `json:"reverted_at"`
+	RevertedBy string     `json:"reverted_by,omitempty"`
+	RevertNote string     `json:"revert_note,omitempty"`
+}
+
+// ModerationEvent is the kind of a moderation log entry.
+type ModerationEvent string
+
+// The moderation log events.
+const (
+	EventActionTaken     ModerationEvent = "action_taken"
+	EventActionReverted  ModerationEvent = "action_reverted"
+	EventReportResolved  ModerationEvent = "report_resolved"
+	EventReportDismissed ModerationEvent = "report_dismissed"
+	EventReportReopened  ModerationEvent = "report_reopened"
+)
+
+// ModerationLogEntry records a decision of a moderator.
+type ModerationLogEntry struct {
+	ID              int64           `json:"id"`
+	ModeratorHandle string          `json:"moderator_handle"`
+	Event           ModerationEvent `json:"event"`
+	ReportID        *string         `json:"report_id"`
+	ActionID        *string         `json:"action_id"`
+	Note            string          `json:"note"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
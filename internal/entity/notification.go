@@ -0,0 +1,64 @@
+package entity
+
+import "time"
+
+// NotificationType is the kind of event a notification is about.
+type NotificationType string
+
+// The notification types.
+const (
+	NotificationInvitation NotificationType = "invitation"
+	NotificationComment    NotificationType = "comment"
+	NotificationReply      NotificationType = "reply"
+	NotificationMention    NotificationType = "mention"
+	NotificationReaction   NotificationType = "reaction"
+	NotificationSecurity   NotificationType = "security"
+	// NotificationModeration tells users about moderation decisions on their content and their reports.
+	NotificationModeration NotificationType = "moderation"
+)
+
+// NotificationTypes lists all notification types.
+var NotificationTypes = []NotificationType{
+	NotificationInvitation, NotificationComment, NotificationReply, NotificationMention, NotificationReaction, NotificationSecurity,
+	NotificationModeration,
+}
+
+// Valid checks if the notification type is known.
+func (t NotificationType) Valid() bool {
+	for _, v := range NotificationTypes {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Optional reports whether users can turn off notifications of this type. Security alerts and moderation
+// decisions are always delivered.
+func (t NotificationType) Optional() bool {
+	return t != NotificationSecurity && t != NotificationModeration
+}
+
+// Anonymous reports whether notifications of this type are sent on behalf of the service rather than the
+// user causing them, so they carry no actor. Moderators stay anonymous.
+func (t NotificationType) Anonymous() bool {
+	return t == NotificationModeration
+}
+
+// Notification is an event shown to a single user.
+type Notification struct {
+	ID        int64            `json:"id"`
+	UserID    int              `json:"-"`
+	Type      NotificationType `json:"type"`
+	ActorID   *int             `json:"actor_id,omitempty"`
+	AlbumID   *string          `json:"album_id,omitempty"`
+	MediaID   *string          `json:"media_id,omitempty"`
+	CommentID *string          `json:"comment_id,omitempty"`
+	// Detail holds type specific information, such as the reaction type or the address of a sign-in.
+	Detail    string     `json:"detail,omitempty"`
+	ReadAt    *time.Time `json:"read_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	// ActorFirstName and ActorLastName are empty for notifications without an actor.
+	ActorFirstName string `json:"actor_first_name,omitempty"`
+	ActorLastName  string `json:"actor_last_name,omitempty"`
+}
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The length limits of handles. The maximum matches the longest handle that can be mentioned in comments.
+const (
+	MinHandleLength = 3
+	MaxHandleLength = 30
+)
+
+// handleRegex matches the characters allowed in handles, the same ones that mentions consist of.
+var handleRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// reservedHandles are names that could be mistaken for the service, its staff or its routes.
+var reservedHandles = map[string]bool{
+	"about": true, "abuse": true, "account": true, "admin": true, "administrator": true, "albums": true,
+	"all": true, "api": true, "everyone": true, "feed": true, "help": true, "here": true, "info": true,
+	"login": true, "logout": true, "mail": true, "me": true, "media": true, "mod": true, "moderator": true,
+	"notifications": true, "null": true, "official": true, "postmaster": true, "privacy": true,
+	"register": true, "root": true, "search": true, "security": true, "settings": true, "shareflow": true,
+	"signin": true, "signup": true, "staff": true, "support": true, "system": true, "team": true,
+	"terms": true, "undefined": true, "user": true, "users": true, "webmaster": true,
+}
+
+// NormalizeHandle returns the form in which handles are compared, so that they are unique regardless of case.
+func NormalizeHandle(handle string) string {
+	return strings.ToLower(handle)
+}
+
+// ReservedHandle reports whether a handle is reserved and cannot be claimed by users.
+func ReservedHandle(handle string) bool {
+	return reservedHandles[NormalizeHandle(handle)]
+}
+
+// ValidHandle reports whether a handle is well-formed: letters, digits and underscores of the allowed
+// length, with at least one letter so that handles cannot be confused with user IDs.
+func ValidHandle(handle string) bool {
+	return len(handle) >= MinHandleLength && len(handle) <= MaxHandleLength &&
+		handleRegex.MatchString(handle) && strings.ContainsFunc(handle, func(r rune) bool {
+		return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+	})
+}
+
+// UserHandle is the handle of the current user.
+type UserHandle struct {
+	Handle string `json:"handle"`
+	// ChangedAt is when the handle was last changed, or nil if it is the generated one.
+	ChangedAt *time.Time `json:"changed_at"`
+	// NextChangeAt is the earliest time the handle can be changed again.
+	NextChangeAt *time.Time `json:"next_change_at,omitempty"`
+}
+
+// Profile is the public profile of a user. It deliberately leaves out the email address and the user ID.
+type Profile struct {
+	Handle     string `json:"handle"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	ProfileIMG string `json:"profile_img"`
+	// Albums are the albums the user lists publicly, newest first.
+	Albums []ProfileAlbum `json:"albums"`
+}
+
+// ProfileAlbum is an album listed on a public profile.
+type ProfileAlbum struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// PushPlatform is the push service a push subscription belongs to.
+type PushPlatform string
+
+// The push platforms.
+const (
+	// PushWeb is a browser subscription of the Web Push protocol.
+	PushWeb PushPlatform = "web"
+	// PushFCM is an Android or web app registered with Firebase Cloud Messaging.
+	PushFCM PushPlatform = "fcm"
+	// PushAPNs is an Apple device registered with the Apple Push Notification service.
+	PushAPNs PushPlatform = "apns"
+)
+
+// PushSubscription is a browser or device of a user that receives push notifications.
+type PushSubscription struct {
+	ID       string       `json:"id"`
+	UserID   int          `json:"-"`
+	Platform PushPlatform `json:"platform"`
+	// Endpoint is the push endpoint URL of a browser or the token of a device.
+	Endpoint string `json:"endpoint"`
+	// P256dh and Auth are the base64url encoded encryption keys of a browser subscription.
+	P256dh     string     `json:"-"`
+	Auth       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
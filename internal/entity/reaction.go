@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// ReactionType is a kind of reaction users can leave on media items, identified by a short name.
+type ReactionType struct {
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+}
+
+// Reactor is a user who reacted to a media item.
+type Reactor struct {
+	UserID    int       `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Reaction  string    `json:"reaction"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,10 @@
+package entity
+
+// SearchResult is an album, a media item or a comment found by a search, depending on its type.
+type SearchResult struct {
+	Type    string   `json:"type"`
+	Score   int      `json:"score"`
+	Album   *Album   `json:"album,omitempty"`
+	Media   *Media   `json:"media,omitempty"`
+	Comment *Comment `json:"comment,omitempty"`
+}
@@ -0,0 +1,39 @@
+package entity
+
+import "time"
+
+// ShareLink represents a public link to an album that can be used without an account.
+type ShareLink struct {
+	ID            string     `json:"id"`
+	AlbumID       string     `json:"album_id"`
+	CreatedBy     int        `json:"created_by"`
+	Token         string     `json:"token"`
+	Label         string     `json:"label"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	PasswordHash  *string    `json:"-"`
+	MaxViews      *int       `json:"max_views"`
+	ViewCount     int        `json:"view_count"`
+	AllowDownload bool       `json:"allow_download"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// HasPassword reports whether the link is protected with a password.
+func (l ShareLink) HasPassword() bool {
+	return l.PasswordHash != nil
+}
+
+// Active reports whether the link is neither revoked nor expired at the given time.
+func (l ShareLink) Active(now time.Time) bool {
+	if l.RevokedAt != nil {
+		return false
+	}
+	return l.ExpiresAt == nil || now.Before(*l.ExpiresAt)
+}
+
+// RequiresAccess reports whether the media of the link can only be requested with the access parameters
+// handed out when the link is viewed. This holds for password protected links and for view limited ones,
+// whose media URLs would otherwise keep working after the last view.
+func (l ShareLink) RequiresAccess() bool {
+	return l.HasPassword() || l.MaxViews != nil
+}
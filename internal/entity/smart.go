@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SmartRules are the saved criteria that compute the content of a smart album. All of the given criteria
+// have to match, and at least one has to be given.
+type SmartRules struct {
+	// Tags are normalized tags a media item has to carry.
+	Tags []string `json:"tags,omitempty"`
+	// TakenAfter and TakenBefore restrict media items to a capture time range. Items without a capture time
+	// are dated by their upload time.
+	TakenAfter  *time.Time `json:"taken_after,omitempty"`
+	TakenBefore *time.Time `json:"taken_before,omitempty"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty"`
+	// UploaderID restricts media items to the uploads of a user.
+	UploaderID *int `json:"uploader_id,omitempty"`
+	// SourceAlbumID restricts media items to a regular album.
+	SourceAlbumID string `json:"source_album_id,omitempty"`
+}
+
+// Empty reports whether no criteria are given.
+func (r SmartRules) Empty() bool {
+	return len(r.Tags) == 0 && r.TakenAfter == nil && r.TakenBefore == nil && r.CameraMake == "" &&
+		r.CameraModel == "" && r.UploaderID == nil && r.SourceAlbumID == ""
+}
+
+// Value implements driver.Valuer, storing the rules as JSON.
+func (r SmartRules) Value() (driver.Value, error) {
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner, reading the rules from JSON.
+func (r *SmartRules) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("entity: cannot scan %T into SmartRules", src)
+}
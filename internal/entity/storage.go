@@ -0,0 +1,29 @@
+package entity
+
+// StorageUsage represents the storage used by a user together with the user's quota.
+// Every media item counts with its full size, even if its content is shared with other media items.
+type StorageUsage struct {
+	UserID    int   `json:"user_id"`
+	BytesUsed int64 `json:"bytes_used"`
+	ItemCount int   `json:"item_count"`
+	// QuotaBytes is the storage limit of the user. Zero means unlimited.
+	QuotaBytes int64 `json:"quota_bytes"`
+	// CustomQuota is set when the default quota has been overridden for the user.
+	CustomQuota bool `json:"custom_quota"`
+}
+
+// Allows reports whether size more bytes can be stored without exceeding the quota.
+func (u StorageUsage) Allows(size int64) bool {
+	return u.QuotaBytes == 0 || u.BytesUsed+size <= u.QuotaBytes
+}
+
+// Remaining returns the number of bytes that can still be stored, or -1 if the quota is unlimited.
+func (u StorageUsage) Remaining() int64 {
+	if u.QuotaBytes == 0 {
+		return -1
+	}
+	if u.BytesUsed >= u.QuotaBytes {
+		return 0
+	}
+	return u.QuotaBytes - u.BytesUsed
+}
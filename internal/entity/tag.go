@@ -0,0 +1,45 @@
+package entity
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// TagCount is a tag together with the number of albums and media items it is attached to.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// MaxTagLength is the maximum number of characters of a normalized tag.
+const MaxTagLength = 64
+
+// NormalizeTag returns the canonical form of a tag: Unicode NFKC normalized, lowercased, without a leading
+// '#', with runs of whitespace collapsed into a single space and without control characters. It returns
+// an empty string if nothing is left of the tag.
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(norm.NFKC.String(tag))
+	var b strings.Builder
+	space := false
+	for _, r := range tag {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+		case unicode.IsControl(r) || unicode.Is(unicode.Cf, r):
+		default:
+			if space {
+				b.WriteRune(' ')
+				space = false
+			}
+			b.WriteRune(r)
+		}
+	}
+	tag = strings.TrimLeft(b.String(), "#")
+	tag = strings.TrimSpace(tag)
+	if runes := []rune(tag); len(runes) > MaxTagLength {
+		tag = strings.TrimSpace(string(runes[:MaxTagLength]))
+	}
+	return tag
+}
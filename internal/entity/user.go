@@ -7,6 +7,7 @@ type User struct {
 	HashedPassword string
 	FirstName      string
 	LastName       string
+	Handle         string
 	ProfileIMG     string
 	AuthCode       string
 	CreatedAt      string
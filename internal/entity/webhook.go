@@ -0,0 +1,64 @@
+package entity
+
+import "time"
+
+// Webhook is an endpoint that receives the events of its owner as signed HTTP requests. Global webhooks
+// of admins receive the events of all users.
+type Webhook struct {
+	ID         string   `json:"id"`
+	OwnerID    int      `json:"owner_id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types" db:"-"`
+	Global     bool     `json:"global"`
+	Active     bool     `json:"active"`
+	// Secret signs the payloads. It is only returned when the webhook is created or the secret is rotated.
+	Secret string `json:"secret,omitempty" db:"-"`
+	// FailingSince is the time of the first failed attempt since the last successful delivery.
+	FailingSince *time.Time `json:"failing_since"`
+	// DisabledAt is set when the webhook was disabled automatically because its deliveries kept failing.
+	DisabledAt *time.Time `json:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// WebhookDeliveryStatus is the state of a webhook delivery.
+type WebhookDeliveryStatus string
+
+// The webhook delivery states.
+const (
+	DeliveryPending   WebhookDeliveryStatus = "pending"
+	DeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	DeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event sent to a webhook, retried until it succeeds or runs out of attempts.
+type WebhookDelivery struct {
+	ID        int64                 `json:"id"`
+	WebhookID string                `json:"webhook_id"`
+	EventID   string                `json:"event_id"`
+	EventType string                `json:"event_type"`
+	Payload   string                `json:"payload,omitempty"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	Attempts  int                   `json:"attempts"`
+	// RedeliveryOf is the ID of the delivery this one repeats on request.
+	RedeliveryOf   *int64     `json:"redelivery_of"`
+	ResponseStatus *int       `json:"response_status"`
+	Error          string     `json:"error"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	// Log lists the attempts of the delivery. It is only filled when a single delivery is requested.
+	Log []WebhookAttempt `json:"log,omitempty" db:"-"`
+}
+
+// WebhookAttempt is a single request of a webhook delivery.
+type WebhookAttempt struct {
+	ID             int64     `json:"-"`
+	DeliveryID     int64     `json:"-"`
+	Attempt        int       `json:"attempt"`
+	ResponseStatus *int      `json:"response_status"`
+	ResponseBody   string    `json:"response_body"`
+	Error          string    `json:"error"`
+	DurationMS     int       `json:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at"`
+}
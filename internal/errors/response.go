@@ -79,6 +79,29 @@ func BadRequest(msg string) ErrorResponse {
 	}
 }
 
+// QuotaExceeded creates a new error response representing a request that would exceed a storage quota (HTTP 413)
+func QuotaExceeded(msg string, details interface{}) ErrorResponse {
+	if msg == "" {
+		msg = "The request would exceed your storage quota."
+	}
+	return ErrorResponse{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: msg,
+		Details: details,
+	}
+}
+
+// ServiceUnavailable creates a new error response representing a temporarily unavailable service (HTTP 503)
+func ServiceUnavailable(msg string) ErrorResponse {
+	if msg == "" {
+		msg = "The service is temporarily unavailable."
+	}
+	return ErrorResponse{
+		Status:  http.StatusServiceUnavailable,
+		Message: msg,
+	}
+}
+
 type invalidField struct {
 	Field string `json:"field"`
 	Error string `json:"error"`
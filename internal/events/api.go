@@ -0,0 +1,105 @@
+package events
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// heartbeatInterval is how often a comment is sent on an idle stream, so that proxies keep the
+// connection open and clients notice a dead connection.
+const heartbeatInterval = 15 * time.Second
+
+// retryDelay is the reconnection delay suggested to clients, in milliseconds.
+const retryDelay = 3000
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the event stream handler, which requires an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	// EventSource cannot send headers, so the token may also be passed in the access_token query parameter
+	r.Get("/events", auth.QueryTokenHandler, authHandler, res.stream)
+}
+
+// stream sends the events of the current user as Server-Sent Events until the client disconnects or the
+// server shuts down. A client resumes with the Last-Event-ID header, or the last_event_id query parameter.
+// If events were missed, a reset event is sent before the replayed events.
+func (r resource) stream(c *routing.Context) error {
+	ctx := c.Request.Context()
+	lastEventID := c.Request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	sub, replay, complete, err := r.service.Subscribe(ctx, lastEventID)
+	if err == ErrClosed {
+		return errors.ServiceUnavailable("the server is shutting down")
+	}
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(c.Response)
+	h := c.Response.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	c.Response.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(c.Response, "retry: %d\n\n", retryDelay); err != nil {
+		return nil
+	}
+	if lastEventID != "" && !complete {
+		if err := write(c.Response, Event{Type: TypeReset, Data: []byte("{}")}); err != nil {
+			return nil
+		}
+	}
+	for _, e := range replay {
+		if err := write(c.Response, e); err != nil {
+			return nil
+		}
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		if err := rc.Flush(); err != nil {
+			r.logger.WithContext(ctx).WithError(err).Warn("Event stream cannot be flushed")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			err = write(c.Response, e)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(c.Response, ": heartbeat\n\n")
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// write writes an event in the Server-Sent Events format. Events without an ID do not move the resume
+// position of the client.
+func write(w http.ResponseWriter, e Event) error {
+	if e.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %s\n", strconv.FormatUint(e.ID, 10)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, e.Data)
+	return err
+}
@@ -0,0 +1,187 @@
+package events
+
+import (
+	stderrors "errors"
+	"sync"
+	"time"
+)
+
+const (
+	// bufferSize is the number of recent events kept per topic for resuming subscriptions.
+	bufferSize = 100
+	// bufferTTL is how long the events of a topic without subscribers are kept.
+	bufferTTL = 5 * time.Minute
+	// queueSize is the number of events a subscriber may fall behind before it is dropped.
+	queueSize = 64
+)
+
+// ErrClosed is returned when subscribing to a closed broker.
+var ErrClosed = stderrors.New("events: broker closed")
+
+// Event is a message published to a topic.
+type Event struct {
+	// ID increases with every event of the broker, so a subscriber can resume after the last event it received.
+	ID   uint64
+	Type string
+	// Data is the JSON encoded payload.
+	Data []byte
+	// Actor is the ID of the user whose action the event is about, or zero if there is none.
+	Actor int
+	time  time.Time
+}
+
+// Broker distributes events to the subscribers of topics within this process. It keeps the recent events
+// of every topic, so subscribers that reconnect can resume where they left off. Subscribers that do not
+// keep up are dropped instead of blocking the publishers; they can reconnect and resume.
+type Broker struct {
+	mu     sync.Mutex
+	start  uint64
+	seq    uint64
+	topics map[string]*topic
+	// pruned is the ID of the newest event of a topic that was removed from the broker.
+	pruned     uint64
+	prunedTime time.Time
+	closed     bool
+}
+
+type topic struct {
+	events []Event
+	// dropped is the ID of the newest event that is no longer in the buffer.
+	dropped uint64
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives the events of a topic.
+type Subscription struct {
+	broker *Broker
+	topic  string
+	c      chan Event
+}
+
+// NewBroker creates a new broker. Event IDs start at the current time in nanoseconds, so IDs handed
+// out by a previous process are recognized as unknown rather than being confused with new events.
+func NewBroker() *Broker {
+	start := uint64(time.Now().UnixNano())
+	return &Broker{start: start, seq: start, topics: map[string]*topic{}, prunedTime: time.Now()}
+}
+
+// topic returns a topic, creating it if needed. The lock must be held.
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		// the events of a removed topic are gone, which a new topic cannot tell apart from its own
+		t = &topic{dropped: b.pruned, subs: map[*Subscription]struct{}{}}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish sends an event about an action of the given actor to the subscribers of a topic and returns it
+// with its assigned ID.
+func (b *Broker) Publish(name, eventType string, actor int, data []byte) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e := Event{ID: b.seq, Type: eventType, Data: data, Actor: actor, time: time.Now()}
+	if b.closed {
+		return e
+	}
+	t := b.topic(name)
+	if len(t.events) == bufferSize {
+		t.dropped = t.events[0].ID
+		t.events = append(t.events[:0], t.events[1:]...)
+	}
+	t.events = append(t.events, e)
+	for sub := range t.subs {
+		select {
+		case sub.c <- e:
+		default:
+			b.drop(t, sub)
+		}
+	}
+	b.prune()
+	return e
+}
+
+// prune removes the topics without subscribers whose last event is older than bufferTTL. It runs at
+// most once a minute. The lock must be held.
+func (b *Broker) prune() {
+	if time.Since(b.prunedTime) < time.Minute {
+		return
+	}
+	b.prunedTime = time.Now()
+	for name, t := range b.topics {
+		if len(t.subs) > 0 || (len(t.events) > 0 && time.Since(t.events[len(t.events)-1].time) < bufferTTL) {
+			continue
+		}
+		if len(t.events) > 0 && t.events[len(t.events)-1].ID > b.pruned {
+			b.pruned = t.events[len(t.events)-1].ID
+		}
+		delete(b.topics, name)
+	}
+}
+
+// Subscribe subscribes to a topic. When resume is true, the buffered events after lastID are returned
+// for replay, and complete reports whether they are all the events published since lastID. The replayed
+// events precede all events delivered through the subscription.
+func (b *Broker) Subscribe(name string, lastID uint64, resume bool) (sub *Subscription, replay []Event, complete bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, false, ErrClosed
+	}
+	t := b.topic(name)
+	if resume {
+		complete = lastID >= b.start && lastID >= t.dropped && lastID <= b.seq
+		for _, e := range t.events {
+			if e.ID > lastID {
+				replay = append(replay, e)
+			}
+		}
+	}
+	sub = &Subscription{broker: b, topic: name, c: make(chan Event, queueSize)}
+	t.subs[sub] = struct{}{}
+	return sub, replay, complete, nil
+}
+
+// drop removes a subscriber and closes its channel. The lock must be held.
+func (b *Broker) drop(t *topic, sub *Subscription) {
+	delete(t.subs, sub)
+	close(sub.c)
+}
+
+// Closed reports whether the broker was closed.
+func (b *Broker) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// Close closes the subscriptions of all topics and rejects new ones.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, t := range b.topics {
+		for sub := range t.subs {
+			b.drop(t, sub)
+		}
+	}
+}
+
+// Events returns the channel delivering the events of the subscription. It is closed when the
+// subscription is closed, when the subscriber fell behind and when the broker is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.c
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if t, ok := s.broker.topics[s.topic]; ok {
+		if _, ok := t.subs[s]; ok {
+			s.broker.drop(t, s)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package events implements the real-time events of users, such as new notifications, album changes and
+// the status of uploads, which clients receive as a Server-Sent Events stream. The changes of an album are
+// also published to a topic of the album, which the live album channels subscribe to.
+//
+// Events are distributed within the process, so every client receives the events published by the
+// instance it is connected to. Durable consumers, such as webhooks, receive them through a Sink.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// The event types.
+const (
+	// TypeNotification carries a new notification.
+	TypeNotification = "notification"
+	// TypeAlbumUpdated tells that the details or the members of an album changed.
+	TypeAlbumUpdated = "album.updated"
+	// TypeAlbumDeleted tells that an album was moved to the trash.
+	TypeAlbumDeleted = "album.deleted"
+	// TypeMediaAdded tells that a media item was added to an album.
+	TypeMediaAdded = "media.added"
+	// TypeMediaMoved tells that a media item was moved within the manual order of an album.
+	TypeMediaMoved = "media.moved"
+	// TypeMediaDeleted tells that a media item was moved to the trash.
+	TypeMediaDeleted = "media.deleted"
+	// TypeCommentAdded tells that a comment was posted on an album or a media item.
+	TypeCommentAdded = "comment.added"
+	// TypeUploadCompleted tells the uploader that an upload was processed and saved.
+	TypeUploadCompleted = "upload.completed"
+	// TypeUploadFailed tells the uploader that an upload could not be processed.
+	TypeUploadFailed = "upload.failed"
+	// TypeReset tells a resuming client that events were missed, so it should reload its state.
+	TypeReset = "reset"
+)
+
+// Types lists the event types that are published about changes, which excludes the reset of a stream.
+var Types = []string{
+	TypeNotification, TypeAlbumUpdated, TypeAlbumDeleted, TypeMediaAdded, TypeMediaMoved, TypeMediaDeleted,
+	TypeCommentAdded, TypeUploadCompleted, TypeUploadFailed,
+}
+
+// ValidType checks if an event type is one of the published types.
+func ValidType(eventType string) bool {
+	for _, t := range Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// AlbumChange is the payload of the album and media events.
+type AlbumChange struct {
+	AlbumID   string `json:"album_id"`
+	MediaID   string `json:"media_id,omitempty"`
+	CommentID string `json:"comment_id,omitempty"`
+}
+
+// UploadStatus is the payload of the upload events.
+type UploadStatus struct {
+	AlbumID  string `json:"album_id"`
+	MediaID  string `json:"media_id,omitempty"`
+	Filename string `json:"filename"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Publisher publishes events. Events published within a transaction are sent once it commits.
+type Publisher interface {
+	// Publish sends an event to users.
+	Publish(ctx context.Context, eventType string, data interface{}, users ...int) error
+	// PublishAlbum sends an event to everyone who can view an album and to the topic of the album. The
+	// recipients are determined immediately, so an event about removing a member still reaches that member.
+	// Members who are hidden from the current user by a block, or who blocked or muted the current user,
+	// do not receive it, and subscribers of the topic can tell the current user as the actor of the event.
+	PublishAlbum(ctx context.Context, albumID, eventType string, data interface{}) error
+}
+
+// Sink receives every published event together with its recipients. It is called with the context of
+// the publisher, so it can record the event in the same transaction as the change it is about.
+type Sink interface {
+	Receive(ctx context.Context, eventType string, payload json.RawMessage, users []int) error
+}
+
+// Service encapsulates the event logic.
+type Service interface {
+	Publisher
+	// Subscribe subscribes the current user to their events. When lastEventID is not empty, the buffered
+	// events after it are returned for replay, and complete reports whether no event was missed.
+	Subscribe(ctx context.Context, lastEventID string) (sub *Subscription, replay []Event, complete bool, err error)
+	// SubscribeAlbum subscribes to the topic of an album. The caller has to authorize the access.
+	SubscribeAlbum(albumID string) (*Subscription, error)
+	// Broadcast sends an event to the topic of an album only, such as the presence in its live channel.
+	Broadcast(albumID, eventType string, data interface{}) error
+	// Closed reports whether the service was closed.
+	Closed() bool
+	// Close ends all subscriptions, which ends the event streams.
+	Close()
+}
+
+type service struct {
+	db     *dbcontext.DB
+	broker *Broker
+	sink   Sink
+	logger *logrus.Logger
+}
+
+// NewService creates a new event service that also passes the events to the given sink.
+func NewService(db *dbcontext.DB, sink Sink, logger *logrus.Logger) Service {
+	return service{db, NewBroker(), sink, logger}
+}
+
+// userTopic returns the topic of the events of a user.
+func userTopic(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// albumTopic returns the topic of the changes of an album.
+func albumTopic(albumID string) string {
+	return "album:" + albumID
+}
+
+func (s service) Publish(ctx context.Context, eventType string, data interface{}, users ...int) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := s.sink.Receive(ctx, eventType, payload, users); err != nil {
+		return err
+	}
+	actor := actorOf(ctx)
+	dbcontext.AfterCommit(ctx, func() {
+		for _, user := range users {
+			s.broker.Publish(userTopic(user), eventType, actor, payload)
+		}
+	})
+	return nil
+}
+
+// actorOf returns the ID of the current user, or zero if there is none, such as in background jobs.
+func actorOf(ctx context.Context) int {
+	if user := auth.CurrentUser(ctx); user != nil {
+		return user.GetID()
+	}
+	return 0
+}
+
+func (s service) PublishAlbum(ctx context.Context, albumID, eventType string, data interface{}) error {
+	actor := actorOf(ctx)
+	var users []int
+	if err := s.db.With(ctx).NewQuery("SELECT user_id FROM (SELECT user_id FROM album_members WHERE album_id = {:album} " +
+		"UNION SELECT owner_id FROM albums WHERE id = {:album}) v WHERE NOT " + block.Hidden("v.user_id", "{:actor}")).
+		Bind(dbx.Params{"album": albumID, "actor": actor}).
+		Column(&users); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := s.sink.Receive(ctx, eventType, payload, users); err != nil {
+		return err
+	}
+	dbcontext.AfterCommit(ctx, func() {
+		s.broker.Publish(albumTopic(albumID), eventType, actor, payload)
+		for _, user := range users {
+			s.broker.Publish(userTopic(user), eventType, actor, payload)
+		}
+	})
+	return nil
+}
+
+func (s service) SubscribeAlbum(albumID string) (*Subscription, error) {
+	sub, _, _, err := s.broker.Subscribe(albumTopic(albumID), 0, false)
+	return sub, err
+}
+
+func (s service) Broadcast(albumID, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.broker.Publish(albumTopic(albumID), eventType, 0, payload)
+	return nil
+}
+
+func (s service) Closed() bool {
+	return s.broker.Closed()
+}
+
+func (s service) Subscribe(ctx context.Context, lastEventID string) (*Subscription, []Event, bool, error) {
+	var lastID uint64
+	if lastEventID != "" {
+		// an unknown ID resumes with a reset
+		lastID, _ = strconv.ParseUint(lastEventID, 10, 64)
+	}
+	return s.broker.Subscribe(userTopic(auth.CurrentUser(ctx).GetID()), lastID, lastEventID != "")
+}
+
+func (s service) Close() {
+	s.broker.Close()
+}
@@ -0,0 +1,95 @@
+package feed
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the follow and feed handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/feed", res.feed)
+	r.Put("/users/<id>/follow", res.follow)
+	r.Delete("/users/<id>/follow", res.unfollow)
+	r.Get("/users/<id>/followers", res.followers)
+	r.Get("/users/<id>/following", res.following)
+}
+
+// feed returns a page of the feed of the current user, selected by the cursor and limit query parameters.
+func (r resource) feed(c *routing.Context) error {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return errors.BadRequest("invalid limit")
+		}
+	}
+	feed, err := r.service.Feed(c.Request.Context(), c.Query("cursor"), limit)
+	if err != nil {
+		return err
+	}
+	return c.Write(feed)
+}
+
+func (r resource) follow(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.BadRequest("invalid user id")
+	}
+	if err := r.service.Follow(c.Request.Context(), userID); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) unfollow(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.BadRequest("invalid user id")
+	}
+	if err := r.service.Unfollow(c.Request.Context(), userID); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) followers(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.BadRequest("invalid user id")
+	}
+	pages := pagination.NewFromRequest(c.Request, -1)
+	follows, err := r.service.Followers(c.Request.Context(), userID, pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = follows
+	return c.Write(pages)
+}
+
+func (r resource) following(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.BadRequest("invalid user id")
+	}
+	pages := pagination.NewFromRequest(c.Request, -1)
+	follows, err := r.service.Following(c.Request.Context(), userID, pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = follows
+	return c.Write(pages)
+}
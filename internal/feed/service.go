@@ -0,0 +1,254 @@
+// Package feed implements following users and the activity feed of the users they follow.
+//
+// Feeds are written when an activity is published (fan-out on write), so reading a feed is a single
+// indexed range scan. An activity only reaches the followers who can view its album. The fan-out of
+// private albums starts from the album members, so its cost is bounded by the size of the album rather
+// than by the number of followers of the actor; activities in public albums reach all followers. Access is checked again when a feed is read, so items of albums
+// that were deleted or left since disappear.
+package feed
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// burstWindow is the time within which uploads of a user to the same album are merged into one activity.
+	burstWindow = 30 * time.Minute
+	// backfillSize is the number of recent activities added to the feed of a new follower.
+	backfillSize = 50
+	// DefaultLimit is the number of feed items returned when no limit is requested.
+	DefaultLimit = 20
+	// MaxLimit is the maximum number of feed items returned at once.
+	MaxLimit = 100
+)
+
+// Publisher records activities for the feeds of the followers of their actor.
+type Publisher interface {
+	// Publish records an activity of the current user. It uses the transaction found in the context, so
+	// it can be called together with the change the activity describes.
+	Publish(ctx context.Context, a entity.Activity) error
+}
+
+// Service encapsulates the follow and feed logic.
+type Service interface {
+	Publisher
+	// Follow makes the current user follow a user. Following a user twice has no effect.
+	Follow(ctx context.Context, userID int) error
+	// Unfollow makes the current user stop following a user and removes the user's activities from the feed.
+	Unfollow(ctx context.Context, userID int) error
+	// Followers returns a page of the followers of a user, most recent first.
+	Followers(ctx context.Context, userID int, pages *pagination.Pages) ([]entity.Follow, error)
+	// Following returns a page of the users a user follows, most recent first.
+	Following(ctx context.Context, userID int, pages *pagination.Pages) ([]entity.Follow, error)
+	// Feed returns the feed of the current user, newest first. The cursor is the NextCursor of the previous
+	// page, or empty for the first page. A limit of zero selects DefaultLimit.
+	Feed(ctx context.Context, cursor string, limit int) (Feed, error)
+}
+
+// Feed is a page of the feed of a user.
+type Feed struct {
+	Items []entity.FeedItem `json:"items"`
+	// NextCursor selects the next page. It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type service struct {
+	db     *dbcontext.DB
+	logger *logrus.Logger
+}
+
+// NewService creates a new feed service.
+func NewService(db *dbcontext.DB, logger *logrus.Logger) Service {
+	return service{db, logger}
+}
+
+// fanOut selects the followers of the actor bound to the "actor" parameter who can view the album bound
+// to the "album" parameter.
+var fanOut = "SELECT f.follower_id FROM (SELECT user_id FROM album_members WHERE album_id = {:album} " +
+	"UNION SELECT owner_id FROM albums WHERE id = {:album}) v " +
+	"JOIN follows f ON f.follower_id = v.user_id AND f.followee_id = {:actor} " +
+	"UNION SELECT f.follower_id FROM follows f JOIN albums a ON a.id = {:album} AND a.`public` " +
+	"WHERE f.followee_id = {:actor} AND NOT " + block.BlockedBy("a.owner_id", "f.follower_id")
+
+func (s service) Publish(ctx context.Context, a entity.Activity) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	a.ActorID = auth.CurrentUser(ctx).GetID()
+	if a.Type == entity.ActivityMediaUploaded {
+		// an upload shortly after another one to the same album extends the burst, whose followers
+		// already have it in their feeds
+		res, err := s.db.With(ctx).NewQuery("UPDATE activities SET count = count + 1, media_id = {:media}, updated_at = {:now} " +
+			"WHERE actor_id = {:actor} AND type = {:type} AND album_id = {:album} AND updated_at >= {:since} ORDER BY id DESC LIMIT 1").
+			Bind(dbx.Params{"media": a.MediaID, "now": now, "actor": a.ActorID, "type": a.Type, "album": a.AlbumID, "since": now.Add(-burstWindow)}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+	}
+	res, err := s.db.With(ctx).Insert("activities", dbx.Params{
+		"actor_id":   a.ActorID,
+		"type":       a.Type,
+		"album_id":   a.AlbumID,
+		"media_id":   a.MediaID,
+		"comment_id": a.CommentID,
+		"created_at": now,
+		"updated_at": now,
+	}).Execute()
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).NewQuery("INSERT IGNORE INTO feed_items (user_id, activity_id, created_at) SELECT follower_id, {:activity}, {:now} FROM (" + fanOut + ") r").
+		Bind(dbx.Params{"activity": id, "now": now, "actor": a.ActorID, "album": a.AlbumID}).
+		Execute()
+	return err
+}
+
+func (s service) Follow(ctx context.Context, userID int) error {
+	user := auth.CurrentUser(ctx).GetID()
+	if userID == user {
+		return errors.BadRequest("you cannot follow yourself")
+	}
+	if err := s.exists(ctx, userID); err != nil {
+		return err
+	}
+	if blocked, err := block.Blocked(ctx, s.db, user, userID); err != nil {
+		return err
+	} else if blocked {
+		return errors.Forbidden("you cannot follow this user")
+	}
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		res, err := s.db.With(ctx).NewQuery("INSERT IGNORE INTO follows (follower_id, followee_id, created_at) VALUES ({:user}, {:followee}, {:now})").
+			Bind(dbx.Params{"user": user, "followee": userID, "now": time.Now().UTC().Truncate(time.Second)}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		// a new follower starts with the recent activities of the user in albums the follower can view,
+		// inserted oldest first so that the feed keeps their order
+		_, err = s.db.With(ctx).NewQuery("INSERT IGNORE INTO feed_items (user_id, activity_id, created_at) SELECT {:user}, id, {:now} FROM (" +
+			"SELECT act.id FROM activities act JOIN albums a ON a.id = act.album_id " +
+			block.MemberJoin("{:user}") +
+			"WHERE act.actor_id = {:followee} AND " + block.AlbumVisible("{:user}") + " " +
+			"ORDER BY act.id DESC LIMIT {:limit}) r ORDER BY id").
+			Bind(dbx.Params{"user": user, "followee": userID, "now": time.Now().UTC().Truncate(time.Second), "limit": backfillSize}).
+			Execute()
+		return err
+	})
+}
+
+func (s service) Unfollow(ctx context.Context, userID int) error {
+	user := auth.CurrentUser(ctx).GetID()
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Delete("follows", dbx.HashExp{"follower_id": user, "followee_id": userID}).Execute(); err != nil {
+			return err
+		}
+		_, err := s.db.With(ctx).NewQuery("DELETE fi FROM feed_items fi JOIN activities act ON act.id = fi.activity_id " +
+			"WHERE fi.user_id = {:user} AND act.actor_id = {:followee}").
+			Bind(dbx.Params{"user": user, "followee": userID}).
+			Execute()
+		return err
+	})
+}
+
+// exists returns a NotFound error unless the user exists.
+func (s service) exists(ctx context.Context, userID int) error {
+	var n int
+	if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM users WHERE id={:user}").
+		Bind(dbx.Params{"user": userID}).
+		Row(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.NotFound("user not found")
+	}
+	return nil
+}
+
+func (s service) Followers(ctx context.Context, userID int, pages *pagination.Pages) ([]entity.Follow, error) {
+	return s.follows(ctx, "followee_id", "follower_id", userID, pages)
+}
+
+func (s service) Following(ctx context.Context, userID int, pages *pagination.Pages) ([]entity.Follow, error) {
+	return s.follows(ctx, "follower_id", "followee_id", userID, pages)
+}
+
+// follows returns a page of the users in the other column of the follows rows whose column is the user.
+func (s service) follows(ctx context.Context, column, other string, userID int, pages *pagination.Pages) ([]entity.Follow, error) {
+	if err := s.exists(ctx, userID); err != nil {
+		return nil, err
+	}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("follows").Where(dbx.HashExp{column: userID}).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	follows := []entity.Follow{}
+	err := s.db.With(ctx).Select("f."+other+" AS user_id", "u.first_name", "u.last_name", "f.created_at").
+		From("follows f").
+		InnerJoin("users u", dbx.NewExp("u.id = f."+other)).
+		Where(dbx.HashExp{"f." + column: userID}).
+		OrderBy("f.created_at DESC", "f."+other).
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&follows)
+	return follows, err
+}
+
+func (s service) Feed(ctx context.Context, cursor string, limit int) (Feed, error) {
+	feed := Feed{Items: []entity.FeedItem{}}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	params := dbx.Params{"user": auth.CurrentUser(ctx).GetID(), "limit": limit + 1}
+	where := ""
+	if cursor != "" {
+		before, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return feed, errors.BadRequest("invalid cursor")
+		}
+		where = "AND fi.id < {:before} "
+		params["before"] = before
+	}
+	err := s.db.With(ctx).NewQuery("SELECT fi.id AS item_id, act.*, u.first_name AS actor_first_name, u.last_name AS actor_last_name, a.name AS album_name " +
+		"FROM feed_items fi JOIN activities act ON act.id = fi.activity_id JOIN users u ON u.id = act.actor_id " +
+		"JOIN albums a ON a.id = act.album_id " + block.MemberJoin("{:user}") +
+		"LEFT JOIN comments c ON c.id = act.comment_id " +
+		"WHERE fi.user_id = {:user} " + where + "AND " + block.AlbumVisible("{:user}") + " " +
+		"AND (act.comment_id IS NULL OR (c.id IS NOT NULL AND c.deleted_at IS NULL AND c.hidden_at IS NULL)) " +
+		"AND NOT " + block.Hidden("{:user}", "act.actor_id") + " " +
+		"ORDER BY fi.id DESC LIMIT {:limit}").
+		Bind(params).
+		All(&feed.Items)
+	if err != nil {
+		return feed, err
+	}
+	if len(feed.Items) > limit {
+		feed.Items = feed.Items[:limit]
+		feed.NextCursor = strconv.FormatInt(feed.Items[limit-1].ItemID, 10)
+	}
+	return feed, nil
+}
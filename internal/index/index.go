@@ -0,0 +1,244 @@
+// Package index implements the full-text search index of albums, media items and comments.
+//
+// The index is an inverted index kept in the database. The searchable fields of every album, media
+// item and comment are split into terms by Tokenize, and each term is stored with its field, position and weight, so
+// that queries only look up terms and phrases can be matched by their positions. The owning services
+// update the index in the same transaction as the content, and items removed for good drop out of the
+// index through foreign key cascades.
+package index
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// Index maintains and queries the search index.
+type Index interface {
+	// IndexAlbum updates the index entries of an album. It has to be called whenever the name,
+	// description or tags of the album change.
+	IndexAlbum(ctx context.Context, id string) error
+	// IndexMedia updates the index entries of a media item. It has to be called whenever the filename,
+	// caption, metadata or tags of the item change.
+	IndexMedia(ctx context.Context, id string) error
+	// IndexComment updates the index entries of a comment. It has to be called whenever the body of the
+	// comment changes. Deleted comments are not indexed.
+	IndexComment(ctx context.Context, id string) error
+	// Backfill indexes the albums, media items and comments that have no index entries yet, such as
+	// content created before the index existed. Every item is indexed in a transaction of its own that
+	// locks the item, so it never overwrites the entries written by a concurrent change. It returns the
+	// number of indexed items.
+	Backfill(ctx context.Context) (int, error)
+	// Search returns a page of the albums, media items and comments matching the query that the current
+	// user can view, best matches first.
+	Search(ctx context.Context, q Query, pages *pagination.Pages) ([]Hit, error)
+}
+
+// field is an indexed field. Matches in fields with a higher weight rank higher.
+type field struct {
+	name   string
+	weight int
+}
+
+var (
+	albumName        = field{"name", 4}
+	albumTags        = field{"tags", 3}
+	albumDescription = field{"description", 1}
+	mediaTags        = field{"tags", 4}
+	mediaFilename    = field{"filename", 3}
+	mediaCaption     = field{"caption", 2}
+	mediaCamera      = field{"camera", 1}
+	commentBody      = field{"body", 2}
+)
+
+// valueGap is the position gap between the values of a multi-valued field, such as tags, so that phrases
+// never match across two values.
+const valueGap = 16
+
+// insertBatch is the maximum number of terms written by a single INSERT statement.
+const insertBatch = 200
+
+type termRow struct {
+	field    string
+	position int
+	term     string
+	weight   int
+}
+
+// document collects the terms of an indexed item.
+type document struct {
+	terms []termRow
+	next  map[string]int
+}
+
+// add adds the terms of the given values of a field to the document.
+func (d *document) add(f field, values ...string) {
+	if d.next == nil {
+		d.next = map[string]int{}
+	}
+	for _, v := range values {
+		pos := d.next[f.name]
+		for _, t := range Tokenize(v) {
+			d.terms = append(d.terms, termRow{f.name, pos, t, f.weight})
+			pos++
+		}
+		d.next[f.name] = pos + valueGap
+	}
+}
+
+type index struct {
+	db     *dbcontext.DB
+	logger *logrus.Logger
+}
+
+// New creates a new search index.
+func New(db *dbcontext.DB, logger *logrus.Logger) Index {
+	return index{db, logger}
+}
+
+func (idx index) IndexAlbum(ctx context.Context, id string) error {
+	var a struct {
+		Name        string
+		Description string
+	}
+	err := idx.db.With(ctx).NewQuery("SELECT name, description FROM albums WHERE id={:id}").
+		Bind(dbx.Params{"id": id}).
+		One(&a)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var tags []string
+	if err := idx.db.With(ctx).Select("tag").From("album_tags").
+		Where(dbx.HashExp{"album_id": id}).
+		OrderBy("tag").
+		Column(&tags); err != nil {
+		return err
+	}
+	var doc document
+	doc.add(albumName, a.Name)
+	doc.add(albumDescription, a.Description)
+	doc.add(albumTags, tags...)
+	return idx.write(ctx, "album_search_terms", "album_id", id, doc)
+}
+
+func (idx index) IndexMedia(ctx context.Context, id string) error {
+	var m struct {
+		Filename    string
+		Caption     string
+		CameraMake  string
+		CameraModel string
+		LensModel   string
+	}
+	err := idx.db.With(ctx).NewQuery("SELECT m.filename, m.caption, COALESCE(md.camera_make, '') AS camera_make, COALESCE(md.camera_model, '') AS camera_model, " +
+		"COALESCE(md.lens_model, '') AS lens_model FROM media m LEFT JOIN media_metadata md ON md.media_id = m.id WHERE m.id={:id}").
+		Bind(dbx.Params{"id": id}).
+		One(&m)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var tags []string
+	if err := idx.db.With(ctx).Select("tag").From("media_tags").
+		Where(dbx.HashExp{"media_id": id}).
+		OrderBy("tag").
+		Column(&tags); err != nil {
+		return err
+	}
+	var doc document
+	doc.add(mediaFilename, m.Filename)
+	doc.add(mediaCaption, m.Caption)
+	doc.add(mediaTags, tags...)
+	doc.add(mediaCamera, m.CameraMake, m.CameraModel, m.LensModel)
+	return idx.write(ctx, "media_search_terms", "media_id", id, doc)
+}
+
+func (idx index) IndexComment(ctx context.Context, id string) error {
+	var body string
+	err := idx.db.With(ctx).NewQuery("SELECT body FROM comments WHERE id={:id} AND deleted_at IS NULL").
+		Bind(dbx.Params{"id": id}).
+		Row(&body)
+	if err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var doc document
+	doc.add(commentBody, body)
+	return idx.write(ctx, "comment_search_terms", "comment_id", id, doc)
+}
+
+// write replaces the index entries of an item with the terms of the document.
+func (idx index) write(ctx context.Context, table, column, id string, doc document) error {
+	if _, err := idx.db.With(ctx).Delete(table, dbx.HashExp{column: id}).Execute(); err != nil {
+		return err
+	}
+	for start := 0; start < len(doc.terms); start += insertBatch {
+		end := min(start+insertBatch, len(doc.terms))
+		values := make([]string, 0, end-start)
+		params := dbx.Params{"id": id}
+		for i, t := range doc.terms[start:end] {
+			values = append(values, fmt.Sprintf("({:id}, {:f%d}, {:p%d}, {:t%d}, {:w%d})", i, i, i, i))
+			params[fmt.Sprintf("f%d", i)] = t.field
+			params[fmt.Sprintf("p%d", i)] = t.position
+			params[fmt.Sprintf("t%d", i)] = t.term
+			params[fmt.Sprintf("w%d", i)] = t.weight
+		}
+		if _, err := idx.db.With(ctx).NewQuery("INSERT INTO " + table + " (" + column + ", field, position, term, weight) VALUES " + strings.Join(values, ", ")).
+			Bind(params).
+			Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx index) Backfill(ctx context.Context) (int, error) {
+	indexed := 0
+	for _, t := range []struct {
+		table, terms, column string
+		index                func(ctx context.Context, id string) error
+	}{
+		{"albums", "album_search_terms", "album_id", idx.IndexAlbum},
+		{"media", "media_search_terms", "media_id", idx.IndexMedia},
+		{"comments", "comment_search_terms", "comment_id", idx.IndexComment},
+	} {
+		// blanked comments have no terms and are skipped, so they are not indexed again on every start
+		where := ""
+		if t.table == "comments" {
+			where = " AND i.deleted_at IS NULL"
+		}
+		missing := "NOT EXISTS (SELECT 1 FROM " + t.terms + " t WHERE t." + t.column + " = i.id)"
+		var ids []string
+		if err := idx.db.With(ctx).NewQuery("SELECT id FROM " + t.table + " i WHERE " + missing + where).
+			Column(&ids); err != nil {
+			return indexed, err
+		}
+		for _, id := range ids {
+			// the item is locked and checked again, as it may have been indexed since it was selected
+			var found []string
+			err := idx.db.Transactional(ctx, func(ctx context.Context) error {
+				if err := idx.db.With(ctx).NewQuery("SELECT id FROM " + t.table + " i WHERE id={:id} AND " + missing + " FOR UPDATE").
+					Bind(dbx.Params{"id": id}).
+					Column(&found); err != nil || len(found) == 0 {
+					return err
+				}
+				return t.index(ctx, id)
+			})
+			if err != nil {
+				return indexed, err
+			}
+			indexed += len(found)
+		}
+	}
+	return indexed, nil
+}
@@ -0,0 +1,221 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// The types of search hits.
+const (
+	TypeAlbum   = "album"
+	TypeMedia   = "media"
+	TypeComment = "comment"
+)
+
+// Query is a parsed search query. All of its conditions have to match.
+type Query struct {
+	// Terms are the terms an item has to contain, as returned by Tokenize.
+	Terms []string
+	// Phrases are sequences of terms an item has to contain in the given order within a single field.
+	Phrases [][]string
+	// Tags are normalized tags an item has to carry. Comments carry no tags, so they never match.
+	Tags []string
+	// Album holds terms the name of an album has to contain. Media items and comments match if their
+	// album does.
+	Album []string
+	// After and Before restrict items to a time range. Media items are dated by their capture time, or
+	// by their upload time if it is unknown, albums and comments by their creation time.
+	After  *time.Time
+	Before *time.Time
+	// Type restricts the hits to albums, media items or comments. Empty means all of them.
+	Type string
+}
+
+// Hit is an album, media item or comment matching a search query.
+type Hit struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Score int    `json:"score"`
+}
+
+func (idx index) Search(ctx context.Context, q Query, pages *pagination.Pages) ([]Hit, error) {
+	b := builder{params: dbx.Params{"user": auth.CurrentUser(ctx).GetID()}}
+	var parts []string
+	if q.Type == "" || q.Type == TypeAlbum {
+		parts = append(parts, b.albums(q))
+	}
+	if q.Type == "" || q.Type == TypeMedia {
+		parts = append(parts, b.media(q))
+	}
+	if (q.Type == "" || q.Type == TypeComment) && len(q.Tags) == 0 {
+		parts = append(parts, b.comments(q))
+	}
+	if len(parts) == 0 {
+		*pages = *pagination.New(pages.Page, pages.PerPage, 0)
+		return []Hit{}, nil
+	}
+	union := strings.Join(parts, " UNION ALL ")
+
+	var total int
+	if err := idx.db.With(ctx).NewQuery("SELECT COUNT(*) FROM (" + union + ") h").
+		Bind(b.params).
+		Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	hits := []Hit{}
+	b.params["limit"], b.params["offset"] = pages.Limit(), pages.Offset()
+	err := idx.db.With(ctx).NewQuery("SELECT type, id, score FROM (" + union + ") h ORDER BY score DESC, date DESC, id LIMIT {:limit} OFFSET {:offset}").
+		Bind(b.params).
+		All(&hits)
+	return hits, err
+}
+
+// builder builds the SQL selecting the hits of a query. Every value is bound as a parameter.
+type builder struct {
+	params dbx.Params
+	n      int
+}
+
+// bind binds a value to a new parameter and returns its placeholder.
+func (b *builder) bind(v interface{}) string {
+	name := fmt.Sprintf("q%d", b.n)
+	b.n++
+	b.params[name] = v
+	return "{:" + name + "}"
+}
+
+// list binds the values to new parameters and returns their comma separated placeholders.
+func (b *builder) list(values []string) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = b.bind(v)
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// matching selects the IDs and scores of the items of a term table containing all of the given terms.
+// The score is the sum of the weights of all occurrences of the terms.
+func (b *builder) matching(table, column string, terms []string) string {
+	terms = distinct(terms)
+	return fmt.Sprintf("SELECT %s AS id, SUM(weight) AS score FROM %s WHERE term IN (%s) GROUP BY %s HAVING COUNT(DISTINCT term) = %d",
+		column, table, b.list(terms), column, len(terms))
+}
+
+// phrase returns a condition matching if the item referenced by ref contains the terms in the given order
+// within a single field.
+func (b *builder) phrase(table, column, ref string, terms []string) string {
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "EXISTS (SELECT 1 FROM %s p0", table)
+	for i := 1; i < len(terms); i++ {
+		fmt.Fprintf(&sql, " JOIN %s p%d ON p%d.%s = p0.%s AND p%d.field = p0.field AND p%d.position = p0.position + %d",
+			table, i, i, column, column, i, i, i)
+	}
+	fmt.Fprintf(&sql, " WHERE p0.%s = %s", column, ref)
+	for i, t := range terms {
+		fmt.Fprintf(&sql, " AND p%d.term = %s", i, b.bind(t))
+	}
+	sql.WriteString(")")
+	return sql.String()
+}
+
+// conditions returns the term and phrase conditions of a query for a term table, together with the join
+// computing the score of an item. The join is empty if the query has no terms.
+func (b *builder) conditions(q Query, table, column, ref string) (join, score string, where []string) {
+	terms := append([]string{}, q.Terms...)
+	for _, p := range q.Phrases {
+		terms = append(terms, p...)
+		if len(p) > 1 {
+			where = append(where, b.phrase(table, column, ref, p))
+		}
+	}
+	if len(terms) == 0 {
+		return "", "0", where
+	}
+	return "JOIN (" + b.matching(table, column, terms) + ") s ON s.id = " + ref + " ", "s.score", where
+}
+
+// album returns the condition restricting the album referenced by ref to the albums matching q.Album.
+func (b *builder) album(q Query, ref string) []string {
+	if len(q.Album) == 0 {
+		return nil
+	}
+	terms := distinct(q.Album)
+	return []string{fmt.Sprintf("%s IN (SELECT album_id FROM album_search_terms WHERE field = '%s' AND term IN (%s) GROUP BY album_id HAVING COUNT(DISTINCT term) = %d)",
+		ref, albumName.name, b.list(terms), len(terms))}
+}
+
+// dates returns the conditions restricting the given date expression to the time range of the query.
+func (b *builder) dates(q Query, date string) []string {
+	var where []string
+	if q.After != nil {
+		where = append(where, date+" >= "+b.bind(*q.After))
+	}
+	if q.Before != nil {
+		where = append(where, date+" < "+b.bind(*q.Before))
+	}
+	return where
+}
+
+// albums selects the albums matching the query that the user can view.
+func (b *builder) albums(q Query) string {
+	join, score, where := b.conditions(q, "album_search_terms", "album_id", "a.id")
+	where = append(where, block.AlbumVisible("{:user}"))
+	for _, t := range q.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM album_tags t WHERE t.album_id = a.id AND t.tag = "+b.bind(t)+")")
+	}
+	where = append(where, b.album(q, "a.id")...)
+	where = append(where, b.dates(q, "a.created_at")...)
+	return "SELECT '" + TypeAlbum + "' AS type, a.id, " + score + " AS score, a.created_at AS date FROM albums a " +
+		block.MemberJoin("{:user}") + join +
+		"WHERE " + strings.Join(where, " AND ")
+}
+
+// media selects the media items matching the query that the user can view.
+func (b *builder) media(q Query) string {
+	join, score, where := b.conditions(q, "media_search_terms", "media_id", "m.id")
+	where = append(where, "m.deleted_at IS NULL", "m.hidden_at IS NULL", block.AlbumVisible("{:user}"))
+	for _, t := range q.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM media_tags t WHERE t.media_id = m.id AND t.tag = "+b.bind(t)+")")
+	}
+	where = append(where, b.album(q, "a.id")...)
+	where = append(where, b.dates(q, "COALESCE(md.captured_at, m.created_at)")...)
+	return "SELECT '" + TypeMedia + "' AS type, m.id, " + score + " AS score, COALESCE(md.captured_at, m.created_at) AS date FROM media m " +
+		"JOIN albums a ON a.id = m.album_id " + block.MemberJoin("{:user}") +
+		"LEFT JOIN media_metadata md ON md.media_id = m.id " + join +
+		"WHERE " + strings.Join(where, " AND ")
+}
+
+// comments selects the comments matching the query that the user can view: comments that are neither
+// deleted nor hidden by moderators, on albums and media items the user can view, by authors not hidden
+// from the user by a block or mute.
+func (b *builder) comments(q Query) string {
+	join, score, where := b.conditions(q, "comment_search_terms", "comment_id", "c.id")
+	where = append(where, "c.deleted_at IS NULL", "c.hidden_at IS NULL", block.AlbumVisible("{:user}"),
+		"(c.media_id IS NULL OR (m.deleted_at IS NULL AND m.hidden_at IS NULL))", "NOT "+block.Hidden("{:user}", "c.author_id"))
+	where = append(where, b.album(q, "a.id")...)
+	where = append(where, b.dates(q, "c.created_at")...)
+	return "SELECT '" + TypeComment + "' AS type, c.id, " + score + " AS score, c.created_at AS date FROM comments c " +
+		"JOIN albums a ON a.id = c.album_id LEFT JOIN media m ON m.id = c.media_id " + block.MemberJoin("{:user}") + join +
+		"WHERE " + strings.Join(where, " AND ")
+}
+
+func distinct(values []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,34 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxTermLength is the maximum number of characters of a term. Longer terms are truncated.
+const MaxTermLength = 64
+
+// Tokenize splits text into search terms. The text is Unicode normalized, lowercased and stripped of
+// diacritics, and split at every character that is neither a letter nor a digit, so "Café_2024.JPG"
+// yields "cafe", "2024" and "jpg".
+func Tokenize(text string) []string {
+	// transformers keep state, so a new chain is needed for every call
+	fold := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if folded, _, err := transform.String(fold, text); err == nil {
+		text = folded
+	}
+	terms := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, t := range terms {
+		if utf8.RuneCountInString(t) > MaxTermLength {
+			terms[i] = string([]rune(t)[:MaxTermLength])
+		}
+	}
+	return terms
+}
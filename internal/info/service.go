@@ -7,6 +7,7 @@ import (
 	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
 	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/quota"
 	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
 	dbx "github.com/go-ozzo/ozzo-dbx"
 	"github.com/sirupsen/logrus"
@@ -21,11 +22,12 @@ type Service interface {
 
 type service struct {
 	db     *dbcontext.DB
+	quotas quota.Service
 	logger *logrus.Logger
 }
 
-func NewService(logger *logrus.Logger, db *dbcontext.DB) Service {
-	return service{db, logger}
+func NewService(logger *logrus.Logger, db *dbcontext.DB, quotas quota.Service) Service {
+	return service{db, quotas, logger}
 }
 
 func (s service) Info(ctx context.Context) interface{} {
@@ -38,12 +40,19 @@ func (s service) Info(ctx context.Context) interface{} {
 		logging.WithError(err).Error("Error querying user's info from db")
 		return nil
 	}
+	usage, err := s.quotas.Usage(ctx, user.GetID())
+	if err != nil {
+		logging.WithError(err).Error("Error querying user's storage usage from db")
+		return nil
+	}
 	UserData := struct {
-		FirstName  string
-		LastName   string
-		Email      string
-		ProfileIMG string
-	}{dbUserData.FirstName, dbUserData.LastName, dbUserData.Email, dbUserData.ProfileIMG}
+		FirstName  string              `json:"first_name"`
+		LastName   string              `json:"last_name"`
+		Email      string              `json:"email"`
+		Handle     string              `json:"handle"`
+		ProfileIMG string              `json:"profile_img"`
+		Storage    entity.StorageUsage `json:"storage"`
+	}{dbUserData.FirstName, dbUserData.LastName, dbUserData.Email, dbUserData.Handle, dbUserData.ProfileIMG, usage}
 	return UserData
 }
 
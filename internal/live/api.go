@@ -0,0 +1,249 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/websocket"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// writeWait is how long writing a message may take before the client is considered too slow.
+	writeWait = 10 * time.Second
+	// pingInterval is how often the server pings the client.
+	pingInterval = 30 * time.Second
+	// pongWait is how long the client may stay silent, which has to exceed pingInterval.
+	pongWait = 60 * time.Second
+	// maxMessageSize is the limit of the size of client messages.
+	maxMessageSize = 4096
+)
+
+// The channels a client can subscribe to. Album events, such as its deletion, are always delivered.
+const (
+	ChannelPresence = "presence"
+	ChannelMedia    = "media"
+	ChannelComments = "comments"
+)
+
+// channelOf returns the channel of an event type, or an empty string for album events.
+func channelOf(eventType string) string {
+	switch {
+	case eventType == TypePresence:
+		return ChannelPresence
+	case strings.HasPrefix(eventType, "media."):
+		return ChannelMedia
+	case strings.HasPrefix(eventType, "comment."):
+		return ChannelComments
+	}
+	return ""
+}
+
+// request is a message of the client.
+type request struct {
+	// Type is subscribe or unsubscribe.
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+// message is a message of the server.
+type message struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type resource struct {
+	service Service
+	events  events.Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the live channel handler, which requires an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, events events.Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, events, logger}
+	// browsers cannot send headers with a WebSocket handshake, so the token may be passed in the query
+	r.Get("/albums/<id>/live", auth.QueryTokenHandler, authHandler, res.connect)
+}
+
+// connect upgrades the request to the live channel of an album. The client subscribes to channels with
+// {"type":"subscribe","channel":"media"} and receives {"type":"<event type>","data":{...}} messages.
+// Clients that do not keep up with the events are disconnected with code 1013 and may reconnect.
+func (r resource) connect(c *routing.Context) error {
+	ctx := c.Request.Context()
+	session, err := r.service.Join(ctx, c.Param("id"))
+	if err == events.ErrClosed {
+		return errors.ServiceUnavailable("the server is shutting down")
+	}
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	conn, err := websocket.Upgrade(c.Response, c.Request)
+	if err != nil {
+		if err != websocket.ErrBadHandshake {
+			r.logger.WithContext(ctx).WithError(err).Error("WebSocket upgrade failed")
+		}
+		return nil
+	}
+	conn.MaxMessageSize = maxMessageSize
+	conn.ReadTimeout = pongWait
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+
+	ch := channel{conn: conn, albumID: session.Album.ID, subscribed: map[string]bool{}}
+	requests := make(chan request)
+	done := make(chan struct{})
+	defer close(done)
+	go ch.read(requests, done)
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return nil
+			}
+			if !r.handle(ctx, &ch, req) {
+				return nil
+			}
+		case e, ok := <-session.Events():
+			if !ok {
+				if r.events.Closed() {
+					conn.Close(websocket.CloseGoingAway, "server shutting down")
+				} else {
+					conn.Close(websocket.CloseTryAgainLater, "client too slow")
+				}
+				return nil
+			}
+			if !r.deliver(ctx, &ch, e) {
+				return nil
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.Ping(); err != nil {
+				conn.Close(websocket.CloseGoingAway, "")
+				return nil
+			}
+		}
+	}
+}
+
+// handle handles a client request. It returns false when the connection was closed.
+func (r resource) handle(ctx context.Context, ch *channel, req request) bool {
+	switch req.Type {
+	case "subscribe", "unsubscribe":
+	default:
+		return ch.send(message{"error", map[string]string{"message": "unknown message type"}})
+	}
+	switch req.Channel {
+	case ChannelPresence, ChannelMedia, ChannelComments:
+	default:
+		return ch.send(message{"error", map[string]string{"message": "unknown channel"}})
+	}
+	if req.Type == "unsubscribe" {
+		delete(ch.subscribed, req.Channel)
+		return ch.send(message{"unsubscribed", map[string]string{"channel": req.Channel}})
+	}
+	if err := r.service.Authorize(ctx, ch.albumID); err != nil {
+		ch.send(message{"error", map[string]string{"message": err.Error()}})
+		ch.conn.Close(websocket.ClosePolicy, "access denied")
+		return false
+	}
+	ch.subscribed[req.Channel] = true
+	if !ch.send(message{"subscribed", map[string]string{"channel": req.Channel}}) {
+		return false
+	}
+	if req.Channel == ChannelPresence {
+		return r.sendPresence(ctx, ch)
+	}
+	return true
+}
+
+// deliver sends an album event to the client if it subscribed to its channel. It returns false when the
+// connection was closed.
+func (r resource) deliver(ctx context.Context, ch *channel, e events.Event) bool {
+	switch e.Type {
+	case events.TypeAlbumUpdated:
+		// the members may have changed
+		if err := r.service.Authorize(ctx, ch.albumID); err != nil {
+			ch.conn.Close(websocket.ClosePolicy, "access revoked")
+			return false
+		}
+	case events.TypeAlbumDeleted:
+		ch.send(message{e.Type, json.RawMessage(e.Data)})
+		ch.conn.Close(websocket.CloseNormal, "album deleted")
+		return false
+	}
+	if name := channelOf(e.Type); name != "" && !ch.subscribed[name] {
+		return true
+	}
+	if e.Type == TypePresence {
+		// the broadcast lists all viewers, so every client gets the viewers it may see instead
+		return r.sendPresence(ctx, ch)
+	}
+	if visible, err := r.service.Visible(ctx, e); err != nil || !visible {
+		if err != nil {
+			r.logger.WithContext(ctx).WithError(err).WithField("album", ch.albumID).Error("Failed to check the actor of an event")
+		}
+		return true
+	}
+	return ch.send(message{e.Type, json.RawMessage(e.Data)})
+}
+
+// sendPresence sends the current viewers of the album. It returns false when the connection was closed.
+func (r resource) sendPresence(ctx context.Context, ch *channel) bool {
+	p, err := r.service.Presence(ctx, ch.albumID)
+	if err != nil {
+		r.logger.WithContext(ctx).WithError(err).WithField("album", ch.albumID).Error("Failed to list the viewers of an album")
+		return true
+	}
+	return ch.send(message{TypePresence, p})
+}
+
+// channel is the connection of a client to the live channel of an album.
+type channel struct {
+	conn       *websocket.Conn
+	albumID    string
+	subscribed map[string]bool
+}
+
+// read passes the requests of the client on until the connection is closed.
+func (ch *channel) read(requests chan<- request, done <-chan struct{}) {
+	defer close(requests)
+	for {
+		messageType, data, err := ch.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req request
+		if messageType != websocket.TextMessage || json.Unmarshal(data, &req) != nil {
+			req = request{Type: "invalid"}
+		}
+		select {
+		case requests <- req:
+		case <-done:
+			return
+		}
+	}
+}
+
+// send writes a message. A client that does not accept it in time is disconnected. It returns false when
+// the connection was closed.
+func (ch *channel) send(m message) bool {
+	data, err := json.Marshal(m)
+	if err == nil {
+		ch.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		err = ch.conn.WriteMessage(websocket.TextMessage, data)
+	}
+	if err != nil {
+		ch.conn.Close(websocket.CloseGoingAway, "")
+		return false
+	}
+	return true
+}
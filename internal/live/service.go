@@ -0,0 +1,207 @@
+// Package live implements the live channels of albums, through which the people curating a shared album
+// see who else is viewing it and receive its uploads, reorders and comments as they happen.
+//
+// A channel is a WebSocket connection per album. Access is checked when connecting and again on every
+// subscription and whenever the album changes, so removed members stop receiving its events. Viewers do
+// not see each other, nor the events about each other's actions, if one of them blocked the other, and
+// muted users are hidden from the users who muted them.
+package live
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// TypePresence is the type of the event listing the viewers of an album.
+const TypePresence = "presence"
+
+// Viewer is a user viewing an album.
+type Viewer struct {
+	UserID    int    `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// Presence is the payload of the presence event.
+type Presence struct {
+	AlbumID string   `json:"album_id"`
+	Viewers []Viewer `json:"viewers"`
+}
+
+// Service encapsulates the live channel logic.
+type Service interface {
+	// Join checks that the current user can view an album, subscribes to the album events and adds the
+	// user to the viewers of the album. The session has to be closed.
+	Join(ctx context.Context, albumID string) (*Session, error)
+	// Authorize checks that the current user can still view an album.
+	Authorize(ctx context.Context, albumID string) error
+	// Visible reports whether an album event is meant for the current user, which it is not if its actor
+	// is hidden from the user.
+	Visible(ctx context.Context, e events.Event) (bool, error)
+	// Presence returns the users currently viewing an album, except for the users hidden from the current user.
+	Presence(ctx context.Context, albumID string) (Presence, error)
+}
+
+// Session is the presence of a user in the live channel of an album.
+type Session struct {
+	// Album is the album as seen when joining.
+	Album entity.Album
+	sub   *events.Subscription
+	leave func()
+	once  sync.Once
+}
+
+// Events returns the events of the album. The channel is closed when the subscriber fell behind or the
+// server shuts down.
+func (s *Session) Events() <-chan events.Event {
+	return s.sub.Events()
+}
+
+// Close ends the subscription and removes the user from the viewers.
+func (s *Session) Close() {
+	s.once.Do(func() {
+		s.sub.Close()
+		s.leave()
+	})
+}
+
+type service struct {
+	db     *dbcontext.DB
+	albums album.Service
+	events events.Service
+	logger *logrus.Logger
+
+	mu sync.Mutex
+	// viewers counts the connections of every viewer per album, since a user may have several open
+	viewers map[string]map[Viewer]int
+}
+
+// NewService creates a new live channel service.
+func NewService(db *dbcontext.DB, albums album.Service, events events.Service, logger *logrus.Logger) Service {
+	return &service{db: db, albums: albums, events: events, logger: logger, viewers: map[string]map[Viewer]int{}}
+}
+
+func (s *service) Join(ctx context.Context, albumID string) (*Session, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.events.SubscribeAlbum(a.ID)
+	if err != nil {
+		return nil, err
+	}
+	user := auth.CurrentUser(ctx)
+	v := Viewer{UserID: user.GetID(), FirstName: user.GetFirstName(), LastName: user.GetLastName()}
+	s.update(a.ID, v, 1)
+	return &Session{Album: a, sub: sub, leave: func() { s.update(a.ID, v, -1) }}, nil
+}
+
+// update changes the number of connections of a viewer and announces the viewers when the set of viewers changed.
+func (s *service) update(albumID string, v Viewer, delta int) {
+	s.mu.Lock()
+	viewers := s.viewers[albumID]
+	if viewers == nil {
+		viewers = map[Viewer]int{}
+		s.viewers[albumID] = viewers
+	}
+	before := viewers[v]
+	viewers[v] += delta
+	if viewers[v] <= 0 {
+		delete(viewers, v)
+	}
+	if len(viewers) == 0 {
+		delete(s.viewers, albumID)
+	}
+	// the presence is broadcast under the lock, so the announcements of concurrent changes keep their order
+	if changed := (before == 0) != (viewers[v] == 0); changed {
+		if err := s.events.Broadcast(albumID, TypePresence, s.presence(albumID)); err != nil {
+			s.logger.WithError(err).WithField("album", albumID).Error("Failed to broadcast presence")
+		}
+	}
+	s.mu.Unlock()
+}
+
+func (s *service) Authorize(ctx context.Context, albumID string) error {
+	_, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	return err
+}
+
+func (s *service) Visible(ctx context.Context, e events.Event) (bool, error) {
+	if e.Actor == 0 {
+		return true, nil
+	}
+	hidden, err := s.hidden(ctx, []int{e.Actor})
+	return !hidden[e.Actor], err
+}
+
+func (s *service) Presence(ctx context.Context, albumID string) (Presence, error) {
+	s.mu.Lock()
+	p := s.presence(albumID)
+	s.mu.Unlock()
+	users := make([]int, len(p.Viewers))
+	for i, v := range p.Viewers {
+		users[i] = v.UserID
+	}
+	hidden, err := s.hidden(ctx, users)
+	if err != nil {
+		return p, err
+	}
+	viewers := p.Viewers[:0]
+	for _, v := range p.Viewers {
+		if !hidden[v.UserID] {
+			viewers = append(viewers, v)
+		}
+	}
+	p.Viewers = viewers
+	return p, nil
+}
+
+// hidden returns the set of the given users who are hidden from the current user by a block or mute.
+func (s *service) hidden(ctx context.Context, users []int) (map[int]bool, error) {
+	hidden := map[int]bool{}
+	if len(users) == 0 {
+		return hidden, nil
+	}
+	params := dbx.Params{"viewer": auth.CurrentUser(ctx).GetID()}
+	placeholders := make([]string, len(users))
+	for i, id := range users {
+		name := fmt.Sprintf("user%d", i)
+		params[name], placeholders[i] = id, "{:"+name+"}"
+	}
+	var ids []int
+	err := s.db.With(ctx).NewQuery("SELECT id FROM users WHERE id IN (" + strings.Join(placeholders, ", ") + ") AND " + block.Hidden("{:viewer}", "id")).
+		Bind(params).
+		Column(&ids)
+	for _, id := range ids {
+		hidden[id] = true
+	}
+	return hidden, err
+}
+
+// presence lists the viewers of an album ordered by name. The lock must be held.
+func (s *service) presence(albumID string) Presence {
+	p := Presence{AlbumID: albumID, Viewers: []Viewer{}}
+	for v := range s.viewers[albumID] {
+		p.Viewers = append(p.Viewers, v)
+	}
+	sort.Slice(p.Viewers, func(i, j int) bool {
+		a, b := p.Viewers[i], p.Viewers[j]
+		if an, bn := a.FirstName+" "+a.LastName, b.FirstName+" "+b.LastName; an != bn {
+			return an < bn
+		}
+		return a.UserID < b.UserID
+	})
+	return p
+}
@@ -0,0 +1,301 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httprange"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the media handlers. Apart from the signed URL route, all of them require
+// an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	// signed URLs are authorized by their signature, so this route is registered before the auth handler
+	r.To("GET,HEAD", "/m/<id>", res.signedContent)
+
+	r.Use(authHandler)
+	r.Get("/albums/<id>/media", res.list)
+	r.Post("/albums/<id>/media", res.upload)
+	r.Post("/albums/<id>/media/claim", res.claim)
+	r.Get("/albums/<id>/archive", res.archive)
+	r.Get("/media/<id>", res.get)
+	r.To("GET,HEAD", "/media/<id>/content", res.content)
+	r.Get("/media/<id>/url", res.signURL)
+	r.Patch("/media/<id>", res.update)
+	r.Put("/media/<id>/position", res.move)
+	r.Delete("/media/<id>", res.delete)
+}
+
+// upload handles a multipart/form-data upload with the file in the "file" field. The caption is taken from a
+// "caption" field preceding the file, or from the query string.
+// When the client sends the SHA-256 hash of the file in the X-Content-SHA256 header and the current user
+// already uploaded that content, the media item is created without reading the body. Clients using
+// "Expect: 100-continue" therefore never transmit the file. Otherwise the header is used to verify the
+// uploaded content, which is still stored only once if others uploaded it before.
+func (r resource) upload(c *routing.Context) error {
+	logger := r.logger.WithContext(c.Request.Context())
+	hash := c.Request.Header.Get("X-Content-SHA256")
+	if hash != "" {
+		m, err := r.service.Claim(c.Request.Context(), c.Param("id"), hash, c.Query("filename"), c.Query("caption"))
+		if err == nil {
+			return c.WriteWithStatus(m, http.StatusCreated)
+		}
+		if res, ok := err.(errors.ErrorResponse); !ok || res.Status != http.StatusNotFound {
+			return err
+		}
+	}
+	// the declared length includes the multipart framing, so it slightly overestimates the file size
+	if c.Request.ContentLength > 0 {
+		if err := r.service.CheckQuota(c.Request.Context(), c.Request.ContentLength); err != nil {
+			return err
+		}
+	}
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("expected a multipart/form-data body")
+	}
+	caption := c.Query("caption")
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return errors.BadRequest("missing \"file\" field")
+		}
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("invalid request")
+			return errors.BadRequest("")
+		}
+		if part.FormName() == "caption" {
+			// a caption has at most four bytes per character, anything longer fails the validation
+			b, err := io.ReadAll(io.LimitReader(part, 4*maxCaptionLength+1))
+			part.Close()
+			if err != nil {
+				return errors.BadRequest("")
+			}
+			caption = string(b)
+			continue
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		m, err := r.service.Upload(c.Request.Context(), c.Param("id"), part.FileName(), caption, hash, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+		return c.WriteWithStatus(m, http.StatusCreated)
+	}
+}
+
+// claim creates a media item from content the current user already uploaded, identified by its SHA-256 hash.
+func (r resource) claim(c *routing.Context) error {
+	var req struct {
+		SHA256   string `json:"sha256"`
+		Filename string `json:"filename"`
+		Caption  string `json:"caption"`
+	}
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	m, err := r.service.Claim(c.Request.Context(), c.Param("id"), req.SHA256, req.Filename, req.Caption)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(m, http.StatusCreated)
+}
+
+func (r resource) list(c *routing.Context) error {
+	filter, err := parseFilter(c)
+	if err != nil {
+		return err
+	}
+	// the listing is paginated on request, which is recommended for smart albums
+	if c.Query(pagination.PageVar) == "" && c.Query(pagination.PageSizeVar) == "" {
+		items, err := r.service.List(c.Request.Context(), c.Param("id"), filter, nil)
+		if err != nil {
+			return err
+		}
+		return c.Write(items)
+	}
+	pages := pagination.NewFromRequest(c.Request, -1)
+	items, err := r.service.List(c.Request.Context(), c.Param("id"), filter, pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = items
+	return c.Write(pages)
+}
+
+func (r resource) get(c *routing.Context) error {
+	m, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(m)
+}
+
+// content serves a variant of a media item with support for range and conditional requests.
+func (r resource) content(c *routing.Context) error {
+	m, content, err := r.service.Content(c.Request.Context(), c.Param("id"), c.Query("variant"))
+	if err != nil {
+		return err
+	}
+	return r.serve(c, m, content)
+}
+
+// signedContent serves a media variant through a signed URL, without requiring a JWT.
+func (r resource) signedContent(c *routing.Context) error {
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		return errors.Forbidden("invalid signature")
+	}
+	m, content, err := r.service.SignedContent(c.Request.Context(), c.Param("id"), c.Query("variant"), expires, c.Query("kid"), c.Query("sig"))
+	if err != nil {
+		return err
+	}
+	maxAge := expires - time.Now().Unix()
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	c.Response.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(maxAge, 10))
+	return r.serve(c, m, content)
+}
+
+func (r resource) serve(c *routing.Context, m entity.Media, content httprange.Content) error {
+	c.Response.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": m.Filename}))
+	if err := httprange.Serve(c.Response, c.Request, content); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithError(err).WithField("media", m.ID).Error("Failed to open media content")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// archive streams a ZIP archive of an album. The optional variant query parameter selects the variant
+// of the included images.
+func (r resource) archive(c *routing.Context) error {
+	archive, err := r.service.Archive(c.Request.Context(), c.Param("id"), c.Query("variant"))
+	if err != nil {
+		return err
+	}
+	WriteArchive(c, archive, r.logger)
+	return nil
+}
+
+// WriteArchive streams an archive as the response of the request. Since the response has already been
+// started when writing fails, errors are only logged and the client receives a truncated archive.
+func WriteArchive(c *routing.Context, archive Archive, logger *logrus.Logger) {
+	c.Response.Header().Set("Content-Type", "application/zip")
+	c.Response.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive.Name}))
+	c.Response.Header().Set("Cache-Control", "private, no-store")
+	c.Response.WriteHeader(http.StatusOK)
+	if err := archive.Write(c.Request.Context(), c.Response); err != nil {
+		logger.WithContext(c.Request.Context()).WithError(err).Warn("Album archive download interrupted")
+	}
+}
+
+// signURL hands out a signed URL for a media variant. The optional ttl query parameter is in seconds.
+func (r resource) signURL(c *routing.Context) error {
+	var ttl time.Duration
+	if v := c.Query("ttl"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return errors.BadRequest("invalid ttl value")
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	signed, err := r.service.SignURL(c.Request.Context(), c.Param("id"), c.Query("variant"), ttl)
+	if err != nil {
+		return err
+	}
+	return c.Write(signed)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var req UpdateRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	m, err := r.service.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(m)
+}
+
+func (r resource) move(c *routing.Context) error {
+	var req MoveRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	m, err := r.service.Move(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(m)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	if err := r.service.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// parseFilter builds a metadata filter from the query string.
+// taken_after and taken_before accept either RFC 3339 timestamps or YYYY-MM-DD dates, sort overrides
+// the sort mode of the album.
+func parseFilter(c *routing.Context) (Filter, error) {
+	f := Filter{
+		CameraMake:  c.Query("camera_make"),
+		CameraModel: c.Query("camera_model"),
+		LensModel:   c.Query("lens_model"),
+		Sort:        entity.SortMode(c.Query("sort")),
+	}
+	if f.Sort != "" && !f.Sort.Valid() {
+		return f, errors.BadRequest("invalid sort value")
+	}
+	for name, dst := range map[string]**time.Time{"taken_after": &f.TakenAfter, "taken_before": &f.TakenBefore} {
+		if v := c.Query(name); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				return f, errors.BadRequest(fmt.Sprintf("invalid %s value", name))
+			}
+			*dst = &t
+		}
+	}
+	if v := c.Query("has_location"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return f, errors.BadRequest("invalid has_location value")
+		}
+		f.HasLocation = &b
+	}
+	return f, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
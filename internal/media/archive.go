@@ -0,0 +1,159 @@
+package media
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+)
+
+// Archive is a ZIP archive of the media items of an album that is written on the fly.
+type Archive struct {
+	// Name is the suggested file name of the archive.
+	Name    string
+	variant string
+	redact  bool
+	// keep holds the IDs of the albums whose items keep their location data despite redact.
+	keep    map[string]bool
+	items   []entity.Media
+	service service
+}
+
+func (s service) Archive(ctx context.Context, albumID, variant string) (Archive, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return Archive{}, err
+	}
+	return s.archive(ctx, a, variant, false)
+}
+
+func (s service) PublicArchive(ctx context.Context, a entity.Album, variant string) (Archive, error) {
+	return s.archive(ctx, a, variant, true)
+}
+
+func (s service) archive(ctx context.Context, a entity.Album, variant string, redact bool) (Archive, error) {
+	if variant == "" {
+		variant = VariantOriginal
+	}
+	if !ValidVariant(variant) {
+		return Archive{}, errors.BadRequest("unknown variant")
+	}
+	items, err := s.list(ctx, a, Filter{}, nil)
+	if err != nil {
+		return Archive{}, err
+	}
+	var keep map[string]bool
+	if redact {
+		if keep, err = s.keepsLocation(ctx, a, items); err != nil {
+			return Archive{}, err
+		}
+	}
+	return Archive{
+		Name:    archiveName(a.Name),
+		variant: variant,
+		redact:  redact,
+		keep:    keep,
+		items:   items,
+		service: s,
+	}, nil
+}
+
+// Write streams the archive to w. Entries are stored without compression because photos and videos
+// are already compressed, and ZIP64 records are written automatically for large archives. Variants are
+// resolved lazily, so an error may occur after a part of the archive has already been written. Originals
+// that have to be redacted but whose format does not allow it are left out.
+func (z Archive) Write(ctx context.Context, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	names := archiveNames{}
+	for _, m := range z.items {
+		variant := z.variant
+		if variant != VariantOriginal && (m.BlobHash == "" || !resizable(m.ContentType)) {
+			// videos and legacy media have no resized variants, so their original is included instead
+			variant = VariantOriginal
+		}
+		content, err := z.service.variantContent(ctx, m, variant, z.redact && !z.keep[m.AlbumID])
+		if err == errUnredactable {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		filename := entryName(m.Filename)
+		if variant != VariantOriginal {
+			filename = strings.TrimSuffix(filename, path.Ext(filename)) + ".jpg"
+		}
+		modified := m.CreatedAt
+		if m.Metadata != nil && m.Metadata.CapturedAt != nil {
+			modified = *m.Metadata.CapturedAt
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     names.unique(filename),
+			Method:   zip.Store,
+			Modified: modified,
+		})
+		if err != nil {
+			return err
+		}
+		rc, err := content.Open(0, content.Size)
+		if err != nil {
+			return fmt.Errorf("open media %s: %w", m.ID, err)
+		}
+		_, err = io.Copy(fw, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// archiveNames makes the entry names of an archive unique by numbering duplicates like "IMG_0001 (2).jpg".
+// Names are compared case-insensitively because the archive may be extracted on such a file system.
+type archiveNames map[string]bool
+
+func (n archiveNames) unique(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; n[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	n[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// entryName returns a file name that stays inside the directory the archive is extracted to. Filenames are
+// sanitized on upload, but the archive does not rely on it: anything up to the last slash or backslash is
+// dropped, and names that refer to a directory are replaced.
+func entryName(filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "." || name == ".." || name == "/" || strings.TrimSpace(name) == "" {
+		return "media"
+	}
+	return name
+}
+
+// archiveName derives the file name of an album archive from the album name.
+func archiveName(album string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(album))
+	if name == "" {
+		name = "album"
+	}
+	return truncate(name, 200) + ".zip"
+}
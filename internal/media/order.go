@@ -0,0 +1,233 @@
+package media
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/fractional"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// maxSortKeyLength is the length of sort keys beyond which the keys of an album are rebalanced. Moving
+// items into the same gap again and again makes the keys grow by a character every few moves, and
+// rebalancing keeps them well within the sort_key column.
+const maxSortKeyLength = 64
+
+// orderBy holds the ORDER BY clauses of the album sort modes for selectMedia.
+var orderBy = map[entity.SortMode]string{
+	entity.SortManual:   "m.sort_key IS NULL, m.sort_key, COALESCE(md.captured_at, m.created_at), m.id",
+	entity.SortCaptured: "COALESCE(md.captured_at, m.created_at), m.id",
+	entity.SortUploaded: "m.created_at, m.id",
+	entity.SortName:     "m.filename, m.id",
+}
+
+// MoveRequest represents a request moving a media item within the manual order of its album. The item is
+// placed right after the AfterID item, or right before the BeforeID item if AfterID is empty. If both are
+// given, the item is placed between them.
+type MoveRequest struct {
+	AfterID  string `json:"after_id"`
+	BeforeID string `json:"before_id"`
+}
+
+// Validate validates the MoveRequest fields.
+func (m MoveRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.AfterID, validation.Required.When(m.BeforeID == "").Error("either after_id or before_id is required")),
+	)
+}
+
+func (s service) Move(ctx context.Context, id string, req MoveRequest) (entity.Media, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Media{}, err
+	}
+	m, err := s.authorize(ctx, id, entity.RoleEditor)
+	if err != nil {
+		return m, err
+	}
+	if req.AfterID == m.ID || req.BeforeID == m.ID {
+		return entity.Media{}, errors.BadRequest("a media item cannot be moved next to itself")
+	}
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := s.lockOrder(ctx, m.AlbumID); err != nil {
+			return err
+		}
+		if err := s.assignSortKeys(ctx, m.AlbumID); err != nil {
+			return err
+		}
+		lower, upper, err := s.neighbours(ctx, m, req)
+		if err != nil {
+			return err
+		}
+		key, err := fractional.Between(lower, upper)
+		if err == nil && len(key) > maxSortKeyLength {
+			if err := s.rebalanceSortKeys(ctx, m.AlbumID); err != nil {
+				return err
+			}
+			if lower, upper, err = s.neighbours(ctx, m, req); err != nil {
+				return err
+			}
+			key, err = fractional.Between(lower, upper)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.With(ctx).NewQuery("UPDATE media SET sort_key={:key} WHERE id={:id}").
+			Bind(dbx.Params{"id": m.ID, "key": key}).
+			Execute(); err != nil {
+			return err
+		}
+		// arranging items by hand only makes sense in the manual order
+		_, err = s.db.With(ctx).NewQuery("UPDATE albums SET sort_mode={:mode} WHERE id={:album}").
+			Bind(dbx.Params{"album": m.AlbumID, "mode": entity.SortManual}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		return s.events.PublishAlbum(ctx, m.AlbumID, events.TypeMediaMoved, events.AlbumChange{AlbumID: m.AlbumID, MediaID: m.ID})
+	})
+	if _, ok := err.(errors.ErrorResponse); ok {
+		return entity.Media{}, err
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithField("media", m.ID).Error("Failed to move media")
+		return entity.Media{}, errors.InternalServerError("")
+	}
+	return s.Get(ctx, m.ID)
+}
+
+// neighbours returns the sort keys between which a media item is moved according to the request. An
+// empty key means there is no neighbour on that side.
+func (s service) neighbours(ctx context.Context, m entity.Media, req MoveRequest) (lower, upper string, err error) {
+	if req.AfterID != "" {
+		if lower, err = s.sortKey(ctx, m.AlbumID, req.AfterID); err != nil {
+			return "", "", err
+		}
+	}
+	if req.BeforeID != "" {
+		if upper, err = s.sortKey(ctx, m.AlbumID, req.BeforeID); err != nil {
+			return "", "", err
+		}
+	}
+	switch {
+	case req.AfterID != "" && req.BeforeID != "":
+		if lower >= upper {
+			return "", "", errors.BadRequest("after_id has to precede before_id")
+		}
+	case req.AfterID != "":
+		upper, err = s.adjacentKey(ctx, m, "MIN(sort_key)", "sort_key > {:key}", lower)
+	default:
+		lower, err = s.adjacentKey(ctx, m, "MAX(sort_key)", "sort_key < {:key}", upper)
+	}
+	return lower, upper, err
+}
+
+// sortKey returns the sort key of a neighbour of a moved media item. The neighbour has to belong to the
+// same album and must not be in the trash.
+func (s service) sortKey(ctx context.Context, albumID, id string) (string, error) {
+	var key string
+	err := s.db.With(ctx).NewQuery("SELECT sort_key FROM media WHERE id={:id} AND album_id={:album} AND deleted_at IS NULL").
+		Bind(dbx.Params{"id": id, "album": albumID}).
+		Row(&key)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return "", errors.BadRequest("the neighbours have to be media items of the same album")
+	}
+	return key, err
+}
+
+// adjacentKey returns the sort key next to the given key in the album of a media item, ignoring the item
+// itself, or an empty string if there is none. Items in the trash keep their keys, so they are taken into
+// account to keep the keys unique.
+func (s service) adjacentKey(ctx context.Context, m entity.Media, aggregate, condition, key string) (string, error) {
+	var adjacent sql.NullString
+	err := s.db.With(ctx).NewQuery("SELECT " + aggregate + " FROM media WHERE album_id={:album} AND id<>{:id} AND " + condition).
+		Bind(dbx.Params{"album": m.AlbumID, "id": m.ID, "key": key}).
+		Row(&adjacent)
+	return adjacent.String, err
+}
+
+// lockOrder locks the album of the media items whose sort keys are about to change until the surrounding
+// transaction ends, so that concurrent uploads and moves cannot produce duplicate keys.
+func (s service) lockOrder(ctx context.Context, albumID string) error {
+	var id string
+	return s.db.With(ctx).NewQuery("SELECT id FROM albums WHERE id={:album} FOR UPDATE").
+		Bind(dbx.Params{"album": albumID}).
+		Row(&id)
+}
+
+// assignSortKeys appends the media items of an album that have no sort key yet, such as items uploaded
+// before manual ordering existed, to the manual order. They keep the position they are listed at.
+func (s service) assignSortKeys(ctx context.Context, albumID string) error {
+	var ids []string
+	if err := s.db.With(ctx).NewQuery("SELECT m.id FROM media m LEFT JOIN media_metadata md ON md.media_id = m.id " +
+		"WHERE m.album_id={:album} AND m.sort_key IS NULL ORDER BY " + orderBy[entity.SortManual]).
+		Bind(dbx.Params{"album": albumID}).
+		Column(&ids); err != nil || len(ids) == 0 {
+		return err
+	}
+	last, err := s.lastSortKey(ctx, albumID)
+	if err != nil {
+		return err
+	}
+	keys, err := fractional.N(last, len(ids))
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := s.db.With(ctx).NewQuery("UPDATE media SET sort_key={:key} WHERE id={:id}").
+			Bind(dbx.Params{"id": id, "key": keys[i]}).
+			Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebalanceSortKeys replaces the sort keys of all media items of an album, including the items in the
+// trash, with the shortest keys that keep their order. The album has to be locked by lockOrder and all of
+// its items need a sort key.
+func (s service) rebalanceSortKeys(ctx context.Context, albumID string) error {
+	var ids []string
+	if err := s.db.With(ctx).NewQuery("SELECT id FROM media WHERE album_id={:album} ORDER BY sort_key, id").
+		Bind(dbx.Params{"album": albumID}).
+		Column(&ids); err != nil {
+		return err
+	}
+	keys, err := fractional.N("", len(ids))
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := s.db.With(ctx).NewQuery("UPDATE media SET sort_key={:key} WHERE id={:id}").
+			Bind(dbx.Params{"id": id, "key": keys[i]}).
+			Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextSortKey returns the sort key placing a new media item at the end of the manual order of an album.
+// It has to be called in a transaction.
+func (s service) nextSortKey(ctx context.Context, albumID string) (string, error) {
+	if err := s.lockOrder(ctx, albumID); err != nil {
+		return "", err
+	}
+	last, err := s.lastSortKey(ctx, albumID)
+	if err != nil {
+		return "", err
+	}
+	return fractional.Between(last, "")
+}
+
+func (s service) lastSortKey(ctx context.Context, albumID string) (string, error) {
+	var last sql.NullString
+	err := s.db.With(ctx).NewQuery("SELECT MAX(sort_key) FROM media WHERE album_id={:album}").
+		Bind(dbx.Params{"album": albumID}).
+		Row(&last)
+	return last.String, err
+}
@@ -0,0 +1,62 @@
+package media
+
+import (
+	"context"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// loadReactions fills in the reaction counts of the given media items and the reactions of the current
+// user. It runs two queries regardless of the number of items.
+func (s service) loadReactions(ctx context.Context, items []entity.Media) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(items))
+	index := map[string]int{}
+	for i := range items {
+		ids[i] = items[i].ID
+		index[items[i].ID] = i
+	}
+
+	var counts []struct {
+		MediaID  string
+		Reaction string
+		Count    int
+	}
+	if err := s.db.With(ctx).Select("media_id", "reaction", "COUNT(*) AS count").From("media_reactions").
+		Where(dbx.In("media_id", ids...)).
+		GroupBy("media_id", "reaction").
+		All(&counts); err != nil {
+		return err
+	}
+	for _, c := range counts {
+		m := &items[index[c.MediaID]]
+		if m.Reactions == nil {
+			m.Reactions = map[string]int{}
+		}
+		m.Reactions[c.Reaction] = c.Count
+	}
+
+	user := auth.CurrentUser(ctx)
+	if user == nil {
+		return nil
+	}
+	var mine []struct {
+		MediaID  string
+		Reaction string
+	}
+	if err := s.db.With(ctx).Select("media_id", "reaction").From("media_reactions").
+		Where(dbx.And(dbx.HashExp{"user_id": user.GetID()}, dbx.In("media_id", ids...))).
+		OrderBy("reaction").
+		All(&mine); err != nil {
+		return err
+	}
+	for _, r := range mine {
+		m := &items[index[r.MediaID]]
+		m.MyReactions = append(m.MyReactions, r.Reaction)
+	}
+	return nil
+}
@@ -0,0 +1,822 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/blob"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/feed"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/quota"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/exif"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httprange"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// exifHeaderSize is the number of leading bytes kept in memory during an upload for EXIF parsing.
+// JPEG APP1 segments are limited to 64KB, but they may be preceded by other APPn segments.
+const exifHeaderSize = 256 << 10
+
+// Service encapsulates the media management logic.
+type Service interface {
+	// CheckQuota returns a QuotaExceeded error if an upload of the given size would exceed the storage quota
+	// of the current user. It lets uploads be rejected before their content is transmitted.
+	CheckQuota(ctx context.Context, size int64) error
+	// Upload stores a new media item in the given album and extracts its EXIF metadata.
+	// If expectedHash is not empty the upload is rejected unless the content has that SHA-256 hash.
+	// The content type is detected from the content. The item is charged to the storage quota of the current user.
+	Upload(ctx context.Context, albumID, filename, caption, expectedHash string, body io.Reader) (entity.Media, error)
+	// Claim creates a media item from content the current user already uploaded, identified by its SHA-256
+	// hash. It returns a NotFound error if none of the media items of the user has that content, in which
+	// case the client has to upload it; content uploaded only by others is never claimed, so the hash of a
+	// file grants neither its content nor the knowledge that it is stored.
+	// Like an upload, the item is charged to the storage quota of the current user with its full size.
+	Claim(ctx context.Context, albumID, hash, filename, caption string) (entity.Media, error)
+	// List returns the media items of an album matching the given metadata filter together with their
+	// reaction counts and tags. The items of smart albums are computed from their rules. If pages is not
+	// nil, only the requested page is returned.
+	List(ctx context.Context, albumID string, filter Filter, pages *pagination.Pages) ([]entity.Media, error)
+	// GetAll returns the media items with the given IDs in albums the current user can view, public albums
+	// included, together with their reaction counts and tags. Items the user cannot view are left out, and the order of the items
+	// is unspecified.
+	GetAll(ctx context.Context, ids []string) ([]entity.Media, error)
+	// Tagged returns a page of the media items carrying the given normalized tag in all albums the current
+	// user can view, public albums included, most recently uploaded first.
+	Tagged(ctx context.Context, tag string, pages *pagination.Pages) ([]entity.Media, error)
+	// Get returns the media item with the given ID together with its reaction counts and tags.
+	Get(ctx context.Context, id string) (entity.Media, error)
+	// Open returns the media item with the given ID together with a reader for its original content.
+	Open(ctx context.Context, id string) (entity.Media, io.ReadCloser, error)
+	// Content returns the media item with the given ID together with the description of the requested
+	// variant, which supports reading byte ranges from the storage backend.
+	Content(ctx context.Context, id, variant string) (entity.Media, httprange.Content, error)
+	// SignURL returns a URL that serves the given variant of a media item without authentication until the TTL
+	// passes. A zero TTL selects the configured default.
+	SignURL(ctx context.Context, id, variant string, ttl time.Duration) (SignedURL, error)
+	// SignedContent is like Content, but authorizes the request with a signature created by SignURL
+	// instead of the current user.
+	SignedContent(ctx context.Context, id, variant string, expires int64, kid, sig string) (entity.Media, httprange.Content, error)
+	// AlbumMedia returns the media items of an album without checking access. It is meant for public
+	// views whose access has already been authorized by other means, such as a share link.
+	AlbumMedia(ctx context.Context, a entity.Album) ([]entity.Media, error)
+	// PublicContent returns a variant of a media item of the given album for a public view. Sensitive
+	// metadata is stripped from originals unless the album keeps location data. Items shown in a smart
+	// album belong to other albums, which have to keep location data as well.
+	PublicContent(ctx context.Context, a entity.Album, id, variant string) (entity.Media, httprange.Content, error)
+	// Archive returns a ZIP archive of the given variant of all media items of an album. Media without the
+	// variant, such as videos, are included as originals.
+	Archive(ctx context.Context, albumID, variant string) (Archive, error)
+	// PublicArchive is like Archive for a public view of the album. Like PublicContent, it strips sensitive
+	// metadata from originals unless both the album and the album of the item keep location data.
+	PublicArchive(ctx context.Context, a entity.Album, variant string) (Archive, error)
+	// Move moves a media item within the manual order of its album and switches the album to the manual
+	// sort mode. Only the key of the moved item changes.
+	Move(ctx context.Context, id string, req MoveRequest) (entity.Media, error)
+	// Update changes the caption of a media item. Contributors may caption their own uploads, captioning
+	// the uploads of others requires an editor.
+	Update(ctx context.Context, id string, req UpdateRequest) (entity.Media, error)
+	// Delete moves the media item with the given ID to the trash.
+	Delete(ctx context.Context, id string) error
+}
+
+// maxCaptionLength is the maximum number of characters of a caption.
+const maxCaptionLength = 2000
+
+// UpdateRequest represents a media item update request. Nil fields are left unchanged.
+type UpdateRequest struct {
+	Caption *string `json:"caption"`
+}
+
+// Validate validates the UpdateRequest fields.
+func (m UpdateRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Caption, validation.Length(0, maxCaptionLength)),
+	)
+}
+
+// Filter restricts a media listing by EXIF metadata. Empty fields are ignored.
+type Filter struct {
+	CameraMake  string
+	CameraModel string
+	LensModel   string
+	TakenAfter  *time.Time
+	TakenBefore *time.Time
+	HasLocation *bool
+	// Sort overrides the sort mode of the album.
+	Sort entity.SortMode
+}
+
+type service struct {
+	db      *dbcontext.DB
+	blobs   blob.Service
+	albums  album.Service
+	quotas  quota.Service
+	index   index.Index
+	feed    feed.Publisher
+	events  events.Publisher
+	signing URLSigning
+	logger  *logrus.Logger
+}
+
+// NewService creates a new media service that keeps the given search index up to date.
+func NewService(db *dbcontext.DB, blobs blob.Service, albums album.Service, quotas quota.Service, index index.Index, feed feed.Publisher, events events.Publisher, signing URLSigning, logger *logrus.Logger) Service {
+	return service{db, blobs, albums, quotas, index, feed, events, signing, logger}
+}
+
+// mediaRow is a media row joined with its (optional) metadata row.
+type mediaRow struct {
+	entity.Media
+	entity.MediaMetadata
+	HasMetadata bool
+}
+
+const selectMedia = "SELECT m.id, m.album_id, m.owner_id, m.filename, m.caption, m.content_type, m.size, COALESCE(m.blob_hash, '') AS blob_hash, m.storage_key, m.created_at, m.deleted_at, m.sort_key, " +
+	"md.media_id IS NOT NULL AS has_metadata, COALESCE(md.camera_make, '') AS camera_make, COALESCE(md.camera_model, '') AS camera_model, " +
+	"COALESCE(md.lens_model, '') AS lens_model, COALESCE(md.exposure_time, '') AS exposure_time, md.f_number, md.iso, md.focal_length, " +
+	"md.captured_at, md.latitude, md.longitude, md.altitude " +
+	"FROM media m LEFT JOIN media_metadata md ON md.media_id = m.id "
+
+func (r mediaRow) entity() entity.Media {
+	m := r.Media
+	if r.HasMetadata {
+		md := r.MediaMetadata
+		md.MediaID = m.ID
+		m.Metadata = &md
+	}
+	return m
+}
+
+func (s service) CheckQuota(ctx context.Context, size int64) error {
+	return s.quotas.Check(ctx, auth.CurrentUser(ctx).GetID(), size)
+}
+
+func (s service) Upload(ctx context.Context, albumID, filename, caption, expectedHash string, body io.Reader) (entity.Media, error) {
+	logger := s.logger.WithContext(ctx).WithField("album", albumID)
+	if err := (UpdateRequest{Caption: &caption}).Validate(); err != nil {
+		return entity.Media{}, err
+	}
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleContributor)
+	if err != nil {
+		return entity.Media{}, err
+	}
+	if a.Rules != nil {
+		return entity.Media{}, errSmartAlbum
+	}
+
+	// the content type is sniffed from the first bytes, as redaction and the variants depend on the
+	// actual format rather than the one claimed by the client
+	head := &headBuffer{max: exifHeaderSize}
+	hash := sha256.New()
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(io.TeeReader(body, io.MultiWriter(head, hash)), sniff)
+	contentType := sniffContentType(sniff[:n])
+	var br io.Reader = io.MultiReader(bytes.NewReader(sniff[:n]), io.TeeReader(body, io.MultiWriter(head, hash)))
+	if !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "video/") {
+		return entity.Media{}, errors.BadRequest("unsupported media type")
+	}
+
+	// reading stops right after the quota is exceeded, so an oversized upload is not spooled completely
+	usage, err := s.quotas.Usage(ctx, auth.CurrentUser(ctx).GetID())
+	if err != nil {
+		return entity.Media{}, err
+	}
+	if remaining := usage.Remaining(); remaining >= 0 {
+		br = io.LimitReader(br, remaining+1)
+	}
+
+	// the content has to be spooled locally because its storage key depends on the hash of the whole body
+	spool, err := os.CreateTemp("", "shareflow-upload-*")
+	if err != nil {
+		logger.WithError(err).Error("Failed to create upload spool file")
+		return entity.Media{}, errors.InternalServerError("")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, br)
+	if err != nil {
+		logger.WithError(err).Warn("Upload interrupted")
+		return entity.Media{}, errors.BadRequest("upload interrupted")
+	}
+	if !usage.Allows(size) {
+		return entity.Media{}, s.quotas.Check(ctx, usage.UserID, size)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expectedHash != "" && !strings.EqualFold(expectedHash, sum) {
+		return entity.Media{}, errors.BadRequest("content does not match the provided SHA-256 hash")
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return entity.Media{}, err
+	}
+
+	m := entity.Media{
+		ID:          entity.GenerateID(),
+		AlbumID:     a.ID,
+		OwnerID:     auth.CurrentUser(ctx).GetID(),
+		Filename:    sanitizeFilename(filename),
+		Caption:     caption,
+		ContentType: contentType,
+		Size:        size,
+		BlobHash:    sum,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	if contentType == "image/jpeg" {
+		if data, err := exif.Parse(bytes.NewReader(head.Bytes())); err == nil {
+			md := metadataFromExif(m.ID, data)
+			m.Metadata = &md
+		} else if err != exif.ErrNoExif {
+			logger.WithError(err).Warn("Failed to parse EXIF data")
+		}
+	}
+
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := s.quotas.Charge(ctx, m.OwnerID, size); err != nil {
+			return err
+		}
+		b, err := s.blobs.Create(ctx, sum, size, contentType, spool)
+		if err != nil {
+			return err
+		}
+		m.StorageKey = b.StorageKey
+		if err := s.insert(ctx, m); err != nil {
+			return err
+		}
+		return s.events.Publish(ctx, events.TypeUploadCompleted, events.UploadStatus{AlbumID: m.AlbumID, MediaID: m.ID, Filename: m.Filename}, m.OwnerID)
+	})
+	if err != nil {
+		status := events.UploadStatus{AlbumID: m.AlbumID, Filename: m.Filename, Error: err.Error()}
+		if _, ok := err.(errors.ErrorResponse); !ok {
+			status.Error = "the upload could not be saved"
+		}
+		if err := s.events.Publish(ctx, events.TypeUploadFailed, status, m.OwnerID); err != nil {
+			logger.WithError(err).Warn("Failed to publish upload status")
+		}
+	}
+	if _, ok := err.(errors.ErrorResponse); ok {
+		return entity.Media{}, err
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to save media")
+		return entity.Media{}, errors.InternalServerError("")
+	}
+	return m, nil
+}
+
+func (s service) Claim(ctx context.Context, albumID, hash, filename, caption string) (entity.Media, error) {
+	hash = strings.ToLower(hash)
+	if !blob.ValidHash(hash) {
+		return entity.Media{}, errors.BadRequest("invalid SHA-256 hash")
+	}
+	if err := (UpdateRequest{Caption: &caption}).Validate(); err != nil {
+		return entity.Media{}, err
+	}
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleContributor)
+	if err != nil {
+		return entity.Media{}, err
+	}
+	if a.Rules != nil {
+		return entity.Media{}, errSmartAlbum
+	}
+	m := entity.Media{
+		ID:        entity.GenerateID(),
+		AlbumID:   a.ID,
+		OwnerID:   auth.CurrentUser(ctx).GetID(),
+		Filename:  sanitizeFilename(filename),
+		Caption:   caption,
+		BlobHash:  hash,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		var owned int
+		if err := s.db.With(ctx).Select("COUNT(*)").From("media").
+			Where(dbx.HashExp{"owner_id": m.OwnerID, "blob_hash": hash}).
+			Row(&owned); err != nil {
+			return err
+		}
+		if owned == 0 {
+			return sql.ErrNoRows
+		}
+		b, err := s.blobs.Acquire(ctx, hash)
+		if err != nil {
+			return err
+		}
+		m.ContentType, m.Size, m.StorageKey = b.ContentType, b.Size, b.StorageKey
+		if err := s.quotas.Charge(ctx, m.OwnerID, m.Size); err != nil {
+			return err
+		}
+		// identical content has identical EXIF data, so it is copied from another media item of the user
+		var row mediaRow
+		err = s.db.With(ctx).NewQuery(selectMedia + "WHERE m.blob_hash = {:hash} AND m.owner_id = {:owner} AND md.media_id IS NOT NULL LIMIT 1").
+			Bind(dbx.Params{"hash": hash, "owner": m.OwnerID}).
+			One(&row)
+		if err == nil {
+			md := row.MediaMetadata
+			md.MediaID = m.ID
+			m.Metadata = &md
+		} else if !stderrors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		return s.insert(ctx, m)
+	})
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return entity.Media{}, errors.NotFound("you have not uploaded content with the given hash yet")
+	}
+	if _, ok := err.(errors.ErrorResponse); ok {
+		return entity.Media{}, err
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithField("album", albumID).Error("Failed to claim media")
+		return entity.Media{}, errors.InternalServerError("")
+	}
+	return m, nil
+}
+
+// insert saves a media row and its metadata, places the item at the end of the manual order of its album
+// and adds it to the search index and the feeds of the followers of the uploader.
+func (s service) insert(ctx context.Context, m entity.Media) error {
+	key, err := s.nextSortKey(ctx, m.AlbumID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.With(ctx).Insert("media", dbx.Params{
+		"id":           m.ID,
+		"album_id":     m.AlbumID,
+		"owner_id":     m.OwnerID,
+		"filename":     m.Filename,
+		"caption":      m.Caption,
+		"content_type": m.ContentType,
+		"size":         m.Size,
+		"blob_hash":    m.BlobHash,
+		"storage_key":  m.StorageKey,
+		"created_at":   m.CreatedAt,
+		"sort_key":     key,
+	}).Execute(); err != nil {
+		return err
+	}
+	if m.Metadata != nil {
+		if err := s.insertMetadata(ctx, *m.Metadata); err != nil {
+			return err
+		}
+	}
+	if err := s.index.IndexMedia(ctx, m.ID); err != nil {
+		return err
+	}
+	if err := s.events.PublishAlbum(ctx, m.AlbumID, events.TypeMediaAdded, events.AlbumChange{AlbumID: m.AlbumID, MediaID: m.ID}); err != nil {
+		return err
+	}
+	return s.feed.Publish(ctx, entity.Activity{Type: entity.ActivityMediaUploaded, AlbumID: m.AlbumID, MediaID: &m.ID})
+}
+
+// insertMetadata saves the metadata of a new media item.
+func (s service) insertMetadata(ctx context.Context, md entity.MediaMetadata) error {
+	_, err := s.db.With(ctx).Insert("media_metadata", dbx.Params{
+		"media_id":      md.MediaID,
+		"camera_make":   md.CameraMake,
+		"camera_model":  md.CameraModel,
+		"lens_model":    md.LensModel,
+		"exposure_time": md.ExposureTime,
+		"f_number":      md.FNumber,
+		"iso":           md.ISO,
+		"focal_length":  md.FocalLength,
+		"captured_at":   md.CapturedAt,
+		"latitude":      md.Latitude,
+		"longitude":     md.Longitude,
+		"altitude":      md.Altitude,
+	}).Execute()
+	return err
+}
+
+func (s service) List(ctx context.Context, albumID string, filter Filter, pages *pagination.Pages) ([]entity.Media, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.list(ctx, a, filter, pages)
+	if err != nil {
+		return nil, err
+	}
+	return items, s.load(ctx, items)
+}
+
+func (s service) GetAll(ctx context.Context, ids []string) ([]entity.Media, error) {
+	if len(ids) == 0 {
+		return []entity.Media{}, nil
+	}
+	params := dbx.Params{"user": auth.CurrentUser(ctx).GetID()}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		name := fmt.Sprintf("id%d", i)
+		params[name], placeholders[i] = id, "{:"+name+"}"
+	}
+	var rows []mediaRow
+	err := s.db.With(ctx).NewQuery(selectMedia + "JOIN albums a ON a.id = m.album_id " + block.MemberJoin("{:user}") +
+		"WHERE m.id IN (" + strings.Join(placeholders, ", ") + ") AND m.deleted_at IS NULL AND m.hidden_at IS NULL AND " + block.AlbumVisible("{:user}")).
+		Bind(params).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]entity.Media, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, row.entity())
+	}
+	return items, s.load(ctx, items)
+}
+
+// selectTagged restricts selectMedia to the media items carrying the tag bound to the "tag" parameter
+// in the albums the user bound to the "user" parameter can view.
+var selectTagged = "JOIN media_tags t ON t.media_id = m.id JOIN albums a ON a.id = m.album_id " + block.MemberJoin("{:user}") +
+	"WHERE t.tag = {:tag} AND m.deleted_at IS NULL AND m.hidden_at IS NULL AND " + block.AlbumVisible("{:user}") + " "
+
+func (s service) Tagged(ctx context.Context, tag string, pages *pagination.Pages) ([]entity.Media, error) {
+	params := dbx.Params{"tag": tag, "user": auth.CurrentUser(ctx).GetID()}
+	var total int
+	if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM media m " + selectTagged).
+		Bind(params).
+		Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	var rows []mediaRow
+	err := s.db.With(ctx).NewQuery(selectMedia + selectTagged + "ORDER BY m.created_at DESC, m.id LIMIT {:limit} OFFSET {:offset}").
+		Bind(params).
+		Bind(dbx.Params{"limit": pages.Limit(), "offset": pages.Offset()}).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]entity.Media, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, row.entity())
+	}
+	return items, s.load(ctx, items)
+}
+
+func (s service) AlbumMedia(ctx context.Context, a entity.Album) ([]entity.Media, error) {
+	items, err := s.list(ctx, a, Filter{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := s.keepsLocation(ctx, a, items)
+	for i := range items {
+		items[i] = PublicView(items[i], keep[items[i].AlbumID])
+	}
+	return items, err
+}
+
+// keepsLocation returns the set of the IDs of the albums of the given items whose location data may be
+// served through public and shared links of album a. The items of a smart album belong to other albums
+// whose owners decide about the location data of their items, so it is only kept if both albums allow it.
+func (s service) keepsLocation(ctx context.Context, a entity.Album, items []entity.Media) (map[string]bool, error) {
+	keep := map[string]bool{}
+	if !a.KeepLocation {
+		return keep, nil
+	}
+	var others []interface{}
+	for _, m := range items {
+		if m.AlbumID == a.ID {
+			keep[a.ID] = true
+		} else {
+			others = append(others, m.AlbumID)
+		}
+	}
+	if len(others) == 0 {
+		return keep, nil
+	}
+	var kept []string
+	err := s.db.With(ctx).Select("id").From("albums").
+		Where(dbx.And(dbx.In("id", others...), dbx.HashExp{"keep_location": true})).
+		Column(&kept)
+	for _, id := range kept {
+		keep[id] = true
+	}
+	return keep, err
+}
+
+func (s service) PublicContent(ctx context.Context, a entity.Album, id, variant string) (entity.Media, httprange.Content, error) {
+	m, err := s.find(ctx, id)
+	if err != nil {
+		return m, httprange.Content{}, err
+	}
+	if ok, err := s.contains(ctx, a, m); err != nil || !ok {
+		if err == nil {
+			err = errors.NotFound("")
+		}
+		return entity.Media{}, httprange.Content{}, err
+	}
+	keep, err := s.keepsLocation(ctx, a, []entity.Media{m})
+	if err != nil {
+		return entity.Media{}, httprange.Content{}, err
+	}
+	content, err := s.variantContent(ctx, m, variant, !keep[m.AlbumID])
+	return PublicView(m, keep[m.AlbumID]), content, err
+}
+
+// order returns the ORDER BY clause listing the media items of an album.
+func order(a entity.Album, filter Filter) string {
+	mode := filter.Sort
+	if mode == "" {
+		mode = a.SortMode
+	}
+	// sort keys position items within their own album, so they cannot order the items of a smart album
+	if mode == entity.SortManual && a.Rules != nil {
+		mode = entity.SortCaptured
+	}
+	if o, ok := orderBy[mode]; ok {
+		return o
+	}
+	return orderBy[entity.SortCaptured]
+}
+
+// list returns the media items of an album matching the given filter in the sort mode of the album. If
+// pages is not nil, only the requested page is returned.
+func (s service) list(ctx context.Context, a entity.Album, filter Filter, pages *pagination.Pages) ([]entity.Media, error) {
+	params := dbx.Params{}
+	joins, where := scope(ctx, a, params)
+	if filter.CameraMake != "" {
+		where = append(where, "md.camera_make = {:camera_make}")
+		params["camera_make"] = filter.CameraMake
+	}
+	if filter.CameraModel != "" {
+		where = append(where, "md.camera_model = {:camera_model}")
+		params["camera_model"] = filter.CameraModel
+	}
+	if filter.LensModel != "" {
+		where = append(where, "md.lens_model = {:lens_model}")
+		params["lens_model"] = filter.LensModel
+	}
+	if filter.TakenAfter != nil {
+		where = append(where, "md.captured_at >= {:taken_after}")
+		params["taken_after"] = *filter.TakenAfter
+	}
+	if filter.TakenBefore != nil {
+		where = append(where, "md.captured_at < {:taken_before}")
+		params["taken_before"] = *filter.TakenBefore
+	}
+	if filter.HasLocation != nil {
+		if *filter.HasLocation {
+			where = append(where, "md.latitude IS NOT NULL")
+		} else {
+			where = append(where, "md.latitude IS NULL")
+		}
+	}
+
+	query := joins + "WHERE " + strings.Join(where, " AND ")
+	limit := ""
+	if pages != nil {
+		var total int
+		if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM media m LEFT JOIN media_metadata md ON md.media_id = m.id " + query).
+			Bind(params).
+			Row(&total); err != nil {
+			return nil, err
+		}
+		*pages = *pagination.New(pages.Page, pages.PerPage, total)
+		limit = " LIMIT {:limit} OFFSET {:offset}"
+		params["limit"], params["offset"] = pages.Limit(), pages.Offset()
+	}
+
+	var rows []mediaRow
+	err := s.db.With(ctx).NewQuery(selectMedia + query + " ORDER BY " + order(a, filter) + limit).
+		Bind(params).
+		All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]entity.Media, 0, len(rows))
+	for _, row := range rows {
+		items = append(items, row.entity())
+	}
+	return items, nil
+}
+
+func (s service) Get(ctx context.Context, id string) (entity.Media, error) {
+	m, err := s.authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return m, err
+	}
+	items := []entity.Media{m}
+	err = s.load(ctx, items)
+	return items[0], err
+}
+
+// load fills in the reactions and tags of the given media items.
+func (s service) load(ctx context.Context, items []entity.Media) error {
+	if err := s.loadReactions(ctx, items); err != nil {
+		return err
+	}
+	return s.loadTags(ctx, items)
+}
+
+// authorize returns the media item with the given ID if the current user holds at least the given role
+// in its album. Like album.Service.Authorize, a missing media item is reported as Forbidden.
+func (s service) authorize(ctx context.Context, id string, role entity.Role) (entity.Media, error) {
+	m, err := s.find(ctx, id)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return m, errors.Forbidden("")
+	}
+	if err != nil {
+		return m, err
+	}
+	if _, err := s.albums.Authorize(ctx, m.AlbumID, role); err != nil {
+		return entity.Media{}, err
+	}
+	return m, nil
+}
+
+// find returns the media item with the given ID without checking whether the current user may access it.
+// Media items in the trash or hidden by moderators are not found, nor are the items of albums in the trash
+// or hidden, which stay untouched in their albums. Signed URLs rely on this, as they are served without
+// any other check.
+func (s service) find(ctx context.Context, id string) (entity.Media, error) {
+	var row mediaRow
+	if err := s.db.With(ctx).NewQuery(selectMedia + "WHERE m.id = {:id} AND m.deleted_at IS NULL AND m.hidden_at IS NULL " +
+		"AND EXISTS (SELECT 1 FROM albums a WHERE a.id = m.album_id AND a.deleted_at IS NULL AND a.hidden_at IS NULL)").
+		Bind(dbx.Params{"id": id}).
+		One(&row); err != nil {
+		return entity.Media{}, err
+	}
+	return row.entity(), nil
+}
+
+func (s service) Open(ctx context.Context, id string) (entity.Media, io.ReadCloser, error) {
+	m, err := s.authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return m, nil, err
+	}
+	rc, err := s.blobs.Open(ctx, m.StorageKey)
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithField("media", m.ID).Error("Failed to open media object")
+		return m, nil, errors.InternalServerError("")
+	}
+	return m, rc, nil
+}
+
+func (s service) Content(ctx context.Context, id, variant string) (entity.Media, httprange.Content, error) {
+	m, err := s.authorize(ctx, id, entity.RoleViewer)
+	if err != nil {
+		return m, httprange.Content{}, err
+	}
+	content, err := s.variantContent(ctx, m, variant, false)
+	return m, content, err
+}
+
+// content describes the original content of a media item. Media content never changes once uploaded,
+// so the media ID is a strong validator. The SHA-256 hash would be one as well, but it is kept from
+// clients, who could claim the content with it.
+func (s service) content(ctx context.Context, m entity.Media) httprange.Content {
+	return httprange.Content{
+		Size:        m.Size,
+		ContentType: m.ContentType,
+		ETag:        `"` + m.ID + `"`,
+		ModTime:     m.CreatedAt,
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return s.blobs.OpenRange(ctx, m.StorageKey, offset, length)
+		},
+	}
+}
+
+func (s service) Update(ctx context.Context, id string, req UpdateRequest) (entity.Media, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Media{}, err
+	}
+	role := entity.RoleEditor
+	if m, err := s.find(ctx, id); err == nil && m.OwnerID == auth.CurrentUser(ctx).GetID() {
+		role = entity.RoleContributor
+	}
+	m, err := s.authorize(ctx, id, role)
+	if err != nil {
+		return m, err
+	}
+	if req.Caption != nil {
+		err = s.db.Transactional(ctx, func(ctx context.Context) error {
+			if _, err := s.db.With(ctx).NewQuery("UPDATE media SET caption={:caption} WHERE id={:id}").
+				Bind(dbx.Params{"id": m.ID, "caption": *req.Caption}).
+				Execute(); err != nil {
+				return err
+			}
+			return s.index.IndexMedia(ctx, m.ID)
+		})
+		if err != nil {
+			return entity.Media{}, err
+		}
+	}
+	return s.Get(ctx, m.ID)
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	// contributors may delete their own uploads, deleting the uploads of others requires an editor
+	role := entity.RoleEditor
+	if m, err := s.find(ctx, id); err == nil && m.OwnerID == auth.CurrentUser(ctx).GetID() {
+		role = entity.RoleContributor
+	}
+	m, err := s.authorize(ctx, id, role)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).NewQuery("UPDATE media SET deleted_at={:now}, deleted_by={:user} WHERE id={:id}").
+		Bind(dbx.Params{"id": m.ID, "user": auth.CurrentUser(ctx).GetID(), "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	return s.events.PublishAlbum(ctx, m.AlbumID, events.TypeMediaDeleted, events.AlbumChange{AlbumID: m.AlbumID, MediaID: m.ID})
+}
+
+// PublicView returns a copy of the media item that is safe to be shown through public or shared links:
+// location data is removed unless keepLocation is set, as decided by keepsLocation.
+func PublicView(m entity.Media, keepLocation bool) entity.Media {
+	if m.Metadata != nil && !keepLocation {
+		md := m.Metadata.Redacted()
+		m.Metadata = &md
+	}
+	return m
+}
+
+func metadataFromExif(mediaID string, data *exif.Data) entity.MediaMetadata {
+	return entity.MediaMetadata{
+		MediaID:      mediaID,
+		CameraMake:   truncate(data.CameraMake, 127),
+		CameraModel:  truncate(data.CameraModel, 127),
+		LensModel:    truncate(data.LensModel, 127),
+		ExposureTime: truncate(data.ExposureTime, 31),
+		FNumber:      data.FNumber,
+		ISO:          data.ISO,
+		FocalLength:  data.FocalLength,
+		CapturedAt:   data.CapturedAt,
+		Latitude:     data.Latitude,
+		Longitude:    data.Longitude,
+		Altitude:     data.Altitude,
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// sniffContentType detects the content type of media from its first bytes. HEIF images and QuickTime
+// videos, which http.DetectContentType does not know, are recognized by the brand of their ftyp box.
+func sniffContentType(b []byte) string {
+	if len(b) >= 12 && string(b[4:8]) == "ftyp" {
+		switch string(b[8:12]) {
+		case "heic", "heix", "heim", "heis":
+			return "image/heic"
+		case "mif1", "msf1":
+			return "image/heif"
+		case "qt  ":
+			return "video/quicktime"
+		}
+	}
+	return http.DetectContentType(b)
+}
+
+// sanitizeFilename strips any client supplied path and falls back to a generic name.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	return truncate(name, 255)
+}
+
+// headBuffer keeps the first max bytes written to it and silently discards the rest.
+type headBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
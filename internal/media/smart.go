@@ -0,0 +1,84 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// errSmartAlbum is returned when media items are added to a smart album.
+var errSmartAlbum = errors.BadRequest("smart albums cannot hold media items directly")
+
+// scope returns the joins and conditions for selectMedia selecting the media items of an album, and binds
+// their parameters.
+//
+// The items of a smart album are the media items matching its rules in the regular albums its owner can
+// view. For everyone else, such as visitors of share links, only the albums the owner owns or co-owns
+// are taken into account, because those are the albums the owner may share.
+func scope(ctx context.Context, a entity.Album, params dbx.Params) (string, []string) {
+	if a.Rules == nil {
+		params["album"] = a.ID
+		return "", []string{"m.album_id = {:album}", "m.deleted_at IS NULL", "m.hidden_at IS NULL"}
+	}
+	r := a.Rules
+	joins := "JOIN albums sa ON sa.id = m.album_id LEFT JOIN album_members sm ON sm.album_id = sa.id AND sm.user_id = {:smart_owner} "
+	where := []string{"m.deleted_at IS NULL", "m.hidden_at IS NULL", "sa.deleted_at IS NULL", "sa.hidden_at IS NULL", "sa.rules IS NULL"}
+	params["smart_owner"] = a.OwnerID
+	if user := auth.CurrentUser(ctx); user != nil && user.GetID() == a.OwnerID {
+		where = append(where, "(sa.owner_id = {:smart_owner} OR sm.user_id IS NOT NULL)")
+	} else {
+		where = append(where, "(sa.owner_id = {:smart_owner} OR sm.role = {:smart_role})")
+		params["smart_role"] = entity.RoleCoOwner
+	}
+	for i, t := range r.Tags {
+		name := fmt.Sprintf("smart_tag%d", i)
+		where = append(where, "EXISTS (SELECT 1 FROM media_tags st WHERE st.media_id = m.id AND st.tag = {:"+name+"})")
+		params[name] = t
+	}
+	if r.TakenAfter != nil {
+		where = append(where, "COALESCE(md.captured_at, m.created_at) >= {:smart_after}")
+		params["smart_after"] = *r.TakenAfter
+	}
+	if r.TakenBefore != nil {
+		where = append(where, "COALESCE(md.captured_at, m.created_at) < {:smart_before}")
+		params["smart_before"] = *r.TakenBefore
+	}
+	if r.CameraMake != "" {
+		where = append(where, "md.camera_make = {:smart_camera_make}")
+		params["smart_camera_make"] = r.CameraMake
+	}
+	if r.CameraModel != "" {
+		where = append(where, "md.camera_model = {:smart_camera_model}")
+		params["smart_camera_model"] = r.CameraModel
+	}
+	if r.UploaderID != nil {
+		where = append(where, "m.owner_id = {:smart_uploader}")
+		params["smart_uploader"] = *r.UploaderID
+	}
+	if r.SourceAlbumID != "" {
+		where = append(where, "m.album_id = {:smart_source}")
+		params["smart_source"] = r.SourceAlbumID
+	}
+	return joins, where
+}
+
+// contains reports whether a media item belongs to an album, which for smart albums means that it
+// matches the rules.
+func (s service) contains(ctx context.Context, a entity.Album, m entity.Media) (bool, error) {
+	if a.Rules == nil {
+		return m.AlbumID == a.ID, nil
+	}
+	params := dbx.Params{"id": m.ID}
+	joins, where := scope(ctx, a, params)
+	var n int
+	err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM media m LEFT JOIN media_metadata md ON md.media_id = m.id " + joins +
+		"WHERE m.id = {:id} AND " + strings.Join(where, " AND ")).
+		Bind(params).
+		Row(&n)
+	return n > 0, err
+}
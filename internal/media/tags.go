@@ -0,0 +1,36 @@
+package media
+
+import (
+	"context"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+)
+
+// loadTags fills in the tags of the given media items with a single query.
+func (s service) loadTags(ctx context.Context, items []entity.Media) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(items))
+	index := map[string]int{}
+	for i := range items {
+		ids[i] = items[i].ID
+		index[items[i].ID] = i
+	}
+	var tags []struct {
+		MediaID string
+		Tag     string
+	}
+	if err := s.db.With(ctx).Select("media_id", "tag").From("media_tags").
+		Where(dbx.In("media_id", ids...)).
+		OrderBy("tag").
+		All(&tags); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		m := &items[index[t.MediaID]]
+		m.Tags = append(m.Tags, t.Tag)
+	}
+	return nil
+}
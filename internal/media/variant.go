@@ -0,0 +1,121 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for the supported upload formats
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/exif"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httprange"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/imaging"
+)
+
+// Media variants. Resized variants are re-encoded JPEG images that never carry EXIF metadata.
+const (
+	VariantOriginal  = "original"
+	VariantPreview   = "preview"
+	VariantThumbnail = "thumbnail"
+)
+
+// variantRedacted is the original image with sensitive metadata removed, served through public links.
+const variantRedacted = "redacted"
+
+// variantSizes holds the bounding box of the resized variants.
+var variantSizes = map[string]int{
+	VariantPreview:   1280,
+	VariantThumbnail: 320,
+}
+
+// errUnredactable is returned for originals that are served without their metadata but whose format does not
+// allow removing it.
+var errUnredactable = errors.Forbidden("the original of this media item cannot be shared without its metadata")
+
+// maxDecodePixels protects variant generation from decompression bombs.
+const maxDecodePixels = 80_000_000
+
+// ValidVariant reports whether the given name is a known variant.
+func ValidVariant(name string) bool {
+	_, ok := variantSizes[name]
+	return ok || name == VariantOriginal
+}
+
+// variantContent describes the content of the given media variant. If redact is set the original is served
+// without its sensitive metadata, and refused if its format does not allow removing it. Derived content
+// is created on first use.
+func (s service) variantContent(ctx context.Context, m entity.Media, variant string, redact bool) (httprange.Content, error) {
+	if variant == "" {
+		variant = VariantOriginal
+	}
+	if !ValidVariant(variant) {
+		return httprange.Content{}, errors.BadRequest("unknown variant")
+	}
+	if variant == VariantOriginal && !redact {
+		return s.content(ctx, m), nil
+	}
+	if variant == VariantOriginal && !exif.Strippable(m.ContentType) {
+		return httprange.Content{}, errUnredactable
+	}
+	if m.BlobHash == "" || !resizable(m.ContentType) {
+		return httprange.Content{}, errors.BadRequest("variant not available for this media item")
+	}
+
+	var d entity.BlobDerivative
+	var err error
+	if variant == VariantOriginal {
+		d, err = s.blobs.Derivative(ctx, m.BlobHash, variantRedacted, m.ContentType, exif.Strip)
+	} else {
+		size := variantSizes[variant]
+		d, err = s.blobs.Derivative(ctx, m.BlobHash, variant, "image/jpeg", func(w io.Writer, src io.Reader) error {
+			return resize(w, src, size)
+		})
+	}
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).WithFields(map[string]interface{}{"media": m.ID, "variant": variant}).Error("Failed to create media variant")
+		return httprange.Content{}, errors.InternalServerError("")
+	}
+	return httprange.Content{
+		Size:        d.Size,
+		ContentType: d.ContentType,
+		ETag:        `"` + m.ID + "-" + d.Name + `"`,
+		ModTime:     d.CreatedAt,
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return s.blobs.OpenRange(ctx, d.StorageKey, offset, length)
+		},
+	}, nil
+}
+
+func resizable(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// resize decodes an image, rotates it upright according to its EXIF orientation and writes a JPEG
+// that fits into a size x size box.
+func resize(w io.Writer, src io.Reader, size int) error {
+	br := bufio.NewReaderSize(src, exifHeaderSize)
+	head, _ := br.Peek(exifHeaderSize)
+	orientation := 1
+	if data, err := exif.Parse(bytes.NewReader(head)); err == nil {
+		orientation = data.Orientation
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil && cfg.Width*cfg.Height > maxDecodePixels {
+		return fmt.Errorf("image is too large to resize (%dx%d)", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(br)
+	if err != nil {
+		return err
+	}
+	img = imaging.Fit(imaging.Orient(img, orientation), size)
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
@@ -0,0 +1,162 @@
+package moderation
+
+import (
+	"net/http"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the report and moderation handlers. All of them require an authenticated
+// user, the ones under /moderation a moderator.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, moderatorHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Post("/reports", res.report)
+	r.Get("/me/reports", res.myReports)
+
+	m := r.Group("/moderation")
+	m.Use(moderatorHandler)
+	m.Get("/reports", res.queue)
+	m.Get("/reports/<id>", res.get)
+	m.Post("/reports/<id>/actions", res.act)
+	m.Post("/reports/<id>/resolve", res.resolve)
+	m.Post("/reports/<id>/reopen", res.reopen)
+	m.Get("/actions", res.actions)
+	m.Post("/actions/<id>/revert", res.revert)
+	m.Get("/log", res.log)
+}
+
+func (r resource) report(c *routing.Context) error {
+	var req ReportRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	report, err := r.service.Report(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(report, http.StatusCreated)
+}
+
+func (r resource) myReports(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	reports, err := r.service.MyReports(c.Request.Context(), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = reports
+	return c.Write(pages)
+}
+
+// queue returns a page of reports. The status query parameter selects open (the default), resolved or
+// dismissed reports, and target_type and target_id narrow them down to reports of the same content.
+func (r resource) queue(c *routing.Context) error {
+	filter := QueueFilter{
+		Status:     entity.ReportStatus(c.Query("status")),
+		TargetType: entity.ReportTarget(c.Query("target_type")),
+		TargetID:   c.Query("target_id"),
+	}
+	switch filter.Status {
+	case "", entity.ReportOpen, entity.ReportResolved, entity.ReportDismissed:
+	default:
+		return errors.BadRequest("invalid status value")
+	}
+	pages := pagination.NewFromRequest(c.Request, -1)
+	reports, err := r.service.Queue(c.Request.Context(), filter, pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = reports
+	return c.Write(pages)
+}
+
+func (r resource) get(c *routing.Context) error {
+	report, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(report)
+}
+
+func (r resource) act(c *routing.Context) error {
+	var req ActionRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	action, err := r.service.Act(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(action, http.StatusCreated)
+}
+
+func (r resource) resolve(c *routing.Context) error {
+	var req ResolveRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	report, err := r.service.Resolve(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(report)
+}
+
+func (r resource) reopen(c *routing.Context) error {
+	var req NoteRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	report, err := r.service.Reopen(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(report)
+}
+
+// actions returns a page of actions. The user query parameter limits them to the user with the handle.
+func (r resource) actions(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	actions, err := r.service.Actions(c.Request.Context(), c.Query("user"), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = actions
+	return c.Write(pages)
+}
+
+func (r resource) revert(c *routing.Context) error {
+	var req NoteRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	action, err := r.service.Revert(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(action)
+}
+
+func (r resource) log(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	entries, err := r.service.Log(c.Request.Context(), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = entries
+	return c.Write(pages)
+}
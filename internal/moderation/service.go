@@ -0,0 +1,741 @@
+// Package moderation lets users report content and moderators review the reports. Moderators hide
+// content, warn and suspend users through actions, which are reverted rather than deleted, and every
+// decision is written to the moderation log.
+//
+// The hidden and suspension columns of the content and the users are derived from the actions in effect,
+// so reverting one of several actions on the same target keeps the others in place.
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/notification"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the reporting and moderation logic.
+type Service interface {
+	// Report reports content on behalf of the current user, who has to be able to view it. A user can
+	// have a single open report of the same content.
+	Report(ctx context.Context, req ReportRequest) (entity.Report, error)
+	// MyReports returns a page of the reports of the current user, most recent first.
+	MyReports(ctx context.Context, pages *pagination.Pages) ([]entity.Report, error)
+
+	// Queue returns a page of the reports matching the filter. Open reports are listed oldest first,
+	// closed ones most recently resolved first.
+	Queue(ctx context.Context, filter QueueFilter, pages *pagination.Pages) ([]entity.Report, error)
+	// Get returns a report with the actions taken on it.
+	Get(ctx context.Context, id string) (entity.Report, error)
+	// Act takes an action on the content of a report or its user and notifies the user.
+	Act(ctx context.Context, reportID string, req ActionRequest) (entity.ModerationAction, error)
+	// Resolve closes an open report with a decision and notifies the reporter.
+	Resolve(ctx context.Context, reportID string, req ResolveRequest) (entity.Report, error)
+	// Reopen puts a closed report back into the queue.
+	Reopen(ctx context.Context, reportID string, req NoteRequest) (entity.Report, error)
+	// Actions returns a page of actions, most recent first, optionally only those on the user with the
+	// given handle.
+	Actions(ctx context.Context, handle string, pages *pagination.Pages) ([]entity.ModerationAction, error)
+	// Revert reverts an action and notifies the user it was taken on.
+	Revert(ctx context.Context, actionID string, req NoteRequest) (entity.ModerationAction, error)
+	// Log returns a page of the moderation log, most recent first.
+	Log(ctx context.Context, pages *pagination.Pages) ([]entity.ModerationLogEntry, error)
+}
+
+// ReportRequest represents a report of content. The TargetID of a profile is the handle of its user.
+type ReportRequest struct {
+	TargetType entity.ReportTarget `json:"target_type"`
+	TargetID   string              `json:"target_id"`
+	Reason     entity.ReportReason `json:"reason"`
+	Details    string              `json:"details"`
+}
+
+// Validate validates the ReportRequest fields.
+func (m ReportRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.TargetType, validation.Required,
+			validation.In(entity.ReportAlbum, entity.ReportMedia, entity.ReportComment, entity.ReportProfile)),
+		validation.Field(&m.TargetID, validation.Required, validation.Length(1, 36)),
+		validation.Field(&m.Reason, validation.Required, validation.In(entity.ReasonSpam, entity.ReasonHarassment, entity.ReasonHate,
+			entity.ReasonViolence, entity.ReasonNudity, entity.ReasonCopyright, entity.ReasonImpersonation, entity.ReasonOther)),
+		validation.Field(&m.Details, validation.When(m.Reason == entity.ReasonOther, validation.Required), validation.RuneLength(0, 2000)),
+	)
+}
+
+// QueueFilter selects the reports of the moderation queue. An empty Status selects the open reports.
+type QueueFilter struct {
+	Status     entity.ReportStatus
+	TargetType entity.ReportTarget
+	TargetID   string
+}
+
+// ActionRequest represents a moderation action. DurationHours limits a suspension, which is indefinite
+// without it.
+type ActionRequest struct {
+	Type          entity.ModerationActionType `json:"type"`
+	Message       string                      `json:"message"`
+	Note          string                      `json:"note"`
+	DurationHours *int                        `json:"duration_hours"`
+}
+
+// Validate validates the ActionRequest fields.
+func (m ActionRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Type, validation.Required, validation.In(entity.ActionHide, entity.ActionWarn, entity.ActionSuspend)),
+		validation.Field(&m.Message, validation.When(m.Type == entity.ActionWarn, validation.Required), validation.RuneLength(0, 2000)),
+		validation.Field(&m.Note, validation.RuneLength(0, 2000)),
+		validation.Field(&m.DurationHours, validation.When(m.Type != entity.ActionSuspend, validation.Nil), validation.NilOrNotEmpty, validation.Min(1)),
+	)
+}
+
+// ResolveRequest represents the decision on a report.
+type ResolveRequest struct {
+	Status entity.ReportStatus `json:"status"`
+	Note   string              `json:"note"`
+}
+
+// Validate validates the ResolveRequest fields.
+func (m ResolveRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Status, validation.Required, validation.In(entity.ReportResolved, entity.ReportDismissed)),
+		validation.Field(&m.Note, validation.RuneLength(0, 2000)),
+	)
+}
+
+// NoteRequest represents a moderator decision that only needs an explanation.
+type NoteRequest struct {
+	Note string `json:"note"`
+}
+
+// Validate validates the NoteRequest fields.
+func (m NoteRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Note, validation.RuneLength(0, 2000)),
+	)
+}
+
+type service struct {
+	db     *dbcontext.DB
+	albums album.Service
+	media  media.Service
+	notify notification.Publisher
+	logger *logrus.Logger
+}
+
+// NewService creates a new moderation service. Reported content is looked up through the album and media
+// services, so users can only report what they can view.
+func NewService(db *dbcontext.DB, albums album.Service, media media.Service, notify notification.Publisher, logger *logrus.Logger) Service {
+	return service{db, albums, media, notify, logger}
+}
+
+// target is the reported content as seen by the reporter.
+type target struct {
+	id       string
+	userID   int
+	snapshot string
+}
+
+// resolve returns the reported content if the current user can view it.
+func (s service) resolve(ctx context.Context, t entity.ReportTarget, id string) (target, error) {
+	switch t {
+	case entity.ReportAlbum:
+		a, err := s.albums.Authorize(ctx, id, entity.RoleViewer)
+		if err != nil {
+			return target{}, err
+		}
+		return target{a.ID, a.OwnerID, strings.TrimSpace(a.Name + "\n\n" + a.Description)}, nil
+	case entity.ReportMedia:
+		m, err := s.media.Get(ctx, id)
+		if err != nil {
+			return target{}, err
+		}
+		return target{m.ID, m.OwnerID, m.Filename + " (" + m.ContentType + ")"}, nil
+	case entity.ReportComment:
+		var c struct {
+			ID       string
+			AlbumID  string
+			MediaID  *string
+			AuthorID int
+			Body     string
+		}
+		err := s.db.With(ctx).NewQuery("SELECT id, album_id, media_id, author_id, body FROM comments " +
+			"WHERE id={:id} AND deleted_at IS NULL AND hidden_at IS NULL").
+			Bind(dbx.Params{"id": id}).
+			One(&c)
+		if stderrors.Is(err, sql.ErrNoRows) {
+			return target{}, errors.NotFound("")
+		}
+		if err != nil {
+			return target{}, err
+		}
+		if c.MediaID != nil {
+			_, err = s.media.Get(ctx, *c.MediaID)
+		} else {
+			_, err = s.albums.Authorize(ctx, c.AlbumID, entity.RoleViewer)
+		}
+		if err != nil {
+			return target{}, err
+		}
+		return target{c.ID, c.AuthorID, c.Body}, nil
+	}
+	var u struct {
+		ID        int
+		Handle    string
+		FirstName string
+		LastName  string
+	}
+	err := s.db.With(ctx).NewQuery("SELECT id, handle, first_name, last_name FROM users WHERE handle_normalized={:handle} AND hidden_at IS NULL").
+		Bind(dbx.Params{"handle": entity.NormalizeHandle(id)}).
+		One(&u)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return target{}, errors.NotFound("")
+	}
+	if err != nil {
+		return target{}, err
+	}
+	return target{u.Handle, u.ID, u.FirstName + " " + u.LastName}, nil
+}
+
+func (s service) Report(ctx context.Context, req ReportRequest) (entity.Report, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Report{}, err
+	}
+	t, err := s.resolve(ctx, req.TargetType, req.TargetID)
+	if err != nil {
+		return entity.Report{}, err
+	}
+	reporter := auth.CurrentUser(ctx).GetID()
+	if t.userID == reporter {
+		return entity.Report{}, errors.BadRequest("you cannot report your own content")
+	}
+	var open int
+	err = s.db.With(ctx).Select("COUNT(*)").From("reports").
+		Where(dbx.HashExp{"reporter_id": reporter, "target_type": req.TargetType, "target_id": t.id, "status": entity.ReportOpen}).
+		Row(&open)
+	if err != nil {
+		return entity.Report{}, err
+	}
+	if open > 0 {
+		return entity.Report{}, errors.BadRequest("you have already reported this content")
+	}
+	report := entity.Report{
+		ID:         entity.GenerateID(),
+		TargetType: req.TargetType,
+		TargetID:   t.id,
+		Reason:     req.Reason,
+		Details:    req.Details,
+		Status:     entity.ReportOpen,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	_, err = s.db.With(ctx).Insert("reports", dbx.Params{
+		"id":              report.ID,
+		"reporter_id":     reporter,
+		"target_type":     report.TargetType,
+		"target_id":       report.TargetID,
+		"target_user_id":  t.userID,
+		"reason":          report.Reason,
+		"details":         report.Details,
+		"snapshot":        t.snapshot,
+		"status":          report.Status,
+		"resolution_note": "",
+		"created_at":      report.CreatedAt,
+	}).Execute()
+	return report, err
+}
+
+func (s service) MyReports(ctx context.Context, pages *pagination.Pages) ([]entity.Report, error) {
+	where := dbx.HashExp{"reporter_id": auth.CurrentUser(ctx).GetID()}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("reports").Where(where).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	reports := []entity.Report{}
+	err := s.db.With(ctx).Select("id", "target_type", "target_id", "reason", "details", "status", "created_at", "resolved_at").
+		From("reports").
+		Where(where).
+		OrderBy("created_at DESC", "id").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&reports)
+	return reports, err
+}
+
+// reports returns a query of reports with the handles of the users involved.
+func (s service) reports(ctx context.Context) *dbx.SelectQuery {
+	return s.db.With(ctx).Select("r.id", "r.target_type", "r.target_id", "r.reason", "r.details", "r.status", "r.created_at", "r.resolved_at",
+		"COALESCE(ru.handle, '') AS reporter_handle", "COALESCE(tu.handle, '') AS target_user_handle", "r.snapshot", "r.resolution_note",
+		"COALESCE(mu.handle, '') AS resolved_by").
+		From("reports r").
+		LeftJoin("users ru", dbx.NewExp("ru.id = r.reporter_id")).
+		LeftJoin("users tu", dbx.NewExp("tu.id = r.target_user_id")).
+		LeftJoin("users mu", dbx.NewExp("mu.id = r.resolved_by"))
+}
+
+// actions returns a query of moderation actions with the handles of the users involved.
+func (s service) actions(ctx context.Context) *dbx.SelectQuery {
+	return s.db.With(ctx).Select("ma.id", "ma.report_id", "ma.type", "ma.target_type", "ma.target_id", "COALESCE(tu.handle, '') AS target_user_handle",
+		"COALESCE(mu.handle, '') AS moderator_handle", "ma.message", "ma.note", "ma.expires_at", "ma.created_at", "ma.reverted_at",
+		"COALESCE(ru.handle, '') AS reverted_by", "ma.revert_note").
+		From("moderation_actions ma").
+		LeftJoin("users tu", dbx.NewExp("tu.id = ma.target_user_id")).
+		LeftJoin("users mu", dbx.NewExp("mu.id = ma.moderator_id")).
+		LeftJoin("users ru", dbx.NewExp("ru.id = ma.reverted_by"))
+}
+
+func (s service) Queue(ctx context.Context, filter QueueFilter, pages *pagination.Pages) ([]entity.Report, error) {
+	if filter.Status == "" {
+		filter.Status = entity.ReportOpen
+	}
+	where := dbx.HashExp{"r.status": filter.Status}
+	if filter.TargetType != "" {
+		where["r.target_type"] = filter.TargetType
+	}
+	if filter.TargetID != "" {
+		where["r.target_id"] = filter.TargetID
+	}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("reports r").Where(where).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	order := []string{"r.created_at", "r.id"}
+	if filter.Status != entity.ReportOpen {
+		order = []string{"r.resolved_at DESC", "r.id"}
+	}
+	reports := []entity.Report{}
+	err := s.reports(ctx).
+		Where(where).
+		OrderBy(order...).
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&reports)
+	return reports, err
+}
+
+func (s service) Get(ctx context.Context, id string) (entity.Report, error) {
+	var report entity.Report
+	if err := s.reports(ctx).Where(dbx.HashExp{"r.id": id}).One(&report); err != nil {
+		return entity.Report{}, err
+	}
+	report.Actions = []entity.ModerationAction{}
+	err := s.actions(ctx).Where(dbx.HashExp{"ma.report_id": id}).OrderBy("ma.created_at", "ma.id").All(&report.Actions)
+	return report, err
+}
+
+// report holds the columns of a report the moderation decisions work with.
+type report struct {
+	ID           string
+	ReporterID   int
+	TargetType   entity.ReportTarget
+	TargetID     string
+	TargetUserID int
+	Status       entity.ReportStatus
+}
+
+// lockReport returns a report, locked for the rest of the transaction.
+func (s service) lockReport(ctx context.Context, id string) (report, error) {
+	var r report
+	err := s.db.With(ctx).NewQuery("SELECT id, reporter_id, target_type, target_id, target_user_id, status FROM reports WHERE id={:id} FOR UPDATE").
+		Bind(dbx.Params{"id": id}).
+		One(&r)
+	return r, err
+}
+
+// mayAct returns a Forbidden error unless the current user may take action against the given user.
+// Nobody acts on reports about themselves, and only admins act against other moderators and admins.
+func (s service) mayAct(ctx context.Context, target int) error {
+	moderator := auth.CurrentUser(ctx).GetID()
+	if target == moderator {
+		return errors.Forbidden("you cannot act on reports about yourself")
+	}
+	var staff, admin bool
+	err := s.db.With(ctx).NewQuery("SELECT COALESCE((SELECT is_admin OR is_moderator FROM users WHERE id={:target}), FALSE), "+
+		"COALESCE((SELECT is_admin FROM users WHERE id={:moderator}), FALSE)").
+		Bind(dbx.Params{"target": target, "moderator": moderator}).
+		Row(&staff, &admin)
+	if err != nil {
+		return err
+	}
+	if staff && !admin {
+		return errors.Forbidden("only admins can act against moderators and admins")
+	}
+	return nil
+}
+
+// action holds the columns of a moderation action its effects depend on.
+type action struct {
+	ID           string
+	Type         entity.ModerationActionType
+	TargetType   entity.ReportTarget
+	TargetID     string
+	TargetUserID int
+	ExpiresAt    *time.Time
+	RevertedAt   *time.Time
+}
+
+// log writes an entry to the moderation log.
+func (s service) log(ctx context.Context, event entity.ModerationEvent, reportID, actionID *string, note string) error {
+	_, err := s.db.With(ctx).Insert("moderation_log", dbx.Params{
+		"moderator_id": auth.CurrentUser(ctx).GetID(),
+		"event":        event,
+		"report_id":    reportID,
+		"action_id":    actionID,
+		"note":         note,
+		"created_at":   time.Now().UTC().Truncate(time.Second),
+	}).Execute()
+	return err
+}
+
+// hiddenTables maps the report targets to the tables holding their hidden_at column.
+var hiddenTables = map[entity.ReportTarget]string{
+	entity.ReportAlbum:   "albums",
+	entity.ReportMedia:   "media",
+	entity.ReportComment: "comments",
+	entity.ReportProfile: "users",
+}
+
+// targetOf returns the condition selecting the actions on the same target as a, with the ID of the row
+// holding the target. Profiles are identified by their user, as their handles change.
+func targetOf(a action) (dbx.HashExp, interface{}) {
+	if a.TargetType == entity.ReportProfile {
+		return dbx.HashExp{"target_type": a.TargetType, "target_user_id": a.TargetUserID}, a.TargetUserID
+	}
+	return dbx.HashExp{"target_type": a.TargetType, "target_id": a.TargetID}, a.TargetID
+}
+
+// sync updates the hidden or suspension columns affected by an action to the actions in effect.
+func (s service) sync(ctx context.Context, a action) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	switch a.Type {
+	case entity.ActionHide:
+		where, id := targetOf(a)
+		var hiddenAt *time.Time
+		err := s.db.With(ctx).Select("MIN(created_at)").From("moderation_actions").
+			Where(dbx.And(where, dbx.HashExp{"type": entity.ActionHide, "reverted_at": nil})).
+			Row(&hiddenAt)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.With(ctx).Update(hiddenTables[a.TargetType], dbx.Params{"hidden_at": hiddenAt}, dbx.HashExp{"id": id}).Execute()
+		return err
+	case entity.ActionSuspend:
+		// the user stays suspended until the last suspension in effect ends, or indefinitely if any of
+		// them is indefinite
+		var suspension struct {
+			SuspendedAt    *time.Time
+			SuspendedUntil *time.Time
+		}
+		err := s.db.With(ctx).NewQuery("SELECT MIN(created_at) AS suspended_at, IF(COUNT(*) > COUNT(expires_at), NULL, MAX(expires_at)) AS suspended_until " +
+			"FROM moderation_actions WHERE target_user_id={:user} AND type={:type} AND reverted_at IS NULL AND (expires_at IS NULL OR expires_at > {:now})").
+			Bind(dbx.Params{"user": a.TargetUserID, "type": entity.ActionSuspend, "now": now}).
+			One(&suspension)
+		if err != nil {
+			return err
+		}
+		_, err = s.db.With(ctx).Update("users", dbx.Params{
+			"suspended_at":    suspension.SuspendedAt,
+			"suspended_until": suspension.SuspendedUntil,
+		}, dbx.HashExp{"id": a.TargetUserID}).Execute()
+		return err
+	}
+	return nil
+}
+
+// targetNames names the report targets in the messages to users.
+var targetNames = map[entity.ReportTarget]string{
+	entity.ReportAlbum:   "album",
+	entity.ReportMedia:   "media item",
+	entity.ReportComment: "comment",
+	entity.ReportProfile: "profile",
+}
+
+// actionMessage returns the notification of the user affected by an action.
+func actionMessage(a action, message string) string {
+	var text string
+	switch a.Type {
+	case entity.ActionHide:
+		text = "Your " + targetNames[a.TargetType] + " was hidden by a moderator."
+	case entity.ActionWarn:
+		text = "You received a warning from a moderator."
+	case entity.ActionSuspend:
+		text = "Your account was suspended indefinitely."
+		if a.ExpiresAt != nil {
+			text = "Your account was suspended until " + a.ExpiresAt.Format(time.RFC3339) + "."
+		}
+	}
+	if message != "" {
+		text += " " + message
+	}
+	return text
+}
+
+// revertMessage returns the notification of the user affected by a reverted action.
+func revertMessage(a action) string {
+	switch a.Type {
+	case entity.ActionHide:
+		return "Your " + targetNames[a.TargetType] + " is visible again after a review by a moderator."
+	case entity.ActionSuspend:
+		return "A suspension of your account was lifted by a moderator."
+	}
+	return "A warning you received was withdrawn by a moderator."
+}
+
+// moderationError passes on the errors meant for the client and logs the others.
+func (s service) moderationError(ctx context.Context, err error, msg string) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case errors.ErrorResponse, validation.Errors:
+		return err
+	}
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return errors.NotFound("")
+	}
+	s.logger.WithContext(ctx).WithError(err).Error(msg)
+	return errors.InternalServerError("")
+}
+
+func (s service) Act(ctx context.Context, reportID string, req ActionRequest) (entity.ModerationAction, error) {
+	if err := req.Validate(); err != nil {
+		return entity.ModerationAction{}, err
+	}
+	id := entity.GenerateID()
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		r, err := s.lockReport(ctx, reportID)
+		if err != nil {
+			return err
+		}
+		if err := s.mayAct(ctx, r.TargetUserID); err != nil {
+			return err
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		a := action{ID: id, Type: req.Type, TargetType: r.TargetType, TargetID: r.TargetID, TargetUserID: r.TargetUserID}
+		if req.Type == entity.ActionHide {
+			where, _ := targetOf(a)
+			var hidden int
+			err := s.db.With(ctx).Select("COUNT(*)").From("moderation_actions").
+				Where(dbx.And(where, dbx.HashExp{"type": entity.ActionHide, "reverted_at": nil})).
+				Row(&hidden)
+			if err != nil {
+				return err
+			}
+			if hidden > 0 {
+				return errors.BadRequest("the content is already hidden")
+			}
+		}
+		if req.DurationHours != nil {
+			expires := now.Add(time.Duration(*req.DurationHours) * time.Hour)
+			a.ExpiresAt = &expires
+		}
+		_, err = s.db.With(ctx).Insert("moderation_actions", dbx.Params{
+			"id":             a.ID,
+			"report_id":      r.ID,
+			"moderator_id":   auth.CurrentUser(ctx).GetID(),
+			"type":           a.Type,
+			"target_type":    a.TargetType,
+			"target_id":      a.TargetID,
+			"target_user_id": a.TargetUserID,
+			"message":        req.Message,
+			"note":           req.Note,
+			"expires_at":     a.ExpiresAt,
+			"created_at":     now,
+			"revert_note":    "",
+		}).Execute()
+		if err != nil {
+			return err
+		}
+		if err := s.sync(ctx, a); err != nil {
+			return err
+		}
+		if err := s.log(ctx, entity.EventActionTaken, &r.ID, &a.ID, req.Note); err != nil {
+			return err
+		}
+		return s.notify.Notify(ctx, entity.Notification{Type: entity.NotificationModeration, Detail: actionMessage(a, req.Message)}, a.TargetUserID)
+	})
+	if err := s.moderationError(ctx, err, "Moderation action failed"); err != nil {
+		return entity.ModerationAction{}, err
+	}
+	return s.action(ctx, id)
+}
+
+// action returns a moderation action.
+func (s service) action(ctx context.Context, id string) (entity.ModerationAction, error) {
+	var a entity.ModerationAction
+	err := s.actions(ctx).Where(dbx.HashExp{"ma.id": id}).One(&a)
+	return a, err
+}
+
+// resolutionMessages are the notifications of reporters about the decisions on their reports.
+var resolutionMessages = map[entity.ReportStatus]string{
+	entity.ReportResolved:  "A moderator reviewed the %s you reported and took action. Thank you for your report.",
+	entity.ReportDismissed: "A moderator reviewed the %s you reported and found that it does not break the rules.",
+}
+
+func (s service) Resolve(ctx context.Context, reportID string, req ResolveRequest) (entity.Report, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Report{}, err
+	}
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		r, err := s.lockReport(ctx, reportID)
+		if err != nil {
+			return err
+		}
+		if r.Status != entity.ReportOpen {
+			return errors.BadRequest("the report is already closed")
+		}
+		_, err = s.db.With(ctx).Update("reports", dbx.Params{
+			"status":          req.Status,
+			"resolution_note": req.Note,
+			"resolved_by":     auth.CurrentUser(ctx).GetID(),
+			"resolved_at":     time.Now().UTC().Truncate(time.Second),
+		}, dbx.HashExp{"id": r.ID}).Execute()
+		if err != nil {
+			return err
+		}
+		event := entity.EventReportResolved
+		if req.Status == entity.ReportDismissed {
+			event = entity.EventReportDismissed
+		}
+		if err := s.log(ctx, event, &r.ID, nil, req.Note); err != nil {
+			return err
+		}
+		detail := fmt.Sprintf(resolutionMessages[req.Status], targetNames[r.TargetType])
+		return s.notify.Notify(ctx, entity.Notification{Type: entity.NotificationModeration, Detail: detail}, r.ReporterID)
+	})
+	if err := s.moderationError(ctx, err, "Report resolution failed"); err != nil {
+		return entity.Report{}, err
+	}
+	return s.Get(ctx, reportID)
+}
+
+func (s service) Reopen(ctx context.Context, reportID string, req NoteRequest) (entity.Report, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Report{}, err
+	}
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		r, err := s.lockReport(ctx, reportID)
+		if err != nil {
+			return err
+		}
+		if r.Status == entity.ReportOpen {
+			return errors.BadRequest("the report is already open")
+		}
+		_, err = s.db.With(ctx).Update("reports", dbx.Params{
+			"status":          entity.ReportOpen,
+			"resolution_note": "",
+			"resolved_by":     nil,
+			"resolved_at":     nil,
+		}, dbx.HashExp{"id": r.ID}).Execute()
+		if err != nil {
+			return err
+		}
+		return s.log(ctx, entity.EventReportReopened, &r.ID, nil, req.Note)
+	})
+	if err := s.moderationError(ctx, err, "Report reopening failed"); err != nil {
+		return entity.Report{}, err
+	}
+	return s.Get(ctx, reportID)
+}
+
+func (s service) Actions(ctx context.Context, handle string, pages *pagination.Pages) ([]entity.ModerationAction, error) {
+	where := dbx.HashExp{}
+	if handle != "" {
+		var user int
+		err := s.db.With(ctx).NewQuery("SELECT id FROM users WHERE handle_normalized={:handle}").
+			Bind(dbx.Params{"handle": entity.NormalizeHandle(handle)}).
+			Row(&user)
+		if stderrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.NotFound("user not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		where["ma.target_user_id"] = user
+	}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("moderation_actions ma").Where(where).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	actions := []entity.ModerationAction{}
+	err := s.actions(ctx).
+		Where(where).
+		OrderBy("ma.created_at DESC", "ma.id").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&actions)
+	return actions, err
+}
+
+func (s service) Revert(ctx context.Context, actionID string, req NoteRequest) (entity.ModerationAction, error) {
+	if err := req.Validate(); err != nil {
+		return entity.ModerationAction{}, err
+	}
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		var a action
+		err := s.db.With(ctx).NewQuery("SELECT id, type, target_type, target_id, target_user_id, expires_at, reverted_at " +
+			"FROM moderation_actions WHERE id={:id} FOR UPDATE").
+			Bind(dbx.Params{"id": actionID}).
+			One(&a)
+		if err != nil {
+			return err
+		}
+		if a.RevertedAt != nil {
+			return errors.BadRequest("the action is already reverted")
+		}
+		_, err = s.db.With(ctx).Update("moderation_actions", dbx.Params{
+			"reverted_at": time.Now().UTC().Truncate(time.Second),
+			"reverted_by": auth.CurrentUser(ctx).GetID(),
+			"revert_note": req.Note,
+		}, dbx.HashExp{"id": a.ID}).Execute()
+		if err != nil {
+			return err
+		}
+		if err := s.sync(ctx, a); err != nil {
+			return err
+		}
+		if err := s.log(ctx, entity.EventActionReverted, nil, &a.ID, req.Note); err != nil {
+			return err
+		}
+		return s.notify.Notify(ctx, entity.Notification{Type: entity.NotificationModeration, Detail: revertMessage(a)}, a.TargetUserID)
+	})
+	if err := s.moderationError(ctx, err, "Moderation action revert failed"); err != nil {
+		return entity.ModerationAction{}, err
+	}
+	return s.action(ctx, actionID)
+}
+
+func (s service) Log(ctx context.Context, pages *pagination.Pages) ([]entity.ModerationLogEntry, error) {
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("moderation_log").Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	entries := []entity.ModerationLogEntry{}
+	err := s.db.With(ctx).Select("l.id", "COALESCE(u.handle, '') AS moderator_handle", "l.event", "l.report_id", "l.action_id", "l.note", "l.created_at").
+		From("moderation_log l").
+		LeftJoin("users u", dbx.NewExp("u.id = l.moderator_id")).
+		OrderBy("l.id DESC").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&entries)
+	return entries, err
+}
@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the notification handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/notifications", res.list)
+	r.Get("/notifications/unread-count", res.unreadCount)
+	r.Post("/notifications/read", res.markAllRead)
+	r.Post("/notifications/<id>/read", res.markRead)
+	r.Get("/notifications/preferences", res.preferences)
+	r.Put("/notifications/preferences", res.updatePreferences)
+}
+
+// list returns a page of notifications. With unread=true only the unread notifications are listed.
+func (r resource) list(c *routing.Context) error {
+	unread := false
+	if v := c.Query("unread"); v != "" {
+		var err error
+		if unread, err = strconv.ParseBool(v); err != nil {
+			return errors.BadRequest("invalid unread value")
+		}
+	}
+	pages := pagination.NewFromRequest(c.Request, -1)
+	notifications, err := r.service.List(c.Request.Context(), unread, pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = notifications
+	return c.Write(pages)
+}
+
+func (r resource) unreadCount(c *routing.Context) error {
+	count, err := r.service.UnreadCount(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(struct {
+		Count int `json:"count"`
+	}{count})
+}
+
+func (r resource) markRead(c *routing.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return errors.NotFound("")
+	}
+	if err := r.service.MarkRead(c.Request.Context(), id); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) markAllRead(c *routing.Context) error {
+	if err := r.service.MarkAllRead(c.Request.Context()); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) preferences(c *routing.Context) error {
+	prefs, err := r.service.Preferences(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(prefs)
+}
+
+func (r resource) updatePreferences(c *routing.Context) error {
+	var req map[entity.NotificationType]bool
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	prefs, err := r.service.UpdatePreferences(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(prefs)
+}
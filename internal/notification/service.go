@@ -0,0 +1,318 @@
+// Package notification implements the notifications of users about events that concern them, such as
+// invitations, comments, mentions, reactions and security alerts.
+//
+// Other features publish notifications through a Publisher. Notifications of the same event that the
+// recipient has not read yet are collapsed into one, so repeated reactions of a user to a media item
+// notify once.
+package notification
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/push"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// Publisher delivers notifications to users.
+type Publisher interface {
+	// Notify notifies the recipients of an event caused by the current user, if any. The current user,
+	// recipients who turned off the notification type and, unless the notification is an invitation,
+	// recipients who cannot view the album of the notification are skipped. It uses the transaction found in
+	// the context, so it can be called together with the change the notification is about.
+	Notify(ctx context.Context, n entity.Notification, recipients ...int) error
+}
+
+// Service encapsulates the notification logic.
+type Service interface {
+	Publisher
+	// List returns a page of the notifications of the current user, newest first, optionally only the unread ones.
+	List(ctx context.Context, unread bool, pages *pagination.Pages) ([]entity.Notification, error)
+	// UnreadCount returns the number of unread notifications of the current user.
+	UnreadCount(ctx context.Context) (int, error)
+	// MarkRead marks a notification of the current user as read.
+	MarkRead(ctx context.Context, id int64) error
+	// MarkAllRead marks all notifications of the current user as read.
+	MarkAllRead(ctx context.Context) error
+	// Preferences returns whether each notification type is enabled for the current user.
+	Preferences(ctx context.Context) (map[entity.NotificationType]bool, error)
+	// UpdatePreferences turns notification types on or off for the current user. Types that are not
+	// included keep their setting.
+	UpdatePreferences(ctx context.Context, prefs map[entity.NotificationType]bool) (map[entity.NotificationType]bool, error)
+	// Cleanup deletes the notifications older than the retention period and returns their number.
+	Cleanup(ctx context.Context) (int, error)
+}
+
+type service struct {
+	db        *dbcontext.DB
+	retention time.Duration
+	events    events.Publisher
+	push      push.Publisher
+	logger    *logrus.Logger
+}
+
+// NewService creates a new notification service that keeps notifications for the given retention period
+// and sends new notifications to the event streams of their recipients and, if important, to their
+// browsers and devices.
+func NewService(db *dbcontext.DB, retention time.Duration, events events.Publisher, push push.Publisher, logger *logrus.Logger) Service {
+	return service{db, retention, events, push, logger}
+}
+
+func (s service) Notify(ctx context.Context, n entity.Notification, recipients ...int) error {
+	if user := auth.CurrentUser(ctx); user != nil && !n.Type.Anonymous() {
+		id := user.GetID()
+		n.ActorID = &id
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	seen := map[int]bool{}
+	for _, recipient := range recipients {
+		if seen[recipient] || (n.ActorID != nil && *n.ActorID == recipient) {
+			continue
+		}
+		seen[recipient] = true
+		if n.ActorID != nil {
+			hidden, err := s.hidden(ctx, recipient, *n.ActorID)
+			if err != nil {
+				return err
+			}
+			if hidden {
+				continue
+			}
+		}
+		if n.AlbumID != nil && n.Type != entity.NotificationInvitation {
+			viewer, err := s.viewer(ctx, recipient, *n.AlbumID)
+			if err != nil {
+				return err
+			}
+			if !viewer {
+				continue
+			}
+		}
+		if n.Type.Optional() {
+			enabled, err := s.enabled(ctx, recipient, n.Type)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				continue
+			}
+		}
+		params := dbx.Params{
+			"user_id":    recipient,
+			"type":       n.Type,
+			"actor_id":   n.ActorID,
+			"album_id":   n.AlbumID,
+			"media_id":   n.MediaID,
+			"comment_id": n.CommentID,
+			"detail":     n.Detail,
+			"created_at": now,
+		}
+		// an unread notification of the same event is brought up to date instead of adding another one,
+		// except for moderation decisions, which are separate events without an album or actor
+		var id int64
+		err := sql.ErrNoRows
+		if n.Type != entity.NotificationModeration {
+			err = s.db.With(ctx).NewQuery("SELECT id FROM notifications WHERE user_id = {:user_id} AND type = {:type} AND read_at IS NULL " +
+				"AND actor_id <=> {:actor_id} AND album_id <=> {:album_id} AND media_id <=> {:media_id} AND comment_id <=> {:comment_id} LIMIT 1 FOR UPDATE").
+				Bind(params).
+				Row(&id)
+		}
+		switch {
+		case err == nil:
+			_, err = s.db.With(ctx).Update("notifications", dbx.Params{"detail": n.Detail, "created_at": now}, dbx.HashExp{"id": id}).Execute()
+		case stderrors.Is(err, sql.ErrNoRows):
+			var res sql.Result
+			if res, err = s.db.With(ctx).Insert("notifications", params).Execute(); err == nil {
+				id, err = res.LastInsertId()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		delivered := n
+		delivered.ID, delivered.UserID, delivered.CreatedAt = id, recipient, now
+		if err := s.events.Publish(ctx, events.TypeNotification, delivered, recipient); err != nil {
+			return err
+		}
+		if err := s.push.Push(ctx, delivered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hidden reports whether the recipient blocked or muted the actor, or the actor blocked the recipient.
+func (s service) hidden(ctx context.Context, recipient, actor int) (bool, error) {
+	var hidden bool
+	err := s.db.With(ctx).NewQuery("SELECT " + block.Hidden("{:recipient}", "{:actor}")).
+		Bind(dbx.Params{"recipient": recipient, "actor": actor}).
+		Row(&hidden)
+	return hidden, err
+}
+
+// viewer reports whether a user can view an album, as a member or because the album is public.
+func (s service) viewer(ctx context.Context, userID int, albumID string) (bool, error) {
+	var n int
+	err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM albums a " + block.MemberJoin("{:user}") +
+		"WHERE a.id = {:album} AND " + block.AlbumVisible("{:user}")).
+		Bind(dbx.Params{"user": userID, "album": albumID}).
+		Row(&n)
+	return n > 0, err
+}
+
+// visible is the condition of the notifications whose actor is not hidden from their recipient, which
+// keeps notifications received before a block or mute out of sight.
+var visible = dbx.NewExp("n.actor_id IS NULL OR NOT " + block.Hidden("n.user_id", "n.actor_id"))
+
+// enabled reports whether a user has the notification type turned on.
+func (s service) enabled(ctx context.Context, userID int, t entity.NotificationType) (bool, error) {
+	var disabled int
+	err := s.db.With(ctx).Select("COUNT(*)").
+		From("notification_preferences").
+		Where(dbx.HashExp{"user_id": userID, "type": t, "enabled": false}).
+		Row(&disabled)
+	return disabled == 0, err
+}
+
+func (s service) List(ctx context.Context, unread bool, pages *pagination.Pages) ([]entity.Notification, error) {
+	where := dbx.HashExp{"n.user_id": auth.CurrentUser(ctx).GetID()}
+	if unread {
+		where["n.read_at"] = nil
+	}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("notifications n").Where(dbx.And(where, visible)).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	notifications := []entity.Notification{}
+	err := s.db.With(ctx).Select("n.*", "COALESCE(u.first_name, '') AS actor_first_name", "COALESCE(u.last_name, '') AS actor_last_name").
+		From("notifications n").
+		LeftJoin("users u", dbx.NewExp("u.id = n.actor_id")).
+		Where(dbx.And(where, visible)).
+		OrderBy("n.id DESC").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&notifications)
+	return notifications, err
+}
+
+func (s service) UnreadCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.With(ctx).Select("COUNT(*)").
+		From("notifications n").
+		Where(dbx.And(dbx.HashExp{"n.user_id": auth.CurrentUser(ctx).GetID(), "n.read_at": nil}, visible)).
+		Row(&count)
+	return count, err
+}
+
+func (s service) MarkRead(ctx context.Context, id int64) error {
+	user := auth.CurrentUser(ctx).GetID()
+	var n int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("notifications").Where(dbx.HashExp{"id": id, "user_id": user}).Row(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.NotFound("")
+	}
+	_, err := s.db.With(ctx).Update("notifications", dbx.Params{"read_at": time.Now().UTC().Truncate(time.Second)},
+		dbx.HashExp{"id": id, "user_id": user, "read_at": nil}).Execute()
+	return err
+}
+
+func (s service) MarkAllRead(ctx context.Context) error {
+	_, err := s.db.With(ctx).Update("notifications", dbx.Params{"read_at": time.Now().UTC().Truncate(time.Second)},
+		dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID(), "read_at": nil}).Execute()
+	return err
+}
+
+func (s service) Preferences(ctx context.Context) (map[entity.NotificationType]bool, error) {
+	var rows []struct {
+		Type    entity.NotificationType
+		Enabled bool
+	}
+	if err := s.db.With(ctx).Select("type", "enabled").
+		From("notification_preferences").
+		Where(dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID()}).
+		All(&rows); err != nil {
+		return nil, err
+	}
+	prefs := map[entity.NotificationType]bool{}
+	for _, t := range entity.NotificationTypes {
+		prefs[t] = true
+	}
+	for _, row := range rows {
+		if row.Type.Optional() {
+			prefs[row.Type] = row.Enabled
+		}
+	}
+	return prefs, nil
+}
+
+func (s service) UpdatePreferences(ctx context.Context, prefs map[entity.NotificationType]bool) (map[entity.NotificationType]bool, error) {
+	for t, enabled := range prefs {
+		if !t.Valid() {
+			return nil, errors.BadRequest("unknown notification type " + string(t))
+		}
+		if !t.Optional() && !enabled {
+			return nil, errors.BadRequest("security notifications cannot be turned off")
+		}
+	}
+	user := auth.CurrentUser(ctx).GetID()
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		for t, enabled := range prefs {
+			if _, err := s.db.With(ctx).NewQuery("INSERT INTO notification_preferences (user_id, type, enabled) VALUES ({:user}, {:type}, {:enabled}) " +
+				"ON DUPLICATE KEY UPDATE enabled = VALUES(enabled)").
+				Bind(dbx.Params{"user": user, "type": t, "enabled": enabled}).
+				Execute(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Notification preferences update failed")
+		return nil, errors.InternalServerError("")
+	}
+	return s.Preferences(ctx)
+}
+
+func (s service) Cleanup(ctx context.Context) (int, error) {
+	res, err := s.db.With(ctx).NewQuery("DELETE FROM notifications WHERE created_at < {:before}").
+		Bind(dbx.Params{"before": time.Now().UTC().Add(-s.retention)}).
+		Execute()
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// RunCleanup deletes expired notifications in the given interval until the context is canceled.
+func RunCleanup(ctx context.Context, service Service, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := service.Cleanup(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Notification cleanup failed")
+		} else if n > 0 {
+			logger.WithField("count", n).Info("Deleted expired notifications")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package profile
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the profile handlers. Profiles are public, but signed in users are identified
+// by the optional authentication handler so that blocks apply. Managing the own handle requires an
+// authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, optionalAuthHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Get("/users/<handle>", optionalAuthHandler, res.get)
+
+	r.Use(authHandler)
+	r.Get("/me/handle", res.handle)
+	r.Put("/me/handle", res.setHandle)
+}
+
+func (r resource) get(c *routing.Context) error {
+	handle := c.Param("handle")
+	profile, err := r.service.Get(c.Request.Context(), handle)
+	if err != nil {
+		return err
+	}
+	// an old handle points to the current one until it is released, so the redirect must not be cached
+	// for good: the handle may be taken by another user afterwards
+	if entity.NormalizeHandle(profile.Handle) != entity.NormalizeHandle(handle) {
+		http.Redirect(c.Response, c.Request, url.PathEscape(profile.Handle), http.StatusFound)
+		c.Abort()
+		return nil
+	}
+	return c.Write(profile)
+}
+
+func (r resource) handle(c *routing.Context) error {
+	handle, err := r.service.Handle(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(handle)
+}
+
+func (r resource) setHandle(c *routing.Context) error {
+	var req SetHandleRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	handle, err := r.service.SetHandle(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(handle)
+}
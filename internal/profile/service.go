@@ -0,0 +1,219 @@
+package profile
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RedirectPeriod is how long an old handle keeps pointing to its user after a change.
+	RedirectPeriod = 30 * 24 * time.Hour
+	// ChangeCooldown is the minimum time between two handle changes, which keeps users from holding on to
+	// many handles through their redirects.
+	ChangeCooldown = 7 * 24 * time.Hour
+)
+
+// Service encapsulates the handle and public profile logic.
+type Service interface {
+	// Get returns the public profile of the user with the given handle, compared regardless of case. An
+	// old handle within its redirect period returns the profile under the current handle. Users who were
+	// blocked by the user get a NotFound error, as if the profile did not exist, and so does everyone for
+	// profiles hidden by moderators.
+	Get(ctx context.Context, handle string) (entity.Profile, error)
+	// Handle returns the handle of the current user.
+	Handle(ctx context.Context) (entity.UserHandle, error)
+	// SetHandle changes the handle of the current user. The old handle redirects to the new one for the
+	// RedirectPeriod.
+	SetHandle(ctx context.Context, req SetHandleRequest) (entity.UserHandle, error)
+}
+
+// SetHandleRequest represents a handle change request.
+type SetHandleRequest struct {
+	Handle string `json:"handle"`
+}
+
+// Validate validates the SetHandleRequest fields.
+func (m SetHandleRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Handle, validation.Required, validation.Length(entity.MinHandleLength, entity.MaxHandleLength),
+			validation.By(func(interface{}) error {
+				if !entity.ValidHandle(m.Handle) {
+					return validation.NewError("validation_handle_invalid", "must consist of letters, digits and underscores and contain a letter")
+				}
+				if entity.ReservedHandle(m.Handle) {
+					return validation.NewError("validation_handle_reserved", "is reserved")
+				}
+				return nil
+			})),
+	)
+}
+
+// errHandleTaken is returned for handles that belong to another user, currently or through a redirect.
+var errHandleTaken = validation.Errors{"handle": validation.NewError("validation_handle_taken", "is already taken")}
+
+type service struct {
+	db     *dbcontext.DB
+	logger *logrus.Logger
+}
+
+// NewService creates a new profile service.
+func NewService(db *dbcontext.DB, logger *logrus.Logger) Service {
+	return service{db, logger}
+}
+
+// user holds the columns of a user that make up a profile.
+type user struct {
+	ID              int
+	Handle          string
+	FirstName       string
+	LastName        string
+	ProfileIMG      string
+	HandleChangedAt *time.Time
+	HiddenAt        *time.Time
+}
+
+const selectUser = "SELECT u.id, u.handle, u.first_name, u.last_name, u.profile_img, u.handle_changed_at, u.hidden_at FROM users u "
+
+func (s service) Get(ctx context.Context, handle string) (entity.Profile, error) {
+	if !entity.ValidHandle(handle) {
+		return entity.Profile{}, errors.NotFound("")
+	}
+	normalized := entity.NormalizeHandle(handle)
+	var u user
+	err := s.db.With(ctx).NewQuery(selectUser + "WHERE u.handle_normalized={:handle}").
+		Bind(dbx.Params{"handle": normalized}).
+		One(&u)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		err = s.db.With(ctx).NewQuery(selectUser + "JOIN handle_redirects r ON r.user_id = u.id WHERE r.handle={:handle} AND r.expires_at > {:now}").
+			Bind(dbx.Params{"handle": normalized, "now": time.Now().UTC()}).
+			One(&u)
+	}
+	if stderrors.Is(err, sql.ErrNoRows) || (err == nil && u.HiddenAt != nil) {
+		return entity.Profile{}, errors.NotFound("")
+	}
+	if err != nil {
+		return entity.Profile{}, err
+	}
+	if viewer := auth.CurrentUser(ctx); viewer != nil {
+		var blocked bool
+		if err := s.db.With(ctx).NewQuery("SELECT " + block.BlockedBy("{:user}", "{:viewer}")).
+			Bind(dbx.Params{"user": u.ID, "viewer": viewer.GetID()}).
+			Row(&blocked); err != nil {
+			return entity.Profile{}, err
+		}
+		if blocked {
+			return entity.Profile{}, errors.NotFound("")
+		}
+	}
+	profile := entity.Profile{
+		Handle:     u.Handle,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		ProfileIMG: u.ProfileIMG,
+		Albums:     []entity.ProfileAlbum{},
+	}
+	err = s.db.With(ctx).NewQuery("SELECT id, name, description, created_at, updated_at FROM albums " +
+		"WHERE owner_id={:owner} AND `public` AND deleted_at IS NULL AND hidden_at IS NULL ORDER BY created_at DESC").
+		Bind(dbx.Params{"owner": u.ID}).
+		All(&profile.Albums)
+	return profile, err
+}
+
+func (s service) Handle(ctx context.Context) (entity.UserHandle, error) {
+	var u user
+	err := s.db.With(ctx).NewQuery(selectUser + "WHERE u.id={:id}").
+		Bind(dbx.Params{"id": auth.CurrentUser(ctx).GetID()}).
+		One(&u)
+	if err != nil {
+		return entity.UserHandle{}, err
+	}
+	return userHandle(u), nil
+}
+
+// userHandle returns the handle of a user with the time it can be changed again.
+func userHandle(u user) entity.UserHandle {
+	h := entity.UserHandle{Handle: u.Handle, ChangedAt: u.HandleChangedAt}
+	if u.HandleChangedAt != nil {
+		next := u.HandleChangedAt.Add(ChangeCooldown)
+		h.NextChangeAt = &next
+	}
+	return h
+}
+
+func (s service) SetHandle(ctx context.Context, req SetHandleRequest) (entity.UserHandle, error) {
+	if err := req.Validate(); err != nil {
+		return entity.UserHandle{}, err
+	}
+	id := auth.CurrentUser(ctx).GetID()
+	normalized := entity.NormalizeHandle(req.Handle)
+	now := time.Now().UTC().Truncate(time.Second)
+	var u user
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		if err := s.db.With(ctx).NewQuery(selectUser + "WHERE u.id={:id} FOR UPDATE").Bind(dbx.Params{"id": id}).One(&u); err != nil {
+			return err
+		}
+		// changing only the case keeps the handle, so it needs neither a redirect nor a cooldown
+		if entity.NormalizeHandle(u.Handle) == normalized {
+			u.Handle = req.Handle
+			_, err := s.db.With(ctx).Update("users", dbx.Params{"handle": req.Handle}, dbx.HashExp{"id": id}).Execute()
+			return err
+		}
+		if u.HandleChangedAt != nil && now.Before(u.HandleChangedAt.Add(ChangeCooldown)) {
+			return errors.BadRequest("the handle was changed recently and cannot be changed again before " + u.HandleChangedAt.Add(ChangeCooldown).Format(time.RFC3339))
+		}
+		var taken int
+		err := s.db.With(ctx).NewQuery("SELECT (SELECT COUNT(*) FROM users WHERE handle_normalized={:handle}) + " +
+			"(SELECT COUNT(*) FROM handle_redirects WHERE handle={:handle} AND user_id<>{:id} AND expires_at > {:now})").
+			Bind(dbx.Params{"handle": normalized, "id": id, "now": now}).
+			Row(&taken)
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errHandleTaken
+		}
+		// the handle may be an old one of the user, and expired redirects no longer hold on to their handles
+		if _, err := s.db.With(ctx).NewQuery("DELETE FROM handle_redirects WHERE handle={:handle} OR expires_at <= {:now}").
+			Bind(dbx.Params{"handle": normalized, "now": now}).Execute(); err != nil {
+			return err
+		}
+		_, err = s.db.With(ctx).NewQuery("INSERT INTO handle_redirects (handle, user_id, expires_at) VALUES ({:handle}, {:id}, {:expires})").
+			Bind(dbx.Params{"handle": entity.NormalizeHandle(u.Handle), "id": id, "expires": now.Add(RedirectPeriod)}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		u.Handle, u.HandleChangedAt = req.Handle, &now
+		_, err = s.db.With(ctx).Update("users", dbx.Params{
+			"handle":            req.Handle,
+			"handle_normalized": normalized,
+			"handle_changed_at": now,
+		}, dbx.HashExp{"id": id}).Execute()
+		return err
+	})
+	var mysqlErr *mysql.MySQLError
+	if stderrors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		// another user claimed the handle concurrently
+		return entity.UserHandle{}, errHandleTaken
+	}
+	switch err.(type) {
+	case nil:
+		return userHandle(u), nil
+	case errors.ErrorResponse, validation.Errors:
+		return entity.UserHandle{}, err
+	}
+	s.logger.WithContext(ctx).WithError(err).Error("Handle change failed")
+	return entity.UserHandle{}, errors.InternalServerError("")
+}
@@ -0,0 +1,63 @@
+package push
+
+import (
+	"net/http"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the push subscription handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/push/vapid-key", res.vapidKey)
+	r.Get("/push/subscriptions", res.list)
+	r.Post("/push/subscriptions", res.subscribe)
+	r.Delete("/push/subscriptions/<id>", res.unsubscribe)
+}
+
+func (r resource) vapidKey(c *routing.Context) error {
+	key, err := r.service.VAPIDPublicKey()
+	if err != nil {
+		return err
+	}
+	return c.Write(struct {
+		PublicKey string `json:"public_key"`
+	}{key})
+}
+
+func (r resource) list(c *routing.Context) error {
+	subs, err := r.service.List(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(subs)
+}
+
+func (r resource) subscribe(c *routing.Context) error {
+	var req SubscribeRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	sub, err := r.service.Subscribe(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(sub, http.StatusCreated)
+}
+
+func (r resource) unsubscribe(c *routing.Context) error {
+	if err := r.service.Unsubscribe(c.Request.Context(), c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
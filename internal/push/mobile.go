@@ -0,0 +1,285 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/golang-jwt/jwt"
+)
+
+// fcmScope is the OAuth scope of the FCM HTTP v1 API.
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCM sends messages to devices with the Firebase Cloud Messaging HTTP v1 API, authenticated with the
+// service account of a Firebase project.
+type FCM struct {
+	projectID   string
+	clientEmail string
+	key         *rsa.PrivateKey
+	tokenURL    string
+	endpoint    string
+	client      *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewFCM creates an FCM provider from a service account key file. The messages are sent to endpoint,
+// which defaults to the FCM API, and the access tokens are requested from the token URI of the service
+// account, so both can point to a local fake service.
+func NewFCM(credentialsFile, endpoint string, client *http.Client) (*FCM, error) {
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var account struct {
+		ProjectID   string `json:"project_id"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid FCM credentials: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid FCM private key: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("FCM credentials have to contain project_id, client_email and token_uri")
+	}
+	if endpoint == "" {
+		endpoint = "https://fcm.googleapis.com"
+	}
+	return &FCM{
+		projectID:   account.ProjectID,
+		clientEmail: account.ClientEmail,
+		key:         key,
+		tokenURL:    account.TokenURI,
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		client:      client,
+	}, nil
+}
+
+// Platform returns PushFCM.
+func (p *FCM) Platform() entity.PushPlatform {
+	return entity.PushFCM
+}
+
+// Send sends a message to a device token.
+func (p *FCM) Send(ctx context.Context, sub entity.PushSubscription, msg Message) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	type notification struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+	type android struct {
+		CollapseKey string `json:"collapse_key,omitempty"`
+		Priority    string `json:"priority"`
+	}
+	var body struct {
+		Message struct {
+			Token        string            `json:"token"`
+			Notification notification      `json:"notification"`
+			Data         map[string]string `json:"data,omitempty"`
+			Android      android           `json:"android"`
+		} `json:"message"`
+	}
+	body.Message.Token = sub.Endpoint
+	body.Message.Notification = notification{msg.Title, msg.Body}
+	body.Message.Data = msg.Data
+	body.Message.Android = android{msg.Tag, "high"}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/v1/projects/"+url.PathEscape(p.projectID)+"/messages:send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = statusError(res)
+	// tokens of uninstalled apps are reported as not found, or as unregistered in the error details
+	if res.StatusCode == http.StatusNotFound || strings.Contains(err.Error(), "UNREGISTERED") {
+		return ErrGone
+	}
+	return err
+}
+
+// accessToken returns an OAuth access token of the service account, requesting a new one when the
+// cached token is about to expire.
+func (p *FCM) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.token != "" && now.Before(p.expires.Add(-time.Minute)) {
+		return p.token, nil
+	}
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   p.clientEmail,
+		"scope": fcmScope,
+		"aud":   p.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", statusError(res)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("FCM token response contains no access token")
+	}
+	p.token, p.expires = token.AccessToken, now.Add(time.Duration(token.ExpiresIn)*time.Second)
+	return p.token, nil
+}
+
+// APNs sends messages to Apple devices with the token-based authentication of the Apple Push
+// Notification service.
+type APNs struct {
+	key      *ecdsa.PrivateKey
+	keyID    string
+	teamID   string
+	topic    string
+	endpoint string
+	client   *http.Client
+
+	mu     sync.Mutex
+	token  string
+	issued time.Time
+}
+
+// NewAPNs creates an APNs provider from a .p8 signing key. The topic is the bundle ID of the app, and the
+// messages are sent to endpoint, which defaults to the production service.
+func NewAPNs(keyFile, keyID, teamID, topic, endpoint string, client *http.Client) (*APNs, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs key: %w", err)
+	}
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("APNs requires a key ID, a team ID and a topic")
+	}
+	if endpoint == "" {
+		endpoint = "https://api.push.apple.com"
+	}
+	return &APNs{key: key, keyID: keyID, teamID: teamID, topic: topic, endpoint: strings.TrimRight(endpoint, "/"), client: client}, nil
+}
+
+// Platform returns PushAPNs.
+func (p *APNs) Platform() entity.PushPlatform {
+	return entity.PushAPNs
+}
+
+// Send sends a message to a device token.
+func (p *APNs) Send(ctx context.Context, sub entity.PushSubscription, msg Message) error {
+	token, err := p.providerToken()
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{"title": msg.Title, "body": msg.Body},
+			"sound": "default",
+		},
+	}
+	for k, v := range msg.Data {
+		if k != "aps" {
+			body[k] = v
+		}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/3/device/"+url.PathEscape(sub.Endpoint), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	if msg.Tag != "" && len(msg.Tag) <= 64 {
+		req.Header.Set("apns-collapse-id", msg.Tag)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	err = statusError(res)
+	if res.StatusCode == http.StatusGone || strings.Contains(err.Error(), "BadDeviceToken") {
+		return ErrGone
+	}
+	return err
+}
+
+// providerToken returns the authentication token, which APNs expects to be renewed at most once every
+// 20 minutes and at least once an hour.
+func (p *APNs) providerToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.token != "" && now.Sub(p.issued) < 50*time.Minute {
+		return p.token, nil
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{"iss": p.teamID, "iat": now.Unix()})
+	t.Header["kid"] = p.keyID
+	token, err := t.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	p.token, p.issued = token, now
+	return token, nil
+}
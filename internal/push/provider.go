@@ -0,0 +1,115 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/webpush"
+)
+
+// ErrGone is returned by providers when a subscription no longer exists, so it should be deleted.
+var ErrGone = errors.New("push subscription is gone")
+
+// Message is a push notification.
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// Tag identifies the event, so that newer messages about it replace older ones.
+	Tag string `json:"tag,omitempty"`
+	// Data carries the fields of the notification for the app.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// Provider sends push notifications through a push service.
+type Provider interface {
+	// Platform returns the platform of the subscriptions the provider serves.
+	Platform() entity.PushPlatform
+	// Send sends a message to a subscription. ErrGone is returned if the subscription no longer exists.
+	Send(ctx context.Context, sub entity.PushSubscription, msg Message) error
+}
+
+// statusError describes an unexpected response of a push service.
+func statusError(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("push service responded with status %d: %s", res.StatusCode, bytes.TrimSpace(body))
+}
+
+// WebPush sends messages to browsers with the Web Push protocol.
+type WebPush struct {
+	VAPID *webpush.VAPID
+	// Client sends the requests. The endpoints are chosen by the browsers, so it should refuse internal addresses.
+	Client *http.Client
+	// TTL is how long push services keep a message for an offline browser.
+	TTL time.Duration
+}
+
+// Platform returns PushWeb.
+func (p WebPush) Platform() entity.PushPlatform {
+	return entity.PushWeb
+}
+
+// Send encrypts a message for a browser and posts it to its endpoint.
+func (p WebPush) Send(ctx context.Context, sub entity.PushSubscription, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p256dh, err := decodeKey(sub.P256dh)
+	if err != nil {
+		return err
+	}
+	auth, err := decodeKey(sub.Auth)
+	if err != nil {
+		return err
+	}
+	body, err := webpush.Encrypt(payload, p256dh, auth)
+	if err != nil {
+		return err
+	}
+	authorization, err := p.VAPID.Authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(p.TTL/time.Second)))
+	req.Header.Set("Urgency", "high")
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return statusError(res)
+	}
+	return nil
+}
+
+// decodeKey decodes a base64url key of a browser subscription, with or without padding.
+func decodeKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(trimPadding(s))
+}
+
+func trimPadding(s string) string {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
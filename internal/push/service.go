@@ -0,0 +1,352 @@
+// Package push implements push notifications, which deliver important notifications to the browsers
+// and devices of users even when the app is closed.
+//
+// Browsers subscribe with the Web Push protocol, apps register the device tokens of Firebase Cloud
+// Messaging or the Apple Push Notification service. Messages are sent through a Provider per platform
+// once the notification is committed, and subscriptions that the push service reports as gone are deleted.
+// Committed notifications wait in a bounded queue for the workers started by RunSender.
+package push
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	stderrors "errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxSubscriptions is the number of subscriptions kept per user. The oldest ones are deleted first.
+	maxSubscriptions = 20
+	// sendTimeout bounds the time spent sending the push messages of a notification.
+	sendTimeout = 30 * time.Second
+	// queueSize is the number of notifications waiting to be pushed. Notifications beyond it are dropped.
+	queueSize = 1000
+)
+
+// important lists the notification types that are pushed.
+var important = map[entity.NotificationType]bool{
+	entity.NotificationInvitation: true,
+	entity.NotificationReply:      true,
+	entity.NotificationMention:    true,
+	entity.NotificationSecurity:   true,
+	entity.NotificationModeration: true,
+}
+
+// Publisher pushes notifications.
+type Publisher interface {
+	// Push sends a notification of an important type to the subscriptions of its recipient once the
+	// surrounding transaction commits. The notification is queued and sent in the background by Deliver.
+	Push(ctx context.Context, n entity.Notification) error
+}
+
+// Service encapsulates the push logic.
+type Service interface {
+	Publisher
+	// VAPIDPublicKey returns the key browsers subscribe with, base64url encoded.
+	VAPIDPublicKey() (string, error)
+	// List returns the push subscriptions of the current user.
+	List(ctx context.Context) ([]entity.PushSubscription, error)
+	// Subscribe registers a browser or device of the current user. Registering a known endpoint of the
+	// user again updates it, while an endpoint registered by another user is refused.
+	Subscribe(ctx context.Context, req SubscribeRequest) (entity.PushSubscription, error)
+	// Unsubscribe deletes a push subscription of the current user.
+	Unsubscribe(ctx context.Context, id string) error
+	// Deliver sends the queued notifications until the context is canceled, then sends the ones still
+	// queued and returns.
+	Deliver(ctx context.Context)
+}
+
+// SubscribeRequest represents a push subscription request. Browsers send their PushSubscription as
+// returned by toJSON(), apps send their platform and device token.
+type SubscribeRequest struct {
+	Platform entity.PushPlatform `json:"platform"`
+	Endpoint string              `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+	Token string `json:"token"`
+}
+
+// Validate validates the SubscribeRequest fields.
+func (m SubscribeRequest) Validate() error {
+	web := m.Platform == entity.PushWeb
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Platform, validation.Required, validation.In(entity.PushWeb, entity.PushFCM, entity.PushAPNs)),
+		validation.Field(&m.Endpoint, validation.Required.When(web), validation.Empty.When(!web), validation.Length(0, 4096)),
+		validation.Field(&m.Keys, validation.By(func(interface{}) error {
+			if !web {
+				return nil
+			}
+			if p, err := decodeKey(m.Keys.P256dh); err != nil || len(p) != 65 {
+				return stderrors.New("p256dh has to be a base64url encoded P-256 public key")
+			} else if _, err := ecdh.P256().NewPublicKey(p); err != nil {
+				return stderrors.New("p256dh has to be a base64url encoded P-256 public key")
+			}
+			if a, err := decodeKey(m.Keys.Auth); err != nil || len(a) != 16 {
+				return stderrors.New("auth has to be a base64url encoded 16 byte secret")
+			}
+			return nil
+		})),
+		validation.Field(&m.Token, validation.Required.When(!web), validation.Empty.When(web), validation.Length(0, 4096)),
+	)
+}
+
+type service struct {
+	db           *dbcontext.DB
+	providers    map[entity.PushPlatform]Provider
+	allowPrivate bool
+	queue        chan entity.Notification
+	logger       *logrus.Logger
+}
+
+// NewService creates a new push service sending through the given providers. Only the platforms of the
+// providers can be subscribed to. Unless allowPrivate is set, browser endpoints have to use HTTPS.
+func NewService(db *dbcontext.DB, providers []Provider, allowPrivate bool, logger *logrus.Logger) Service {
+	s := service{db, map[entity.PushPlatform]Provider{}, allowPrivate, make(chan entity.Notification, queueSize), logger}
+	for _, p := range providers {
+		s.providers[p.Platform()] = p
+	}
+	return s
+}
+
+func (s service) VAPIDPublicKey() (string, error) {
+	if p, ok := s.providers[entity.PushWeb].(WebPush); ok {
+		return base64.RawURLEncoding.EncodeToString(p.VAPID.PublicKey), nil
+	}
+	return "", errors.NotFound("web push is not configured")
+}
+
+func (s service) List(ctx context.Context) ([]entity.PushSubscription, error) {
+	subs := []entity.PushSubscription{}
+	err := s.db.With(ctx).Select().
+		From("push_subscriptions").
+		Where(dbx.HashExp{"user_id": auth.CurrentUser(ctx).GetID()}).
+		OrderBy("created_at", "id").
+		All(&subs)
+	return subs, err
+}
+
+func (s service) Subscribe(ctx context.Context, req SubscribeRequest) (entity.PushSubscription, error) {
+	if req.Platform == "" && req.Endpoint != "" {
+		req.Platform = entity.PushWeb
+	}
+	if err := req.Validate(); err != nil {
+		return entity.PushSubscription{}, err
+	}
+	if _, ok := s.providers[req.Platform]; !ok {
+		return entity.PushSubscription{}, errors.BadRequest("push platform " + string(req.Platform) + " is not available")
+	}
+	endpoint := req.Token
+	if req.Platform == entity.PushWeb {
+		endpoint = req.Endpoint
+		if u, err := url.Parse(endpoint); err != nil || u.Host == "" || (u.Scheme != "https" && !(s.allowPrivate && u.Scheme == "http")) {
+			return entity.PushSubscription{}, errors.BadRequest("endpoint has to be an https URL")
+		}
+	}
+	hash := sha256.Sum256([]byte(endpoint))
+	user := auth.CurrentUser(ctx).GetID()
+	id := entity.GenerateID()
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		// a known endpoint is only updated for the user who registered it, so that nobody can take over
+		// the pushes of another user by submitting their endpoint
+		if _, err := s.db.With(ctx).NewQuery("INSERT INTO push_subscriptions (id, user_id, platform, endpoint, endpoint_hash, p256dh, auth, created_at) " +
+			"VALUES ({:id}, {:user}, {:platform}, {:endpoint}, {:hash}, {:p256dh}, {:auth}, {:now}) " +
+			"ON DUPLICATE KEY UPDATE p256dh = IF(user_id = VALUES(user_id), VALUES(p256dh), p256dh), auth = IF(user_id = VALUES(user_id), VALUES(auth), auth)").
+			Bind(dbx.Params{
+				"id":       id,
+				"user":     user,
+				"platform": req.Platform,
+				"endpoint": endpoint,
+				"hash":     hex.EncodeToString(hash[:]),
+				"p256dh":   trimPadding(req.Keys.P256dh),
+				"auth":     trimPadding(req.Keys.Auth),
+				"now":      time.Now().UTC().Truncate(time.Second),
+			}).
+			Execute(); err != nil {
+			return err
+		}
+		var owner int
+		if err := s.db.With(ctx).Select("id", "user_id").
+			From("push_subscriptions").
+			Where(dbx.HashExp{"platform": req.Platform, "endpoint_hash": hex.EncodeToString(hash[:])}).
+			Row(&id, &owner); err != nil {
+			return err
+		}
+		if owner != user {
+			return errors.Forbidden("the endpoint is registered by another user")
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.PushSubscription{}, err
+	}
+	if err := s.prune(ctx, user); err != nil {
+		return entity.PushSubscription{}, err
+	}
+	var sub entity.PushSubscription
+	err = s.db.With(ctx).Select().From("push_subscriptions").Where(dbx.HashExp{"id": id}).One(&sub)
+	return sub, err
+}
+
+// prune deletes the oldest subscriptions of a user beyond the limit.
+func (s service) prune(ctx context.Context, userID int) error {
+	_, err := s.db.With(ctx).NewQuery("DELETE FROM push_subscriptions WHERE user_id = {:user} AND id NOT IN " +
+		"(SELECT id FROM (SELECT id FROM push_subscriptions WHERE user_id = {:user} ORDER BY created_at DESC, id DESC LIMIT {:limit}) keep)").
+		Bind(dbx.Params{"user": userID, "limit": maxSubscriptions}).
+		Execute()
+	return err
+}
+
+func (s service) Unsubscribe(ctx context.Context, id string) error {
+	res, err := s.db.With(ctx).Delete("push_subscriptions", dbx.HashExp{"id": id, "user_id": auth.CurrentUser(ctx).GetID()}).Execute()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.NotFound("")
+	}
+	return nil
+}
+
+func (s service) Push(ctx context.Context, n entity.Notification) error {
+	if !important[n.Type] || len(s.providers) == 0 {
+		return nil
+	}
+	dbcontext.AfterCommit(ctx, func() {
+		select {
+		case s.queue <- n:
+		default:
+			s.logger.WithContext(ctx).WithField("notification", n.ID).Warn("Push queue is full, dropping notification")
+		}
+	})
+	return nil
+}
+
+func (s service) Deliver(ctx context.Context) {
+	for {
+		select {
+		case n := <-s.queue:
+			s.send(n)
+		case <-ctx.Done():
+			for {
+				select {
+				case n := <-s.queue:
+					s.send(n)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// RunSender sends the queued notifications with the given number of workers until the context is
+// canceled. It returns once the notifications queued by then are sent, so that a shutdown waiting for
+// it does not lose them.
+func RunSender(ctx context.Context, service Service, workers int, logger *logrus.Logger) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.Deliver(ctx)
+		}()
+	}
+	wg.Wait()
+	logger.Info("Push sender stopped")
+}
+
+// send sends a notification to all subscriptions of its recipient and deletes the ones that are gone.
+func (s service) send(n entity.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	logger := s.logger.WithField("user", n.UserID).WithField("notification", n.ID)
+	var subs []entity.PushSubscription
+	if err := s.db.With(ctx).Select().From("push_subscriptions").Where(dbx.HashExp{"user_id": n.UserID}).All(&subs); err != nil {
+		logger.WithError(err).Error("Failed to load push subscriptions")
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+	msg, err := s.message(ctx, n)
+	if err != nil {
+		logger.WithError(err).Error("Failed to compose push message")
+		return
+	}
+	for _, sub := range subs {
+		provider, ok := s.providers[sub.Platform]
+		if !ok {
+			continue
+		}
+		err := provider.Send(ctx, sub, msg)
+		switch {
+		case stderrors.Is(err, ErrGone):
+			logger.WithField("subscription", sub.ID).Info("Deleting gone push subscription")
+			_, err = s.db.With(ctx).Delete("push_subscriptions", dbx.HashExp{"id": sub.ID}).Execute()
+		case err == nil:
+			_, err = s.db.With(ctx).Update("push_subscriptions", dbx.Params{"last_used_at": time.Now().UTC().Truncate(time.Second)},
+				dbx.HashExp{"id": sub.ID}).Execute()
+		}
+		if err != nil {
+			logger.WithError(err).WithField("subscription", sub.ID).Error("Failed to push notification")
+		}
+	}
+}
+
+// message composes the push message of a notification.
+func (s service) message(ctx context.Context, n entity.Notification) (Message, error) {
+	var names struct {
+		Actor string
+		Album string
+	}
+	if err := s.db.With(ctx).NewQuery("SELECT COALESCE((SELECT TRIM(CONCAT(first_name, ' ', last_name)) FROM users WHERE id = {:actor}), 'Someone') AS actor, " +
+		"COALESCE((SELECT name FROM albums WHERE id = {:album}), '') AS album").
+		Bind(dbx.Params{"actor": n.ActorID, "album": n.AlbumID}).
+		One(&names); err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		Tag:  string(n.Type) + ":" + strconv.FormatInt(n.ID, 10),
+		Data: map[string]string{"type": string(n.Type), "notification_id": strconv.FormatInt(n.ID, 10)},
+	}
+	for k, v := range map[string]*string{"album_id": n.AlbumID, "media_id": n.MediaID, "comment_id": n.CommentID} {
+		if v != nil {
+			msg.Data[k] = *v
+		}
+	}
+	switch n.Type {
+	case entity.NotificationInvitation:
+		msg.Title, msg.Body = "Album invitation", names.Actor+" invited you to "+names.Album
+	case entity.NotificationReply:
+		msg.Title, msg.Body = "New reply", names.Actor+" replied to your comment in "+names.Album
+	case entity.NotificationMention:
+		msg.Title, msg.Body = "New mention", names.Actor+" mentioned you in "+names.Album
+	case entity.NotificationSecurity:
+		msg.Title, msg.Body = "Security alert", "There was activity on your account"
+		if n.Detail != "" {
+			msg.Body = strings.ToUpper(n.Detail[:1]) + n.Detail[1:]
+		}
+	case entity.NotificationModeration:
+		msg.Title, msg.Body = "Moderation", n.Detail
+	default:
+		msg.Title, msg.Body = "ShareFlow", n.Detail
+	}
+	return msg, nil
+}
@@ -0,0 +1,52 @@
+package quota
+
+import (
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the storage administration handlers. All of them require an authenticated admin.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler, adminHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler, adminHandler)
+	r.Get("/admin/users/<id>/storage", res.usage)
+	r.Put("/admin/users/<id>/storage/quota", res.setQuota)
+}
+
+func (r resource) usage(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.NotFound("")
+	}
+	u, err := r.service.Usage(c.Request.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.Write(u)
+}
+
+// setQuota overrides the quota of a user. Sending a null quota_bytes restores the default quota.
+func (r resource) setQuota(c *routing.Context) error {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return errors.NotFound("")
+	}
+	var req SetQuotaRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	u, err := r.service.SetQuota(c.Request.Context(), userID, req)
+	if err != nil {
+		return err
+	}
+	return c.Write(u)
+}
@@ -0,0 +1,166 @@
+// Package quota implements per-user storage accounting and quotas.
+//
+// Charge and Release use the transaction found in the context, so callers are expected to run them
+// inside dbcontext.DB.Transactional together with the media changes they account for.
+package quota
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the storage accounting logic.
+type Service interface {
+	// Usage returns the storage usage and quota of a user.
+	Usage(ctx context.Context, userID int) (entity.StorageUsage, error)
+	// Check returns a QuotaExceeded error if storing size more bytes would exceed the quota of a user.
+	// It does not reserve anything, the final decision is made by Charge.
+	Check(ctx context.Context, userID int, size int64) error
+	// Charge accounts a new media item of the given size to a user. It returns a QuotaExceeded error if
+	// the item does not fit into the user's quota.
+	Charge(ctx context.Context, userID int, size int64) error
+	// Release removes a media item of the given size from the usage of a user.
+	Release(ctx context.Context, userID int, size int64) error
+	// SetQuota overrides the quota of a user. A nil quota restores the default, zero means unlimited.
+	SetQuota(ctx context.Context, userID int, req SetQuotaRequest) (entity.StorageUsage, error)
+}
+
+// SetQuotaRequest represents a quota update request.
+type SetQuotaRequest struct {
+	QuotaBytes *int64 `json:"quota_bytes"`
+}
+
+// Validate validates the SetQuotaRequest fields.
+func (m SetQuotaRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.QuotaBytes, validation.Min(int64(0))),
+	)
+}
+
+type service struct {
+	db           *dbcontext.DB
+	defaultQuota int64
+	logger       *logrus.Logger
+}
+
+// NewService creates a new quota service. Users without a custom quota are limited to defaultQuota bytes,
+// zero means unlimited.
+func NewService(db *dbcontext.DB, defaultQuota int64, logger *logrus.Logger) Service {
+	return service{db, defaultQuota, logger}
+}
+
+// usageRow is a row of the storage_usage table.
+type usageRow struct {
+	UserID     int
+	BytesUsed  int64
+	ItemCount  int
+	QuotaBytes *int64
+}
+
+func (s service) usage(r usageRow) entity.StorageUsage {
+	u := entity.StorageUsage{UserID: r.UserID, BytesUsed: r.BytesUsed, ItemCount: r.ItemCount, QuotaBytes: s.defaultQuota}
+	if r.QuotaBytes != nil {
+		u.QuotaBytes, u.CustomQuota = *r.QuotaBytes, true
+	}
+	return u
+}
+
+func (s service) Usage(ctx context.Context, userID int) (entity.StorageUsage, error) {
+	r := usageRow{UserID: userID}
+	err := s.db.With(ctx).NewQuery("SELECT * FROM storage_usage WHERE user_id={:user}").
+		Bind(dbx.Params{"user": userID}).
+		One(&r)
+	if err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+		return entity.StorageUsage{}, err
+	}
+	return s.usage(r), nil
+}
+
+func (s service) Check(ctx context.Context, userID int, size int64) error {
+	u, err := s.Usage(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return exceeded(u, size)
+}
+
+// lock reads the usage row of a user, creating it if necessary, and locks it until the surrounding
+// transaction ends so that concurrent uploads cannot exceed the quota together.
+func (s service) lock(ctx context.Context, userID int) (entity.StorageUsage, error) {
+	if _, err := s.db.With(ctx).NewQuery("INSERT IGNORE INTO storage_usage (user_id) VALUES ({:user})").
+		Bind(dbx.Params{"user": userID}).
+		Execute(); err != nil {
+		return entity.StorageUsage{}, err
+	}
+	var r usageRow
+	err := s.db.With(ctx).NewQuery("SELECT * FROM storage_usage WHERE user_id={:user} FOR UPDATE").
+		Bind(dbx.Params{"user": userID}).
+		One(&r)
+	return s.usage(r), err
+}
+
+func (s service) Charge(ctx context.Context, userID int, size int64) error {
+	u, err := s.lock(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := exceeded(u, size); err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).NewQuery("UPDATE storage_usage SET bytes_used = bytes_used + {:size}, item_count = item_count + 1, updated_at={:now} WHERE user_id={:user}").
+		Bind(dbx.Params{"user": userID, "size": size, "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	return err
+}
+
+func (s service) Release(ctx context.Context, userID int, size int64) error {
+	_, err := s.db.With(ctx).NewQuery("UPDATE storage_usage SET bytes_used = GREATEST(bytes_used - {:size}, 0), item_count = GREATEST(item_count - 1, 0), updated_at={:now} WHERE user_id={:user}").
+		Bind(dbx.Params{"user": userID, "size": size, "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	return err
+}
+
+func (s service) SetQuota(ctx context.Context, userID int, req SetQuotaRequest) (entity.StorageUsage, error) {
+	if err := req.Validate(); err != nil {
+		return entity.StorageUsage{}, err
+	}
+	var exists int
+	if err := s.db.With(ctx).NewQuery("SELECT COUNT(*) FROM users WHERE id={:user}").
+		Bind(dbx.Params{"user": userID}).
+		Row(&exists); err != nil {
+		return entity.StorageUsage{}, err
+	}
+	if exists == 0 {
+		return entity.StorageUsage{}, errors.NotFound("user not found")
+	}
+	_, err := s.db.With(ctx).NewQuery("INSERT INTO storage_usage (user_id, quota_bytes) VALUES ({:user}, {:quota}) " +
+		"ON DUPLICATE KEY UPDATE quota_bytes=VALUES(quota_bytes), updated_at={:now}").
+		Bind(dbx.Params{"user": userID, "quota": req.QuotaBytes, "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	if err != nil {
+		return entity.StorageUsage{}, err
+	}
+	s.logger.WithContext(ctx).WithFields(logrus.Fields{"user": userID, "quota": req.QuotaBytes}).Info("Storage quota changed")
+	return s.Usage(ctx, userID)
+}
+
+// exceeded returns a QuotaExceeded error describing the usage if size more bytes do not fit into it.
+func exceeded(u entity.StorageUsage, size int64) error {
+	if u.Allows(size) {
+		return nil
+	}
+	return errors.QuotaExceeded("", struct {
+		QuotaBytes int64 `json:"quota_bytes"`
+		BytesUsed  int64 `json:"bytes_used"`
+		Requested  int64 `json:"requested_bytes"`
+	}{u.QuotaBytes, u.BytesUsed, size})
+}
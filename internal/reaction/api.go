@@ -0,0 +1,56 @@
+package reaction
+
+import (
+	"net/http"
+
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the reaction handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/reactions", res.types)
+	r.Get("/media/<id>/reactions", res.reactors)
+	r.Put("/media/<id>/reactions/<reaction>", res.react)
+	r.Delete("/media/<id>/reactions/<reaction>", res.unreact)
+}
+
+func (r resource) types(c *routing.Context) error {
+	return c.Write(r.service.Types())
+}
+
+// reactors lists the users who reacted to a media item. The optional reaction query parameter restricts
+// the list to a single reaction type.
+func (r resource) reactors(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	reactors, err := r.service.Reactors(c.Request.Context(), c.Param("id"), c.Query("reaction"), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = reactors
+	return c.Write(pages)
+}
+
+func (r resource) react(c *routing.Context) error {
+	if err := r.service.React(c.Request.Context(), c.Param("id"), c.Param("reaction")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) unreact(c *routing.Context) error {
+	if err := r.service.Unreact(c.Request.Context(), c.Param("id"), c.Param("reaction")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,151 @@
+// Package reaction implements emoji reactions on media items.
+package reaction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/block"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/notification"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the reaction logic. Everyone who can view a media item can react to it.
+type Service interface {
+	// Types returns the configured reaction types.
+	Types() []entity.ReactionType
+	// React adds a reaction of the current user to a media item. Reacting twice with the same type has no effect.
+	React(ctx context.Context, mediaID, reaction string) error
+	// Unreact removes a reaction of the current user from a media item.
+	Unreact(ctx context.Context, mediaID, reaction string) error
+	// Reactors returns a page of the users who reacted to a media item, most recent first. An empty
+	// reaction lists the reactions of all types.
+	Reactors(ctx context.Context, mediaID, reaction string, pages *pagination.Pages) ([]entity.Reactor, error)
+}
+
+type service struct {
+	db     *dbcontext.DB
+	media  media.Service
+	types  []entity.ReactionType
+	notify notification.Publisher
+	logger *logrus.Logger
+}
+
+// NewService creates a new reaction service offering the given reaction types. Uploaders are notified
+// about the reactions to their media items.
+func NewService(db *dbcontext.DB, media media.Service, types []entity.ReactionType, notify notification.Publisher, logger *logrus.Logger) Service {
+	return service{db, media, types, notify, logger}
+}
+
+// ParseTypes parses a comma separated list of "name:emoji" pairs.
+func ParseTypes(s string) ([]entity.ReactionType, error) {
+	var types []entity.ReactionType
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, emoji, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || emoji == "" || len(name) > 32 {
+			return nil, fmt.Errorf("reaction: invalid reaction type %q, expected name:emoji", pair)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("reaction: duplicate reaction type %q", name)
+		}
+		seen[name] = true
+		types = append(types, entity.ReactionType{Name: name, Emoji: emoji})
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("reaction: no reaction types configured")
+	}
+	return types, nil
+}
+
+func (s service) Types() []entity.ReactionType {
+	return s.types
+}
+
+// valid returns a BadRequest error unless the reaction is one of the configured types.
+func (s service) valid(reaction string) error {
+	for _, t := range s.types {
+		if t.Name == reaction {
+			return nil
+		}
+	}
+	return errors.BadRequest("unknown reaction type")
+}
+
+func (s service) React(ctx context.Context, mediaID, reaction string) error {
+	if err := s.valid(reaction); err != nil {
+		return err
+	}
+	m, err := s.media.Get(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		res, err := s.db.With(ctx).NewQuery("INSERT IGNORE INTO media_reactions (media_id, user_id, reaction, created_at) VALUES ({:media}, {:user}, {:reaction}, {:now})").
+			Bind(dbx.Params{"media": m.ID, "user": auth.CurrentUser(ctx).GetID(), "reaction": reaction, "now": time.Now().UTC().Truncate(time.Second)}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		return s.notify.Notify(ctx, entity.Notification{
+			Type:    entity.NotificationReaction,
+			AlbumID: &m.AlbumID,
+			MediaID: &m.ID,
+			Detail:  reaction,
+		}, m.OwnerID)
+	})
+}
+
+func (s service) Unreact(ctx context.Context, mediaID, reaction string) error {
+	m, err := s.media.Get(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.With(ctx).Delete("media_reactions", dbx.HashExp{"media_id": m.ID, "user_id": auth.CurrentUser(ctx).GetID(), "reaction": reaction}).Execute()
+	return err
+}
+
+func (s service) Reactors(ctx context.Context, mediaID, reaction string, pages *pagination.Pages) ([]entity.Reactor, error) {
+	m, err := s.media.Get(ctx, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	match := dbx.HashExp{"r.media_id": m.ID}
+	if reaction != "" {
+		match["r.reaction"] = reaction
+	}
+	// reactions of users hidden from the current user by a block or mute are left out
+	where := dbx.And(match, dbx.NewExp("NOT "+block.Hidden("{:viewer}", "r.user_id"), dbx.Params{"viewer": auth.CurrentUser(ctx).GetID()}))
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("media_reactions r").Where(where).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	reactors := []entity.Reactor{}
+	err = s.db.With(ctx).Select("r.user_id", "u.first_name", "u.last_name", "r.reaction", "r.created_at").
+		From("media_reactions r").
+		InnerJoin("users u", dbx.NewExp("u.id = r.user_id")).
+		Where(where).
+		OrderBy("r.created_at DESC", "r.user_id").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&reactors)
+	return reactors, err
+}
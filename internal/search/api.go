@@ -0,0 +1,31 @@
+package search
+
+import (
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the search handler, which requires an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/search", res.search)
+}
+
+// search runs the query given by the q query parameter. The optional type query parameter restricts the
+// results to albums, media items or comments.
+func (r resource) search(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	results, err := r.service.Search(c.Request.Context(), c.Query("q"), c.Query("type"), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = results
+	return c.Write(pages)
+}
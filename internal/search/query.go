@@ -0,0 +1,146 @@
+package search
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+)
+
+const (
+	// maxTerms is the maximum number of terms of a query, including the terms of phrases.
+	maxTerms = 32
+	// maxPhraseLength is the maximum number of terms of a phrase.
+	maxPhraseLength = 8
+)
+
+// qualifiers are the keys of the "key:value" parts of a query.
+var qualifiers = map[string]bool{"tag": true, "album": true, "before": true, "after": true}
+
+// dateLayouts are the accepted formats of before: and after: dates, from the most to the least precise.
+var dateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// part is a part of a query: a word, a quoted phrase, or a qualifier with its value.
+type part struct {
+	key   string
+	value string
+}
+
+// lex splits a query into its parts. Parts are separated by whitespace, double quotes group words into
+// a phrase, both on their own and as qualifier values such as album:"summer trip". An unterminated
+// quote extends to the end of the query.
+func lex(q string) []part {
+	var parts []part
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			return parts
+		}
+		if q[0] == '"' {
+			var value string
+			value, q = quoted(q[1:])
+			parts = append(parts, part{value: value})
+			continue
+		}
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		key, value, ok := strings.Cut(word, ":")
+		if !ok || !qualifiers[strings.ToLower(key)] {
+			parts = append(parts, part{value: word})
+			q = q[end:]
+			continue
+		}
+		p := part{key: strings.ToLower(key), value: value}
+		if strings.HasPrefix(value, `"`) {
+			p.value, q = quoted(q[len(key)+2:])
+		} else {
+			q = q[end:]
+		}
+		parts = append(parts, p)
+	}
+}
+
+// quoted returns the text up to the next double quote and the rest of s following the quote.
+func quoted(s string) (string, string) {
+	if i := strings.IndexByte(s, '"'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// parse parses a search query. Words and quoted phrases are matched against the text of albums, media
+// items and comments. Words that consist of several terms, such as "IMG_0001", are matched like phrases.
+// The qualifiers restrict the results:
+//
+//	tag:beach            items carrying the tag, which leaves out comments
+//	album:"summer trip"  albums, and media items and comments in albums, whose name contains the words
+//	after:2024-05        items dated on or after the date
+//	before:2024          items dated before the date
+//
+// Dates are given as YYYY-MM-DD, YYYY-MM or YYYY in UTC.
+func parse(q string) (index.Query, error) {
+	var query index.Query
+	count := 0
+	for _, p := range lex(q) {
+		switch p.key {
+		case "tag":
+			t := entity.NormalizeTag(p.value)
+			if t == "" {
+				return query, errors.BadRequest("tag: requires a tag")
+			}
+			query.Tags = append(query.Tags, t)
+		case "album":
+			terms := index.Tokenize(p.value)
+			if len(terms) == 0 {
+				return query, errors.BadRequest("album: requires an album name")
+			}
+			query.Album = append(query.Album, terms...)
+			count += len(terms)
+		case "before", "after":
+			t, err := parseDate(p.value)
+			if err != nil {
+				return query, errors.BadRequest(p.key + ": requires a date formatted as YYYY-MM-DD, YYYY-MM or YYYY")
+			}
+			if p.key == "before" {
+				query.Before = &t
+			} else {
+				query.After = &t
+			}
+		default:
+			terms := index.Tokenize(p.value)
+			switch {
+			case len(terms) == 1:
+				query.Terms = append(query.Terms, terms[0])
+			case len(terms) > maxPhraseLength:
+				return query, errors.BadRequest("phrases are limited to 8 words")
+			case len(terms) > 1:
+				query.Phrases = append(query.Phrases, terms)
+			}
+			count += len(terms)
+		}
+	}
+	if count > maxTerms {
+		return query, errors.BadRequest("the query has too many words")
+	}
+	if count == 0 && len(query.Tags) == 0 && query.Before == nil && query.After == nil {
+		return query, errors.BadRequest("the query is empty")
+	}
+	return query, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,106 @@
+// Package search implements searching the albums, media items and comments the current user can view.
+package search
+
+import (
+	"context"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/comment"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the search logic.
+type Service interface {
+	// Search returns a page of the albums, media items and comments matching a query, best matches first.
+	// The query language is described by parse. A non-empty kind restricts the results to index.TypeAlbum,
+	// index.TypeMedia or index.TypeComment.
+	Search(ctx context.Context, query, kind string, pages *pagination.Pages) ([]entity.SearchResult, error)
+}
+
+type service struct {
+	index    index.Index
+	albums   album.Service
+	media    media.Service
+	comments comment.Service
+	logger   *logrus.Logger
+}
+
+// NewService creates a new search service.
+func NewService(index index.Index, albums album.Service, media media.Service, comments comment.Service, logger *logrus.Logger) Service {
+	return service{index, albums, media, comments, logger}
+}
+
+func (s service) Search(ctx context.Context, query, kind string, pages *pagination.Pages) ([]entity.SearchResult, error) {
+	if kind != "" && kind != index.TypeAlbum && kind != index.TypeMedia && kind != index.TypeComment {
+		return nil, errors.BadRequest("type must be album, media or comment")
+	}
+	q, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	q.Type = kind
+	hits, err := s.index.Search(ctx, q, pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var albumIDs, mediaIDs, commentIDs []string
+	for _, h := range hits {
+		switch h.Type {
+		case index.TypeAlbum:
+			albumIDs = append(albumIDs, h.ID)
+		case index.TypeMedia:
+			mediaIDs = append(mediaIDs, h.ID)
+		default:
+			commentIDs = append(commentIDs, h.ID)
+		}
+	}
+	albums, err := s.albums.GetAll(ctx, albumIDs)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.media.GetAll(ctx, mediaIDs)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := s.comments.GetAll(ctx, commentIDs)
+	if err != nil {
+		return nil, err
+	}
+	albumsByID := map[string]*entity.Album{}
+	for i := range albums {
+		albumsByID[albums[i].ID] = &albums[i]
+	}
+	mediaByID := map[string]*entity.Media{}
+	for i := range items {
+		mediaByID[items[i].ID] = &items[i]
+	}
+
+	commentsByID := map[string]*entity.Comment{}
+	for i := range comments {
+		commentsByID[comments[i].ID] = &comments[i]
+	}
+
+	// items deleted since the index was queried are left out
+	results := []entity.SearchResult{}
+	for _, h := range hits {
+		r := entity.SearchResult{Type: h.Type, Score: h.Score}
+		switch h.Type {
+		case index.TypeAlbum:
+			r.Album = albumsByID[h.ID]
+		case index.TypeMedia:
+			r.Media = mediaByID[h.ID]
+		default:
+			r.Comment = commentsByID[h.ID]
+		}
+		if r.Album != nil || r.Media != nil || r.Comment != nil {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
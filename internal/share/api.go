@@ -0,0 +1,108 @@
+package share
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httprange"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the share link handlers. The public link routes are registered before
+// the authentication handler, the management routes require an authenticated album owner.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Get("/s/<token>", res.resolve)
+	r.To("GET,HEAD", "/s/<token>/media/<id>", res.content)
+	r.Get("/s/<token>/archive", res.archive)
+
+	r.Use(authHandler)
+	r.Get("/albums/<id>/links", res.list)
+	r.Post("/albums/<id>/links", res.create)
+	r.Delete("/albums/<id>/links/<link>", res.revoke)
+}
+
+func (r resource) list(c *routing.Context) error {
+	links, err := r.service.List(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(links)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var req CreateLinkRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	link, err := r.service.Create(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(link, http.StatusCreated)
+}
+
+func (r resource) revoke(c *routing.Context) error {
+	if err := r.service.Revoke(c.Request.Context(), c.Param("id"), c.Param("link")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// resolve returns the read-only album view of a link. The password of protected links is sent in the
+// X-Share-Password header.
+func (r resource) resolve(c *routing.Context) error {
+	view, err := r.service.Resolve(c.Request.Context(), c.Param("token"), c.Request.Header.Get("X-Share-Password"))
+	if err != nil {
+		return err
+	}
+	c.Response.Header().Set("Cache-Control", "no-store")
+	return c.Write(view)
+}
+
+func (r resource) content(c *routing.Context) error {
+	access := parseAccess(c)
+	variant := c.Query("variant", media.VariantOriginal)
+	m, content, err := r.service.Content(c.Request.Context(), c.Param("token"), c.Param("id"), variant, access)
+	if err != nil {
+		return err
+	}
+	disposition := "inline"
+	if variant == media.VariantOriginal {
+		disposition = "attachment"
+	}
+	c.Response.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": m.Filename}))
+	c.Response.Header().Set("Cache-Control", "private, no-cache")
+	if err := httprange.Serve(c.Response, c.Request, content); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithError(err).WithField("media", m.ID).Error("Failed to open media content")
+		return errors.InternalServerError("")
+	}
+	return nil
+}
+
+// archive streams a ZIP archive of a shared album when the link allows downloads.
+func (r resource) archive(c *routing.Context) error {
+	archive, err := r.service.Archive(c.Request.Context(), c.Param("token"), c.Query("variant"), parseAccess(c))
+	if err != nil {
+		return err
+	}
+	media.WriteArchive(c, archive, r.logger)
+	return nil
+}
+
+// parseAccess reads the access parameters of password protected links from the query string.
+func parseAccess(c *routing.Context) Access {
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	return Access{Expires: expires, KID: c.Query("kid"), Sig: c.Query("sig")}
+}
@@ -0,0 +1,359 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	stderrors "errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/crypt"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/httprange"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the share link logic.
+type Service interface {
+	// Create creates a new share link for an album of the current user.
+	Create(ctx context.Context, albumID string, req CreateLinkRequest) (Link, error)
+	// List returns all share links of an album, including revoked and expired ones.
+	List(ctx context.Context, albumID string) ([]Link, error)
+	// Revoke revokes a share link so it can no longer be used.
+	Revoke(ctx context.Context, albumID, linkID string) error
+	// Resolve resolves a link token to a read-only album view and counts a view of the link.
+	Resolve(ctx context.Context, token, password string) (AlbumView, error)
+	// Content returns a variant of a media item of a shared album. Password protected and view limited
+	// links require the access parameters handed out by Resolve.
+	Content(ctx context.Context, token, mediaID, variant string, access Access) (entity.Media, httprange.Content, error)
+	// Archive returns a ZIP archive of a shared album. It requires a link that allows downloads and, for
+	// password protected and view limited links, the access parameters handed out by Resolve.
+	Archive(ctx context.Context, token, variant string, access Access) (media.Archive, error)
+}
+
+// CreateLinkRequest represents a share link creation request.
+type CreateLinkRequest struct {
+	Label         string     `json:"label"`
+	ExpiresAt     *time.Time `json:"expires_at"`
+	Password      string     `json:"password"`
+	MaxViews      *int       `json:"max_views"`
+	AllowDownload bool       `json:"allow_download"`
+}
+
+// Validate validates the CreateLinkRequest fields.
+func (m CreateLinkRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Label, validation.Length(0, 255)),
+		validation.Field(&m.ExpiresAt, validation.By(func(interface{}) error {
+			if m.ExpiresAt != nil && !m.ExpiresAt.After(time.Now()) {
+				return stderrors.New("must be in the future")
+			}
+			return nil
+		})),
+		validation.Field(&m.Password, validation.Length(0, 72)),
+		validation.Field(&m.MaxViews, validation.NilOrNotEmpty, validation.Min(1)),
+	)
+}
+
+// Link is a share link as shown to the album owner.
+type Link struct {
+	entity.ShareLink
+	HasPassword bool   `json:"has_password"`
+	URL         string `json:"url"`
+}
+
+// AlbumView is the read-only view of an album opened through a share link.
+type AlbumView struct {
+	Album struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"album"`
+	AllowDownload bool          `json:"allow_download"`
+	ExpiresAt     *time.Time    `json:"expires_at"`
+	ArchiveURL    string        `json:"archive_url,omitempty"`
+	Media         []PublicMedia `json:"media"`
+}
+
+// PublicMedia is a media item as shown through a share link.
+type PublicMedia struct {
+	ID           string                `json:"id"`
+	Filename     string                `json:"filename"`
+	ContentType  string                `json:"content_type"`
+	Size         int64                 `json:"size"`
+	CreatedAt    time.Time             `json:"created_at"`
+	Metadata     *entity.MediaMetadata `json:"metadata,omitempty"`
+	ThumbnailURL string                `json:"thumbnail_url"`
+	PreviewURL   string                `json:"preview_url"`
+	DownloadURL  string                `json:"download_url,omitempty"`
+}
+
+// Access carries the signed parameters that authorize media requests of password protected and view
+// limited links. They expire after the default lifetime of signed media URLs.
+type Access struct {
+	Expires int64
+	KID     string
+	Sig     string
+}
+
+type service struct {
+	db      *dbcontext.DB
+	albums  album.Service
+	media   media.Service
+	signing media.URLSigning
+	logger  *logrus.Logger
+}
+
+// NewService creates a new share link service.
+func NewService(db *dbcontext.DB, albums album.Service, media media.Service, signing media.URLSigning, logger *logrus.Logger) Service {
+	return service{db, albums, media, signing, logger}
+}
+
+func (s service) Create(ctx context.Context, albumID string, req CreateLinkRequest) (Link, error) {
+	if err := req.Validate(); err != nil {
+		return Link{}, err
+	}
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return Link{}, err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return Link{}, err
+	}
+	l := entity.ShareLink{
+		ID:            entity.GenerateID(),
+		AlbumID:       a.ID,
+		CreatedBy:     auth.CurrentUser(ctx).GetID(),
+		Token:         token,
+		Label:         req.Label,
+		ExpiresAt:     req.ExpiresAt,
+		MaxViews:      req.MaxViews,
+		AllowDownload: req.AllowDownload,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+	}
+	if req.Password != "" {
+		hashed, err := crypt.HashPassword(req.Password)
+		if err != nil {
+			s.logger.WithContext(ctx).Error("Failed to hash password")
+			return Link{}, errors.InternalServerError("failed to hash password")
+		}
+		l.PasswordHash = &hashed
+	}
+	if _, err := s.db.With(ctx).Insert("share_links", dbx.Params{
+		"id":             l.ID,
+		"album_id":       l.AlbumID,
+		"created_by":     l.CreatedBy,
+		"token":          l.Token,
+		"label":          l.Label,
+		"expires_at":     l.ExpiresAt,
+		"password_hash":  l.PasswordHash,
+		"max_views":      l.MaxViews,
+		"allow_download": l.AllowDownload,
+		"created_at":     l.CreatedAt,
+	}).Execute(); err != nil {
+		s.logger.WithContext(ctx).WithError(err).Error("Share link creation failed")
+		return Link{}, errors.InternalServerError("")
+	}
+	return s.link(l), nil
+}
+
+func (s service) List(ctx context.Context, albumID string) ([]Link, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return nil, err
+	}
+	var rows []entity.ShareLink
+	if err := s.db.With(ctx).NewQuery("SELECT * FROM share_links WHERE album_id={:album} ORDER BY created_at DESC").
+		Bind(dbx.Params{"album": a.ID}).
+		All(&rows); err != nil {
+		return nil, err
+	}
+	links := make([]Link, 0, len(rows))
+	for _, l := range rows {
+		links = append(links, s.link(l))
+	}
+	return links, nil
+}
+
+func (s service) Revoke(ctx context.Context, albumID, linkID string) error {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleCoOwner)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.With(ctx).NewQuery("UPDATE share_links SET revoked_at={:now} WHERE id={:id} AND album_id={:album} AND revoked_at IS NULL").
+		Bind(dbx.Params{"id": linkID, "album": a.ID, "now": time.Now().UTC().Truncate(time.Second)}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.NotFound("")
+	}
+	return nil
+}
+
+func (s service) Resolve(ctx context.Context, token, password string) (AlbumView, error) {
+	l, a, err := s.open(ctx, token)
+	if err != nil {
+		return AlbumView{}, err
+	}
+	if l.HasPassword() && !crypt.CheckPasswordHash(password, *l.PasswordHash) {
+		return AlbumView{}, errors.Unauthorized("this link is protected with a password")
+	}
+	// the view is counted atomically so concurrent visitors cannot exceed the limit
+	res, err := s.db.With(ctx).NewQuery("UPDATE share_links SET view_count = view_count + 1 WHERE id={:id} AND (max_views IS NULL OR view_count < max_views)").
+		Bind(dbx.Params{"id": l.ID}).
+		Execute()
+	if err != nil {
+		return AlbumView{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return AlbumView{}, errors.NotFound("")
+	}
+
+	items, err := s.media.AlbumMedia(ctx, a)
+	if err != nil {
+		return AlbumView{}, err
+	}
+	view := AlbumView{AllowDownload: l.AllowDownload, ExpiresAt: l.ExpiresAt, Media: make([]PublicMedia, 0, len(items))}
+	view.Album.ID, view.Album.Name, view.Album.Description = a.ID, a.Name, a.Description
+
+	var access url.Values
+	if l.RequiresAccess() {
+		expires := time.Now().Add(s.signing.DefaultTTL)
+		if l.ExpiresAt != nil && l.ExpiresAt.Before(expires) {
+			expires = *l.ExpiresAt
+		}
+		kid, sig := s.signing.Signer.Sign(accessPayload(l), expires)
+		access = url.Values{"expires": {strconv.FormatInt(expires.Unix(), 10)}, "kid": {kid}, "sig": {sig}}
+	}
+	for _, m := range items {
+		pm := PublicMedia{
+			ID:           m.ID,
+			Filename:     m.Filename,
+			ContentType:  m.ContentType,
+			Size:         m.Size,
+			CreatedAt:    m.CreatedAt,
+			Metadata:     m.Metadata,
+			ThumbnailURL: s.mediaURL(l, m, media.VariantThumbnail, access),
+			PreviewURL:   s.mediaURL(l, m, media.VariantPreview, access),
+		}
+		if l.AllowDownload {
+			pm.DownloadURL = s.mediaURL(l, m, media.VariantOriginal, access)
+		}
+		view.Media = append(view.Media, pm)
+	}
+	if l.AllowDownload {
+		view.ArchiveURL = s.archiveURL(l, access)
+	}
+	return view, nil
+}
+
+func (s service) Content(ctx context.Context, token, mediaID, variant string, access Access) (entity.Media, httprange.Content, error) {
+	l, a, err := s.authorize(ctx, token, access)
+	if err != nil {
+		return entity.Media{}, httprange.Content{}, err
+	}
+	if (variant == "" || variant == media.VariantOriginal) && !l.AllowDownload {
+		return entity.Media{}, httprange.Content{}, errors.Forbidden("downloads are not allowed for this link")
+	}
+	return s.media.PublicContent(ctx, a, mediaID, variant)
+}
+
+func (s service) Archive(ctx context.Context, token, variant string, access Access) (media.Archive, error) {
+	l, a, err := s.authorize(ctx, token, access)
+	if err != nil {
+		return media.Archive{}, err
+	}
+	if !l.AllowDownload {
+		return media.Archive{}, errors.Forbidden("downloads are not allowed for this link")
+	}
+	return s.media.PublicArchive(ctx, a, variant)
+}
+
+// authorize opens a link for a media request. Password protected and view limited links require the
+// access parameters handed out by Resolve, so the media of a view limited link stay available for a
+// short while after a counted view only.
+func (s service) authorize(ctx context.Context, token string, access Access) (entity.ShareLink, entity.Album, error) {
+	l, a, err := s.open(ctx, token)
+	if err != nil {
+		return l, a, err
+	}
+	if l.RequiresAccess() {
+		if err := s.signing.Signer.Verify(accessPayload(l), access.Expires, access.KID, access.Sig, time.Now()); err != nil {
+			if l.HasPassword() {
+				return l, a, errors.Unauthorized("this link is protected with a password")
+			}
+			return l, a, errors.NotFound("")
+		}
+	}
+	return l, a, nil
+}
+
+// open looks up an active link and its album. Unknown, revoked and expired links, and the links of albums
+// in the trash or hidden by moderators, are all reported as not found so that a link reveals nothing once
+// it stops working.
+func (s service) open(ctx context.Context, token string) (entity.ShareLink, entity.Album, error) {
+	var l entity.ShareLink
+	var a entity.Album
+	err := s.db.With(ctx).NewQuery("SELECT * FROM share_links WHERE token={:token}").
+		Bind(dbx.Params{"token": token}).
+		One(&l)
+	if err == nil && l.Active(time.Now()) {
+		err = s.db.With(ctx).NewQuery("SELECT * FROM albums WHERE id={:id} AND deleted_at IS NULL AND hidden_at IS NULL").
+			Bind(dbx.Params{"id": l.AlbumID}).
+			One(&a)
+		if err == nil {
+			return l, a, nil
+		}
+	}
+	if err != nil && !stderrors.Is(err, sql.ErrNoRows) {
+		return l, a, err
+	}
+	return l, a, errors.NotFound("")
+}
+
+func (s service) link(l entity.ShareLink) Link {
+	return Link{l, l.HasPassword(), s.signing.BaseURL + "/v1/s/" + l.Token}
+}
+
+func (s service) mediaURL(l entity.ShareLink, m entity.Media, variant string, access url.Values) string {
+	query := url.Values{"variant": {variant}}
+	for k, v := range access {
+		query[k] = v
+	}
+	return s.signing.BaseURL + "/v1/s/" + l.Token + "/media/" + url.PathEscape(m.ID) + "?" + query.Encode()
+}
+
+func (s service) archiveURL(l entity.ShareLink, access url.Values) string {
+	u := s.signing.BaseURL + "/v1/s/" + l.Token + "/archive"
+	if len(access) > 0 {
+		u += "?" + access.Encode()
+	}
+	return u
+}
+
+// accessPayload binds media access signatures to a link, so they stop working when the link is revoked.
+func accessPayload(l entity.ShareLink) string {
+	return "share\n" + l.ID
+}
+
+// generateToken returns a random URL-safe link token.
+func generateToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,107 @@
+package tag
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the tag handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Post("/media/<id>/tags", res.tagMedia)
+	r.Delete("/media/<id>/tags", res.untagMedia)
+	r.Post("/albums/<id>/tags", res.tagAlbum)
+	r.Delete("/albums/<id>/tags", res.untagAlbum)
+	r.Get("/tags", res.tags)
+	r.Get("/tags/autocomplete", res.autocomplete)
+	r.Get("/tags/<tag>/media", res.media)
+}
+
+func (r resource) tagMedia(c *routing.Context) error {
+	var req TagsRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	tags, err := r.service.TagMedia(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(tags)
+}
+
+// untagMedia removes the tag given by the tag query parameter from a media item.
+func (r resource) untagMedia(c *routing.Context) error {
+	if err := r.service.UntagMedia(c.Request.Context(), c.Param("id"), c.Query("tag")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) tagAlbum(c *routing.Context) error {
+	var req TagsRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	tags, err := r.service.TagAlbum(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(tags)
+}
+
+// untagAlbum removes the tag given by the tag query parameter from an album.
+func (r resource) untagAlbum(c *routing.Context) error {
+	if err := r.service.UntagAlbum(c.Request.Context(), c.Param("id"), c.Query("tag")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) tags(c *routing.Context) error {
+	tags, err := r.service.Tags(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(tags)
+}
+
+// autocomplete suggests tags of the current user starting with the prefix query parameter.
+func (r resource) autocomplete(c *routing.Context) error {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return errors.BadRequest("invalid limit")
+		}
+	}
+	tags, err := r.service.Autocomplete(c.Request.Context(), c.Query("prefix"), limit)
+	if err != nil {
+		return err
+	}
+	return c.Write(tags)
+}
+
+func (r resource) media(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	items, err := r.service.Media(c.Request.Context(), c.Param("tag"), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = items
+	return c.Write(pages)
+}
@@ -0,0 +1,232 @@
+// Package tag implements free-form tags on albums and media items.
+package tag
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/album"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/index"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/media"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxTagsPerRequest is the maximum number of tags that can be added at once.
+	maxTagsPerRequest = 50
+	// DefaultSuggestions is the number of autocomplete suggestions returned when no limit is requested.
+	DefaultSuggestions = 10
+	// MaxSuggestions is the maximum number of autocomplete suggestions.
+	MaxSuggestions = 50
+)
+
+// Service encapsulates the tagging logic. Tags of media items are managed by album contributors, tags of
+// albums by album editors. All tags are normalized with entity.NormalizeTag before they are stored or looked up.
+type Service interface {
+	// TagMedia adds tags to a media item and returns all of its tags. Existing tags are kept.
+	TagMedia(ctx context.Context, mediaID string, req TagsRequest) ([]string, error)
+	// UntagMedia removes a tag from a media item.
+	UntagMedia(ctx context.Context, mediaID, tag string) error
+	// TagAlbum adds tags to an album and returns all of its tags. Existing tags are kept.
+	TagAlbum(ctx context.Context, albumID string, req TagsRequest) ([]string, error)
+	// UntagAlbum removes a tag from an album.
+	UntagAlbum(ctx context.Context, albumID, tag string) error
+	// Tags returns the tags the current user attached to albums and media items that are not in the
+	// trash, together with the number of items carrying each of them, most used first.
+	Tags(ctx context.Context) ([]entity.TagCount, error)
+	// Autocomplete returns up to limit tags of the current user starting with the given prefix, most used
+	// first. A limit of zero selects DefaultSuggestions.
+	Autocomplete(ctx context.Context, prefix string, limit int) ([]entity.TagCount, error)
+	// Media returns a page of the media items carrying a tag in all albums the current user can view.
+	Media(ctx context.Context, tag string, pages *pagination.Pages) ([]entity.Media, error)
+}
+
+// TagsRequest represents a request adding tags to an album or a media item.
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// Validate validates the TagsRequest fields.
+func (m TagsRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Tags, validation.Required, validation.Length(1, maxTagsPerRequest)),
+	)
+}
+
+// normalize returns the distinct normalized tags of the request. It returns a BadRequest error if a tag
+// is empty after normalization.
+func (m TagsRequest) normalize() ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range m.Tags {
+		t = entity.NormalizeTag(t)
+		if t == "" {
+			return nil, errors.BadRequest("tags must not be empty")
+		}
+		if !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags, nil
+}
+
+type service struct {
+	db     *dbcontext.DB
+	albums album.Service
+	media  media.Service
+	index  index.Index
+	logger *logrus.Logger
+}
+
+// NewService creates a new tag service that keeps the given search index up to date.
+func NewService(db *dbcontext.DB, albums album.Service, media media.Service, index index.Index, logger *logrus.Logger) Service {
+	return service{db, albums, media, index, logger}
+}
+
+func (s service) TagMedia(ctx context.Context, mediaID string, req TagsRequest) ([]string, error) {
+	m, err := s.authorizeMedia(ctx, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.add(ctx, "media_tags", "media_id", m.ID, req, s.index.IndexMedia); err != nil {
+		return nil, err
+	}
+	return s.tags(ctx, "media_tags", "media_id", m.ID)
+}
+
+func (s service) UntagMedia(ctx context.Context, mediaID, tag string) error {
+	m, err := s.authorizeMedia(ctx, mediaID)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, "media_tags", "media_id", m.ID, tag, s.index.IndexMedia)
+}
+
+func (s service) TagAlbum(ctx context.Context, albumID string, req TagsRequest) ([]string, error) {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.add(ctx, "album_tags", "album_id", a.ID, req, s.index.IndexAlbum); err != nil {
+		return nil, err
+	}
+	return s.tags(ctx, "album_tags", "album_id", a.ID)
+}
+
+func (s service) UntagAlbum(ctx context.Context, albumID, tag string) error {
+	a, err := s.albums.Authorize(ctx, albumID, entity.RoleEditor)
+	if err != nil {
+		return err
+	}
+	return s.remove(ctx, "album_tags", "album_id", a.ID, tag, s.index.IndexAlbum)
+}
+
+// authorizeMedia returns the media item with the given ID if the current user can contribute to its album.
+func (s service) authorizeMedia(ctx context.Context, id string) (entity.Media, error) {
+	m, err := s.media.Get(ctx, id)
+	if err != nil {
+		return m, err
+	}
+	if _, err := s.albums.Authorize(ctx, m.AlbumID, entity.RoleContributor); err != nil {
+		return entity.Media{}, err
+	}
+	return m, nil
+}
+
+// add attaches the tags of the request to the item with the given ID in the given tag table and reindexes
+// the item. Tags the item already carries keep the user who added them first.
+func (s service) add(ctx context.Context, table, column, id string, req TagsRequest, reindex func(context.Context, string) error) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+	tags, err := req.normalize()
+	if err != nil {
+		return err
+	}
+	user := auth.CurrentUser(ctx).GetID()
+	now := time.Now().UTC().Truncate(time.Second)
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		for _, t := range tags {
+			if _, err := s.db.With(ctx).NewQuery("INSERT IGNORE INTO " + table + " (" + column + ", tag, user_id, created_at) VALUES ({:id}, {:tag}, {:user}, {:now})").
+				Bind(dbx.Params{"id": id, "tag": t, "user": user, "now": now}).
+				Execute(); err != nil {
+				return err
+			}
+		}
+		return reindex(ctx, id)
+	})
+}
+
+// remove removes a tag from the item with the given ID in the given tag table and reindexes the item.
+func (s service) remove(ctx context.Context, table, column, id, tag string, reindex func(context.Context, string) error) error {
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Delete(table, dbx.HashExp{column: id, "tag": entity.NormalizeTag(tag)}).Execute(); err != nil {
+			return err
+		}
+		return reindex(ctx, id)
+	})
+}
+
+// tags returns the tags of the item with the given ID in the given tag table.
+func (s service) tags(ctx context.Context, table, column, id string) ([]string, error) {
+	tags := []string{}
+	err := s.db.With(ctx).Select("tag").From(table).
+		Where(dbx.HashExp{column: id}).
+		OrderBy("tag").
+		Column(&tags)
+	return tags, err
+}
+
+// selectUserTags selects the tags the user bound to the "user" parameter attached to albums and media
+// items that are neither in the trash nor hidden by moderators, one row per tagged item.
+const selectUserTags = "SELECT t.tag FROM media_tags t JOIN media m ON m.id = t.media_id JOIN albums a ON a.id = m.album_id " +
+	"WHERE t.user_id = {:user} AND m.deleted_at IS NULL AND m.hidden_at IS NULL AND a.deleted_at IS NULL AND a.hidden_at IS NULL " +
+	"UNION ALL SELECT t.tag FROM album_tags t JOIN albums a ON a.id = t.album_id " +
+	"WHERE t.user_id = {:user} AND a.deleted_at IS NULL AND a.hidden_at IS NULL"
+
+func (s service) Tags(ctx context.Context) ([]entity.TagCount, error) {
+	tags := []entity.TagCount{}
+	err := s.db.With(ctx).NewQuery("SELECT tag, COUNT(*) AS count FROM (" + selectUserTags + ") t GROUP BY tag ORDER BY count DESC, tag").
+		Bind(dbx.Params{"user": auth.CurrentUser(ctx).GetID()}).
+		All(&tags)
+	return tags, err
+}
+
+func (s service) Autocomplete(ctx context.Context, prefix string, limit int) ([]entity.TagCount, error) {
+	if limit <= 0 {
+		limit = DefaultSuggestions
+	}
+	if limit > MaxSuggestions {
+		limit = MaxSuggestions
+	}
+	tags := []entity.TagCount{}
+	err := s.db.With(ctx).NewQuery("SELECT tag, COUNT(*) AS count FROM (" + selectUserTags + ") t WHERE tag LIKE {:prefix} " +
+		"GROUP BY tag ORDER BY count DESC, tag LIMIT {:limit}").
+		Bind(dbx.Params{"user": auth.CurrentUser(ctx).GetID(), "prefix": escapeLike(entity.NormalizeTag(prefix)) + "%", "limit": limit}).
+		All(&tags)
+	return tags, err
+}
+
+func (s service) Media(ctx context.Context, tag string, pages *pagination.Pages) ([]entity.Media, error) {
+	tag = entity.NormalizeTag(tag)
+	if tag == "" {
+		return nil, errors.BadRequest("tag must not be empty")
+	}
+	return s.media.Tagged(ctx, tag, pages)
+}
+
+// likeEscaper escapes the wildcard characters of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,44 @@
+package trash
+
+import (
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the trash handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/trash", res.list)
+	r.Post("/trash/albums/<id>/restore", res.restoreAlbum)
+	r.Post("/trash/media/<id>/restore", res.restoreMedia)
+}
+
+func (r resource) list(c *routing.Context) error {
+	t, err := r.service.List(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(t)
+}
+
+func (r resource) restoreAlbum(c *routing.Context) error {
+	a, err := r.service.RestoreAlbum(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(a)
+}
+
+func (r resource) restoreMedia(c *routing.Context) error {
+	m, err := r.service.RestoreMedia(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(m)
+}
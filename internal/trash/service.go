@@ -0,0 +1,226 @@
+// Package trash implements the trash of deleted albums and media items, which can be restored until
+// the retention period passes and they are purged for good.
+package trash
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/blob"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/quota"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the trash logic.
+type Service interface {
+	// List returns the trash of the current user: the deleted albums the user owns, and the deleted media
+	// items the user may restore.
+	List(ctx context.Context) (Trash, error)
+	// RestoreAlbum restores a deleted album of the current user together with its media.
+	RestoreAlbum(ctx context.Context, id string) (entity.Album, error)
+	// RestoreMedia restores a deleted media item. It can be restored by the user who deleted it while still a
+	// contributor of the album, by the album owner and by album editors, as long as the album itself is not
+	// in the trash.
+	RestoreMedia(ctx context.Context, id string) (entity.Media, error)
+	// Purge permanently deletes the albums and media items that were deleted before the retention period,
+	// releases their content and removes them from the storage usage of their uploaders. It returns the number of purged albums and media items.
+	Purge(ctx context.Context) (int, error)
+}
+
+// Trash lists the deleted albums and media items of a user.
+type Trash struct {
+	Albums []entity.Album `json:"albums"`
+	Media  []entity.Media `json:"media"`
+	// RetentionDays is the number of days items stay in the trash before they are purged.
+	RetentionDays int `json:"retention_days"`
+}
+
+type service struct {
+	db        *dbcontext.DB
+	blobs     blob.Service
+	quotas    quota.Service
+	retention time.Duration
+	logger    *logrus.Logger
+}
+
+// NewService creates a new trash service that keeps deleted items for the given retention period.
+func NewService(db *dbcontext.DB, blobs blob.Service, quotas quota.Service, retention time.Duration, logger *logrus.Logger) Service {
+	return service{db, blobs, quotas, retention, logger}
+}
+
+// selectMedia selects the deleted media items of albums that are neither in the trash nor hidden by
+// moderators which the user bound to the "user" parameter may restore. Users who deleted an item need to be contributors of its album still.
+const selectMedia = "SELECT m.id, m.album_id, m.owner_id, m.filename, m.caption, m.content_type, m.size, COALESCE(m.blob_hash, '') AS blob_hash, " +
+	"m.storage_key, m.created_at, m.deleted_at FROM media m JOIN albums a ON a.id = m.album_id " +
+	"LEFT JOIN album_members am ON am.album_id = a.id AND am.user_id = {:user} " +
+	"WHERE m.deleted_at IS NOT NULL AND a.deleted_at IS NULL AND a.hidden_at IS NULL " +
+	"AND (a.owner_id = {:user} OR am.role IN ({:editor}, {:co_owner}) OR (m.deleted_by = {:user} AND am.role = {:contributor})) "
+
+func (s service) List(ctx context.Context) (Trash, error) {
+	user := auth.CurrentUser(ctx)
+	t := Trash{Albums: []entity.Album{}, Media: []entity.Media{}, RetentionDays: int(s.retention.Hours() / 24)}
+	err := s.db.With(ctx).NewQuery("SELECT * FROM albums WHERE owner_id={:user} AND deleted_at IS NOT NULL ORDER BY deleted_at DESC").
+		Bind(dbx.Params{"user": user.GetID()}).
+		All(&t.Albums)
+	if err != nil {
+		return t, err
+	}
+	err = s.db.With(ctx).NewQuery(selectMedia + "ORDER BY m.deleted_at DESC").
+		Bind(dbx.Params{"user": user.GetID(), "contributor": entity.RoleContributor, "editor": entity.RoleEditor, "co_owner": entity.RoleCoOwner}).
+		All(&t.Media)
+	return t, err
+}
+
+func (s service) RestoreAlbum(ctx context.Context, id string) (entity.Album, error) {
+	user := auth.CurrentUser(ctx)
+	res, err := s.db.With(ctx).NewQuery("UPDATE albums SET deleted_at=NULL, deleted_by=NULL WHERE id={:id} AND owner_id={:user} AND deleted_at IS NOT NULL").
+		Bind(dbx.Params{"id": id, "user": user.GetID()}).
+		Execute()
+	if err != nil {
+		return entity.Album{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return entity.Album{}, errors.Forbidden("")
+	}
+	var a entity.Album
+	err = s.db.With(ctx).NewQuery("SELECT * FROM albums WHERE id={:id}").
+		Bind(dbx.Params{"id": id}).
+		One(&a)
+	a.Role = entity.RoleOwner
+	return a, err
+}
+
+func (s service) RestoreMedia(ctx context.Context, id string) (entity.Media, error) {
+	user := auth.CurrentUser(ctx)
+	var m entity.Media
+	err := s.db.With(ctx).NewQuery(selectMedia + "AND m.id={:id}").
+		Bind(dbx.Params{"id": id, "user": user.GetID(), "contributor": entity.RoleContributor, "editor": entity.RoleEditor, "co_owner": entity.RoleCoOwner}).
+		One(&m)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return m, errors.Forbidden("")
+	}
+	if err != nil {
+		return m, err
+	}
+	if _, err := s.db.With(ctx).NewQuery("UPDATE media SET deleted_at=NULL, deleted_by=NULL WHERE id={:id}").
+		Bind(dbx.Params{"id": m.ID}).
+		Execute(); err != nil {
+		return entity.Media{}, err
+	}
+	m.DeletedAt = nil
+	return m, nil
+}
+
+func (s service) Purge(ctx context.Context) (int, error) {
+	cutoff := time.Now().UTC().Add(-s.retention)
+	var albums, media []string
+	if err := s.db.With(ctx).NewQuery("SELECT id FROM albums WHERE deleted_at < {:cutoff}").
+		Bind(dbx.Params{"cutoff": cutoff}).
+		Column(&albums); err != nil {
+		return 0, err
+	}
+	if err := s.db.With(ctx).NewQuery("SELECT id FROM media WHERE deleted_at < {:cutoff}").
+		Bind(dbx.Params{"cutoff": cutoff}).
+		Column(&media); err != nil {
+		return 0, err
+	}
+
+	// every item is purged in its own transaction so that a failure only affects a single item
+	purged := 0
+	for _, id := range albums {
+		if err := s.db.Transactional(ctx, func(ctx context.Context) error { return s.purgeAlbum(ctx, id) }); err != nil {
+			s.logger.WithContext(ctx).WithError(err).WithField("album", id).Error("Failed to purge album")
+			continue
+		}
+		purged++
+	}
+	for _, id := range media {
+		if err := s.db.Transactional(ctx, func(ctx context.Context) error { return s.purgeMedia(ctx, id) }); err != nil {
+			s.logger.WithContext(ctx).WithError(err).WithField("media", id).Error("Failed to purge media")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// purgeAlbum deletes an album together with all of its media, including media that is in the trash on its own.
+func (s service) purgeAlbum(ctx context.Context, id string) error {
+	var items []entity.Media
+	if err := s.db.With(ctx).NewQuery("SELECT id, owner_id, size, COALESCE(blob_hash, '') AS blob_hash, storage_key FROM media WHERE album_id={:id}").
+		Bind(dbx.Params{"id": id}).
+		All(&items); err != nil {
+		return err
+	}
+	// media rows are removed by the foreign key cascade
+	res, err := s.db.With(ctx).NewQuery("DELETE FROM albums WHERE id={:id} AND deleted_at IS NOT NULL").
+		Bind(dbx.Params{"id": id}).
+		Execute()
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the album was restored in the meantime
+		return nil
+	}
+	for _, m := range items {
+		if err := s.release(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeMedia deletes a media item unless it was restored or purged together with its album in the meantime.
+func (s service) purgeMedia(ctx context.Context, id string) error {
+	var m entity.Media
+	err := s.db.With(ctx).NewQuery("SELECT id, owner_id, size, COALESCE(blob_hash, '') AS blob_hash, storage_key FROM media WHERE id={:id} AND deleted_at IS NOT NULL FOR UPDATE").
+		Bind(dbx.Params{"id": id}).
+		One(&m)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.With(ctx).NewQuery("DELETE FROM media WHERE id={:id}").
+		Bind(dbx.Params{"id": m.ID}).
+		Execute(); err != nil {
+		return err
+	}
+	return s.release(ctx, m)
+}
+
+// release releases the content and the storage usage of a deleted media item.
+func (s service) release(ctx context.Context, m entity.Media) error {
+	if err := s.quotas.Release(ctx, m.OwnerID, m.Size); err != nil {
+		return err
+	}
+	return s.blobs.Unlink(ctx, m)
+}
+
+// RunPurger purges the trash in the given interval until the context is canceled.
+func RunPurger(ctx context.Context, service Service, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := service.Purge(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Trash purge failed")
+		} else if n > 0 {
+			logger.WithField("count", n).Info("Purged trash")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
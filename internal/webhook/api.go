@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	routing "github.com/go-ozzo/ozzo-routing/v2"
+	"github.com/sirupsen/logrus"
+)
+
+type resource struct {
+	service Service
+	logger  *logrus.Logger
+}
+
+// RegisterHandlers registers the webhook handlers. All of them require an authenticated user.
+func RegisterHandlers(r *routing.RouteGroup, service Service, authHandler routing.Handler, logger *logrus.Logger) {
+	res := resource{service, logger}
+	r.Use(authHandler)
+	r.Get("/webhooks", res.list)
+	r.Post("/webhooks", res.create)
+	r.Get("/webhooks/<id>", res.get)
+	r.Patch("/webhooks/<id>", res.update)
+	r.Delete("/webhooks/<id>", res.delete)
+	r.Post("/webhooks/<id>/secret", res.rotateSecret)
+	r.Get("/webhooks/<id>/deliveries", res.deliveries)
+	r.Get("/webhooks/<id>/deliveries/<delivery>", res.delivery)
+	r.Post("/webhooks/<id>/deliveries/<delivery>/redeliver", res.redeliver)
+}
+
+func (r resource) list(c *routing.Context) error {
+	webhooks, err := r.service.List(c.Request.Context())
+	if err != nil {
+		return err
+	}
+	return c.Write(webhooks)
+}
+
+func (r resource) get(c *routing.Context) error {
+	webhook, err := r.service.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+func (r resource) create(c *routing.Context) error {
+	var req CreateWebhookRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	webhook, err := r.service.Create(c.Request.Context(), req)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(webhook, http.StatusCreated)
+}
+
+func (r resource) update(c *routing.Context) error {
+	var req UpdateWebhookRequest
+	if err := c.Read(&req); err != nil {
+		r.logger.WithContext(c.Request.Context()).WithField("error", err.Error()).Error("invalid request")
+		return errors.BadRequest("")
+	}
+	webhook, err := r.service.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+func (r resource) delete(c *routing.Context) error {
+	if err := r.service.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (r resource) rotateSecret(c *routing.Context) error {
+	webhook, err := r.service.RotateSecret(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.Write(webhook)
+}
+
+func (r resource) deliveries(c *routing.Context) error {
+	pages := pagination.NewFromRequest(c.Request, -1)
+	deliveries, err := r.service.Deliveries(c.Request.Context(), c.Param("id"), pages)
+	if err != nil {
+		return err
+	}
+	pages.Items = deliveries
+	return c.Write(pages)
+}
+
+func (r resource) delivery(c *routing.Context) error {
+	id, err := strconv.ParseInt(c.Param("delivery"), 10, 64)
+	if err != nil {
+		return errors.NotFound("")
+	}
+	delivery, err := r.service.Delivery(c.Request.Context(), c.Param("id"), id)
+	if err != nil {
+		return err
+	}
+	return c.Write(delivery)
+}
+
+func (r resource) redeliver(c *routing.Context) error {
+	id, err := strconv.ParseInt(c.Param("delivery"), 10, 64)
+	if err != nil {
+		return errors.NotFound("")
+	}
+	delivery, err := r.service.Redeliver(c.Request.Context(), c.Param("id"), id)
+	if err != nil {
+		return err
+	}
+	return c.WriteWithStatus(delivery, http.StatusAccepted)
+}
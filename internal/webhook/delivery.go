@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SignatureHeader carries the signature of a payload in the form "t=<unix time>,v1=<hex HMAC>". The
+	// HMAC-SHA256 of "<unix time>.<body>" is computed with the secret of the webhook, so receivers can
+	// verify the sender and reject replayed requests by checking the time.
+	SignatureHeader = "X-ShareFlow-Signature"
+	// EventHeader carries the event type.
+	EventHeader = "X-ShareFlow-Event"
+	// DeliveryHeader carries the ID of the delivery. Retries of a delivery have the same ID.
+	DeliveryHeader = "X-ShareFlow-Delivery"
+
+	// batchSize is the maximum number of deliveries sent by a single dispatch.
+	batchSize = 50
+	// workers is the number of deliveries sent concurrently.
+	workers = 8
+	// lease is how long a claimed delivery is hidden from other dispatchers. It has to exceed the client timeout.
+	lease = 2 * time.Minute
+	// maxAttempts is the number of attempts after which a delivery fails.
+	maxAttempts = 10
+	// minBackoff and maxBackoff bound the delay before a delivery is retried, which doubles with every attempt.
+	minBackoff = 30 * time.Second
+	maxBackoff = 6 * time.Hour
+	// disableAfter is how long the deliveries of a webhook have to keep failing before it is disabled.
+	disableAfter = 24 * time.Hour
+	// deliveryRetention is how long finished deliveries are kept in the delivery log.
+	deliveryRetention = 30 * 24 * time.Hour
+	// maxResponseBody is the number of bytes of a response kept in the delivery log.
+	maxResponseBody = 1024
+)
+
+// Sign returns the value of the signature header of a payload sent at the given time.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before the next attempt of a delivery that failed the given number of times.
+func backoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return maxBackoff
+	}
+	if d := minBackoff << (attempts - 1); d < maxBackoff {
+		return d
+	}
+	return maxBackoff
+}
+
+// dueDelivery is a claimed delivery together with its webhook.
+type dueDelivery struct {
+	ID        int64
+	WebhookID string
+	EventType string
+	Payload   string
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+func (s service) Dispatch(ctx context.Context) (int, error) {
+	var due []dueDelivery
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		// skipping locked rows lets several instances dispatch concurrently
+		if err := s.db.With(ctx).NewQuery("SELECT d.id, d.webhook_id, d.event_type, d.payload, d.attempts, w.url, w.secret " +
+			"FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id " +
+			"WHERE d.status = {:status} AND d.next_attempt_at <= {:now} AND w.active " +
+			"ORDER BY d.next_attempt_at LIMIT {:limit} FOR UPDATE OF d SKIP LOCKED").
+			Bind(dbx.Params{"status": entity.DeliveryPending, "now": now, "limit": batchSize}).
+			All(&due); err != nil || len(due) == 0 {
+			return err
+		}
+		ids := make([]interface{}, len(due))
+		for i, d := range due {
+			ids[i] = d.ID
+		}
+		_, err := s.db.With(ctx).Update("webhook_deliveries", dbx.Params{"next_attempt_at": now.Add(lease)}, dbx.In("id", ids...)).Execute()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	queue := make(chan dueDelivery)
+	for i := 0; i < workers && i < len(due); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				s.deliver(ctx, d)
+			}
+		}()
+	}
+	for _, d := range due {
+		queue <- d
+	}
+	close(queue)
+	wg.Wait()
+	return len(due), nil
+}
+
+// deliver sends a delivery and records the outcome. A failure to record it, or a shutdown interrupting
+// it, leaves the delivery to be retried once its lease expires.
+func (s service) deliver(ctx context.Context, d dueDelivery) {
+	attempt := entity.WebhookAttempt{DeliveryID: d.ID, Attempt: d.Attempts + 1}
+	started := time.Now().UTC()
+	status, body, err := s.send(ctx, d, started)
+	if ctx.Err() != nil {
+		// the server is shutting down; the delivery is retried once its lease expires
+		return
+	}
+	attempt.DurationMS = int(time.Since(started) / time.Millisecond)
+	attempt.CreatedAt = started.Truncate(time.Second)
+	attempt.ResponseBody = body
+	if status != 0 {
+		attempt.ResponseStatus = &status
+	}
+	if err != nil {
+		attempt.Error = truncate(err.Error(), 255)
+	} else if status < 200 || status > 299 {
+		attempt.Error = "unexpected response status " + strconv.Itoa(status)
+	}
+	if err := s.record(ctx, d, attempt); err != nil {
+		s.logger.WithError(err).WithField("delivery", d.ID).Error("Failed to record webhook delivery")
+	}
+}
+
+// send posts the payload of a delivery and returns the response status and the beginning of the response body.
+func (s service) send(ctx context.Context, d dueDelivery, at time.Time) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader([]byte(d.Payload)))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ShareFlow-Webhooks/1.0")
+	req.Header.Set(EventHeader, d.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, at, []byte(d.Payload)))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	// drain the rest so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	return res.StatusCode, truncate(string(body), maxResponseBody), nil
+}
+
+// record logs an attempt and updates the delivery and the failure state of its webhook.
+func (s service) record(ctx context.Context, d dueDelivery, attempt entity.WebhookAttempt) error {
+	return s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Insert("webhook_attempts", dbx.Params{
+			"delivery_id":     attempt.DeliveryID,
+			"attempt":         attempt.Attempt,
+			"response_status": attempt.ResponseStatus,
+			"response_body":   attempt.ResponseBody,
+			"error":           attempt.Error,
+			"duration_ms":     attempt.DurationMS,
+			"created_at":      attempt.CreatedAt,
+		}).Execute(); err != nil {
+			return err
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		params := dbx.Params{"attempts": attempt.Attempt, "response_status": attempt.ResponseStatus, "error": attempt.Error}
+		if attempt.Error == "" {
+			params["status"], params["next_attempt_at"], params["delivered_at"] = entity.DeliverySucceeded, nil, now
+			if _, err := s.db.With(ctx).Update("webhook_deliveries", params, dbx.HashExp{"id": d.ID}).Execute(); err != nil {
+				return err
+			}
+			_, err := s.db.With(ctx).Update("webhooks", dbx.Params{"failing_since": nil}, dbx.HashExp{"id": d.WebhookID}).Execute()
+			return err
+		}
+
+		if attempt.Attempt >= maxAttempts {
+			params["status"], params["next_attempt_at"] = entity.DeliveryFailed, nil
+		} else {
+			params["next_attempt_at"] = now.Add(backoff(attempt.Attempt))
+		}
+		if _, err := s.db.With(ctx).Update("webhook_deliveries", params, dbx.HashExp{"id": d.ID}).Execute(); err != nil {
+			return err
+		}
+		if _, err := s.db.With(ctx).NewQuery("UPDATE webhooks SET failing_since = COALESCE(failing_since, {:now}) WHERE id = {:id}").
+			Bind(dbx.Params{"id": d.WebhookID, "now": now}).
+			Execute(); err != nil {
+			return err
+		}
+		res, err := s.db.With(ctx).NewQuery("UPDATE webhooks SET active = FALSE, disabled_at = {:now} " +
+			"WHERE id = {:id} AND active AND failing_since <= {:since}").
+			Bind(dbx.Params{"id": d.WebhookID, "now": now, "since": now.Add(-disableAfter)}).
+			Execute()
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			s.logger.WithField("webhook", d.WebhookID).Warn("Disabled webhook after persistent delivery failures")
+		}
+		return nil
+	})
+}
+
+func (s service) Cleanup(ctx context.Context) (int, error) {
+	res, err := s.db.With(ctx).NewQuery("DELETE FROM webhook_deliveries WHERE status <> {:pending} AND created_at < {:before}").
+		Bind(dbx.Params{"pending": entity.DeliveryPending, "before": time.Now().UTC().Add(-deliveryRetention)}).
+		Execute()
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// RunDispatcher sends the due deliveries in the given interval until the context is canceled. A full
+// batch is followed by the next one right away, and the delivery log is cleaned up once an hour.
+func RunDispatcher(ctx context.Context, service Service, interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var cleaned time.Time
+	for {
+		n, err := service.Dispatch(ctx)
+		if err != nil {
+			logger.WithError(err).Error("Webhook dispatch failed")
+		}
+		if time.Since(cleaned) >= time.Hour {
+			cleaned = time.Now()
+			if n, err := service.Cleanup(ctx); err != nil {
+				logger.WithError(err).Error("Webhook delivery cleanup failed")
+			} else if n > 0 {
+				logger.WithField("count", n).Info("Deleted expired webhook deliveries")
+			}
+		}
+		if n == batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// truncate shortens a string to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		{"event", "secret", `{"type":"album.created"}`, "t=1735732800,v1=852aeabb2193e24dd3ba63b0e7037120c1af7f31a7443b2917dc6925eb98d67c"},
+		{"empty body", "secret", "", "t=1735732800,v1=1631c94a3a69a4aa0cfb82026f976b88745a3cd71cea0a65467f985023b28726"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, at, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if Sign("secret", at, []byte("a")) == Sign("other", at, []byte("a")) {
+		t.Error("Sign() does not depend on the secret")
+	}
+	if Sign("secret", at, []byte("a")) == Sign("secret", at.Add(time.Second), []byte("a")) {
+		t.Error("Sign() does not depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{9, 128 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{20, 6 * time.Hour},
+		{21, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			if got := backoff(tt.attempts); got != tt.want {
+				t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+// Package webhook implements webhooks, which send the events of their owners to external endpoints as
+// signed HTTP requests, so that integrations can react to changes without polling.
+//
+// Events are recorded as deliveries in the transaction of the change they are about and sent
+// asynchronously by the dispatcher. Failed deliveries are retried with an exponential backoff, and a
+// webhook whose deliveries keep failing is disabled until its owner enables it again.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MrPomajdor/ShareFlowAPI/internal/auth"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/entity"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/errors"
+	"github.com/MrPomajdor/ShareFlowAPI/internal/events"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/dbcontext"
+	"github.com/MrPomajdor/ShareFlowAPI/pkg/pagination"
+	dbx "github.com/go-ozzo/ozzo-dbx"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Service encapsulates the webhook logic.
+type Service interface {
+	events.Sink
+	// List returns the webhooks of the current user.
+	List(ctx context.Context) ([]entity.Webhook, error)
+	// Get returns a webhook of the current user.
+	Get(ctx context.Context, id string) (entity.Webhook, error)
+	// Create registers a webhook for the current user. Only admins can create global webhooks. The returned
+	// webhook contains the secret that signs the payloads.
+	Create(ctx context.Context, req CreateWebhookRequest) (entity.Webhook, error)
+	// Update changes a webhook of the current user. Enabling a webhook clears its failure state.
+	Update(ctx context.Context, id string, req UpdateWebhookRequest) (entity.Webhook, error)
+	// Delete deletes a webhook of the current user together with its deliveries.
+	Delete(ctx context.Context, id string) error
+	// RotateSecret replaces the secret of a webhook of the current user and returns the webhook with the new secret.
+	RotateSecret(ctx context.Context, id string) (entity.Webhook, error)
+	// Deliveries returns a page of the deliveries of a webhook of the current user, newest first.
+	Deliveries(ctx context.Context, id string, pages *pagination.Pages) ([]entity.WebhookDelivery, error)
+	// Delivery returns a delivery of a webhook of the current user together with its attempts.
+	Delivery(ctx context.Context, id string, deliveryID int64) (entity.WebhookDelivery, error)
+	// Redeliver sends the event of a delivery again as a new delivery.
+	Redeliver(ctx context.Context, id string, deliveryID int64) (entity.WebhookDelivery, error)
+	// Dispatch sends the deliveries that are due and returns their number.
+	Dispatch(ctx context.Context) (int, error)
+	// Cleanup deletes the finished deliveries older than the retention period and returns their number.
+	Cleanup(ctx context.Context) (int, error)
+}
+
+// CreateWebhookRequest represents a webhook registration request.
+type CreateWebhookRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Global webhooks receive the events of all users. Only admins can create them.
+	Global bool `json:"global"`
+}
+
+// Validate validates the CreateWebhookRequest fields.
+func (m CreateWebhookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.URL, validation.Required, validation.Length(0, 2048), validation.By(validateURL)),
+		validation.Field(&m.EventTypes, validation.Required, validation.Each(validation.By(validateEventType))),
+	)
+}
+
+// UpdateWebhookRequest represents a webhook update request. Fields left nil are not changed.
+type UpdateWebhookRequest struct {
+	URL        *string  `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Active     *bool    `json:"active"`
+}
+
+// Validate validates the UpdateWebhookRequest fields.
+func (m UpdateWebhookRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.URL, validation.NilOrNotEmpty, validation.Length(0, 2048), validation.By(validateURL)),
+		validation.Field(&m.EventTypes, validation.NilOrNotEmpty, validation.Each(validation.By(validateEventType))),
+	)
+}
+
+func validateURL(value interface{}) error {
+	s, _ := value.(string)
+	if p, ok := value.(*string); ok && p != nil {
+		s = *p
+	}
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return stderrors.New("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func validateEventType(value interface{}) error {
+	if t, _ := value.(string); !events.ValidType(t) {
+		return fmt.Errorf("must be one of %s", strings.Join(events.Types, ", "))
+	}
+	return nil
+}
+
+type service struct {
+	db     *dbcontext.DB
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewService creates a new webhook service that sends the deliveries with the given client.
+func NewService(db *dbcontext.DB, client *http.Client, logger *logrus.Logger) Service {
+	return service{db, client, logger}
+}
+
+// selectWebhook selects the webhook columns, leaving out the secret.
+var selectWebhook = []string{"id", "owner_id", "url", "global", "active", "failing_since", "disabled_at", "created_at", "updated_at"}
+
+// selectDelivery selects the delivery columns, leaving out the payload.
+var selectDelivery = []string{"id", "webhook_id", "event_id", "event_type", "status", "attempts", "redelivery_of",
+	"response_status", "error", "next_attempt_at", "delivered_at", "created_at"}
+
+func (s service) List(ctx context.Context) ([]entity.Webhook, error) {
+	webhooks := []entity.Webhook{}
+	if err := s.db.With(ctx).Select(selectWebhook...).
+		From("webhooks").
+		Where(dbx.HashExp{"owner_id": auth.CurrentUser(ctx).GetID()}).
+		OrderBy("created_at", "id").
+		All(&webhooks); err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		if err := s.loadEventTypes(ctx, &webhooks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return webhooks, nil
+}
+
+func (s service) Get(ctx context.Context, id string) (entity.Webhook, error) {
+	var w entity.Webhook
+	err := s.db.With(ctx).Select(selectWebhook...).
+		From("webhooks").
+		Where(dbx.HashExp{"id": id, "owner_id": auth.CurrentUser(ctx).GetID()}).
+		One(&w)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return w, errors.NotFound("")
+	}
+	if err != nil {
+		return w, err
+	}
+	return w, s.loadEventTypes(ctx, &w)
+}
+
+func (s service) loadEventTypes(ctx context.Context, w *entity.Webhook) error {
+	w.EventTypes = []string{}
+	return s.db.With(ctx).Select("event_type").
+		From("webhook_event_types").
+		Where(dbx.HashExp{"webhook_id": w.ID}).
+		OrderBy("event_type").
+		Column(&w.EventTypes)
+}
+
+func (s service) Create(ctx context.Context, req CreateWebhookRequest) (entity.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Webhook{}, err
+	}
+	user := auth.CurrentUser(ctx).GetID()
+	if req.Global {
+		admin, err := s.isAdmin(ctx, user)
+		if err != nil {
+			return entity.Webhook{}, err
+		}
+		if !admin {
+			return entity.Webhook{}, errors.Forbidden("only admins can create global webhooks")
+		}
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	id := entity.GenerateID()
+	now := time.Now().UTC().Truncate(time.Second)
+	err = s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Insert("webhooks", dbx.Params{
+			"id":         id,
+			"owner_id":   user,
+			"url":        req.URL,
+			"secret":     secret,
+			"global":     req.Global,
+			"active":     true,
+			"created_at": now,
+			"updated_at": now,
+		}).Execute(); err != nil {
+			return err
+		}
+		return s.setEventTypes(ctx, id, req.EventTypes)
+	})
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	w, err := s.Get(ctx, id)
+	w.Secret = secret
+	return w, err
+}
+
+func (s service) setEventTypes(ctx context.Context, id string, types []string) error {
+	if _, err := s.db.With(ctx).Delete("webhook_event_types", dbx.HashExp{"webhook_id": id}).Execute(); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, t := range types {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		if _, err := s.db.With(ctx).Insert("webhook_event_types", dbx.Params{"webhook_id": id, "event_type": t}).Execute(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s service) isAdmin(ctx context.Context, userID int) (bool, error) {
+	var admin bool
+	err := s.db.With(ctx).NewQuery("SELECT is_admin FROM users WHERE id={:id}").
+		Bind(dbx.Params{"id": userID}).
+		Row(&admin)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return admin, err
+}
+
+func (s service) Update(ctx context.Context, id string, req UpdateWebhookRequest) (entity.Webhook, error) {
+	if err := req.Validate(); err != nil {
+		return entity.Webhook{}, err
+	}
+	if _, err := s.Get(ctx, id); err != nil {
+		return entity.Webhook{}, err
+	}
+	params := dbx.Params{"updated_at": time.Now().UTC().Truncate(time.Second)}
+	if req.URL != nil {
+		params["url"] = *req.URL
+	}
+	if req.Active != nil {
+		params["active"] = *req.Active
+		if *req.Active {
+			params["failing_since"], params["disabled_at"] = nil, nil
+		}
+	}
+	err := s.db.Transactional(ctx, func(ctx context.Context) error {
+		if _, err := s.db.With(ctx).Update("webhooks", params, dbx.HashExp{"id": id}).Execute(); err != nil {
+			return err
+		}
+		if req.EventTypes != nil {
+			return s.setEventTypes(ctx, id, req.EventTypes)
+		}
+		return nil
+	})
+	if err != nil {
+		return entity.Webhook{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (s service) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	_, err := s.db.With(ctx).Delete("webhooks", dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+func (s service) RotateSecret(ctx context.Context, id string) (entity.Webhook, error) {
+	w, err := s.Get(ctx, id)
+	if err != nil {
+		return w, err
+	}
+	secret, err := generateSecret()
+	if err != nil {
+		return w, err
+	}
+	if _, err := s.db.With(ctx).Update("webhooks", dbx.Params{"secret": secret, "updated_at": time.Now().UTC().Truncate(time.Second)},
+		dbx.HashExp{"id": id}).Execute(); err != nil {
+		return w, err
+	}
+	w, err = s.Get(ctx, id)
+	w.Secret = secret
+	return w, err
+}
+
+func (s service) Deliveries(ctx context.Context, id string, pages *pagination.Pages) ([]entity.WebhookDelivery, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	var total int
+	if err := s.db.With(ctx).Select("COUNT(*)").From("webhook_deliveries").Where(dbx.HashExp{"webhook_id": id}).Row(&total); err != nil {
+		return nil, err
+	}
+	*pages = *pagination.New(pages.Page, pages.PerPage, total)
+
+	deliveries := []entity.WebhookDelivery{}
+	err := s.db.With(ctx).Select(selectDelivery...).
+		From("webhook_deliveries").
+		Where(dbx.HashExp{"webhook_id": id}).
+		OrderBy("id DESC").
+		Offset(int64(pages.Offset())).
+		Limit(int64(pages.Limit())).
+		All(&deliveries)
+	return deliveries, err
+}
+
+func (s service) Delivery(ctx context.Context, id string, deliveryID int64) (entity.WebhookDelivery, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+	var d entity.WebhookDelivery
+	err := s.db.With(ctx).Select(append(selectDelivery, "payload")...).
+		From("webhook_deliveries").
+		Where(dbx.HashExp{"id": deliveryID, "webhook_id": id}).
+		One(&d)
+	if stderrors.Is(err, sql.ErrNoRows) {
+		return d, errors.NotFound("")
+	}
+	if err != nil {
+		return d, err
+	}
+	d.Log = []entity.WebhookAttempt{}
+	err = s.db.With(ctx).Select().
+		From("webhook_attempts").
+		Where(dbx.HashExp{"delivery_id": deliveryID}).
+		OrderBy("attempt").
+		All(&d.Log)
+	return d, err
+}
+
+func (s service) Redeliver(ctx context.Context, id string, deliveryID int64) (entity.WebhookDelivery, error) {
+	original, err := s.Delivery(ctx, id, deliveryID)
+	if err != nil {
+		return original, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	res, err := s.db.With(ctx).Insert("webhook_deliveries", dbx.Params{
+		"webhook_id":      id,
+		"event_id":        original.EventID,
+		"event_type":      original.EventType,
+		"payload":         original.Payload,
+		"status":          entity.DeliveryPending,
+		"redelivery_of":   original.ID,
+		"next_attempt_at": now,
+		"created_at":      now,
+	}).Execute()
+	if err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return entity.WebhookDelivery{}, err
+	}
+	return s.Delivery(ctx, id, newID)
+}
+
+// envelope is the payload sent to webhooks.
+type envelope struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Receive records a delivery of an event for every active webhook subscribed to its type that belongs to
+// one of the recipients, and for every global webhook of an admin.
+func (s service) Receive(ctx context.Context, eventType string, payload json.RawMessage, users []int) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	e := envelope{ID: entity.GenerateID(), Type: eventType, CreatedAt: now, Data: payload}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	params := dbx.Params{"event": e.ID, "type": eventType, "payload": string(body), "status": entity.DeliveryPending, "now": now}
+	recipients := "FALSE"
+	if len(users) > 0 {
+		placeholders := make([]string, len(users))
+		for i, user := range users {
+			name := fmt.Sprintf("user%d", i)
+			params[name], placeholders[i] = user, "{:"+name+"}"
+		}
+		recipients = "w.owner_id IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	_, err = s.db.With(ctx).NewQuery("INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at) " +
+		"SELECT w.id, {:event}, {:type}, {:payload}, {:status}, {:now}, {:now} FROM webhooks w " +
+		"JOIN webhook_event_types t ON t.webhook_id = w.id AND t.event_type = {:type} " +
+		"JOIN users u ON u.id = w.owner_id " +
+		"WHERE w.active AND ((w.global AND u.is_admin) OR (NOT w.global AND " + recipients + "))").
+		Bind(params).
+		Execute()
+	return err
+}
+
+// generateSecret returns a random webhook signing secret.
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,21 @@
+CREATE TABLE `media_tags` (
+  `media_id` CHAR(36) NOT NULL,
+  `tag` VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+  `user_id` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`media_id`, `tag`),
+  KEY `idx_media_tags_tag` (`tag`),
+  KEY `idx_media_tags_user` (`user_id`, `tag`),
+  CONSTRAINT `fk_media_tags_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `album_tags` (
+  `album_id` CHAR(36) NOT NULL,
+  `tag` VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+  `user_id` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`album_id`, `tag`),
+  KEY `idx_album_tags_tag` (`tag`),
+  KEY `idx_album_tags_user` (`user_id`, `tag`),
+  CONSTRAINT `fk_album_tags_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `media_search_terms`;
+DROP TABLE IF EXISTS `album_search_terms`;
@@ -0,0 +1,21 @@
+CREATE TABLE `album_search_terms` (
+  `album_id` CHAR(36) NOT NULL,
+  `field` VARCHAR(16) NOT NULL,
+  `position` INT NOT NULL,
+  `term` VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+  `weight` SMALLINT NOT NULL,
+  PRIMARY KEY (`album_id`, `field`, `position`),
+  KEY `idx_album_search_terms_term` (`term`, `album_id`),
+  CONSTRAINT `fk_album_search_terms_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `media_search_terms` (
+  `media_id` CHAR(36) NOT NULL,
+  `field` VARCHAR(16) NOT NULL,
+  `position` INT NOT NULL,
+  `term` VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+  `weight` SMALLINT NOT NULL,
+  PRIMARY KEY (`media_id`, `field`, `position`),
+  KEY `idx_media_search_terms_term` (`term`, `media_id`),
+  CONSTRAINT `fk_media_search_terms_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+ALTER TABLE `albums`
+  DROP COLUMN `cover_media_id`,
+  DROP COLUMN `sort_mode`;
+
+ALTER TABLE `media`
+  DROP KEY `idx_media_album_sort`,
+  DROP COLUMN `sort_key`;
@@ -0,0 +1,7 @@
+ALTER TABLE `media`
+  ADD COLUMN `sort_key` VARCHAR(255) CHARACTER SET ascii COLLATE ascii_bin NULL,
+  ADD KEY `idx_media_album_sort` (`album_id`, `sort_key`);
+
+ALTER TABLE `albums`
+  ADD COLUMN `sort_mode` VARCHAR(16) NOT NULL DEFAULT 'captured',
+  ADD COLUMN `cover_media_id` CHAR(36) NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE `albums`
+  ADD COLUMN `rules` JSON NULL;
@@ -0,0 +1,36 @@
+CREATE TABLE `follows` (
+  `follower_id` INT NOT NULL,
+  `followee_id` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`follower_id`, `followee_id`),
+  KEY `idx_follows_followee` (`followee_id`, `created_at`)
+);
+
+-- uploads of a burst share a single activity whose count grows
+CREATE TABLE `activities` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `actor_id` INT NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `album_id` CHAR(36) NOT NULL,
+  `media_id` CHAR(36) NULL,
+  `comment_id` CHAR(36) NULL,
+  `count` INT NOT NULL DEFAULT 1,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_activities_actor` (`actor_id`, `type`, `album_id`, `updated_at`),
+  CONSTRAINT `fk_activities_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
+
+-- the feeds of the followers, written when an activity is published
+CREATE TABLE `feed_items` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `user_id` INT NOT NULL,
+  `activity_id` BIGINT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_feed_items_activity` (`user_id`, `activity_id`),
+  KEY `idx_feed_items_user` (`user_id`, `id`),
+  KEY `idx_feed_items_activity` (`activity_id`),
+  CONSTRAINT `fk_feed_items_activity` FOREIGN KEY (`activity_id`) REFERENCES `activities` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,26 @@
+CREATE TABLE `notifications` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `user_id` INT NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `actor_id` INT NULL,
+  `album_id` CHAR(36) NULL,
+  `media_id` CHAR(36) NULL,
+  `comment_id` CHAR(36) NULL,
+  `detail` VARCHAR(255) NOT NULL DEFAULT '',
+  `read_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_notifications_user` (`user_id`, `read_at`, `id`),
+  KEY `idx_notifications_created` (`created_at`),
+  CONSTRAINT `fk_notifications_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_notifications_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_notifications_comment` FOREIGN KEY (`comment_id`) REFERENCES `comments` (`id`) ON DELETE CASCADE
+);
+
+-- types a user turned off; a missing row means the type is enabled
+CREATE TABLE `notification_preferences` (
+  `user_id` INT NOT NULL,
+  `type` VARCHAR(32) NOT NULL,
+  `enabled` TINYINT(1) NOT NULL,
+  PRIMARY KEY (`user_id`, `type`)
+);
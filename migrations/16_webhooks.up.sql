@@ -0,0 +1,58 @@
+CREATE TABLE `webhooks` (
+  `id` CHAR(36) NOT NULL,
+  `owner_id` INT NOT NULL,
+  `url` VARCHAR(2048) NOT NULL,
+  `secret` VARCHAR(80) NOT NULL,
+  `global` TINYINT(1) NOT NULL DEFAULT 0,
+  `active` TINYINT(1) NOT NULL DEFAULT 1,
+  `failing_since` DATETIME NULL,
+  `disabled_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_webhooks_owner` (`owner_id`)
+);
+
+CREATE TABLE `webhook_event_types` (
+  `webhook_id` CHAR(36) NOT NULL,
+  `event_type` VARCHAR(32) NOT NULL,
+  PRIMARY KEY (`webhook_id`, `event_type`),
+  KEY `idx_webhook_event_types_type` (`event_type`),
+  CONSTRAINT `fk_webhook_event_types_webhook` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`id`) ON DELETE CASCADE
+);
+
+-- the payload is stored as sent, so retries and redeliveries repeat the original event
+CREATE TABLE `webhook_deliveries` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `webhook_id` CHAR(36) NOT NULL,
+  `event_id` CHAR(36) NOT NULL,
+  `event_type` VARCHAR(32) NOT NULL,
+  `payload` MEDIUMTEXT NOT NULL,
+  `status` VARCHAR(16) NOT NULL,
+  `attempts` INT NOT NULL DEFAULT 0,
+  `redelivery_of` BIGINT NULL,
+  `response_status` INT NULL,
+  `error` VARCHAR(255) NOT NULL DEFAULT '',
+  `next_attempt_at` DATETIME NULL,
+  `delivered_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_webhook_deliveries_webhook` (`webhook_id`, `id`),
+  KEY `idx_webhook_deliveries_due` (`status`, `next_attempt_at`),
+  KEY `idx_webhook_deliveries_created` (`created_at`),
+  CONSTRAINT `fk_webhook_deliveries_webhook` FOREIGN KEY (`webhook_id`) REFERENCES `webhooks` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `webhook_attempts` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `delivery_id` BIGINT NOT NULL,
+  `attempt` INT NOT NULL,
+  `response_status` INT NULL,
+  `response_body` VARCHAR(1024) NOT NULL DEFAULT '',
+  `error` VARCHAR(255) NOT NULL DEFAULT '',
+  `duration_ms` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_webhook_attempts_delivery` (`delivery_id`, `attempt`),
+  CONSTRAINT `fk_webhook_attempts_delivery` FOREIGN KEY (`delivery_id`) REFERENCES `webhook_deliveries` (`id`) ON DELETE CASCADE
+);
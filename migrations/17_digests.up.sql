@@ -0,0 +1,7 @@
+-- users without a row receive the default frequency
+CREATE TABLE `digest_settings` (
+  `user_id` INT NOT NULL,
+  `frequency` VARCHAR(16) NOT NULL,
+  `last_sent_at` DATETIME NULL,
+  PRIMARY KEY (`user_id`)
+);
@@ -0,0 +1,15 @@
+-- an endpoint belongs to a single user; the hash keeps the unique key short
+CREATE TABLE `push_subscriptions` (
+  `id` CHAR(36) NOT NULL,
+  `user_id` INT NOT NULL,
+  `platform` VARCHAR(16) NOT NULL,
+  `endpoint` VARCHAR(4096) NOT NULL,
+  `endpoint_hash` CHAR(64) NOT NULL,
+  `p256dh` VARCHAR(128) NOT NULL DEFAULT '',
+  `auth` VARCHAR(64) NOT NULL DEFAULT '',
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `last_used_at` DATETIME NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_push_subscriptions_endpoint` (`platform`, `endpoint_hash`),
+  KEY `idx_push_subscriptions_user` (`user_id`, `created_at`)
+);
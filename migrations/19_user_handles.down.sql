@@ -0,0 +1,3 @@
+ALTER TABLE `albums` DROP KEY `idx_albums_public`, DROP COLUMN `public`;
+DROP TABLE IF EXISTS `handle_redirects`;
+ALTER TABLE `users` DROP KEY `uq_users_handle`, DROP COLUMN `handle`, DROP COLUMN `handle_normalized`, DROP COLUMN `handle_changed_at`;
@@ -0,0 +1,23 @@
+-- existing users get a handle derived from their ID, which they can change
+ALTER TABLE `users`
+  ADD COLUMN `handle` VARCHAR(30) NULL,
+  ADD COLUMN `handle_normalized` VARCHAR(30) NULL,
+  ADD COLUMN `handle_changed_at` DATETIME NULL;
+UPDATE `users` SET `handle` = CONCAT('user', `id`), `handle_normalized` = CONCAT('user', `id`);
+ALTER TABLE `users`
+  MODIFY `handle` VARCHAR(30) NOT NULL,
+  MODIFY `handle_normalized` VARCHAR(30) NOT NULL,
+  ADD UNIQUE KEY `uq_users_handle` (`handle_normalized`);
+
+-- old handles keep pointing to their user for a grace period and cannot be claimed by others meanwhile
+CREATE TABLE `handle_redirects` (
+  `handle` VARCHAR(30) NOT NULL,
+  `user_id` INT NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`handle`),
+  KEY `idx_handle_redirects_user` (`user_id`)
+);
+
+ALTER TABLE `albums`
+  ADD COLUMN `public` TINYINT(1) NOT NULL DEFAULT 0,
+  ADD KEY `idx_albums_public` (`owner_id`, `public`);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `media_metadata`;
+DROP TABLE IF EXISTS `media`;
+DROP TABLE IF EXISTS `albums`;
@@ -0,0 +1,45 @@
+CREATE TABLE `albums` (
+  `id` CHAR(36) NOT NULL,
+  `owner_id` INT NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `description` TEXT NOT NULL,
+  `keep_location` TINYINT(1) NOT NULL DEFAULT 0,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_albums_owner` (`owner_id`)
+);
+
+CREATE TABLE `media` (
+  `id` CHAR(36) NOT NULL,
+  `album_id` CHAR(36) NOT NULL,
+  `owner_id` INT NOT NULL,
+  `filename` VARCHAR(255) NOT NULL,
+  `content_type` VARCHAR(127) NOT NULL,
+  `size` BIGINT NOT NULL,
+  `storage_key` VARCHAR(255) NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_media_album` (`album_id`),
+  CONSTRAINT `fk_media_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `media_metadata` (
+  `media_id` CHAR(36) NOT NULL,
+  `camera_make` VARCHAR(127) NOT NULL DEFAULT '',
+  `camera_model` VARCHAR(127) NOT NULL DEFAULT '',
+  `lens_model` VARCHAR(127) NOT NULL DEFAULT '',
+  `exposure_time` VARCHAR(31) NOT NULL DEFAULT '',
+  `f_number` DOUBLE NULL,
+  `iso` INT NULL,
+  `focal_length` DOUBLE NULL,
+  `captured_at` DATETIME NULL,
+  `latitude` DOUBLE NULL,
+  `longitude` DOUBLE NULL,
+  `altitude` DOUBLE NULL,
+  PRIMARY KEY (`media_id`),
+  KEY `idx_media_metadata_camera` (`camera_model`),
+  KEY `idx_media_metadata_lens` (`lens_model`),
+  KEY `idx_media_metadata_captured` (`captured_at`),
+  CONSTRAINT `fk_media_metadata_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- a block keeps the blocked user away from the blocker, a mute only hides the muted user from the muter
+CREATE TABLE `user_blocks` (
+  `user_id` INT NOT NULL,
+  `blocked_id` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`user_id`, `blocked_id`),
+  KEY `idx_user_blocks_blocked` (`blocked_id`)
+);
+
+CREATE TABLE `user_mutes` (
+  `user_id` INT NOT NULL,
+  `muted_id` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`user_id`, `muted_id`)
+);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS `moderation_log`;
+DROP TABLE IF EXISTS `moderation_actions`;
+DROP TABLE IF EXISTS `reports`;
+ALTER TABLE `comments` DROP COLUMN `hidden_at`;
+ALTER TABLE `media` DROP COLUMN `hidden_at`;
+ALTER TABLE `albums` DROP COLUMN `hidden_at`;
+ALTER TABLE `users` DROP COLUMN `is_moderator`, DROP COLUMN `hidden_at`, DROP COLUMN `suspended_at`, DROP COLUMN `suspended_until`;
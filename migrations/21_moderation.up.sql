@@ -0,0 +1,65 @@
+-- hidden_at and the suspension columns mirror the moderation actions in effect, so that access checks do
+-- not have to consult the actions
+ALTER TABLE `users`
+  ADD COLUMN `is_moderator` TINYINT(1) NOT NULL DEFAULT 0,
+  ADD COLUMN `hidden_at` DATETIME NULL,
+  ADD COLUMN `suspended_at` DATETIME NULL,
+  ADD COLUMN `suspended_until` DATETIME NULL;
+ALTER TABLE `albums` ADD COLUMN `hidden_at` DATETIME NULL;
+ALTER TABLE `media` ADD COLUMN `hidden_at` DATETIME NULL;
+ALTER TABLE `comments` ADD COLUMN `hidden_at` DATETIME NULL;
+
+CREATE TABLE `reports` (
+  `id` CHAR(36) NOT NULL,
+  `reporter_id` INT NOT NULL,
+  `target_type` VARCHAR(16) NOT NULL,
+  `target_id` VARCHAR(36) NOT NULL,
+  `target_user_id` INT NOT NULL,
+  `reason` VARCHAR(32) NOT NULL,
+  `details` TEXT NOT NULL,
+  `snapshot` TEXT NOT NULL,
+  `status` VARCHAR(16) NOT NULL DEFAULT 'open',
+  `resolution_note` TEXT NOT NULL,
+  `resolved_by` INT NULL,
+  `resolved_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_reports_status` (`status`, `created_at`),
+  KEY `idx_reports_target` (`target_type`, `target_id`),
+  KEY `idx_reports_reporter` (`reporter_id`, `created_at`)
+);
+
+CREATE TABLE `moderation_actions` (
+  `id` CHAR(36) NOT NULL,
+  `report_id` CHAR(36) NULL,
+  `moderator_id` INT NOT NULL,
+  `type` VARCHAR(16) NOT NULL,
+  `target_type` VARCHAR(16) NOT NULL,
+  `target_id` VARCHAR(36) NOT NULL,
+  `target_user_id` INT NOT NULL,
+  `message` TEXT NOT NULL,
+  `note` TEXT NOT NULL,
+  `expires_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `reverted_at` DATETIME NULL,
+  `reverted_by` INT NULL,
+  `revert_note` TEXT NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_moderation_actions_report` (`report_id`),
+  KEY `idx_moderation_actions_target` (`target_type`, `target_id`, `type`),
+  KEY `idx_moderation_actions_user` (`target_user_id`, `created_at`)
+);
+
+-- every decision of a moderator, kept even when the decision is reverted
+CREATE TABLE `moderation_log` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT,
+  `moderator_id` INT NOT NULL,
+  `event` VARCHAR(32) NOT NULL,
+  `report_id` CHAR(36) NULL,
+  `action_id` CHAR(36) NULL,
+  `note` TEXT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_moderation_log_report` (`report_id`),
+  KEY `idx_moderation_log_action` (`action_id`)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE `comment_search_terms` (
+  `comment_id` CHAR(36) NOT NULL,
+  `field` VARCHAR(16) NOT NULL,
+  `position` INT NOT NULL,
+  `term` VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+  `weight` SMALLINT NOT NULL,
+  PRIMARY KEY (`comment_id`, `field`, `position`),
+  KEY `idx_comment_search_terms_term` (`term`, `comment_id`),
+  CONSTRAINT `fk_comment_search_terms_comment` FOREIGN KEY (`comment_id`) REFERENCES `comments` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+ALTER TABLE `media` ADD COLUMN `caption` VARCHAR(2000) NOT NULL DEFAULT '' AFTER `filename`;
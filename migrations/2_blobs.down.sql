@@ -0,0 +1,2 @@
+ALTER TABLE `media` DROP KEY `idx_media_blob`, DROP COLUMN `blob_hash`;
+DROP TABLE IF EXISTS `blobs`;
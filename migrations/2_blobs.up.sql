@@ -0,0 +1,13 @@
+CREATE TABLE `blobs` (
+  `hash` CHAR(64) NOT NULL,
+  `size` BIGINT NOT NULL,
+  `content_type` VARCHAR(127) NOT NULL,
+  `storage_key` VARCHAR(255) NOT NULL,
+  `ref_count` INT NOT NULL DEFAULT 0,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`hash`)
+);
+
+-- media uploaded before deduplication keeps its own object and has no blob
+ALTER TABLE `media` ADD COLUMN `blob_hash` CHAR(64) NULL AFTER `size`,
+  ADD KEY `idx_media_blob` (`blob_hash`);
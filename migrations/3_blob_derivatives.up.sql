@@ -0,0 +1,10 @@
+CREATE TABLE `blob_derivatives` (
+  `blob_hash` CHAR(64) NOT NULL,
+  `name` VARCHAR(32) NOT NULL,
+  `size` BIGINT NOT NULL,
+  `content_type` VARCHAR(127) NOT NULL,
+  `storage_key` VARCHAR(255) NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`blob_hash`, `name`),
+  CONSTRAINT `fk_blob_derivatives_blob` FOREIGN KEY (`blob_hash`) REFERENCES `blobs` (`hash`) ON DELETE CASCADE
+);
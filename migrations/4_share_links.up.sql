@@ -0,0 +1,18 @@
+CREATE TABLE `share_links` (
+  `id` CHAR(36) NOT NULL,
+  `album_id` CHAR(36) NOT NULL,
+  `created_by` INT NOT NULL,
+  `token` VARCHAR(64) NOT NULL,
+  `label` VARCHAR(255) NOT NULL DEFAULT '',
+  `expires_at` DATETIME NULL,
+  `password_hash` VARCHAR(255) NULL,
+  `max_views` INT NULL,
+  `view_count` INT NOT NULL DEFAULT 0,
+  `allow_download` TINYINT(1) NOT NULL DEFAULT 0,
+  `revoked_at` DATETIME NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_share_links_token` (`token`),
+  KEY `idx_share_links_album` (`album_id`),
+  CONSTRAINT `fk_share_links_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `album_invitations`;
+DROP TABLE IF EXISTS `album_members`;
@@ -0,0 +1,27 @@
+CREATE TABLE `album_members` (
+  `album_id` CHAR(36) NOT NULL,
+  `user_id` INT NOT NULL,
+  `role` VARCHAR(16) NOT NULL,
+  `added_by` INT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`album_id`, `user_id`),
+  KEY `idx_album_members_user` (`user_id`),
+  CONSTRAINT `fk_album_members_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `album_invitations` (
+  `id` CHAR(36) NOT NULL,
+  `album_id` CHAR(36) NOT NULL,
+  `inviter_id` INT NOT NULL,
+  `invitee_id` INT NULL,
+  `invitee_email` VARCHAR(255) NOT NULL DEFAULT '',
+  `role` VARCHAR(16) NOT NULL,
+  `status` VARCHAR(16) NOT NULL DEFAULT 'pending',
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `responded_at` DATETIME NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_album_invitations_album` (`album_id`),
+  KEY `idx_album_invitations_invitee` (`invitee_id`, `status`),
+  KEY `idx_album_invitations_email` (`invitee_email`, `status`),
+  CONSTRAINT `fk_album_invitations_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE
+);
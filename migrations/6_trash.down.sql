@@ -0,0 +1,9 @@
+ALTER TABLE `media`
+  DROP KEY `idx_media_deleted`,
+  DROP COLUMN `deleted_by`,
+  DROP COLUMN `deleted_at`;
+
+ALTER TABLE `albums`
+  DROP KEY `idx_albums_deleted`,
+  DROP COLUMN `deleted_by`,
+  DROP COLUMN `deleted_at`;
@@ -0,0 +1,9 @@
+ALTER TABLE `albums`
+  ADD COLUMN `deleted_at` DATETIME NULL,
+  ADD COLUMN `deleted_by` INT NULL,
+  ADD KEY `idx_albums_deleted` (`deleted_at`);
+
+ALTER TABLE `media`
+  ADD COLUMN `deleted_at` DATETIME NULL,
+  ADD COLUMN `deleted_by` INT NULL,
+  ADD KEY `idx_media_deleted` (`deleted_at`);
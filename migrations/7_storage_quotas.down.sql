@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `storage_usage`;
+ALTER TABLE `users` DROP COLUMN `is_admin`;
@@ -0,0 +1,15 @@
+ALTER TABLE `users` ADD COLUMN `is_admin` TINYINT(1) NOT NULL DEFAULT 0;
+
+-- quota_bytes overrides the configured default quota, 0 means unlimited
+CREATE TABLE `storage_usage` (
+  `user_id` INT NOT NULL,
+  `bytes_used` BIGINT NOT NULL DEFAULT 0,
+  `item_count` INT NOT NULL DEFAULT 0,
+  `quota_bytes` BIGINT NULL,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`user_id`)
+);
+
+-- media in the trash still counts until it is purged
+INSERT INTO `storage_usage` (`user_id`, `bytes_used`, `item_count`)
+  SELECT `owner_id`, SUM(`size`), COUNT(*) FROM `media` GROUP BY `owner_id`;
@@ -0,0 +1,27 @@
+CREATE TABLE `comments` (
+  `id` CHAR(36) NOT NULL,
+  `album_id` CHAR(36) NOT NULL,
+  `media_id` CHAR(36) NULL,
+  `parent_id` CHAR(36) NULL,
+  `author_id` INT NOT NULL,
+  `body` TEXT NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `deleted_at` DATETIME NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_comments_album` (`album_id`, `media_id`, `parent_id`, `created_at`),
+  KEY `idx_comments_media` (`media_id`, `parent_id`, `created_at`),
+  KEY `idx_comments_parent` (`parent_id`, `created_at`),
+  CONSTRAINT `fk_comments_album` FOREIGN KEY (`album_id`) REFERENCES `albums` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_comments_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+);
+
+-- handles mentioned in a comment; user_id is resolved once the handle belongs to a user
+CREATE TABLE `comment_mentions` (
+  `comment_id` CHAR(36) NOT NULL,
+  `handle` VARCHAR(32) NOT NULL,
+  `user_id` INT NULL,
+  PRIMARY KEY (`comment_id`, `handle`),
+  KEY `idx_comment_mentions_user` (`user_id`),
+  CONSTRAINT `fk_comment_mentions_comment` FOREIGN KEY (`comment_id`) REFERENCES `comments` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+CREATE TABLE `media_reactions` (
+  `media_id` CHAR(36) NOT NULL,
+  `user_id` INT NOT NULL,
+  `reaction` VARCHAR(32) NOT NULL,
+  `created_at` DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`media_id`, `reaction`, `user_id`),
+  KEY `idx_media_reactions_user` (`user_id`, `media_id`),
+  CONSTRAINT `fk_media_reactions_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+);
@@ -16,7 +16,7 @@ func Handler(logger *logrus.Logger) routing.Handler {
 		start := time.Now()
 
 		rw := &access.LogResponseWriter{ResponseWriter: c.Response, Status: http.StatusOK}
-		c.Response = rw
+		c.Response = responseWriter{rw}
 
 		// associate request ID and session ID with the request context
 		// so that they can be added to the log messages
@@ -33,3 +33,14 @@ func Handler(logger *logrus.Logger) routing.Handler {
 		return err
 	}
 }
+
+// responseWriter lets http.ResponseController reach the flushing and hijacking support of the wrapped
+// writer, which streaming responses need.
+type responseWriter struct {
+	*access.LogResponseWriter
+}
+
+// Unwrap returns the wrapped response writer.
+func (w responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -21,6 +21,7 @@ type contextKey int
 
 const (
 	txKey contextKey = iota
+	hooksKey
 )
 
 // New returns a new DB connection that wraps the given dbx.DB instance.
@@ -46,19 +47,61 @@ func (db *DB) With(ctx context.Context) dbx.Builder {
 // Transactional starts a transaction and calls the given function with a context storing the transaction.
 // The transaction associated with the context can be accesse via With().
 func (db *DB) Transactional(ctx context.Context, f func(ctx context.Context) error) error {
-	return db.db.TransactionalContext(ctx, nil, func(tx *dbx.Tx) error {
-		return f(context.WithValue(ctx, txKey, tx))
+	h := &hooks{}
+	err := db.db.TransactionalContext(ctx, nil, func(tx *dbx.Tx) error {
+		return f(context.WithValue(context.WithValue(ctx, txKey, tx), hooksKey, h))
 	})
+	h.run(err)
+	return err
+}
+
+// hooks holds the functions registered to run once a transaction ends.
+type hooks struct {
+	commit, rollback []func()
+}
+
+// run calls the commit hooks if the transaction committed, that is, it ended without an error, and the
+// rollback hooks otherwise.
+func (h *hooks) run(err error) {
+	fs := h.commit
+	if err != nil {
+		fs = h.rollback
+	}
+	for _, f := range fs {
+		f()
+	}
+}
+
+// AfterCommit registers a function that is called once the transaction found in the context commits.
+// Functions registered in a transaction that is rolled back are never called. Without a transaction
+// the function is called immediately.
+func AfterCommit(ctx context.Context, f func()) {
+	if h, ok := ctx.Value(hooksKey).(*hooks); ok {
+		h.commit = append(h.commit, f)
+		return
+	}
+	f()
+}
+
+// AfterRollback registers a function that is called once the transaction found in the context is rolled
+// back, such as to remove files written as part of it. Without a transaction the function is never called.
+func AfterRollback(ctx context.Context, f func()) {
+	if h, ok := ctx.Value(hooksKey).(*hooks); ok {
+		h.rollback = append(h.rollback, f)
+	}
 }
 
 // TransactionHandler returns a middleware that starts a transaction.
 // The transaction started is kept in the context and can be accessed via With().
 func (db *DB) TransactionHandler() routing.Handler {
 	return func(c *routing.Context) error {
-		return db.db.TransactionalContext(c.Request.Context(), nil, func(tx *dbx.Tx) error {
-			ctx := context.WithValue(c.Request.Context(), txKey, tx)
+		h := &hooks{}
+		err := db.db.TransactionalContext(c.Request.Context(), nil, func(tx *dbx.Tx) error {
+			ctx := context.WithValue(context.WithValue(c.Request.Context(), txKey, tx), hooksKey, h)
 			c.Request = c.Request.WithContext(ctx)
 			return c.Next()
 		})
+		h.run(err)
+		return err
 	}
 }
@@ -0,0 +1,331 @@
+// Package exif provides a small EXIF reader for JPEG images and a helper that strips
+// privacy-sensitive metadata from JPEG, PNG and GIF images.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrNoExif is returned by Parse when the image carries no EXIF data.
+var ErrNoExif = errors.New("exif: no exif data found")
+
+// Data holds the EXIF fields ShareFlow cares about. Fields that are not present in the image are left empty.
+type Data struct {
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	ExposureTime string
+	FNumber      *float64
+	ISO          *int
+	FocalLength  *float64
+	CapturedAt   *time.Time
+	Latitude     *float64
+	Longitude    *float64
+	Altitude     *float64
+	Orientation  int
+}
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagFocalLength      = 0x920A
+	tagLensModel        = 0xA434
+	tagGPSLatitudeRef   = 0x0001
+	tagGPSLatitude      = 0x0002
+	tagGPSLongitudeRef  = 0x0003
+	tagGPSLongitude     = 0x0004
+	tagGPSAltitudeRef   = 0x0005
+	tagGPSAltitude      = 0x0006
+)
+
+// type sizes indexed by TIFF field type
+var typeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// Parse reads a JPEG stream and returns the EXIF data found in its APP1 segment.
+// Only the image header is consumed, so it is safe to pass a reader limited to the first few hundred kilobytes.
+func Parse(r io.Reader) (*Data, error) {
+	payload, err := findExifSegment(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	return parseTIFF(payload)
+}
+
+// findExifSegment walks the JPEG markers until it finds an APP1 segment with the Exif identifier.
+func findExifSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, ErrNoExif
+	}
+	for {
+		marker, payload, err := readSegment(r)
+		if err != nil {
+			return nil, ErrNoExif
+		}
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return payload[6:], nil
+		}
+		// metadata always precedes the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return nil, ErrNoExif
+		}
+	}
+}
+
+// readSegment reads a single JPEG marker segment. Markers without a payload return a nil payload.
+func readSegment(r *bufio.Reader) (byte, []byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if b != 0xFF {
+		return 0, nil, fmt.Errorf("exif: invalid marker prefix 0x%02x", b)
+	}
+	marker, err := r.ReadByte()
+	for err == nil && marker == 0xFF { // fill bytes
+		marker, err = r.ReadByte()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if marker == 0xD8 || marker == 0xD9 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+		return marker, nil, nil
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(binary.BigEndian.Uint16(length[:]))
+	if n < 2 {
+		return 0, nil, fmt.Errorf("exif: invalid segment length %d", n)
+	}
+	payload := make([]byte, n-2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return marker, payload, nil
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func parseTIFF(b []byte) (*Data, error) {
+	if len(b) < 8 {
+		return nil, ErrNoExif
+	}
+	t := tiffReader{data: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrNoExif
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return nil, ErrNoExif
+	}
+
+	d := &Data{}
+	ifd0, err := t.readIFD(t.order.Uint32(b[4:]))
+	if err != nil {
+		return nil, err
+	}
+	var exifEntries, gpsEntries map[uint16]ifdEntry
+	if e, ok := ifd0[tagExifIFD]; ok {
+		exifEntries, _ = t.readIFD(t.uint(e))
+	}
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		gpsEntries, _ = t.readIFD(t.uint(e))
+	}
+
+	d.CameraMake = t.string(ifd0[tagMake])
+	d.CameraModel = t.string(ifd0[tagModel])
+	if e, ok := ifd0[tagOrientation]; ok {
+		d.Orientation = int(t.uint(e))
+	}
+	d.LensModel = t.string(exifEntries[tagLensModel])
+	if e, ok := exifEntries[tagExposureTime]; ok {
+		if num, den, ok := t.rational(e, 0); ok && den != 0 {
+			d.ExposureTime = formatExposure(num, den)
+		}
+	}
+	d.FNumber = t.float(exifEntries, tagFNumber)
+	d.FocalLength = t.float(exifEntries, tagFocalLength)
+	if e, ok := exifEntries[tagISO]; ok {
+		iso := int(t.uint(e))
+		d.ISO = &iso
+	}
+
+	captured := t.string(exifEntries[tagDateTimeOriginal])
+	if captured == "" {
+		captured = t.string(ifd0[tagDateTime])
+	}
+	if ts, ok := parseDateTime(captured, t.string(exifEntries[tagOffsetOriginal])); ok {
+		d.CapturedAt = &ts
+	}
+
+	if lat, ok := t.coordinate(gpsEntries[tagGPSLatitude], t.string(gpsEntries[tagGPSLatitudeRef])); ok {
+		if lon, ok := t.coordinate(gpsEntries[tagGPSLongitude], t.string(gpsEntries[tagGPSLongitudeRef])); ok {
+			d.Latitude, d.Longitude = &lat, &lon
+		}
+	}
+	if alt := t.float(gpsEntries, tagGPSAltitude); alt != nil {
+		if ref, ok := gpsEntries[tagGPSAltitudeRef]; ok && len(ref.value) > 0 && ref.value[0] == 1 {
+			*alt = -*alt
+		}
+		d.Altitude = alt
+	}
+	return d, nil
+}
+
+// readIFD reads the image file directory at the given offset into a map keyed by tag.
+func (t tiffReader) readIFD(offset uint32) (map[uint16]ifdEntry, error) {
+	if int(offset)+2 > len(t.data) {
+		return nil, fmt.Errorf("exif: ifd offset %d out of range", offset)
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	entries := make(map[uint16]ifdEntry, n)
+	pos := int(offset) + 2
+	for i := 0; i < n; i++ {
+		if pos+12 > len(t.data) {
+			break
+		}
+		e := ifdEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+		}
+		size, ok := typeSize[e.typ]
+		pos += 12
+		if !ok || e.count > uint32(len(t.data)) {
+			continue
+		}
+		total := size * int(e.count)
+		if total <= 4 {
+			e.value = t.data[pos-4 : pos-4+total]
+		} else {
+			start := int(t.order.Uint32(t.data[pos-4:]))
+			if start < 0 || start+total > len(t.data) {
+				continue
+			}
+			e.value = t.data[start : start+total]
+		}
+		entries[e.tag] = e
+	}
+	return entries, nil
+}
+
+func (t tiffReader) uint(e ifdEntry) uint32 {
+	switch {
+	case e.typ == 3 && len(e.value) >= 2:
+		return uint32(t.order.Uint16(e.value))
+	case (e.typ == 4 || e.typ == 9) && len(e.value) >= 4:
+		return t.order.Uint32(e.value)
+	case e.typ == 1 && len(e.value) >= 1:
+		return uint32(e.value[0])
+	}
+	return 0
+}
+
+func (t tiffReader) string(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.value), "\x00"))
+}
+
+func (t tiffReader) rational(e ifdEntry, i int) (uint32, uint32, bool) {
+	if (e.typ != 5 && e.typ != 10) || len(e.value) < (i+1)*8 {
+		return 0, 0, false
+	}
+	return t.order.Uint32(e.value[i*8:]), t.order.Uint32(e.value[i*8+4:]), true
+}
+
+func (t tiffReader) float(entries map[uint16]ifdEntry, tag uint16) *float64 {
+	e, ok := entries[tag]
+	if !ok {
+		return nil
+	}
+	num, den, ok := t.rational(e, 0)
+	if !ok || den == 0 {
+		return nil
+	}
+	v := float64(num) / float64(den)
+	if e.typ == 10 {
+		v = float64(int32(num)) / float64(int32(den))
+	}
+	return &v
+}
+
+// coordinate converts a degrees/minutes/seconds GPS triple into signed decimal degrees.
+func (t tiffReader) coordinate(e ifdEntry, ref string) (float64, bool) {
+	var parts [3]float64
+	for i := range parts {
+		num, den, ok := t.rational(e, i)
+		if !ok || den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	v := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref == "S" || ref == "W" {
+		v = -v
+	}
+	if math.IsNaN(v) || math.Abs(v) > 180 {
+		return 0, false
+	}
+	return v, true
+}
+
+func formatExposure(num, den uint32) string {
+	if num == 0 {
+		return "0"
+	}
+	if num >= den {
+		return fmt.Sprintf("%g", float64(num)/float64(den))
+	}
+	return fmt.Sprintf("1/%d", int(math.Round(float64(den)/float64(num))))
+}
+
+// parseDateTime parses the EXIF "YYYY:MM:DD HH:MM:SS" format. Without an explicit offset the time is taken as UTC.
+func parseDateTime(value, offset string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if ts, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return ts, true
+		}
+	}
+	ts, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil || ts.Year() < 1900 {
+		return time.Time{}, false
+	}
+	return ts, true
+}
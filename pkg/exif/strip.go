@@ -0,0 +1,293 @@
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// sensitive reports whether a JPEG marker segment may carry location or other personal metadata.
+// APP1 holds EXIF (including GPS) and XMP, APP13 holds IPTC/Photoshop data and COM holds free-form comments.
+func sensitive(marker byte) bool {
+	return marker == 0xE1 || marker == 0xED || marker == 0xFE
+}
+
+// orientationSegment returns the payload of an APP1 segment whose EXIF data holds nothing but the given
+// orientation, so that viewers keep rotating the image as the camera recorded it.
+func orientationSegment(orientation int) []byte {
+	b := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08")
+	b = binary.BigEndian.AppendUint16(b, 1) // entry count
+	b = binary.BigEndian.AppendUint16(b, tagOrientation)
+	b = binary.BigEndian.AppendUint16(b, 3) // SHORT
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	b = append(b, 0, 0)                        // value padding
+	return binary.BigEndian.AppendUint32(b, 0) // no next IFD
+}
+
+// ErrUnsupported is returned by Strip for formats whose metadata it cannot remove.
+var ErrUnsupported = errors.New("exif: unsupported image format")
+
+// Strippable reports whether Strip can remove the metadata of images of the given content type.
+func Strippable(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Strip copies the JPEG, PNG or GIF image from r to w, dropping all metadata that may contain GPS
+// coordinates, serial numbers or other personal information. Image data is copied unchanged.
+// The EXIF orientation is kept on its own, as the pixels are stored unrotated. Other input is
+// rejected with ErrUnsupported before anything is written.
+func Strip(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(pngSignature))
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		return stripJPEG(w, br)
+	case bytes.Equal(head, pngSignature):
+		return stripPNG(w, br)
+	case bytes.HasPrefix(head, []byte("GIF87a")) || bytes.HasPrefix(head, []byte("GIF89a")):
+		return stripGIF(w, br)
+	}
+	return ErrUnsupported
+}
+
+// stripJPEG drops the sensitive marker segments of a JPEG image.
+func stripJPEG(w io.Writer, br *bufio.Reader) error {
+	br.Discard(2)
+	if _, err := w.Write([]byte{0xFF, 0xD8}); err != nil {
+		return err
+	}
+	for {
+		marker, payload, err := readSegment(br)
+		if err != nil {
+			return err
+		}
+		if sensitive(marker) {
+			if marker != 0xE1 || !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+				continue
+			}
+			d, err := parseTIFF(payload[6:])
+			if err != nil || d.Orientation < 2 || d.Orientation > 8 {
+				continue
+			}
+			payload = orientationSegment(d.Orientation)
+		}
+		if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+			return err
+		}
+		if payload != nil {
+			var length [2]byte
+			binary.BigEndian.PutUint16(length[:], uint16(len(payload)+2))
+			if _, err := w.Write(length[:]); err != nil {
+				return err
+			}
+			if _, err := w.Write(payload); err != nil {
+				return err
+			}
+		}
+		// everything after the start of scan is entropy-coded image data
+		if marker == 0xDA || marker == 0xD9 {
+			_, err = io.Copy(w, br)
+			return err
+		}
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngSensitive reports whether a PNG chunk may carry personal metadata: text, EXIF data and the time of
+// the last modification.
+func pngSensitive(chunk string) bool {
+	switch chunk {
+	case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+		return true
+	}
+	return false
+}
+
+// stripPNG drops the sensitive chunks of a PNG image. An eXIf chunk is replaced by one holding nothing but
+// the orientation, and anything after the IEND chunk is dropped.
+func stripPNG(w io.Writer, br *bufio.Reader) error {
+	br.Discard(len(pngSignature))
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return err
+		}
+		n := binary.BigEndian.Uint32(header[:4])
+		if n > 1<<31-1 {
+			return fmt.Errorf("exif: invalid PNG chunk length %d", n)
+		}
+		chunk := string(header[4:])
+		if !pngSensitive(chunk) {
+			if _, err := w.Write(header[:]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, br, int64(n)+4); err != nil { // data and CRC
+				return err
+			}
+			if chunk == "IEND" {
+				return nil
+			}
+			continue
+		}
+		if chunk != "eXIf" || n > 1<<16 {
+			if _, err := io.CopyN(io.Discard, br, int64(n)+4); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return err
+		}
+		if _, err := br.Discard(4); err != nil {
+			return err
+		}
+		d, err := parseTIFF(data)
+		if err != nil || d.Orientation < 2 || d.Orientation > 8 {
+			continue
+		}
+		if err := writePNGChunk(w, chunk, orientationSegment(d.Orientation)[6:]); err != nil {
+			return err
+		}
+	}
+}
+
+func writePNGChunk(w io.Writer, chunk string, data []byte) error {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(append(b, chunk...), data...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+	_, err := w.Write(b)
+	return err
+}
+
+// gifKeptApplications are the application extensions that control the animation of a GIF image.
+var gifKeptApplications = map[string]bool{"NETSCAPE2.0": true, "ANIMEXTS1.0": true}
+
+// stripGIF drops the comment extensions of a GIF image and the application extensions other than those
+// controlling its animation, such as embedded XMP data. Anything after the trailer is dropped.
+func stripGIF(w io.Writer, br *bufio.Reader) error {
+	var header [13]byte // signature and logical screen descriptor
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if err := copyColorTable(w, br, header[10]); err != nil {
+		return err
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case 0x3B: // trailer
+			_, err := w.Write([]byte{b})
+			return err
+		case 0x2C: // image descriptor
+			var desc [10]byte
+			desc[0] = b
+			if _, err := io.ReadFull(br, desc[1:]); err != nil {
+				return err
+			}
+			if _, err := w.Write(desc[:]); err != nil {
+				return err
+			}
+			if err := copyColorTable(w, br, desc[9]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(w, br, 1); err != nil { // LZW minimum code size
+				return err
+			}
+			if err := copySubBlocks(w, br); err != nil {
+				return err
+			}
+		case 0x21: // extension
+			label, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			if label != 0xFE && label != 0xFF {
+				if _, err := w.Write([]byte{b, label}); err != nil {
+					return err
+				}
+				if err := copySubBlocks(w, br); err != nil {
+					return err
+				}
+				continue
+			}
+			var first []byte
+			if label == 0xFF {
+				if first, err = readSubBlock(br); err != nil {
+					return err
+				}
+			}
+			if label == 0xFF && len(first) >= 11 && gifKeptApplications[string(first[:11])] {
+				if _, err := w.Write(append([]byte{b, label, byte(len(first))}, first...)); err != nil {
+					return err
+				}
+				if err := copySubBlocks(w, br); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(first) > 0 || label == 0xFE {
+				if err := copySubBlocks(io.Discard, br); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("exif: invalid GIF block 0x%02x", b)
+		}
+	}
+}
+
+// copyColorTable copies the color table announced by the packed field of a GIF screen or image descriptor.
+func copyColorTable(w io.Writer, br *bufio.Reader, packed byte) error {
+	if packed&0x80 == 0 {
+		return nil
+	}
+	_, err := io.CopyN(w, br, 3<<(packed&0x07+1))
+	return err
+}
+
+// readSubBlock reads a single GIF data sub-block. The block terminator returns an empty block.
+func readSubBlock(br *bufio.Reader) ([]byte, error) {
+	n, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(br, b)
+	return b, err
+}
+
+// copySubBlocks copies GIF data sub-blocks up to and including the block terminator.
+func copySubBlocks(w io.Writer, br *bufio.Reader) error {
+	for {
+		b, err := readSubBlock(br)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append([]byte{byte(len(b))}, b...)); err != nil {
+			return err
+		}
+		if len(b) == 0 {
+			return nil
+		}
+	}
+}
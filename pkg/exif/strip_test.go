@@ -0,0 +1,175 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tiff returns big-endian TIFF data whose first IFD holds the camera make and the orientation.
+func tiff(camera string, orientation int) []byte {
+	b := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	b = binary.BigEndian.AppendUint16(b, 2)
+	b = binary.BigEndian.AppendUint16(b, tagMake)
+	b = binary.BigEndian.AppendUint16(b, 2) // ASCII
+	b = binary.BigEndian.AppendUint32(b, uint32(len(camera)+1))
+	b = binary.BigEndian.AppendUint32(b, 8+2+2*12+4)
+	b = binary.BigEndian.AppendUint16(b, tagOrientation)
+	b = binary.BigEndian.AppendUint16(b, 3) // SHORT
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, uint16(orientation))
+	b = append(b, 0, 0)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	return append(append(b, camera...), 0)
+}
+
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xFF, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func pngChunk(chunk string, data []byte) []byte {
+	var b bytes.Buffer
+	writePNGChunk(&b, chunk, data)
+	return b.Bytes()
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStrip(t *testing.T) {
+	soi, scan := []byte{0xFF, 0xD8}, []byte{0xFF, 0xDA, 0x00, 0x02, 0x12, 0x34, 0xFF, 0xD9}
+	jfif := segment(0xE0, []byte("JFIF\x00\x01\x02"))
+	exifSegment := segment(0xE1, append([]byte("Exif\x00\x00"), tiff("Canon", 6)...))
+	upright := segment(0xE1, append([]byte("Exif\x00\x00"), tiff("Canon", 1)...))
+	orientation := segment(0xE1, orientationSegment(6))
+
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	idat := pngChunk("IDAT", []byte{1, 2, 3})
+	iend := pngChunk("IEND", nil)
+
+	gifHeader := []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00")
+	gifColors := []byte{0, 0, 0, 255, 255, 255}
+	gifComment := []byte("\x21\xfe\x0cCanon secret\x00")
+	gifXMP := []byte("\x21\xff\x0bXMP DataXMP\x05Canon\x00")
+	gifLoop := []byte("\x21\xff\x0bNETSCAPE2.0\x03\x01\x00\x00\x00")
+	gifControl := []byte("\x21\xf9\x04\x00\x00\x00\x00\x00")
+	gifImage := []byte("\x2c\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02\x4c\x01\x00")
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wantErr error
+	}{
+		{
+			"JPEG drops EXIF, IPTC and comments but keeps the orientation",
+			concat(soi, jfif, exifSegment, segment(0xED, []byte("Photoshop 3.0 Canon")), segment(0xFE, []byte("Canon")), scan),
+			concat(soi, jfif, orientation, scan),
+			nil,
+		},
+		{
+			"JPEG without rotation drops the EXIF segment",
+			concat(soi, upright, scan),
+			concat(soi, scan),
+			nil,
+		},
+		{
+			"JPEG keeps the image data after the start of scan",
+			concat(soi, scan, []byte("pixels")),
+			concat(soi, scan, []byte("pixels")),
+			nil,
+		},
+		{
+			"PNG drops text chunks and trailing data but keeps the orientation",
+			concat(pngSignature, ihdr, pngChunk("tEXt", []byte("Comment\x00Canon")), pngChunk("eXIf", tiff("Canon", 6)),
+				pngChunk("tIME", make([]byte, 7)), idat, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00Canon")), iend, []byte("Canon")),
+			concat(pngSignature, ihdr, pngChunk("eXIf", orientationSegment(6)[6:]), idat, iend),
+			nil,
+		},
+		{
+			"PNG without metadata is unchanged",
+			concat(pngSignature, ihdr, idat, iend),
+			concat(pngSignature, ihdr, idat, iend),
+			nil,
+		},
+		{
+			"GIF drops comments and XMP but keeps the animation",
+			concat(gifHeader, gifColors, gifComment, gifXMP, gifLoop, gifControl, gifImage, []byte{0x3B}, []byte("Canon")),
+			concat(gifHeader, gifColors, gifLoop, gifControl, gifImage, []byte{0x3B}),
+			nil,
+		},
+		{
+			"WebP is unsupported",
+			[]byte("RIFF\x00\x00\x00\x00WEBPVP8 Canon"),
+			nil,
+			ErrUnsupported,
+		},
+		{
+			"empty input is unsupported",
+			nil,
+			nil,
+			ErrUnsupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Strip(&out, bytes.NewReader(tt.input))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Strip() error = %v, want %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(out.Bytes(), tt.want) {
+				t.Errorf("Strip() = %q, want %q", out.Bytes(), tt.want)
+			}
+			if strings.Contains(out.String(), "Canon") {
+				t.Errorf("Strip() kept metadata: %q", out.Bytes())
+			}
+		})
+	}
+}
+
+func TestStripTruncated(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"JPEG", concat([]byte{0xFF, 0xD8}, segment(0xE0, []byte("JFIF"))[:4])},
+		{"PNG", concat(pngSignature, pngChunk("IHDR", make([]byte, 13))[:10])},
+		{"GIF", []byte("GIF89a\x01\x00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Strip(&bytes.Buffer{}, bytes.NewReader(tt.input)); err == nil {
+				t.Error("Strip() succeeded on a truncated image")
+			}
+		})
+	}
+}
+
+func TestStrippable(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"image/jpeg", true},
+		{"image/png", true},
+		{"image/gif", true},
+		{"image/webp", false},
+		{"image/heic", false},
+		{"image/tiff", false},
+		{"video/mp4", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			if got := Strippable(tt.contentType); got != tt.want {
+				t.Errorf("Strippable(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Package fractional generates ordering keys that allow inserting an item between any two others
+// without changing the keys of the other items.
+//
+// Keys are strings of base62 digits compared byte-wise, so they sort correctly with a binary collation.
+// A key consists of an integer part, whose first character encodes its length, followed by an optional
+// fraction. Appending after the last key increments the integer part, which keeps keys short when items
+// are added one after another, while inserting between two adjacent keys extends the fraction.
+package fractional
+
+import (
+	"errors"
+	"strings"
+)
+
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// smallestInteger is the smallest integer part. No key may consist of it alone, so that there is always
+// room before the first key.
+var smallestInteger = "A" + strings.Repeat("0", 26)
+
+var (
+	// ErrInvalidKey is returned for malformed keys.
+	ErrInvalidKey = errors.New("fractional: invalid key")
+	// ErrOrder is returned when the lower key is not less than the upper key.
+	ErrOrder = errors.New("fractional: keys out of order")
+	// ErrExhausted is returned in the practically unreachable case that no key exists beyond the given one.
+	ErrExhausted = errors.New("fractional: key space exhausted")
+)
+
+// Between returns a key that sorts after a and before b. An empty a means no lower bound, an empty b
+// no upper bound, so Between("", "") returns the first key of an empty list.
+func Between(a, b string) (string, error) {
+	if a != "" {
+		if err := validate(a); err != nil {
+			return "", err
+		}
+	}
+	if b != "" {
+		if err := validate(b); err != nil {
+			return "", err
+		}
+	}
+	if a != "" && b != "" && a >= b {
+		return "", ErrOrder
+	}
+
+	if a == "" {
+		if b == "" {
+			return "a0", nil
+		}
+		ib, _ := integerPart(b)
+		fb := b[len(ib):]
+		if ib == smallestInteger {
+			return ib + midpoint("", fb, true), nil
+		}
+		if ib < b {
+			return ib, nil
+		}
+		i, ok := decrement(ib)
+		if !ok {
+			return "", ErrExhausted
+		}
+		return i, nil
+	}
+
+	ia, _ := integerPart(a)
+	fa := a[len(ia):]
+	if b == "" {
+		if i, ok := increment(ia); ok {
+			return i, nil
+		}
+		return ia + midpoint(fa, "", false), nil
+	}
+	ib, _ := integerPart(b)
+	fb := b[len(ib):]
+	if ia == ib {
+		return ia + midpoint(fa, fb, true), nil
+	}
+	i, ok := increment(ia)
+	if !ok {
+		return "", ErrExhausted
+	}
+	if i < b {
+		return i, nil
+	}
+	return ia + midpoint(fa, "", false), nil
+}
+
+// N returns n consecutive keys after a, such as the keys of the items appended to a list ending with a.
+func N(a string, n int) ([]string, error) {
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		k, err := Between(a, "")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		a = k
+	}
+	return keys, nil
+}
+
+// midpoint returns a fraction between the fractions a and b. Fractions have no trailing zeros. If
+// bounded is false, b is ignored and the fraction has no upper bound.
+func midpoint(a, b string, bounded bool) string {
+	if bounded {
+		// skip the common prefix, treating a as padded with zeros
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(tail(a, n), b[n:], true)
+		}
+	}
+	da := 0
+	if a != "" {
+		da = strings.IndexByte(digits, a[0])
+	}
+	db := len(digits)
+	if bounded && b != "" {
+		db = strings.IndexByte(digits, b[0])
+	}
+	if db-da > 1 {
+		return string(digits[(da+db+1)/2])
+	}
+	// the first digits are adjacent
+	if bounded && len(b) > 1 {
+		return b[:1]
+	}
+	return string(digits[da]) + midpoint(tail(a, 1), "", false)
+}
+
+// digitAt returns the digit of the fraction a at position i, which is zero beyond its end.
+func digitAt(a string, i int) byte {
+	if i < len(a) {
+		return a[i]
+	}
+	return digits[0]
+}
+
+func tail(s string, n int) string {
+	if n >= len(s) {
+		return ""
+	}
+	return s[n:]
+}
+
+// integerLength returns the length of an integer part starting with the given head character.
+func integerLength(head byte) (int, bool) {
+	switch {
+	case head >= 'a' && head <= 'z':
+		return int(head-'a') + 2, true
+	case head >= 'A' && head <= 'Z':
+		return int('Z'-head) + 2, true
+	}
+	return 0, false
+}
+
+func integerPart(key string) (string, error) {
+	if key == "" {
+		return "", ErrInvalidKey
+	}
+	n, ok := integerLength(key[0])
+	if !ok || n > len(key) {
+		return "", ErrInvalidKey
+	}
+	return key[:n], nil
+}
+
+func validate(key string) error {
+	if key == smallestInteger {
+		return ErrInvalidKey
+	}
+	i, err := integerPart(key)
+	if err != nil {
+		return err
+	}
+	for j := 1; j < len(key); j++ {
+		if strings.IndexByte(digits, key[j]) < 0 {
+			return ErrInvalidKey
+		}
+	}
+	if f := key[len(i):]; strings.HasSuffix(f, digits[:1]) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// increment returns the integer following x. It returns false if x is the largest integer.
+func increment(x string) (string, bool) {
+	head, digs := x[0], []byte(x[1:])
+	carry := true
+	for i := len(digs) - 1; carry && i >= 0; i-- {
+		d := strings.IndexByte(digits, digs[i]) + 1
+		if d == len(digits) {
+			digs[i] = digits[0]
+		} else {
+			digs[i] = digits[d]
+			carry = false
+		}
+	}
+	if !carry {
+		return string(head) + string(digs), true
+	}
+	switch head {
+	case 'Z':
+		return "a" + digits[:1], true
+	case 'z':
+		return "", false
+	}
+	h := head + 1
+	if h > 'a' {
+		digs = append(digs, digits[0])
+	} else {
+		digs = digs[:len(digs)-1]
+	}
+	return string(h) + string(digs), true
+}
+
+// decrement returns the integer preceding x. It returns false if x is the smallest integer.
+func decrement(x string) (string, bool) {
+	head, digs := x[0], []byte(x[1:])
+	borrow := true
+	for i := len(digs) - 1; borrow && i >= 0; i-- {
+		d := strings.IndexByte(digits, digs[i]) - 1
+		if d == -1 {
+			digs[i] = digits[len(digits)-1]
+		} else {
+			digs[i] = digits[d]
+			borrow = false
+		}
+	}
+	if !borrow {
+		return string(head) + string(digs), true
+	}
+	switch head {
+	case 'a':
+		return "Z" + digits[len(digits)-1:], true
+	case 'A':
+		return "", false
+	}
+	h := head - 1
+	if h < 'Z' {
+		digs = append(digs, digits[len(digits)-1])
+	} else {
+		digs = digs[:len(digs)-1]
+	}
+	return string(h) + string(digs), true
+}
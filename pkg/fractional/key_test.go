@@ -0,0 +1,97 @@
+package fractional
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		want    string
+		wantErr error
+	}{
+		{"empty list", "", "", "a0", nil},
+		{"append", "a0", "", "a1", nil},
+		{"append carries", "az", "", "b00", nil},
+		{"append to the last one-digit integer", "Zz", "", "a0", nil},
+		{"prepend", "", "a1", "a0", nil},
+		{"prepend borrows", "", "a0", "Zz", nil},
+		{"prepend before a fraction", "", "a0V", "a0", nil},
+		{"adjacent integers", "a0", "a1", "a0V", nil},
+		{"integers with room", "a0", "a5", "a1", nil},
+		{"between fractions", "a0V", "a1", "a0l", nil},
+		{"adjacent fraction digits", "a0V", "a0W", "a0VV", nil},
+		{"before the smallest integer", "", smallestInteger + "1", smallestInteger + "0V", nil},
+		{"invalid lower key", "!", "", "", ErrInvalidKey},
+		{"invalid upper key", "", "a", "", ErrInvalidKey},
+		{"trailing zero", "a00", "", "", ErrInvalidKey},
+		{"invalid digit", "a0-", "", "", ErrInvalidKey},
+		{"smallest integer alone", smallestInteger, "", "", ErrInvalidKey},
+		{"equal keys", "a0", "a0", "", ErrOrder},
+		{"reversed keys", "a1", "a0", "", ErrOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Between(tt.a, tt.b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Between(%q, %q) error = %v, want %v", tt.a, tt.b, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Between(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetweenRepeated(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		next func(a, b, k string) (string, string)
+	}{
+		{"appending", "a0", "", func(a, b, k string) (string, string) { return k, b }},
+		{"prepending", "", "a0", func(a, b, k string) (string, string) { return a, k }},
+		{"inserting after the lower key", "a0", "a1", func(a, b, k string) (string, string) { return a, k }},
+		{"inserting before the upper key", "a0", "a1", func(a, b, k string) (string, string) { return k, b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a, tt.b
+			for i := 0; i < 500; i++ {
+				k, err := Between(a, b)
+				if err != nil {
+					t.Fatalf("Between(%q, %q) error = %v", a, b, err)
+				}
+				if (a != "" && k <= a) || (b != "" && k >= b) {
+					t.Fatalf("Between(%q, %q) = %q, out of order", a, b, k)
+				}
+				if err := validate(k); err != nil {
+					t.Fatalf("Between(%q, %q) = %q, invalid key", a, b, k)
+				}
+				a, b = tt.next(a, b, k)
+			}
+		})
+	}
+}
+
+func TestN(t *testing.T) {
+	keys, err := N("a0", 100)
+	if err != nil {
+		t.Fatalf("N() error = %v", err)
+	}
+	if len(keys) != 100 {
+		t.Fatalf("N() returned %d keys, want 100", len(keys))
+	}
+	prev := "a0"
+	for _, k := range keys {
+		if k <= prev {
+			t.Fatalf("N() returned %q after %q", k, prev)
+		}
+		prev = k
+	}
+	if prev != "b0c" {
+		t.Errorf("N() last key = %q, want b0c", prev)
+	}
+}
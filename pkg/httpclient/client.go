@@ -0,0 +1,58 @@
+// Package httpclient provides the HTTP client for requests to URLs chosen by users, such as webhook and
+// push endpoints.
+package httpclient
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the range of carrier-grade NAT addresses (RFC 6598), which are internal to the
+// network of the provider.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// New returns a client that does not follow redirects. Unless allowPrivate is set, connections to
+// loopback, private, shared and link-local addresses are refused, so that users cannot make the server
+// reach the internal network. The address is checked when connecting, which also covers host names that
+// resolve to such addresses. Requests never go through a proxy from the environment, as the proxy
+// would connect on behalf of the server to addresses that are never checked.
+func New(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internal(ip) {
+				return fmt.Errorf("address %s is not allowed", host)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 20 * time.Second,
+			MaxIdleConnsPerHost:   2,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// internal reports whether an address belongs to the loopback, private, shared, link-local, multicast or
+// unspecified ranges.
+func internal(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,84 @@
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInternal(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"127.0.0.1", true},
+		{"127.8.9.10", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"0.0.0.0", true},
+		{"::", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"100.63.255.255", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := internal(net.ParseIP(tt.address)); got != tt.want {
+				t.Errorf("internal(%s) = %v, want %v", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		allowPrivate bool
+		path         string
+		wantStatus   int
+		wantErr      bool
+	}{
+		{"loopback refused", false, "/", 0, true},
+		{"loopback allowed", true, "/", http.StatusNoContent, false},
+		{"redirect not followed", true, "/redirect", http.StatusFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := New(tt.allowPrivate).Get(server.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("Get() status = %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Package httprange serves downloadable content with support for HTTP range requests and conditional GET.
+//
+// Unlike http.ServeContent it does not need an io.ReadSeeker: the content is described by its size and
+// validators and read through a callback, so it works with any storage backend that can read byte ranges.
+package httprange
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRanges is the number of ranges a single request may ask for before the whole content is served instead.
+const maxRanges = 16
+
+// Content describes a piece of content that can be served.
+type Content struct {
+	// Size is the total size of the content in bytes.
+	Size int64
+	// ContentType is the MIME type of the content.
+	ContentType string
+	// ETag is a strong entity tag, including the surrounding quotes.
+	ETag string
+	// ModTime is the time the content was last modified. The zero time disables Last-Modified handling.
+	ModTime time.Time
+	// Open returns a reader for length bytes starting at offset.
+	Open func(offset, length int64) (io.ReadCloser, error)
+}
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// Serve writes the content as the response to the given GET or HEAD request. It answers with
+// 304 Not Modified, 412 Precondition Failed, 206 Partial Content (single or multipart/byteranges),
+// 416 Range Not Satisfiable or 200 OK as appropriate. An error is returned only if opening the content fails
+// before anything was written; failures while streaming the body are ignored because the client is gone.
+func Serve(w http.ResponseWriter, r *http.Request, c Content) error {
+	header := w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	if c.ETag != "" {
+		header.Set("ETag", c.ETag)
+	}
+	if !c.ModTime.IsZero() {
+		header.Set("Last-Modified", c.ModTime.UTC().Format(http.TimeFormat))
+	}
+
+	if status := checkPreconditions(r, c); status != 0 {
+		if status == http.StatusNotModified {
+			header.Del("Content-Type")
+			header.Del("Content-Length")
+		}
+		w.WriteHeader(status)
+		return nil
+	}
+
+	// a Range header is ignored altogether when If-Range does not match, even if it cannot be satisfied:
+	// the client asked for the whole content in that case (RFC 9110 section 13.1.5)
+	var ranges []byteRange
+	if rangeApplies(r, c) {
+		var err error
+		if ranges, err = parseRange(r.Header.Get("Range"), c.Size); err != nil {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", c.Size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+	}
+	if len(ranges) > maxRanges || sumLength(ranges) > c.Size {
+		ranges = nil
+	}
+
+	switch len(ranges) {
+	case 0:
+		return serveRange(w, r, c, byteRange{0, c.Size}, http.StatusOK)
+	case 1:
+		header.Set("Content-Range", ranges[0].contentRange(c.Size))
+		return serveRange(w, r, c, ranges[0], http.StatusPartialContent)
+	default:
+		return serveMultipart(w, r, c, ranges)
+	}
+}
+
+func serveRange(w http.ResponseWriter, r *http.Request, c Content, br byteRange, status int) error {
+	w.Header().Set("Content-Type", c.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return nil
+	}
+	rc, err := c.Open(br.start, br.length)
+	if err != nil {
+		w.Header().Del("Content-Range")
+		return err
+	}
+	defer rc.Close()
+	w.WriteHeader(status)
+	io.CopyN(w, rc, br.length)
+	return nil
+}
+
+// serveMultipart writes a multipart/byteranges response. Each part is opened lazily so a failing backend
+// aborts the response instead of sending partial garbage with a success status.
+func serveMultipart(w http.ResponseWriter, r *http.Request, c Content, ranges []byteRange) error {
+	mw := multipart.NewWriter(io.Discard)
+	partHeader := func(br byteRange) textproto.MIMEHeader {
+		return textproto.MIMEHeader{
+			"Content-Range": {br.contentRange(c.Size)},
+			"Content-Type":  {c.ContentType},
+		}
+	}
+	// compute the exact length of the body by writing the part headers to a counter
+	counter := &countingWriter{}
+	cw := multipart.NewWriter(counter)
+	cw.SetBoundary(mw.Boundary())
+	for _, br := range ranges {
+		cw.CreatePart(partHeader(br))
+		counter.n += br.length
+	}
+	cw.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(counter.n, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPartialContent)
+		return nil
+	}
+
+	first, err := c.Open(ranges[0].start, ranges[0].length)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusPartialContent)
+	body := multipart.NewWriter(w)
+	body.SetBoundary(mw.Boundary())
+	for i, br := range ranges {
+		rc := first
+		if i > 0 {
+			if rc, err = c.Open(br.start, br.length); err != nil {
+				return nil
+			}
+		}
+		part, err := body.CreatePart(partHeader(br))
+		if err == nil {
+			_, err = io.CopyN(part, rc, br.length)
+		}
+		rc.Close()
+		if err != nil {
+			return nil
+		}
+	}
+	body.Close()
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// checkPreconditions evaluates the conditional request headers in the order given by RFC 9110 section 13.2.2.
+// It returns 0 if the request should be served normally.
+func checkPreconditions(r *http.Request, c Content) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !matchETag(im, c.ETag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !c.ModTime.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && c.ModTime.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, c.ETag, true) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !c.ModTime.IsZero() &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if t, err := http.ParseTime(ims); err == nil && !c.ModTime.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// rangeApplies evaluates If-Range: the range is only honoured if the validator still matches.
+// Entity tags must match strongly and dates must match exactly.
+func rangeApplies(r *http.Request, c Content) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return c.ETag != "" && ir == c.ETag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !c.ModTime.IsZero() && c.ModTime.Truncate(time.Second).Equal(t)
+}
+
+// matchETag checks an If-Match / If-None-Match header value against the entity tag.
+// The weak comparison ignores the W/ prefix, as required for If-None-Match.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnsatisfiable = errors.New("httprange: range not satisfiable")
+
+// parseRange parses a Range header value. A header with an unknown unit or invalid syntax is ignored,
+// as allowed by RFC 9110. Ranges beyond the end of the content are dropped, and an error is returned
+// only when none of the requested ranges can be satisfied.
+func parseRange(s string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if s == "" || !strings.HasPrefix(s, prefix) {
+		return nil, nil
+	}
+	var ranges []byteRange
+	for _, spec := range strings.Split(s[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var br byteRange
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{size - n, n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start, end - start + 1}
+		}
+		if br.length > 0 {
+			ranges = append(ranges, br)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+	return ranges, nil
+}
+
+func sumLength(ranges []byteRange) int64 {
+	var n int64
+	for _, r := range ranges {
+		n += r.length
+	}
+	return n
+}
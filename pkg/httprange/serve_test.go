@@ -0,0 +1,103 @@
+package httprange
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		size    int64
+		want    []byteRange
+		wantErr error
+	}{
+		{"no header", "", 100, nil, nil},
+		{"other unit", "items=0-10", 100, nil, nil},
+		{"closed range", "bytes=0-9", 100, []byteRange{{0, 10}}, nil},
+		{"open range", "bytes=90-", 100, []byteRange{{90, 10}}, nil},
+		{"suffix range", "bytes=-10", 100, []byteRange{{90, 10}}, nil},
+		{"suffix longer than the content", "bytes=-500", 100, []byteRange{{0, 100}}, nil},
+		{"end beyond the content", "bytes=50-500", 100, []byteRange{{50, 50}}, nil},
+		{"several ranges", "bytes=0-0, 10-19,-5", 100, []byteRange{{0, 1}, {10, 10}, {95, 5}}, nil},
+		{"unsatisfiable range skipped", "bytes=200-300,0-9", 100, []byteRange{{0, 10}}, nil},
+		{"empty specs skipped", "bytes=,0-9,", 100, []byteRange{{0, 10}}, nil},
+		{"start beyond the content", "bytes=100-", 100, nil, errUnsatisfiable},
+		{"zero suffix", "bytes=-0", 100, nil, errUnsatisfiable},
+		{"no ranges", "bytes=", 100, nil, errUnsatisfiable},
+		{"empty content", "bytes=0-", 0, nil, errUnsatisfiable},
+		{"missing dash ignored", "bytes=10", 100, nil, nil},
+		{"end before start ignored", "bytes=20-10", 100, nil, nil},
+		{"negative start ignored", "bytes=-5-10", 100, nil, nil},
+		{"not a number ignored", "bytes=a-b", 100, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.header, tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseRange() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	const body = "0123456789abcdefghij"
+	modTime := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	content := Content{
+		Size:        int64(len(body)),
+		ContentType: "text/plain",
+		ETag:        `"v1"`,
+		ModTime:     modTime,
+		Open: func(offset, length int64) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(body[offset : offset+length])), nil
+		},
+	}
+	tests := []struct {
+		name         string
+		header       map[string]string
+		wantStatus   int
+		wantBody     string
+		contentRange string
+	}{
+		{"whole content", nil, http.StatusOK, body, ""},
+		{"single range", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=50-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+		{"matching If-Range", map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`}, http.StatusPartialContent, "01", "bytes 0-1/20"},
+		{"stale If-Range", map[string]string{"Range": "bytes=0-1", "If-Range": `"v0"`}, http.StatusOK, body, ""},
+		{"matching If-None-Match", map[string]string{"If-None-Match": `"v1"`}, http.StatusNotModified, "", ""},
+		{"failing If-Match", map[string]string{"If-Match": `"v0"`}, http.StatusPreconditionFailed, "", ""},
+		{"not modified since", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}, http.StatusNotModified, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if err := Serve(w, r, content); err != nil {
+				t.Fatalf("Serve() error = %v", err)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("Serve() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("Serve() body = %q, want %q", got, tt.wantBody)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Serve() Content-Range = %q, want %q", got, tt.contentRange)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+// Package mailer sends emails. Messages carry a plain-text and an HTML body, which are sent as a
+// multipart/alternative message.
+//
+// SMTP sends the messages to a mail server. Capture keeps them in memory and optionally writes them to a
+// directory instead, for local development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are additional headers, such as List-Unsubscribe.
+	Headers map[string]string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Render returns the message in the Internet Message Format, sent by the given address.
+func (m Message) Render(from string) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	header := map[string]string{
+		"From":         from,
+		"To":           m.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   messageID(from),
+		"MIME-Version": "1.0",
+		"Content-Type": "multipart/alternative; boundary=" + body.Boundary(),
+	}
+	for k, v := range m.Headers {
+		header[k] = v
+	}
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var out bytes.Buffer
+	for _, k := range keys {
+		if strings.ContainsAny(header[k], "\r\n") {
+			return nil, fmt.Errorf("invalid value of header %s", k)
+		}
+		fmt.Fprintf(&out, "%s: %s\r\n", k, header[k])
+	}
+	out.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the domain of the sender.
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(addr.Address, "@"); i >= 0 {
+			domain = addr.Address[i+1:]
+		}
+	}
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
+
+// SMTP sends messages to an SMTP server, using STARTTLS when the server supports it.
+type SMTP struct {
+	// Addr is the address of the server as "host:port".
+	Addr string
+	// Username and Password authenticate with the server. No authentication is used if Username is empty.
+	Username string
+	Password string
+	// From is the sender of the messages.
+	From string
+}
+
+// Send sends a message.
+func (s SMTP) Send(_ context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	data, err := msg.Render(s.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host := s.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, from.Address, []string{to.Address}, data)
+}
+
+// Capture keeps the sent messages in memory instead of sending them. When Dir is set, every message is
+// also written to it as an .eml file.
+type Capture struct {
+	// From is the sender of the messages.
+	From string
+	// Dir is the directory the messages are written to.
+	Dir string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records a message.
+func (c *Capture) Send(_ context.Context, msg Message) error {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return fmt.Errorf("invalid recipient: %w", err)
+	}
+	data, err := msg.Render(c.From)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	if c.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405"), len(c.messages))
+	return os.WriteFile(filepath.Join(c.Dir, name), data, 0o644)
+}
+
+// Messages returns the messages sent so far.
+func (c *Capture) Messages() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
+
+// Reset forgets the messages sent so far.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
@@ -0,0 +1,23 @@
+// Package storage provides an abstraction over the place where uploaded media bytes are kept.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when the requested object does not exist in the storage backend.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage represents a backend that stores binary objects under string keys.
+type Storage interface {
+	// Put stores the content of r under the given key and returns the number of bytes written.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns a reader for the object stored under the given key.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// OpenRange returns a reader for length bytes of the object starting at offset.
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Delete removes the object stored under the given key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
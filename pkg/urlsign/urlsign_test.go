@@ -0,0 +1,112 @@
+package urlsign
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr bool
+	}{
+		{"single key", []Key{{"k1", []byte("secret")}}, false},
+		{"several keys", []Key{{"k2", []byte("new")}, {"k1", []byte("old")}}, false},
+		{"no keys", nil, true},
+		{"missing ID", []Key{{"", []byte("secret")}}, true},
+		{"missing secret", []Key{{"k1", nil}}, true},
+		{"duplicate ID", []Key{{"k1", []byte("a")}, {"k1", []byte("b")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.keys...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Key
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"single", "k1:secret", []Key{{"k1", []byte("secret")}}, false},
+		{"several with spaces", " k2:new , k1:old ,", []Key{{"k2", []byte("new")}, {"k1", []byte("old")}}, false},
+		{"secret with colon", "k1:a:b", []Key{{"k1", []byte("a:b")}}, false},
+		{"missing separator", "k1", nil, true},
+		{"missing secret", "k1:", nil, true},
+		{"missing ID", ":secret", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeys(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseKeys() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID || string(got[i].Secret) != string(tt.want[i].Secret) {
+					t.Errorf("ParseKeys()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	old, _ := New(Key{"k1", []byte("old secret")})
+	rotated, _ := New(Key{"k2", []byte("new secret")}, Key{"k1", []byte("old secret")})
+	retired, _ := New(Key{"k2", []byte("new secret")})
+	kid, sig := old.Sign("media/1/original", expires)
+
+	tests := []struct {
+		name    string
+		signer  *Signer
+		payload string
+		expires int64
+		kid     string
+		sig     string
+		now     time.Time
+		want    error
+	}{
+		{"valid", old, "media/1/original", expires.Unix(), kid, sig, now, nil},
+		{"valid until the expiry", old, "media/1/original", expires.Unix(), kid, sig, expires, nil},
+		{"expired", old, "media/1/original", expires.Unix(), kid, sig, expires.Add(time.Second), ErrExpired},
+		{"other payload", old, "media/2/original", expires.Unix(), kid, sig, now, ErrInvalid},
+		{"extended expiry", old, "media/1/original", expires.Add(time.Hour).Unix(), kid, sig, now, ErrInvalid},
+		{"tampered signature", old, "media/1/original", expires.Unix(), kid, sig[:len(sig)-1] + "A", now, ErrInvalid},
+		{"unknown key", old, "media/1/original", expires.Unix(), "k9", sig, now, ErrInvalid},
+		{"old key after rotation", rotated, "media/1/original", expires.Unix(), kid, sig, now, nil},
+		{"old key after retirement", retired, "media/1/original", expires.Unix(), kid, sig, now, ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.signer.Verify(tt.payload, tt.expires, tt.kid, tt.sig, tt.now); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignUsesFirstKey(t *testing.T) {
+	s, _ := New(Key{"k2", []byte("new secret")}, Key{"k1", []byte("old secret")})
+	expires := time.Now().Add(time.Hour)
+	kid, sig := s.Sign("payload", expires)
+	if kid != "k2" {
+		t.Errorf("Sign() kid = %q, want k2", kid)
+	}
+	if err := s.Verify("payload", expires.Unix(), kid, sig, time.Now()); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+// Package webpush implements the sending side of the Web Push protocol: the message encryption of
+// RFC 8291 with the aes128gcm content coding of RFC 8188, and the VAPID authentication of RFC 8292.
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// recordSize is the record size announced in the content coding header. Push messages are small enough
+// to always fit into a single record.
+const recordSize = 4096
+
+// MaxPayload is the largest plaintext that push services have to accept.
+const MaxPayload = 3993
+
+// Encrypt encrypts a push message for a subscription with the given public key (the p256dh key of the
+// subscription) and authentication secret.
+func Encrypt(plaintext, p256dh, authSecret []byte) ([]byte, error) {
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return encrypt(plaintext, p256dh, authSecret, asKey, salt)
+}
+
+func encrypt(plaintext, p256dh, authSecret []byte, asKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, fmt.Errorf("webpush: payload of %d bytes exceeds %d bytes", len(plaintext), MaxPayload)
+	}
+	if len(authSecret) != 16 {
+		return nil, errors.New("webpush: the authentication secret has to be 16 bytes")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid subscription key: %w", err)
+	}
+	secret, err := asKey.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+
+	// combine the shared secret with the authentication secret (RFC 8291, section 3.4)
+	prkKey, err := hkdf.Extract(sha256.New, secret, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prkKey, "WebPush: info\x00"+string(p256dh)+string(asPublic), 32)
+	if err != nil {
+		return nil, err
+	}
+	// derive the content encryption key and nonce (RFC 8188, section 2.2 and 2.3)
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	// a single record ends with the 0x02 delimiter
+	record := append(append([]byte{}, plaintext...), 0x02)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// VAPID is an application server key pair that identifies the sender to push services (RFC 8292).
+type VAPID struct {
+	key *ecdsa.PrivateKey
+	// PublicKey is the uncompressed public key, which clients pass as applicationServerKey when subscribing.
+	PublicKey []byte
+	// Subject is a contact of the sender, a mailto: or https: URL.
+	Subject string
+}
+
+// NewVAPID creates a VAPID key pair from a private key given as base64url encoded 32 byte scalar, the
+// format used by common Web Push libraries.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	d, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	return newVAPID(key, subject), nil
+}
+
+// GenerateVAPID generates a new VAPID key pair and returns it with its private key in the format
+// accepted by NewVAPID.
+func GenerateVAPID(subject string) (*VAPID, string, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return newVAPID(key, subject), base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+func newVAPID(key *ecdh.PrivateKey, subject string) *VAPID {
+	public := key.PublicKey().Bytes()
+	return &VAPID{
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(key.Bytes()),
+		},
+		PublicKey: public,
+		Subject:   subject,
+	}
+}
+
+// Authorization returns the value of the Authorization header of a request to a push endpoint. The
+// token is valid for the origin of the endpoint for 12 hours.
+func (v *VAPID) Authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": v.Subject,
+	})
+	signed, err := token.SignedString(v.key)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + signed + ", k=" + base64.RawURLEncoding.EncodeToString(v.PublicKey), nil
+}
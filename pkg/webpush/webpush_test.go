@@ -0,0 +1,164 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// The example of RFC 8291, section 5.
+var (
+	rfcPlaintext  = "When I grow up, I want to be a watermelon"
+	rfcASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfcUAPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfcUAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfcAuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfcSalt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfcMessage    = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decrypt decrypts a message as the user agent does.
+func decrypt(t *testing.T, message, uaPrivate, authSecret []byte) []byte {
+	t.Helper()
+	salt, idLen := message[:16], int(message[20])
+	asPublic, ciphertext := message[21:21+idLen], message[21+idLen:]
+	uaKey, err := ecdh.P256().NewPrivateKey(uaPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := ecdh.P256().NewPublicKey(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := uaKey.ECDH(peer)
+	prkKey, _ := hkdf.Extract(sha256.New, secret, authSecret)
+	ikm, _ := hkdf.Expand(sha256.New, prkKey, "WebPush: info\x00"+string(uaKey.PublicKey().Bytes())+string(asPublic), 32)
+	prk, _ := hkdf.Extract(sha256.New, ikm, salt)
+	cek, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	record, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		t.Fatalf("decrypt: record does not end with the last record delimiter")
+	}
+	return record[:len(record)-1]
+}
+
+func TestEncryptRFC8291(t *testing.T) {
+	asKey, err := ecdh.P256().NewPrivateKey(decode(t, rfcASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := encrypt([]byte(rfcPlaintext), decode(t, rfcUAPublic), decode(t, rfcAuthSecret), asKey, decode(t, rfcSalt))
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if want := decode(t, rfcMessage); !bytes.Equal(got, want) {
+		t.Errorf("encrypt() = %s, want %s", base64.RawURLEncoding.EncodeToString(got), rfcMessage)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	p256dh, authSecret := decode(t, rfcUAPublic), decode(t, rfcAuthSecret)
+	tests := []struct {
+		name       string
+		plaintext  []byte
+		p256dh     []byte
+		authSecret []byte
+		wantErr    bool
+	}{
+		{"short message", []byte(rfcPlaintext), p256dh, authSecret, false},
+		{"empty message", nil, p256dh, authSecret, false},
+		{"largest message", bytes.Repeat([]byte("a"), MaxPayload), p256dh, authSecret, false},
+		{"message too large", bytes.Repeat([]byte("a"), MaxPayload+1), p256dh, authSecret, true},
+		{"short authentication secret", []byte(rfcPlaintext), p256dh, authSecret[:8], true},
+		{"invalid subscription key", []byte(rfcPlaintext), p256dh[:33], authSecret, true},
+		{"point not on the curve", []byte(rfcPlaintext), append([]byte{4}, make([]byte, 64)...), authSecret, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encrypt(tt.plaintext, tt.p256dh, tt.authSecret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(got) > recordSize {
+				t.Errorf("Encrypt() returned %d bytes, more than a record", len(got))
+			}
+			if plain := decrypt(t, got, decode(t, rfcUAPrivate), tt.authSecret); !bytes.Equal(plain, tt.plaintext) {
+				t.Errorf("decrypted %q, want %q", plain, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	v, private, err := GenerateVAPID("mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := NewVAPID(private, v.Subject); err != nil || !bytes.Equal(restored.PublicKey, v.PublicKey) {
+		t.Fatalf("NewVAPID() did not restore the generated key: %v", err)
+	}
+	now := time.Now()
+	tests := []struct {
+		endpoint string
+		aud      string
+	}{
+		{"https://fcm.googleapis.com/fcm/send/abc", "https://fcm.googleapis.com"},
+		{"https://updates.push.services.mozilla.com:443/wpush/v2/abc?x=1", "https://updates.push.services.mozilla.com:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			header, err := v.Authorization(tt.endpoint, now)
+			if err != nil {
+				t.Fatalf("Authorization() error = %v", err)
+			}
+			token, key, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ", k=")
+			if !ok || key != base64.RawURLEncoding.EncodeToString(v.PublicKey) {
+				t.Fatalf("Authorization() = %q", header)
+			}
+			claims := jwt.MapClaims{}
+			if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+				return &v.key.PublicKey, nil
+			}); err != nil {
+				t.Fatalf("token does not verify: %v", err)
+			}
+			if claims["aud"] != tt.aud || claims["sub"] != v.Subject {
+				t.Errorf("claims = %v, want aud %q", claims, tt.aud)
+			}
+		})
+	}
+}
+
+func TestNewVAPIDInvalid(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.RawURLEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := NewVAPID(key, "mailto:admin@example.com"); err == nil {
+			t.Errorf("NewVAPID(%q) succeeded", key)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC 6455).
+//
+// It supports text and binary messages, fragmentation and the control frames, which is what browser
+// clients use. Extensions such as compression are not negotiated.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The message types.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// The close codes used by this package and its users.
+const (
+	CloseNormal         = 1000
+	CloseGoingAway      = 1001
+	CloseProtocolError  = 1002
+	CloseInvalidPayload = 1007
+	ClosePolicy         = 1008
+	CloseMessageTooBig  = 1009
+	CloseTryAgainLater  = 1013
+)
+
+// acceptGUID is appended to the key of the client to compute the accept key of the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the default limit of the size of received messages.
+const DefaultMaxMessageSize = 64 << 10
+
+var (
+	// ErrBadHandshake is returned by Upgrade when the request is not a valid WebSocket handshake.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	// ErrMessageTooBig is returned when a received message exceeds the size limit.
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	errProtocol      = errors.New("websocket: protocol error")
+	errInvalidUTF8   = errors.New("websocket: invalid UTF-8 in text message")
+)
+
+// CloseError is returned by ReadMessage when the peer closed the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. Reading has to be done from a single goroutine; writing is safe from
+// multiple goroutines.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	// MaxMessageSize is the limit of the size of received messages.
+	MaxMessageSize int64
+	// ReadTimeout, if set, is how long the peer may stay silent. Every received frame, including the
+	// pong answering a ping, extends the read deadline by this duration.
+	ReadTimeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Upgrade performs the opening handshake and takes over the connection of the request. When the request
+// is not a valid handshake, an error response is written and ErrBadHandshake is returned.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 ||
+		r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	if _, err := fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:])); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// no deadline applies to the connection until the user sets one
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, br: brw.Reader, MaxMessageSize: DefaultMaxMessageSize}, nil
+}
+
+// headerContains reports whether a comma separated header contains a token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadDeadline sets the deadline for reading the next frames.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for the following writes. A write that times out breaks the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage reads the next data message. Ping frames are answered and pong frames are skipped. When the
+// peer closes the connection, the close is confirmed and a *CloseError is returned. Protocol violations
+// close the connection with the matching code.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		if c.ReadTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ce := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Text = string(payload[2:])
+			}
+			c.Close(CloseNormal, "")
+			return 0, nil, ce
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(errProtocol)
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(errProtocol)
+			}
+			messageType = op
+		default:
+			return 0, nil, c.fail(errProtocol)
+		}
+		if int64(len(data)+len(payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(ErrMessageTooBig)
+		}
+		data = append(data, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return 0, nil, c.fail(errInvalidUTF8)
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, int(head[0]&0x0f)
+	// clients have to mask their frames and no extension defining the reserved bits was negotiated
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		return false, 0, nil, errProtocol
+	}
+	size := int64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		if ext[0]&0x80 != 0 {
+			return false, 0, nil, errProtocol
+		}
+		size = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if op >= opClose && (!fin || size > 125) {
+		return false, 0, nil, errProtocol
+	}
+	if size > c.MaxMessageSize {
+		return false, 0, nil, ErrMessageTooBig
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// fail closes the connection with the close code matching a read error and returns the error.
+func (c *Conn) fail(err error) error {
+	switch err {
+	case errProtocol:
+		c.Close(CloseProtocolError, "")
+	case ErrMessageTooBig:
+		c.Close(CloseMessageTooBig, "")
+	case errInvalidUTF8:
+		c.Close(CloseInvalidPayload, "")
+	default:
+		c.conn.Close()
+	}
+	return err
+}
+
+// WriteMessage writes a message in a single frame.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.write(messageType, data)
+}
+
+// Ping sends a ping frame. The peer answers with a pong, which ReadMessage consumes.
+func (c *Conn) Ping() error {
+	return c.write(opPing, nil)
+}
+
+func (c *Conn) write(op int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|byte(op))
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with the given code and reason and closes the connection. Closing a closed
+// connection has no effect.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload = append(payload, reason...)
+	// the close frame is best effort, the connection may already be broken
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.write(opClose, payload)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}